@@ -0,0 +1,100 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AddressPoolSpec defines the desired state of AddressPool
+type AddressPoolSpec struct {
+	// CIDRs represents the network CIDRs of the address pool. Each CIDR is used as an address pool by every NodeCIDRAllocation that references
+	// the AddressPool
+	//+kubebuilder:validation:MinItems=1
+	CIDRs []string `json:"cidrs"`
+
+	// Reservations represents named ranges of the address pool that are reserved from being used by any Node. They apply to every
+	// NodeCIDRAllocation that references the AddressPool
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Reservations []AddressReservation `json:"reservations,omitempty"`
+
+	// Description represents a free-text description of the address pool
+	//+optional
+	Description string `json:"description,omitempty"`
+}
+
+// AddressPoolStatus defines the observed state of AddressPool
+// The capacity of an AddressPool is calculated once for the pool, no matter how many NodeCIDRAllocations reference it
+type AddressPoolStatus struct {
+	// NodeCIDRAllocations lists the NodeCIDRAllocations (as <namespace>/<name>) that reference the AddressPool
+	//+optional
+	NodeCIDRAllocations []string `json:"nodeCIDRAllocations,omitempty"`
+
+	// Addresses represents the total number of addresses in the CIDRs of the AddressPool
+	//+optional
+	Addresses int64 `json:"addresses,omitempty"`
+
+	// Allocated represents the number of addresses of the AddressPool that are allocated to Nodes
+	//+optional
+	Allocated int64 `json:"allocated,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// AddressPool is a cluster-scoped set of network CIDRs (along with their reservations) that can be shared by several NodeCIDRAllocations,
+// which reference it by name from .spec.addressPoolRefs
+//
+// +kubebuilder:printcolumn:name="Created",type="date",JSONPath=".metadata.creationTimestamp",description="AddressPool creation timestamp"
+// +kubebuilder:printcolumn:name="CIDRs",type="string",JSONPath=".spec.cidrs",description="AddressPool CIDRs"
+// +kubebuilder:printcolumn:name="Addresses",type="integer",JSONPath=".status.addresses",description="Total number of addresses in the AddressPool"
+// +kubebuilder:printcolumn:name="Allocated",type="integer",JSONPath=".status.allocated",description="Number of addresses allocated to Nodes"
+type AddressPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AddressPoolSpec   `json:"spec,omitempty"`
+	Status AddressPoolStatus `json:"status,omitempty"`
+}
+
+// SetNodeCIDRAllocations is a helper function to set/update the NodeCIDRAllocations status field
+func (p *AddressPool) SetNodeCIDRAllocations(nodeCIDRAllocations []string) {
+	p.Status.NodeCIDRAllocations = nodeCIDRAllocations
+}
+
+// SetCapacity is a helper function to set/update the Addresses and Allocated status fields
+func (p *AddressPool) SetCapacity(addresses, allocated int64) {
+	p.Status.Addresses = addresses
+	p.Status.Allocated = allocated
+}
+
+//+kubebuilder:object:root=true
+
+// AddressPoolList contains a list of AddressPool
+type AddressPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AddressPool `json:"items"`
+}
+
+// init Registers the AddressPool CRD with the provided manager Scheme
+func init() {
+	SchemeBuilder.Register(&AddressPool{}, &AddressPoolList{})
+}
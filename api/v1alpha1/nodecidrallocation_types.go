@@ -17,6 +17,9 @@ WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
 package v1alpha1
 
 import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -28,24 +31,61 @@ const (
 	HealthStatusUnhealthy   HealthStatus = "Unhealthy"
 )
 
+// DeletionPolicy represents what happens to the PodCIDR allocations of the Nodes owned by a NodeCIDRAllocation when it is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyBlock blocks the deletion of the NodeCIDRAllocation for as long as it owns any Node with a PodCIDR
+	DeletionPolicyBlock DeletionPolicy = "Block"
+	// DeletionPolicyOrphan allows the NodeCIDRAllocation to be deleted and leaves the Nodes it owns with their PodCIDR
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetainAsReservation allows the NodeCIDRAllocation to be deleted and keeps the PodCIDRs of the Nodes it owns reserved
+	// in the ledger, so that they are not handed out again once the Nodes are removed
+	DeletionPolicyRetainAsReservation DeletionPolicy = "RetainAsReservation"
+)
+
 // NodeCIDRAllocationSpec defines the desired state of NodeCIDRAllocation
 // This CRD defines an allocation of Node Pod ranges to be assigned to nodes in the cluster
 type NodeCIDRAllocationSpec struct {
 	// AddressPools represents a list of basic address pools in the form of a list of
 	// network CIDRs that can be allocated to nodes running in the cluster.
 	// These pools exist as a base subnet for the allocation of dynamically sized and positioned podCIDRs which will be
-	// applied to Nodes that match the provided node selector
+	// applied to Nodes that match the provided node selector. Address pools shared with other NodeCIDRAllocations can instead be referenced
+	// through AddressPoolRefs
 	//+required
 	//+patchStrategy=merge
 	//+kubebuilder:validation:MinItems=1
 	AddressPools []string `json:"addressPools,omitempty" protobuf:"bytes,7,opt,name=addressPools" patchStrategy:"merge"`
 
+	// AddressPoolRefs represents the names of the cluster-scoped AddressPools whose CIDRs (and reservations) are used as address pools
+	// in addition to AddressPools
+	//+optional
+	AddressPoolRefs []string `json:"addressPoolRefs,omitempty"`
+
+	// DrainingPools represents the address pools (from AddressPools or the referenced AddressPools) that are being decommissioned. A draining pool is not used for any new
+	// allocation, and the Nodes that still hold a PodCIDR from it are reported in .status.draining
+	//+optional
+	DrainingPools []string `json:"drainingPools,omitempty"`
+
 	// StaticAllocations represents a list of static address pools in the form of a list of
 	// network CIDRs that are reserved from being used by any node.
 	//+optional
 	//+patchStrategy=merge
 	StaticAllocations []string `json:"staticAllocations,omitempty" protobuf:"bytes,7,opt,name=staticAllocations" patchStrategy:"merge"`
 
+	// Reservations represents named ranges that are reserved from being used by any Node, along with who they are reserved for and why.
+	// Unlike StaticAllocations, a reservation can expire, after which its range is released automatically
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Reservations []AddressReservation `json:"reservations,omitempty"`
+
+	// PinnedAllocations represents fixed PodCIDR assignments for individual Nodes (for example, routers or infrastructure Nodes).
+	// Pinned PodCIDRs are assigned ahead of any dynamic allocation and are never handed out to other Nodes. Each pinned PodCIDR must lie inside
+	// one of the address pools, and pins that cannot be honored are reported in .status.pinConflicts
+	//+optional
+	PinnedAllocations []PinnedAllocation `json:"pinnedAllocations,omitempty"`
+
 	// NodeSelector represents a Kubernetes node selector to filter nodes from
 	// the cluster for which to apply Pod CIDRs onto.
 	// NOTE: Nodes that are selected through the node selector MUST specify a maximum number of pods in order to help identify
@@ -53,6 +93,162 @@ type NodeCIDRAllocationSpec struct {
 	//+optional
 	//+mapType=atomic
 	NodeSelector map[string]string `json:"nodeSelector,omitempty" protobuf:"bytes,7,rep,name=nodeSelector"`
+
+	// AggregationLabel represents an optional Node label key (for example, a rack label) used to further group
+	// the aggregated routes that are published for each address pool. When empty, routes are aggregated per address pool only.
+	//+optional
+	AggregationLabel string `json:"aggregationLabel,omitempty"`
+
+	// ResyncPeriod represents how often the NodeCIDRAllocation is reconciled when no change to it or its Nodes has been observed.
+	// This allows Nodes that could not be allocated to be retried on a regular schedule. When unset, no periodic resync is performed
+	//+optional
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+
+	// DeletionPolicy represents what happens to the PodCIDR allocations of the Nodes owned by this NodeCIDRAllocation when it is deleted.
+	// A Node is owned when it was allocated by this NodeCIDRAllocation, or when it is selected by it and holds a PodCIDR from one of its address pools.
+	// One of Block (the default), Orphan or RetainAsReservation
+	//+optional
+	//+kubebuilder:validation:Enum=Block;Orphan;RetainAsReservation
+	//+kubebuilder:default=Block
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// RetentionPeriod represents how long the PodCIDRs retained in the ledger by the RetainAsReservation deletion policy are kept reserved.
+	// When unset, they are kept until their range is owned by a NodeCIDRAllocation again
+	//+optional
+	RetentionPeriod *metav1.Duration `json:"retentionPeriod,omitempty"`
+
+	// AdoptExisting represents whether the PodCIDRs already held by the selected Nodes (for example, those allocated by the kube-controller-manager
+	// with --allocate-node-cidrs) are adopted as allocations of this NodeCIDRAllocation when they fall inside one of its address pools.
+	// Nodes whose PodCIDR cannot be adopted are reported in .status.adoption
+	//+optional
+	AdoptExisting bool `json:"adoptExisting,omitempty"`
+
+	// Paused represents whether new PodCIDR allocations (including pinned allocations and adoptions) are suspended for this NodeCIDRAllocation,
+	// for example during maintenance of the network. The status (and metrics) are still updated while paused, and the Nodes waiting on a PodCIDR
+	// are counted in .status.waiting. Allocations can also be paused with the networking.statcan.gc.ca/paused annotation
+	//+optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// AggregatedRoute represents an aggregate prefix that covers the PodCIDRs allocated to one or more Nodes
+type AggregatedRoute struct {
+	// Prefix represents the aggregated network prefix in CIDR format
+	Prefix string `json:"prefix"`
+
+	// Pool represents the address pool that the aggregated Node allocations were made from
+	Pool string `json:"pool"`
+
+	// Group represents the value of the AggregationLabel shared by all Nodes behind this prefix (if configured)
+	//+optional
+	Group string `json:"group,omitempty"`
+
+	// Nodes represents the names of the Nodes whose PodCIDR is covered by this prefix
+	//+optional
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// NodeAllocationFailure represents a Node tracked by the NodeCIDRAllocation that could not be allocated a PodCIDR
+type NodeAllocationFailure struct {
+	// Node represents the name of the Node that could not be allocated
+	Node string `json:"node"`
+
+	// Reason represents the reason that the allocation failed. It matches the reason of the PodCIDRAllocated condition on the Node
+	// (one of NoFreeAddressSpace or AllocationFailed)
+	Reason string `json:"reason"`
+
+	// Message represents a human-readable description of the failure
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+// AddressReservation represents a range that is reserved from being allocated to any Node
+type AddressReservation struct {
+	// Name represents the unique name of the reservation
+	Name string `json:"name"`
+
+	// CIDR represents the reserved range in CIDR format
+	CIDR string `json:"cidr"`
+
+	// Owner represents the team or system that the range is reserved for
+	//+optional
+	Owner string `json:"owner,omitempty"`
+
+	// Reason represents a free-text description of why the range is reserved
+	//+optional
+	Reason string `json:"reason,omitempty"`
+
+	// ExpiresAt represents the time at which the reservation expires and its range is released. The reservation never expires when unset
+	//+optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+// PinnedAllocation represents a PodCIDR that is always assigned to the same Node. The Node is identified by its name, or by a set of labels
+// which must select exactly one of the Nodes selected by the NodeCIDRAllocation
+type PinnedAllocation struct {
+	// NodeName represents the name of the Node that the PodCIDR is pinned to
+	//+optional
+	NodeName string `json:"nodeName,omitempty"`
+
+	// NodeSelector represents the labels of the Node that the PodCIDR is pinned to. Only used when NodeName is empty
+	//+optional
+	//+mapType=atomic
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// PodCIDR represents the PodCIDR assigned to the Node. It must lie inside one of the address pools
+	PodCIDR string `json:"podCIDR"`
+}
+
+// PinConflict represents a pinned allocation that cannot be honored
+type PinConflict struct {
+	// PodCIDR represents the pinned PodCIDR
+	PodCIDR string `json:"podCIDR"`
+
+	// Node represents the name of the pinned Node (when it is known)
+	//+optional
+	Node string `json:"node,omitempty"`
+
+	// Reason represents a human-readable description of why the pinned allocation cannot be honored
+	Reason string `json:"reason"`
+}
+
+// DrainingPool represents an address pool that is being decommissioned along with the Nodes that still hold a PodCIDR from it
+type DrainingPool struct {
+	// Pool represents the draining address pool
+	Pool string `json:"pool"`
+
+	// Nodes lists the Nodes owned by the NodeCIDRAllocation that still hold a PodCIDR from the pool. The pool can be removed once it is empty
+	//+optional
+	Nodes []string `json:"nodes,omitempty"`
+
+	// Removed is true when the pool was removed from the address pools (or from a referenced AddressPool) while Nodes still hold a PodCIDR
+	// from it. The pool is kept as a draining pool until no Node is left on it, or until the force-pool-removal annotation is set
+	//+optional
+	Removed bool `json:"removed,omitempty"`
+}
+
+// AdoptionMisfit represents a Node selected by the NodeCIDRAllocation whose existing PodCIDR could not be adopted
+type AdoptionMisfit struct {
+	// Node represents the name of the Node
+	Node string `json:"node"`
+
+	// PodCIDR represents the existing PodCIDR of the Node
+	PodCIDR string `json:"podCIDR"`
+
+	// Reason represents a human-readable description of why the PodCIDR could not be adopted
+	Reason string `json:"reason"`
+}
+
+// AdoptionStatus summarizes the adoption of the existing PodCIDRs of the Nodes selected by the NodeCIDRAllocation
+type AdoptionStatus struct {
+	// Adopted represents the number of selected Nodes whose existing PodCIDR was adopted by this NodeCIDRAllocation
+	Adopted int32 `json:"adopted"`
+
+	// Misfits lists the selected Nodes holding a PodCIDR that could not be adopted
+	//+optional
+	Misfits []AdoptionMisfit `json:"misfits,omitempty"`
+
+	// Complete is true once every selected Node holding a PodCIDR is managed by this NodeCIDRAllocation
+	Complete bool `json:"complete"`
 }
 
 // NodeCIDRAllocationStatus defines the observed state of NodeCIDRAllocation
@@ -75,6 +271,53 @@ type NodeCIDRAllocationStatus struct {
 	// CompletedAllocations tracks the total number of Nodes being tracked that have successfully completed a CIDR allocation using this NodeCIDRAllocation resource
 	//+optional
 	CompletedAllocations int32 `json:"completed,omitempty"`
+
+	// WaitingAllocations tracks the number of Nodes being tracked that are still waiting on a CIDR allocation from this NodeCIDRAllocation resource
+	//+optional
+	WaitingAllocations int32 `json:"waiting,omitempty"`
+
+	// Conditions represents the latest available observations of the state of the NodeCIDRAllocation resource.
+	// The Paused condition reports whether new allocations are suspended
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// FailedAllocations lists the Nodes that could not be allocated a PodCIDR during the last reconcile along with the reason for each failure.
+	// A failure for one Node does not prevent the remaining Nodes from being allocated
+	//+optional
+	FailedAllocations []NodeAllocationFailure `json:"failedAllocations,omitempty"`
+
+	// BlockingNodes lists the Nodes owned by this NodeCIDRAllocation that are blocking its deletion (with the Block deletion policy)
+	//+optional
+	BlockingNodes []string `json:"blockingNodes,omitempty"`
+
+	// Aggregates represents the smallest set of aggregate prefixes that covers the PodCIDRs allocated to the Nodes tracked by this
+	// NodeCIDRAllocation resource. Aggregates are calculated per address pool (and per AggregationLabel value when configured)
+	// and are recalculated on every allocation change
+	//+optional
+	Aggregates []AggregatedRoute `json:"aggregates,omitempty"`
+
+	// Adoption summarizes the adoption of the existing PodCIDRs of the selected Nodes (only set when .spec.adoptExisting is enabled)
+	//+optional
+	Adoption *AdoptionStatus `json:"adoption,omitempty"`
+
+	// Draining lists each draining address pool along with the Nodes that still hold a PodCIDR from it
+	//+optional
+	Draining []DrainingPool `json:"draining,omitempty"`
+
+	// AddressPools lists the address pools in effect at the last reconcile, including removed pools that are kept while Nodes still hold a
+	// PodCIDR from them. It is used to detect the address pools removed since the last reconcile
+	//+optional
+	AddressPools []string `json:"addressPools,omitempty"`
+
+	// ActiveReservations lists the reservations that have not expired
+	//+optional
+	ActiveReservations []AddressReservation `json:"activeReservations,omitempty"`
+
+	// PinConflicts lists the pinned allocations that cannot be honored, for example because the pinned Node holds a different PodCIDR
+	//+optional
+	PinConflicts []PinConflict `json:"pinConflicts,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -90,6 +333,7 @@ type NodeCIDRAllocationStatus struct {
 // +kubebuilder:printcolumn:name="Health",type="string",JSONPath=".status.health",description="Current NodeCIDRAllocation resource Health"
 // +kubebuilder:printcolumn:name="Expected",type="integer",JSONPath=".status.expected",description="Expected number of Node allocations"
 // +kubebuilder:printcolumn:name="Completed",type="integer",JSONPath=".status.completed",description="Completed Node allocations"
+// +kubebuilder:printcolumn:name="Waiting",type="integer",JSONPath=".status.waiting",description="Nodes waiting on an allocation",priority=1
 type NodeCIDRAllocation struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -113,6 +357,80 @@ func (n *NodeCIDRAllocation) CompletedAllocations() int32 {
 	return n.Status.CompletedAllocations
 }
 
+// WaitingAllocations will return the current number of Nodes waiting on an allocation from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) WaitingAllocations() int32 {
+	return n.Status.WaitingAllocations
+}
+
+// Paused will return whether new allocations are suspended for the NodeCIDRAllocation, either through .spec.paused or the
+// networking.statcan.gc.ca/paused annotation
+func (n *NodeCIDRAllocation) Paused() bool {
+	return n.Spec.Paused || n.GetAnnotations()[AnnotationPaused] == "true"
+}
+
+// FailedAllocations will return the list of Nodes that could not be allocated during the last reconcile from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) FailedAllocations() []NodeAllocationFailure {
+	return n.Status.FailedAllocations
+}
+
+// Aggregates will return the current list of aggregated routes from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) Aggregates() []AggregatedRoute {
+	return n.Status.Aggregates
+}
+
+// ResyncPeriod will return the period at which the NodeCIDRAllocation is periodically reconciled, or zero when periodic resync is disabled
+func (n *NodeCIDRAllocation) ResyncPeriod() time.Duration {
+	if n.Spec.ResyncPeriod == nil {
+		return 0
+	}
+
+	return n.Spec.ResyncPeriod.Duration
+}
+
+// DeletionPolicy will return the deletion policy of the NodeCIDRAllocation, defaulting to DeletionPolicyBlock when unset
+func (n *NodeCIDRAllocation) DeletionPolicy() DeletionPolicy {
+	if n.Spec.DeletionPolicy == "" {
+		return DeletionPolicyBlock
+	}
+
+	return n.Spec.DeletionPolicy
+}
+
+// RetentionPeriod will return how long the PodCIDRs retained by the RetainAsReservation deletion policy are kept reserved, or zero when they
+// are kept until their range is owned again
+func (n *NodeCIDRAllocation) RetentionPeriod() time.Duration {
+	if n.Spec.RetentionPeriod == nil {
+		return 0
+	}
+
+	return n.Spec.RetentionPeriod.Duration
+}
+
+// BlockingNodes will return the list of Nodes blocking the deletion of the NodeCIDRAllocation from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) BlockingNodes() []string {
+	return n.Status.BlockingNodes
+}
+
+// Draining will return the draining address pools and the Nodes still holding a PodCIDR from each of them from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) Draining() []DrainingPool {
+	return n.Status.Draining
+}
+
+// ActiveReservations will return the reservations that had not expired at the last reconcile from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) ActiveReservations() []AddressReservation {
+	return n.Status.ActiveReservations
+}
+
+// PinConflicts will return the pinned allocations that cannot be honored from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) PinConflicts() []PinConflict {
+	return n.Status.PinConflicts
+}
+
+// Adoption will return the summary of the adoption of existing Node PodCIDRs from the NodeCIDRAllocation status field
+func (n *NodeCIDRAllocation) Adoption() *AdoptionStatus {
+	return n.Status.Adoption
+}
+
 // SetHealthStatus is a helper function to set/update the Health status field
 func (n *NodeCIDRAllocation) SetHealthStatus(newStatus HealthStatus) {
 	if newStatus == HealthStatusHealthy || newStatus == HealthStatusProgressing || newStatus == HealthStatusUnhealthy {
@@ -130,6 +448,57 @@ func (n *NodeCIDRAllocation) SetCompletedAllocations(completed int32) {
 	n.Status.CompletedAllocations = completed
 }
 
+// SetWaitingAllocations is a helper function to set/update the WaitingAllocations status field
+func (n *NodeCIDRAllocation) SetWaitingAllocations(waiting int32) {
+	n.Status.WaitingAllocations = waiting
+}
+
+// SetCondition is a helper function to set/update a condition in the Conditions status field. The last transition time of the condition
+// is only changed when its status changes
+func (n *NodeCIDRAllocation) SetCondition(condition metav1.Condition) {
+	meta.SetStatusCondition(&n.Status.Conditions, condition)
+}
+
+// SetFailedAllocations is a helper function to set/update the FailedAllocations status field
+func (n *NodeCIDRAllocation) SetFailedAllocations(failures []NodeAllocationFailure) {
+	n.Status.FailedAllocations = failures
+}
+
+// SetBlockingNodes is a helper function to set/update the BlockingNodes status field
+func (n *NodeCIDRAllocation) SetBlockingNodes(nodes []string) {
+	n.Status.BlockingNodes = nodes
+}
+
+// SetAdoption is a helper function to set/update the Adoption status field
+func (n *NodeCIDRAllocation) SetAdoption(adoption *AdoptionStatus) {
+	n.Status.Adoption = adoption
+}
+
+// SetAddressPools is a helper function to set/update the AddressPools status field
+func (n *NodeCIDRAllocation) SetAddressPools(addressPools []string) {
+	n.Status.AddressPools = addressPools
+}
+
+// SetDraining is a helper function to set/update the Draining status field
+func (n *NodeCIDRAllocation) SetDraining(draining []DrainingPool) {
+	n.Status.Draining = draining
+}
+
+// SetActiveReservations is a helper function to set/update the ActiveReservations status field
+func (n *NodeCIDRAllocation) SetActiveReservations(reservations []AddressReservation) {
+	n.Status.ActiveReservations = reservations
+}
+
+// SetPinConflicts is a helper function to set/update the PinConflicts status field
+func (n *NodeCIDRAllocation) SetPinConflicts(conflicts []PinConflict) {
+	n.Status.PinConflicts = conflicts
+}
+
+// SetAggregates is a helper function to set/update the Aggregates status field
+func (n *NodeCIDRAllocation) SetAggregates(aggregates []AggregatedRoute) {
+	n.Status.Aggregates = aggregates
+}
+
 //+kubebuilder:object:root=true
 
 // NodeCIDRAllocationList contains a list of NodeCIDRAllocation
@@ -0,0 +1,66 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package v1alpha1
+
+const (
+	// AnnotationNodeCIDRAllocation is set on Nodes (and objects generated for them) to record the NodeCIDRAllocation (namespace/name)
+	// that the Node PodCIDR was allocated from
+	AnnotationNodeCIDRAllocation = "networking.statcan.gc.ca/nodecidrallocation"
+	// AnnotationAddressPool is set on Nodes to record the address pool that the Node PodCIDR was allocated from
+	AnnotationAddressPool = "networking.statcan.gc.ca/address-pool"
+	// AnnotationAllocatedAt is set on Nodes to record the time (RFC 3339) at which the Node PodCIDR was allocated
+	AnnotationAllocatedAt = "networking.statcan.gc.ca/allocated-at"
+	// AnnotationAdopted is set to "true" on Nodes whose existing PodCIDR was adopted by a NodeCIDRAllocation instead of being allocated by it
+	AnnotationAdopted = "networking.statcan.gc.ca/adopted"
+	// AnnotationForcePoolRemoval is set to "true" on a NodeCIDRAllocation to allow address pools to be removed while Nodes still hold PodCIDRs from them
+	AnnotationForcePoolRemoval = "networking.statcan.gc.ca/force-pool-removal"
+	// AnnotationPaused is set to "true" on a NodeCIDRAllocation to suspend new allocations from it (see NodeCIDRAllocationSpec.Paused)
+	AnnotationPaused = "networking.statcan.gc.ca/paused"
+	// AnnotationRequestedPodCIDR is set on a Node to request an exact PodCIDR from the address pools of the NodeCIDRAllocation that selects it
+	AnnotationRequestedPodCIDR = "networking.statcan.gc.ca/requested-pod-cidr"
+	// AnnotationRequestedPrefixLength is set on a Node to request a PodCIDR with the supplied prefix length (for example, "24") instead of
+	// one sized from the allocatable pods of the Node
+	AnnotationRequestedPrefixLength = "networking.statcan.gc.ca/requested-prefix-length"
+	// AnnotationRequestedPool is set on a Node to request a PodCIDR from the supplied address pool of the NodeCIDRAllocation that selects it
+	AnnotationRequestedPool = "networking.statcan.gc.ca/requested-pool"
+)
+
+const (
+	// NodeConditionPodCIDRAllocated is the type of the Node status condition which reports whether a PodCIDR was allocated to the Node
+	NodeConditionPodCIDRAllocated = "PodCIDRAllocated"
+
+	// NodeConditionReasonAllocated indicates that a PodCIDR was allocated to the Node
+	NodeConditionReasonAllocated = "PodCIDRAllocated"
+	// NodeConditionReasonNoAddressSpace indicates that none of the address pools have a free subnet large enough for the Node
+	NodeConditionReasonNoAddressSpace = "NoFreeAddressSpace"
+	// NodeConditionReasonAllocationFailed indicates that a PodCIDR could not be allocated to the Node because of an error
+	NodeConditionReasonAllocationFailed = "AllocationFailed"
+	// NodeConditionReasonInvalidRequest indicates that the PodCIDR requested by the annotations of the Node cannot be honored
+	NodeConditionReasonInvalidRequest = "InvalidAllocationRequest"
+)
+
+const (
+	// ConditionPaused is the type of the NodeCIDRAllocation status condition which reports whether new allocations are suspended
+	ConditionPaused = "Paused"
+
+	// ConditionReasonPausedBySpec indicates that new allocations are suspended through .spec.paused
+	ConditionReasonPausedBySpec = "PausedBySpec"
+	// ConditionReasonPausedByAnnotation indicates that new allocations are suspended through the networking.statcan.gc.ca/paused annotation
+	ConditionReasonPausedByAnnotation = "PausedByAnnotation"
+	// ConditionReasonNotPaused indicates that new allocations are made
+	ConditionReasonNotPaused = "NotPaused"
+)
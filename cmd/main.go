@@ -20,6 +20,7 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,17 +29,25 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	networkingstatcangccav1alpha1 "statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/audit"
 	"statcan.gc.ca/cidr-allocator/internal/controller"
+	"statcan.gc.ca/cidr-allocator/internal/integrations"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+	statcan_metrics "statcan.gc.ca/cidr-allocator/internal/metrics"
+	statcan_webhook "statcan.gc.ca/cidr-allocator/internal/webhook"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -71,6 +80,36 @@ var (
 	secureMetrics bool
 	// enableHTTP2 specifies that HTTP/2 will be enabled for the metrics and webhook servers (if exists)
 	enableHTTP2 bool
+	// enableCiliumIntegration specifies whether Node PodCIDR allocations are mirrored into the matching CiliumNode resources
+	enableCiliumIntegration bool
+	// enableCalicoIntegration specifies whether Node PodCIDR allocations are mirrored into per-Node Calico IPPool resources
+	enableCalicoIntegration bool
+	// enableNodeWebhook specifies whether the Node mutating admission webhook is served to assign PodCIDRs at Node creation
+	enableNodeWebhook bool
+	// enableNodeCapacityGuard specifies whether the Node validating admission webhook is served to reject Nodes that cannot be allocated a PodCIDR
+	enableNodeCapacityGuard bool
+	// enablePoolRemovalGuard specifies whether the NodeCIDRAllocation validating admission webhook is served to reject the removal of address pools with live allocations
+	enablePoolRemovalGuard bool
+	// requeueBaseDelay represents the initial delay before a NodeCIDRAllocation is requeued when some of its Nodes could not be allocated
+	requeueBaseDelay time.Duration
+	// requeueMaxDelay represents the maximum delay between consecutive requeues of a NodeCIDRAllocation
+	requeueMaxDelay time.Duration
+	// maxConcurrentReconciles represents the maximum number of NodeCIDRAllocations that are reconciled at the same time
+	maxConcurrentReconciles int
+	// webhookPort represents the port that the webhook server binds to
+	webhookPort int
+	// ledgerNamespace represents the namespace of the ConfigMap which stores the shared record of PodCIDR reservations
+	ledgerNamespace string
+	// ledgerName represents the name of the ConfigMap which stores the shared record of PodCIDR reservations
+	ledgerName string
+	// auditInterval represents the time between consecutive audits of every Node PodCIDR in the cluster
+	auditInterval time.Duration
+	// auditReportName represents the name of the ConfigMap which the audit report is published in
+	auditReportName string
+	// strictOverlapCheck specifies whether readiness fails while the audit finds overlapping Node PodCIDRs or static allocations
+	strictOverlapCheck bool
+	// trimNodeCache specifies whether the cached Nodes are stripped down to the fields that are read by the allocator
+	trimNodeCache bool
 )
 
 func init() {
@@ -117,6 +156,96 @@ func init() {
 		false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers (if exists)",
 	)
+	flag.BoolVar(
+		&enableCiliumIntegration,
+		"enable-cilium-integration",
+		false,
+		"If set, Node PodCIDR allocations are written into the spec.ipam.podCIDRs of the matching CiliumNode and drift between the two is reported",
+	)
+	flag.BoolVar(
+		&enableCalicoIntegration,
+		"enable-calico-integration",
+		false,
+		"If set, a Calico IPPool is maintained for every Node PodCIDR allocation and mismatches with Calico IPAM blocks are reported",
+	)
+	flag.DurationVar(
+		&requeueBaseDelay,
+		"requeue-base-delay",
+		time.Second,
+		"The initial delay before a NodeCIDRAllocation is requeued after a failure or when some of its Nodes could not be allocated. The delay doubles on each consecutive requeue",
+	)
+	flag.DurationVar(
+		&requeueMaxDelay,
+		"requeue-max-delay",
+		5*time.Minute,
+		"The maximum delay between consecutive requeues of a NodeCIDRAllocation",
+	)
+	flag.IntVar(
+		&maxConcurrentReconciles,
+		"max-concurrent-reconciles",
+		1,
+		"The maximum number of NodeCIDRAllocations that are reconciled at the same time",
+	)
+	flag.BoolVar(
+		&enableNodeWebhook,
+		"enable-node-webhook",
+		false,
+		"If set, a mutating admission webhook assigns a PodCIDR to Nodes when they are created. The controller continues to allocate any Node that was not allocated at admission",
+	)
+	flag.BoolVar(
+		&enableNodeCapacityGuard,
+		"enable-node-capacity-guard",
+		false,
+		"If set, a validating admission webhook rejects the creation of Nodes when the matching NodeCIDRAllocation has no free subnet large enough for the Node",
+	)
+	flag.BoolVar(
+		&enablePoolRemovalGuard,
+		"enable-pool-removal-guard",
+		false,
+		"If set, a validating admission webhook rejects NodeCIDRAllocation updates that remove an address pool still holding Node allocations, unless the force-pool-removal annotation is set. The controller keeps such pools as draining pools regardless",
+	)
+	flag.IntVar(
+		&webhookPort,
+		"webhook-port",
+		9443,
+		"The port the webhook server binds to.",
+	)
+	flag.StringVar(
+		&ledgerNamespace,
+		"ledger-namespace",
+		lookupEnvOrDefault("POD_NAMESPACE", "cidr-allocator-system"),
+		"The namespace of the ConfigMap used to reserve PodCIDRs consistently across the controller and all webhook replicas, and to retain the PodCIDRs of deleted NodeCIDRAllocations",
+	)
+	flag.StringVar(
+		&ledgerName,
+		"ledger-name",
+		lookupEnvOrDefault("LEDGER_NAME", ledger.DefaultName),
+		"The name of the ConfigMap used to reserve PodCIDRs consistently across the controller and all webhook replicas, and to retain the PodCIDRs of deleted NodeCIDRAllocations",
+	)
+	flag.DurationVar(
+		&auditInterval,
+		"audit-interval",
+		5*time.Minute,
+		"How often every Node PodCIDR is classified as managed, foreign (inside or outside of the address pools) or orphaned. Set to 0 to disable the audit",
+	)
+	flag.StringVar(
+		&auditReportName,
+		"audit-report-name",
+		audit.DefaultReportName,
+		"The name of the ConfigMap (in the ledger namespace) which the audit report is published in",
+	)
+	flag.BoolVar(
+		&strictOverlapCheck,
+		"strict-overlap-check",
+		false,
+		"If set, the readiness check of every replica fails while overlapping Node PodCIDRs or static allocations are found (checked every --audit-interval). Requires the audit to be enabled",
+	)
+	flag.BoolVar(
+		&trimNodeCache,
+		"trim-node-cache",
+		false,
+		"If set, cached Nodes only hold their metadata, PodCIDRs, allocatable pods and PodCIDRAllocated condition, which reduces the memory used on large clusters",
+	)
 
 	opts := zap.Options{
 		Development: debugLogging,
@@ -150,16 +279,32 @@ func main() {
 	}
 
 	webhookServer := webhook.NewServer(webhook.Options{
+		Port:    webhookPort,
 		TLSOpts: tlsOpts,
 	})
 
+	cacheOptions := cache.Options{}
+	if trimNodeCache {
+		setupLog.Info("trimming cached Nodes")
+		cacheOptions.ByObject = map[client.Object]cache.ByObject{
+			&corev1.Node{}: {Transform: controller.TrimNode},
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOptions,
 		Metrics: metricsserver.Options{
 			BindAddress:   metricsAddr,
 			SecureServing: secureMetrics,
 			TLSOpts:       tlsOpts,
 		},
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				// generated ConfigMaps are only ever written by the controller. avoid caching every ConfigMap in the cluster
+				DisableFor: []client.Object{&corev1.ConfigMap{}},
+			},
+		},
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -170,14 +315,65 @@ func main() {
 		os.Exit(1)
 	}
 
+	nodeIntegrations := []integrations.Integration{}
+	if enableCiliumIntegration {
+		setupLog.Info("enabling Cilium integration")
+		nodeIntegrations = append(nodeIntegrations, integrations.NewCilium(mgr.GetClient()))
+	}
+	if enableCalicoIntegration {
+		setupLog.Info("enabling Calico integration")
+		nodeIntegrations = append(nodeIntegrations, integrations.NewCalico(mgr.GetClient()))
+	}
+
+	// the ledger serializes allocations with the Node admission webhook and keeps the PodCIDRs retained from deleted NodeCIDRAllocations
+	reservationLedger := ledger.New(mgr.GetClient(), mgr.GetAPIReader(), types.NamespacedName{Name: ledgerName, Namespace: ledgerNamespace})
+	if enableNodeWebhook {
+		setupLog.Info("enabling Node PodCIDR mutating webhook", "ledger", reservationLedger.Key())
+		statcan_webhook.SetupNodeWebhookWithManager(mgr, reservationLedger)
+	}
+	if enableNodeCapacityGuard {
+		setupLog.Info("enabling Node capacity guard validating webhook")
+		statcan_webhook.SetupNodeCapacityWebhookWithManager(mgr, reservationLedger)
+	}
+	if enablePoolRemovalGuard {
+		setupLog.Info("enabling NodeCIDRAllocation pool removal guard validating webhook")
+		statcan_webhook.SetupPoolRemovalWebhookWithManager(mgr)
+	}
+
 	if err = (&controller.NodeCIDRAllocationReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("NodeCIDRAllocationController"),
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		Recorder:     mgr.GetEventRecorderFor("NodeCIDRAllocationController"),
+		APIReader:    mgr.GetAPIReader(),
+		Ledger:       reservationLedger,
+		Integrations: nodeIntegrations,
+
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RequeueBaseDelay:        requeueBaseDelay,
+		RequeueMaxDelay:         requeueMaxDelay,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NodeCIDRAllocation")
 		os.Exit(1)
 	}
+	// the collector is registered once per process (not by the controller setup), since the registry is global.
+	// The capacity metrics are computed from the cache on each scrape rather than on every reconcile
+	if err := metrics.Registry.Register(statcan_metrics.NewCollector(mgr.GetClient())); err != nil {
+		setupLog.Error(err, "unable to register metrics collector")
+		os.Exit(1)
+	}
+	var auditor *audit.Auditor
+	if auditInterval > 0 {
+		auditor = &audit.Auditor{
+			Client:   mgr.GetClient(),
+			Recorder: mgr.GetEventRecorderFor("NodePodCIDRAudit"),
+			Key:      types.NamespacedName{Name: auditReportName, Namespace: ledgerNamespace},
+			Interval: auditInterval,
+		}
+		if err = auditor.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up Node PodCIDR audit")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -188,6 +384,31 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if strictOverlapCheck {
+		if auditor == nil {
+			setupLog.Error(nil, "--strict-overlap-check requires the audit to be enabled (--audit-interval greater than 0)")
+			os.Exit(1)
+		}
+		// the audit only runs on the leader, so every replica checks for overlaps from its own cache
+		overlapChecker := &audit.OverlapChecker{
+			Reader:   mgr.GetClient(),
+			Interval: auditInterval,
+		}
+		if err := overlapChecker.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up PodCIDR overlaps check")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("podcidr-overlaps", overlapChecker.Checker); err != nil {
+			setupLog.Error(err, "unable to set up PodCIDR overlaps ready check")
+			os.Exit(1)
+		}
+	}
+	if enableNodeWebhook || enableNodeCapacityGuard || enablePoolRemovalGuard {
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+	}
 
 	managerContext := ctrl.SetupSignalHandler()
 
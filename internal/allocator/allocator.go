@@ -0,0 +1,125 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package allocator implements the policy used to select a PodCIDR for a Node from the address pools of a NodeCIDRAllocation.
+// The policy is shared by the controller and the Node admission webhook so that both allocation paths make identical decisions
+package allocator
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+// ErrNoCapacity is returned when none of the address pools have a free subnet of the required size
+var ErrNoCapacity = ipam.ErrNoCapacity
+
+// strategy represents the strategy used to select subnets from the address pools
+var strategy ipam.Allocator = ipam.FirstFit{}
+
+// RequiredMask returns the network mask (ones) of the smallest subnet that can address the maximum number of Pods that can be run on the Node
+func RequiredMask(node *corev1.Node) uint8 {
+	return statcan_net.SmallestMaskForNumHosts(uint32(node.Status.Allocatable.Pods().Value()))
+}
+
+// FreeSubnets returns every subnet of the required size (given by ones) from the supplied address pools, in pool order,
+// that does not overlap with the PodCIDR of any of the supplied Nodes or with any of the reserved subnets
+func FreeSubnets(addressPools []string, ones uint8, nodes *corev1.NodeList, reservedSubnets []string) ([]string, error) {
+	pools, used, err := poolsAndUsedAddresses(addressPools, ones, nodes, reservedSubnets)
+	if err != nil {
+		return []string{}, err
+	}
+
+	free, err := strategy.Free(pools, int(ones), used)
+	if err != nil {
+		return []string{}, fmt.Errorf("unable to break down the address pools into /%d subnets: %w", ones, err)
+	}
+
+	subnets := make([]string, len(free))
+	for i, subnet := range free {
+		subnets[i] = subnet.String()
+	}
+
+	return subnets, nil
+}
+
+// Allocate returns the first subnet of the required size (given by ones) from the supplied address pools that does not overlap
+// with the PodCIDR of any of the supplied Nodes or with any of the reserved subnets. ErrNoCapacity is returned when no such subnet exists
+func Allocate(addressPools []string, ones uint8, nodes *corev1.NodeList, reservedSubnets []string) (string, error) {
+	pools, used, err := poolsAndUsedAddresses(addressPools, ones, nodes, reservedSubnets)
+	if err != nil {
+		return "", err
+	}
+
+	subnet, err := strategy.Allocate(pools, int(ones), used)
+	if err != nil {
+		return "", err
+	}
+
+	return subnet.String(), nil
+}
+
+// poolsAndUsedAddresses parses the supplied address pools, and returns them along with the addresses in use by the Nodes or reserved
+func poolsAndUsedAddresses(addressPools []string, ones uint8, nodes *corev1.NodeList, reservedSubnets []string) ([]ipam.Pool, *ipam.Set, error) {
+	pools := make([]ipam.Pool, 0, len(addressPools))
+	for _, addressPool := range addressPools {
+		pool, err := ipam.ParsePool(addressPool)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to break down address pool %s into /%d subnets: %w", addressPool, ones, err)
+		}
+		pools = append(pools, pool)
+	}
+
+	used, err := statcan_net.UsedAddresses(nodes, reservedSubnets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to determine the subnets that are already allocated: %w", err)
+	}
+
+	return pools, used, nil
+}
+
+// PoolFor returns the first of the supplied address pools which contains the supplied subnet, or an empty string when no pool contains it
+func PoolFor(addressPools []string, subnet string) string {
+	for _, pool := range addressPools {
+		if contains, err := statcan_net.NetworkContains(pool, subnet); err == nil && contains {
+			return pool
+		}
+	}
+
+	return ""
+}
+
+// OwnsNode returns true when the supplied Node holds a PodCIDR owned by the NodeCIDRAllocation. A Node is owned when it was annotated
+// with the NodeCIDRAllocation at allocation, or (for Nodes allocated without the annotation) when the NodeCIDRAllocation selects it and
+// its PodCIDR lies within one of the address pools
+func OwnsNode(nodeCIDRAllocation *Resolved, node *corev1.Node) bool {
+	if node.Spec.PodCIDR == "" {
+		return false
+	}
+
+	if owner, ok := node.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation]; ok {
+		return owner == types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+	}
+
+	return labels.SelectorFromSet(nodeCIDRAllocation.Spec.NodeSelector).Matches(labels.Set(node.GetLabels())) &&
+		PoolFor(nodeCIDRAllocation.AddressPools(), node.Spec.PodCIDR) != ""
+}
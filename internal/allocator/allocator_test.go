@@ -0,0 +1,128 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+)
+
+func TestRequiredMask(t *testing.T) {
+	// Case 1: Node with 110 allocatable pods
+	// expected: should return 25 since 110+2(reserved) requires 128 addresses
+	node := corev1.Node{
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourcePods: *resource.NewQuantity(110, resource.DecimalSI),
+			},
+		},
+	}
+
+	if got := allocator.RequiredMask(&node); got != 25 {
+		t.Errorf("got %d, wanted %d", got, 25)
+	}
+}
+
+func TestFreeSubnets(t *testing.T) {
+	nodes := corev1.NodeList{
+		Items: []corev1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeA"},
+				Spec:       corev1.NodeSpec{PodCIDR: "10.0.0.0/26"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeB"},
+			},
+		},
+	}
+
+	// Case 1: Single pool with a Node allocation and a reserved subnet
+	// expected: should return the remaining subnets of the pool in order
+	got, err := allocator.FreeSubnets([]string{"10.0.0.0/24"}, 26, &nodes, []string{"10.0.0.128/26"})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := []string{"10.0.0.64/26", "10.0.0.192/26"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 2: Multiple pools where the first pool is exhausted
+	// expected: should return the subnets of the second pool
+	got, err = allocator.FreeSubnets([]string{"10.0.0.0/26", "10.1.0.0/25"}, 26, &nodes, []string{})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := []string{"10.1.0.0/26", "10.1.0.64/26"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 3: Invalid address pool
+	// expected: should error
+	if _, err := allocator.FreeSubnets([]string{"10.0.0.0"}, 26, &nodes, []string{}); err == nil {
+		t.Error("function was expected to error, but did not")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	nodes := corev1.NodeList{
+		Items: []corev1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeA"},
+				Spec:       corev1.NodeSpec{PodCIDR: "10.0.0.0/26"},
+			},
+		},
+	}
+
+	// Case 1: Pool with free capacity
+	// expected: should return the first free subnet
+	got, err := allocator.Allocate([]string{"10.0.0.0/24"}, 26, &nodes, []string{"10.0.0.64/26"})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.128/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.128/26")
+	}
+
+	// Case 2: Pool without free capacity
+	// expected: should return ErrNoCapacity
+	if _, err := allocator.Allocate([]string{"10.0.0.0/25"}, 26, &nodes, []string{"10.0.0.64/26"}); !errors.Is(err, allocator.ErrNoCapacity) {
+		t.Errorf("got %v, wanted %v", err, allocator.ErrNoCapacity)
+	}
+}
+
+func TestPoolFor(t *testing.T) {
+	pools := []string{"10.0.0.0/24", "10.1.0.0/16"}
+
+	// Case 1: Subnet within the second pool
+	// expected: should return the second pool
+	if got := allocator.PoolFor(pools, "10.1.4.0/26"); got != "10.1.0.0/16" {
+		t.Errorf("got %s, wanted %s", got, "10.1.0.0/16")
+	}
+
+	// Case 2: Subnet outside of every pool
+	// expected: should return an empty string
+	if got := allocator.PoolFor(pools, "10.2.0.0/26"); got != "" {
+		t.Errorf("got %s, wanted %s", got, "")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+// Pins holds the pinned allocations of a NodeCIDRAllocation along with the reason that each of them can never be honored (if any). Every pin
+// is validated once when the Pins are created, so that matching Nodes to their pin does not validate the pins again for every Node
+type Pins struct {
+	pins    []v1alpha1.PinnedAllocation
+	invalid []string
+}
+
+// NewPins validates the pinned allocations of the NodeCIDRAllocation at the supplied time. A pinned PodCIDR must be a network address inside
+// one of the address pools, and must not overlap a static allocation, an unexpired reservation or a pinned PodCIDR listed before it
+func NewPins(nodeCIDRAllocation *Resolved, now time.Time) *Pins {
+	pools := nodeCIDRAllocation.AddressPools()
+	reservations := nodeCIDRAllocation.UnexpiredReservations(now)
+
+	p := &Pins{
+		pins:    nodeCIDRAllocation.Spec.PinnedAllocations,
+		invalid: make([]string, len(nodeCIDRAllocation.Spec.PinnedAllocations)),
+	}
+	for i := range p.pins {
+		p.invalid[i] = invalidPin(p.pins, i, pools, nodeCIDRAllocation.Spec.StaticAllocations, reservations)
+	}
+
+	return p
+}
+
+// invalidPin returns the reason that the pin at the supplied index can never be honored, or an empty string when it is valid
+func invalidPin(pins []v1alpha1.PinnedAllocation, index int, pools, staticAllocations []string, reservations []ipam.Reservation) string {
+	pin := pins[index]
+	if pin.NodeName == "" && len(pin.NodeSelector) == 0 {
+		return "pinned allocation does not name or select a Node"
+	}
+
+	prefix, err := netip.ParsePrefix(pin.PodCIDR)
+	if err != nil {
+		return fmt.Sprintf("PodCIDR is not a valid network: %s", err)
+	}
+	if prefix.Masked() != prefix {
+		return fmt.Sprintf("PodCIDR is not a network address (did you mean %s?)", prefix.Masked())
+	}
+
+	if PoolFor(pools, pin.PodCIDR) == "" {
+		return fmt.Sprintf("PodCIDR is outside of the address pools %v", pools)
+	}
+
+	for _, static := range staticAllocations {
+		if overlap, err := statcan_net.NetworksOverlap(static, pin.PodCIDR); err == nil && overlap {
+			return fmt.Sprintf("PodCIDR overlaps static allocation %s", static)
+		}
+	}
+
+	for _, reservation := range reservations {
+		if reservation.Prefix.Overlaps(prefix) {
+			return fmt.Sprintf("PodCIDR overlaps reservation %s (%s)", reservation.Name, reservation.Prefix)
+		}
+	}
+
+	for _, other := range pins[:index] {
+		if overlap, err := statcan_net.NetworksOverlap(other.PodCIDR, pin.PodCIDR); err == nil && overlap {
+			return fmt.Sprintf("PodCIDR overlaps pinned PodCIDR %s", other.PodCIDR)
+		}
+	}
+
+	return ""
+}
+
+// Invalid returns the reason that the pinned allocation at the supplied index can never be honored, or an empty string when it is valid
+func (p *Pins) Invalid(index int) string {
+	return p.invalid[index]
+}
+
+// Subnets returns the PodCIDR of every valid pinned allocation. Pinned PodCIDRs are reserved for their Node and are never allocated dynamically
+func (p *Pins) Subnets() []string {
+	subnets := []string{}
+	for i, pin := range p.pins {
+		if p.invalid[i] == "" {
+			subnets = append(subnets, pin.PodCIDR)
+		}
+	}
+
+	return subnets
+}
+
+// For returns the PodCIDR pinned to the supplied Node, or an empty string when the Node is not pinned.
+// A valid pin naming the Node takes precedence over a valid pin selecting it by its labels
+func (p *Pins) For(node *corev1.Node) string {
+	selected := ""
+	for i, pin := range p.pins {
+		if p.invalid[i] != "" {
+			continue
+		}
+
+		if pin.NodeName != "" {
+			if pin.NodeName == node.GetName() {
+				return pin.PodCIDR
+			}
+
+			continue
+		}
+
+		if selected == "" && labels.SelectorFromSet(pin.NodeSelector).Matches(labels.Set(node.GetLabels())) {
+			selected = pin.PodCIDR
+		}
+	}
+
+	return selected
+}
+
+// PinnedSubnets returns the PodCIDRs pinned by every NodeCIDRAllocation in the cluster at the supplied time. A PodCIDR pinned to a Node is never
+// allocated dynamically, whichever NodeCIDRAllocation the allocation is made from. A referenced AddressPool that does not exist does not
+// contribute any address pool, so pins inside it are invalid
+func PinnedSubnets(ctx context.Context, reader client.Reader, now time.Time) ([]string, error) {
+	nodeCIDRAllocations := v1alpha1.NodeCIDRAllocationList{}
+	if err := reader.List(ctx, &nodeCIDRAllocations); err != nil {
+		return nil, fmt.Errorf("unable to list NodeCIDRAllocations: %w", err)
+	}
+
+	pinning := []*v1alpha1.NodeCIDRAllocation{}
+	for i := range nodeCIDRAllocations.Items {
+		if len(nodeCIDRAllocations.Items[i].Spec.PinnedAllocations) > 0 {
+			pinning = append(pinning, &nodeCIDRAllocations.Items[i])
+		}
+	}
+	if len(pinning) == 0 {
+		return nil, nil
+	}
+
+	resolved, err := Resolve(ctx, reader, pinning...)
+	if err != nil && !errors.Is(err, ErrAddressPoolNotFound) {
+		return nil, err
+	}
+
+	subnets := []string{}
+	for _, nodeCIDRAllocation := range resolved {
+		subnets = append(subnets, NewPins(nodeCIDRAllocation, now).Subnets()...)
+	}
+
+	return subnets, nil
+}
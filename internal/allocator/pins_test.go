@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+)
+
+func newPinnedNodeCIDRAllocation(pins ...v1alpha1.PinnedAllocation) *allocator.Resolved {
+	return &allocator.Resolved{NodeCIDRAllocation: &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools:      []string{"10.0.0.0/24"},
+			StaticAllocations: []string{"10.0.0.192/26"},
+			PinnedAllocations: pins,
+		},
+	}}
+}
+
+func TestNewPins(t *testing.T) {
+	nodeCIDRAllocation := newPinnedNodeCIDRAllocation(
+		v1alpha1.PinnedAllocation{NodeName: "testNodeA", PodCIDR: "10.0.0.0/26"},
+		v1alpha1.PinnedAllocation{PodCIDR: "10.0.0.64/26"},
+		v1alpha1.PinnedAllocation{NodeName: "testNodeB", PodCIDR: "10.0.0.65/26"},
+		v1alpha1.PinnedAllocation{NodeName: "testNodeB", PodCIDR: "10.1.0.0/26"},
+		v1alpha1.PinnedAllocation{NodeName: "testNodeB", PodCIDR: "10.0.0.192/27"},
+		v1alpha1.PinnedAllocation{NodeName: "testNodeB", PodCIDR: "10.0.0.0/27"},
+		v1alpha1.PinnedAllocation{NodeSelector: map[string]string{"role": "router"}, PodCIDR: "10.0.0.128/26"},
+	)
+
+	// Case 1: Every kind of pinned allocation
+	// expected: only the first and last pins are valid, the others are reported with their reason
+	want := []string{"", "does not name or select a Node", "not a network address", "outside of the address pools", "overlaps static allocation", "overlaps pinned PodCIDR 10.0.0.0/26", ""}
+	pins := allocator.NewPins(nodeCIDRAllocation, time.Now())
+	for i, substring := range want {
+		got := pins.Invalid(i)
+		if (substring == "" && got != "") || !strings.Contains(got, substring) {
+			t.Errorf("pin %d: got %q, wanted %q", i, got, substring)
+		}
+	}
+
+	// Case 2: Pinned subnets of the NodeCIDRAllocation
+	// expected: only the valid pins are returned
+	if got, want := pins.Subnets(), []string{"10.0.0.0/26", "10.0.0.128/26"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+}
+
+func TestPinsFor(t *testing.T) {
+	pins := allocator.NewPins(newPinnedNodeCIDRAllocation(
+		v1alpha1.PinnedAllocation{NodeSelector: map[string]string{"role": "router"}, PodCIDR: "10.0.0.128/26"},
+		v1alpha1.PinnedAllocation{NodeName: "testNodeA", PodCIDR: "10.0.0.0/26"},
+		v1alpha1.PinnedAllocation{NodeName: "testNodeC", PodCIDR: "10.1.0.0/26"},
+	), time.Now())
+	newNode := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	// Case 1: Node pinned by name and selected by a pinned node selector
+	// expected: the pin naming the Node takes precedence
+	if got := pins.For(newNode("testNodeA", map[string]string{"role": "router"})); got != "10.0.0.0/26" {
+		t.Errorf("got %q, wanted %q", got, "10.0.0.0/26")
+	}
+
+	// Case 2: Node selected by a pinned node selector only
+	// expected: should return the PodCIDR pinned by the node selector
+	if got := pins.For(newNode("testNodeB", map[string]string{"role": "router"})); got != "10.0.0.128/26" {
+		t.Errorf("got %q, wanted %q", got, "10.0.0.128/26")
+	}
+
+	// Case 3: Node named by an invalid pin
+	// expected: the Node is not pinned
+	if got := pins.For(newNode("testNodeC", nil)); got != "" {
+		t.Errorf("got %q, wanted %q", got, "")
+	}
+}
+
+func TestPinnedSubnets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("unable to build scheme. got %e", err)
+	}
+
+	pinningA := newPinnedNodeCIDRAllocation(
+		v1alpha1.PinnedAllocation{NodeName: "testNodeA", PodCIDR: "10.0.0.0/26"},
+		v1alpha1.PinnedAllocation{NodeName: "testNodeB", PodCIDR: "10.1.0.0/26"},
+	).NodeCIDRAllocation
+	pinningB := &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocationB", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPoolRefs:   []string{"shared", "missing"},
+			PinnedAllocations: []v1alpha1.PinnedAllocation{{NodeName: "testNodeC", PodCIDR: "10.2.0.64/26"}},
+		},
+	}
+	unpinned := &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocationC", Namespace: "default"},
+		Spec:       v1alpha1.NodeCIDRAllocationSpec{AddressPools: []string{"10.3.0.0/24"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pinningA, pinningB, unpinned, newAddressPool("shared", "10.2.0.0/24")).Build()
+
+	// Case 1: Two NodeCIDRAllocations pin PodCIDRs (one of them from a referenced AddressPool, alongside a missing AddressPool) and a third does not
+	// expected: the valid pins of every NodeCIDRAllocation are returned
+	got, err := allocator.PinnedSubnets(context.Background(), c, time.Now())
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := []string{"10.0.0.0/26", "10.2.0.64/26"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+// ErrAddressPoolNotFound is returned when an AddressPool referenced by a NodeCIDRAllocation does not exist
+var ErrAddressPoolNotFound = errors.New("referenced AddressPool not found")
+
+// Resolved is a NodeCIDRAllocation along with the AddressPools referenced by its .spec.addressPoolRefs. The CIDRs and reservations of the
+// referenced AddressPools are used alongside the inline address pools and reservations of the NodeCIDRAllocation
+type Resolved struct {
+	*v1alpha1.NodeCIDRAllocation
+
+	// ReferencedPools holds the referenced AddressPools that exist, in the order that they are referenced
+	ReferencedPools []v1alpha1.AddressPool
+
+	// RetainedPools holds the address pools that were removed from the NodeCIDRAllocation (or from its referenced AddressPools) while Nodes
+	// that it owns still hold a PodCIDR from them. They are kept as draining address pools: the Nodes on them stay owned, but no new
+	// allocation is made from them
+	RetainedPools []string
+
+	reservations []ipam.Reservation
+}
+
+// ResolveFrom resolves the AddressPools referenced by the NodeCIDRAllocation from the supplied AddressPools, and returns the names of the
+// referenced AddressPools that could not be found. The removed address pools that were retained at the last reconcile (as recorded in the
+// status) are retained again, unless they were added back
+func ResolveFrom(nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, addressPools []v1alpha1.AddressPool) (*Resolved, []string) {
+	resolved := &Resolved{NodeCIDRAllocation: nodeCIDRAllocation}
+
+	missing := []string{}
+	for _, name := range nodeCIDRAllocation.Spec.AddressPoolRefs {
+		i := slices.IndexFunc(addressPools, func(p v1alpha1.AddressPool) bool { return p.GetName() == name })
+		if i < 0 {
+			missing = append(missing, name)
+			continue
+		}
+
+		resolved.ReferencedPools = append(resolved.ReferencedPools, *addressPools[i].DeepCopy())
+	}
+
+	pools := resolved.AddressPools()
+	for _, drainingPool := range nodeCIDRAllocation.Status.Draining {
+		if drainingPool.Removed && !slices.Contains(pools, drainingPool.Pool) {
+			resolved.RetainedPools = append(resolved.RetainedPools, drainingPool.Pool)
+		}
+	}
+
+	return resolved, missing
+}
+
+// Resolve resolves the AddressPools referenced by each of the supplied NodeCIDRAllocations. AddressPools are only listed when at least one
+// of the NodeCIDRAllocations references one. ErrAddressPoolNotFound is returned (along with the missing names) when a referenced AddressPool
+// does not exist. The AddressPools that were found are resolved regardless
+func Resolve(ctx context.Context, reader client.Reader, nodeCIDRAllocations ...*v1alpha1.NodeCIDRAllocation) ([]*Resolved, error) {
+	addressPools := v1alpha1.AddressPoolList{}
+	if slices.ContainsFunc(nodeCIDRAllocations, func(n *v1alpha1.NodeCIDRAllocation) bool { return len(n.Spec.AddressPoolRefs) > 0 }) {
+		if err := reader.List(ctx, &addressPools); err != nil {
+			return nil, fmt.Errorf("unable to list AddressPools: %w", err)
+		}
+	}
+
+	resolved := make([]*Resolved, len(nodeCIDRAllocations))
+	errs := []error{}
+	for i, nodeCIDRAllocation := range nodeCIDRAllocations {
+		var missing []string
+		if resolved[i], missing = ResolveFrom(nodeCIDRAllocation, addressPools.Items); len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("%w: NodeCIDRAllocation %s/%s references %v", ErrAddressPoolNotFound, nodeCIDRAllocation.GetNamespace(), nodeCIDRAllocation.GetName(), missing))
+		}
+	}
+
+	return resolved, errors.Join(errs...)
+}
+
+// ResolveList resolves the AddressPools referenced by every NodeCIDRAllocation in the supplied list (see Resolve)
+func ResolveList(ctx context.Context, reader client.Reader, nodeCIDRAllocations *v1alpha1.NodeCIDRAllocationList) ([]*Resolved, error) {
+	items := make([]*v1alpha1.NodeCIDRAllocation, len(nodeCIDRAllocations.Items))
+	for i := range nodeCIDRAllocations.Items {
+		items[i] = &nodeCIDRAllocations.Items[i]
+	}
+
+	return Resolve(ctx, reader, items...)
+}
+
+// AddressPools will return every address pool of the NodeCIDRAllocation: the inline address pools followed by the CIDRs of the referenced
+// AddressPools and by the retained address pools (without duplicates)
+func (r *Resolved) AddressPools() []string {
+	pools := append([]string{}, r.Spec.AddressPools...)
+	for _, addressPool := range r.ReferencedPools {
+		for _, cidr := range addressPool.Spec.CIDRs {
+			if !slices.Contains(pools, cidr) {
+				pools = append(pools, cidr)
+			}
+		}
+	}
+	for _, pool := range r.RetainedPools {
+		if !slices.Contains(pools, pool) {
+			pools = append(pools, pool)
+		}
+	}
+
+	return pools
+}
+
+// AllocatablePools will return the address pools that new allocations can be made from (every address pool that is neither draining
+// nor retained)
+func (r *Resolved) AllocatablePools() []string {
+	pools := []string{}
+	for _, pool := range r.AddressPools() {
+		if !slices.Contains(r.Spec.DrainingPools, pool) && !slices.Contains(r.RetainedPools, pool) {
+			pools = append(pools, pool)
+		}
+	}
+
+	return pools
+}
+
+// Reservations will return every reservation of the NodeCIDRAllocation followed by the reservations of its referenced AddressPools,
+// whether or not they have expired. The reservations are converted to ipam.Reservations once, on first use, and reservations whose CIDR is
+// not a valid network are ignored
+func (r *Resolved) Reservations() []ipam.Reservation {
+	if r.reservations != nil {
+		return r.reservations
+	}
+
+	r.reservations = appendReservations([]ipam.Reservation{}, r.Spec.Reservations)
+	for _, addressPool := range r.ReferencedPools {
+		r.reservations = appendReservations(r.reservations, addressPool.Spec.Reservations)
+	}
+
+	return r.reservations
+}
+
+// appendReservations converts the supplied AddressReservations to ipam.Reservations and appends them to reservations
+func appendReservations(reservations []ipam.Reservation, addressReservations []v1alpha1.AddressReservation) []ipam.Reservation {
+	for _, addressReservation := range addressReservations {
+		prefix, err := netip.ParsePrefix(addressReservation.CIDR)
+		if err != nil {
+			continue
+		}
+
+		reservation := ipam.Reservation{
+			Name:   addressReservation.Name,
+			Prefix: prefix,
+			Owner:  addressReservation.Owner,
+			Reason: addressReservation.Reason,
+		}
+		if addressReservation.ExpiresAt != nil {
+			reservation.ExpiresAt = addressReservation.ExpiresAt.Time
+		}
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations
+}
+
+// UnexpiredReservations will return the reservations of the NodeCIDRAllocation and of its referenced AddressPools that have not expired at
+// the supplied time
+func (r *Resolved) UnexpiredReservations(now time.Time) []ipam.Reservation {
+	return ipam.Unexpired(r.Reservations(), now)
+}
+
+// ReservedSubnets will return every range that the NodeCIDRAllocation reserves from being allocated at the supplied time: the static allocations
+// along with the ranges of the reservations that have not expired
+func (r *Resolved) ReservedSubnets(now time.Time) []string {
+	subnets := append([]string{}, r.Spec.StaticAllocations...)
+	for _, reservation := range r.UnexpiredReservations(now) {
+		subnets = append(subnets, reservation.Prefix.String())
+	}
+
+	return subnets
+}
+
+// AddressPoolCapacity returns the number of addresses in the CIDRs of the AddressPool, and the number of those addresses that are allocated
+// to the supplied Nodes. Overlapping CIDRs and PodCIDRs are only counted once, and PodCIDRs that only partly overlap the AddressPool only
+// count the addresses that they share with it
+func AddressPoolCapacity(addressPool *v1alpha1.AddressPool, nodes *corev1.NodeList) (*big.Int, *big.Int) {
+	pool := statcan_net.SetFromCIDRs(addressPool.Spec.CIDRs...)
+
+	podCIDRs := make([]string, len(nodes.Items))
+	for i := range nodes.Items {
+		podCIDRs[i] = nodes.Items[i].Spec.PodCIDR
+	}
+	allocated := statcan_net.SetFromCIDRs(podCIDRs...)
+
+	return pool.Size(), pool.Intersect(allocated).Size()
+}
@@ -0,0 +1,176 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+func newAddressPool(name string, cidrs ...string) *v1alpha1.AddressPool {
+	return &v1alpha1.AddressPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1alpha1.AddressPoolSpec{CIDRs: cidrs},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("unable to build scheme. got %e", err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newAddressPool("a", "10.0.0.0/24", "10.1.0.0/24"),
+		newAddressPool("b", "10.1.0.0/24", "10.2.0.0/24"),
+	).Build()
+
+	// Case 1: The NodeCIDRAllocation has inline address pools and references two AddressPools that share a CIDR
+	// expected: the address pools are the inline address pools followed by the CIDRs of the AddressPools, without duplicates
+	nodeCIDRAllocation := &v1alpha1.NodeCIDRAllocation{
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools:    []string{"192.168.0.0/24"},
+			AddressPoolRefs: []string{"a", "b"},
+		},
+	}
+	resolved, err := allocator.Resolve(context.Background(), c, nodeCIDRAllocation)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	want := []string{"192.168.0.0/24", "10.0.0.0/24", "10.1.0.0/24", "10.2.0.0/24"}
+	if got := resolved[0].AddressPools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 2: The NodeCIDRAllocation references an AddressPool that does not exist
+	// expected: ErrAddressPoolNotFound is returned and the AddressPools that exist are still resolved
+	nodeCIDRAllocation.Spec.AddressPoolRefs = []string{"a", "missing"}
+	resolved, err = allocator.Resolve(context.Background(), c, nodeCIDRAllocation)
+	if !errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		t.Errorf("got %v, wanted %v", err, allocator.ErrAddressPoolNotFound)
+	}
+	want = []string{"192.168.0.0/24", "10.0.0.0/24", "10.1.0.0/24"}
+	if got := resolved[0].AddressPools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 3: The status reports a removed address pool that is still retained, and one that was added back to the NodeCIDRAllocation
+	// expected: the removed address pool is retained, so it is one of the address pools, but new allocations are not made from it
+	nodeCIDRAllocation.Spec.AddressPoolRefs = []string{"a"}
+	nodeCIDRAllocation.Status.Draining = []v1alpha1.DrainingPool{
+		{Pool: "10.2.0.0/24", Nodes: []string{"testNode"}, Removed: true},
+		{Pool: "192.168.0.0/24", Removed: true},
+	}
+	resolved, err = allocator.Resolve(context.Background(), c, nodeCIDRAllocation)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	want = []string{"192.168.0.0/24", "10.0.0.0/24", "10.1.0.0/24", "10.2.0.0/24"}
+	if got := resolved[0].AddressPools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+	want = []string{"192.168.0.0/24", "10.0.0.0/24", "10.1.0.0/24"}
+	if got := resolved[0].AllocatablePools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+}
+
+func TestResolvedReservations(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	addressPool := newAddressPool("a", "10.0.0.0/24")
+	addressPool.Spec.Reservations = []v1alpha1.AddressReservation{
+		{Name: "expired", CIDR: "10.0.0.64/26", ExpiresAt: &metav1.Time{Time: now.Add(-time.Minute)}},
+		{Name: "expiring", CIDR: "10.0.0.128/26", ExpiresAt: &metav1.Time{Time: now.Add(time.Hour)}},
+	}
+	resolved, _ := allocator.ResolveFrom(&v1alpha1.NodeCIDRAllocation{
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPoolRefs:   []string{"a"},
+			StaticAllocations: []string{"10.0.0.0/28"},
+			Reservations: []v1alpha1.AddressReservation{
+				{Name: "gateways", CIDR: "10.0.0.16/28", Owner: "network"},
+				{Name: "invalid", CIDR: "10.0.0.300/28"},
+			},
+		},
+	}, []v1alpha1.AddressPool{*addressPool})
+
+	// Case 1: The NodeCIDRAllocation and its referenced AddressPool hold reservations, one of which has an invalid CIDR
+	// expected: every valid reservation is converted, the inline reservations first
+	want := []ipam.Reservation{
+		{Name: "gateways", Prefix: netip.MustParsePrefix("10.0.0.16/28"), Owner: "network"},
+		{Name: "expired", Prefix: netip.MustParsePrefix("10.0.0.64/26"), ExpiresAt: now.Add(-time.Minute)},
+		{Name: "expiring", Prefix: netip.MustParsePrefix("10.0.0.128/26"), ExpiresAt: now.Add(time.Hour)},
+	}
+	if got := resolved.Reservations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 2: One of the reservations has expired
+	// expected: the static allocations and the unexpired reservations are reserved
+	wantSubnets := []string{"10.0.0.0/28", "10.0.0.16/28", "10.0.0.128/26"}
+	if got := resolved.ReservedSubnets(now); !reflect.DeepEqual(got, wantSubnets) {
+		t.Errorf("got %v, wanted %v", got, wantSubnets)
+	}
+}
+
+func TestAddressPoolCapacity(t *testing.T) {
+	nodes := &corev1.NodeList{Items: []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Spec: corev1.NodeSpec{PodCIDR: "10.0.0.0/26"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Spec: corev1.NodeSpec{PodCIDR: "10.0.0.0/26"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c"}, Spec: corev1.NodeSpec{PodCIDR: "10.1.0.0/25"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "d"}, Spec: corev1.NodeSpec{PodCIDR: "192.168.0.0/24"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "e"}},
+	}}
+
+	// Case 1: Nodes hold PodCIDRs inside and outside of the AddressPool, two of them the same
+	// expected: every address of the AddressPool is counted and each PodCIDR inside of it is only counted once
+	addresses, allocated := allocator.AddressPoolCapacity(newAddressPool("a", "10.0.0.0/24", "10.1.0.0/24"), nodes)
+	if addresses.Int64() != 512 {
+		t.Errorf("got %d, wanted %d", addresses, 512)
+	}
+	if allocated.Int64() != 192 {
+		t.Errorf("got %d, wanted %d", allocated, 192)
+	}
+
+	// Case 2: The AddressPool holds overlapping CIDRs and a Node PodCIDR only partly overlaps it
+	// expected: overlapping addresses are counted once and only the overlapping part of the PodCIDR is allocated
+	addresses, allocated = allocator.AddressPoolCapacity(newAddressPool("b", "10.0.0.0/25", "10.0.0.0/26", "192.168.0.0/25"), nodes)
+	if addresses.Int64() != 256 {
+		t.Errorf("got %d, wanted %d", addresses, 256)
+	}
+	if allocated.Int64() != 192 {
+		t.Errorf("got %d, wanted %d", allocated, 192)
+	}
+
+	// Case 3: The AddressPool is an IPv6 network larger than an int64 can hold
+	// expected: every address is counted
+	addresses, _ = allocator.AddressPoolCapacity(newAddressPool("c", "fd00::/56"), nodes)
+	if addresses.String() != "4722366482869645213696" {
+		t.Errorf("got %s, wanted %s", addresses, "4722366482869645213696")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+var (
+	// ErrInvalidRequest is returned when the PodCIDR requested by the annotations of a Node can never be honored by the NodeCIDRAllocation
+	ErrInvalidRequest = errors.New("invalid PodCIDR request")
+	// ErrRequestCollides is returned when the PodCIDR requested by a Node overlaps the PodCIDR of another Node or a reserved subnet
+	ErrRequestCollides = errors.New("requested PodCIDR collides with an existing allocation")
+)
+
+// Request represents the PodCIDR that a Node is allocated by a NodeCIDRAllocation
+type Request struct {
+	// PodCIDR represents the exact PodCIDR requested for the Node (if any)
+	PodCIDR string
+	// Ones represents the network mask (ones) of the PodCIDR
+	Ones uint8
+	// Pools represents the address pools that the PodCIDR can be allocated from
+	Pools []string
+}
+
+// RequestFor returns the PodCIDR request of the supplied Node. Unless the Node is annotated otherwise, a PodCIDR sized from its allocatable pods
+// is requested from any address pool of the NodeCIDRAllocation that is not draining. The Node annotations can request an exact PodCIDR,
+// a prefix length or an address pool, each of which must fit the address pools of the NodeCIDRAllocation and the allocatable pods of the Node.
+// An error wrapping ErrInvalidRequest is returned when the annotations request a PodCIDR that can never be honored
+func RequestFor(nodeCIDRAllocation *Resolved, node *corev1.Node) (Request, error) {
+	annotations := node.GetAnnotations()
+	maxPods := node.Status.Allocatable.Pods().Value()
+	request := Request{
+		Ones:  RequiredMask(node),
+		Pools: nodeCIDRAllocation.AllocatablePools(),
+	}
+
+	if pool, ok := annotations[v1alpha1.AnnotationRequestedPool]; ok {
+		if !slices.Contains(request.Pools, pool) {
+			return Request{}, fmt.Errorf("%w: %s is not an address pool of NodeCIDRAllocation %s that can be allocated from", ErrInvalidRequest, pool, nodeCIDRAllocation.GetName())
+		}
+
+		request.Pools = []string{pool}
+	}
+
+	if value, ok := annotations[v1alpha1.AnnotationRequestedPrefixLength]; ok {
+		ones, err := strconv.ParseUint(strings.TrimPrefix(value, "/"), 10, 8)
+		if err != nil || ones > 32 {
+			return Request{}, fmt.Errorf("%w: %q is not a valid prefix length", ErrInvalidRequest, value)
+		}
+
+		request.Ones = uint8(ones)
+	}
+
+	if value, ok := annotations[v1alpha1.AnnotationRequestedPodCIDR]; ok {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil || !prefix.Addr().Is4() || prefix.Masked() != prefix {
+			return Request{}, fmt.Errorf("%w: %q is not a valid IPv4 network address", ErrInvalidRequest, value)
+		}
+		if _, ok := annotations[v1alpha1.AnnotationRequestedPrefixLength]; ok && uint8(prefix.Bits()) != request.Ones {
+			return Request{}, fmt.Errorf("%w: PodCIDR %s does not match the requested prefix length /%d", ErrInvalidRequest, value, request.Ones)
+		}
+
+		request.PodCIDR = value
+		request.Ones = uint8(prefix.Bits())
+	}
+
+	if maxPods > 0 && request.Ones > RequiredMask(node) {
+		return Request{}, fmt.Errorf("%w: a /%d cannot address the %d allocatable pods of the Node (at most /%d)", ErrInvalidRequest, request.Ones, maxPods, RequiredMask(node))
+	}
+
+	if request.PodCIDR != "" {
+		if PoolFor(request.Pools, request.PodCIDR) == "" {
+			return Request{}, fmt.Errorf("%w: PodCIDR %s is outside of the address pools %v", ErrInvalidRequest, request.PodCIDR, request.Pools)
+		}
+
+		return request, nil
+	}
+
+	// address pools smaller than the requested PodCIDR cannot be broken down into subnets of its size
+	fitting := []string{}
+	for _, pool := range request.Pools {
+		if prefix, err := netip.ParsePrefix(pool); err != nil || prefix.Bits() <= int(request.Ones) {
+			fitting = append(fitting, pool)
+		}
+	}
+	if _, ok := annotations[v1alpha1.AnnotationRequestedPrefixLength]; ok && len(fitting) == 0 {
+		return Request{}, fmt.Errorf("%w: a /%d is larger than the address pools %v", ErrInvalidRequest, request.Ones, request.Pools)
+	}
+	request.Pools = fitting
+
+	return request, nil
+}
+
+// Free returns every subnet that the request can be satisfied with, in pool order, that does not overlap with the PodCIDR of any of the
+// supplied Nodes or with any of the reserved subnets. An error wrapping ErrRequestCollides is returned when an exact PodCIDR was requested
+// and it is not free
+func (r Request) Free(nodes *corev1.NodeList, reservedSubnets []string) ([]string, error) {
+	if r.PodCIDR == "" {
+		return FreeSubnets(r.Pools, r.Ones, nodes, reservedSubnets)
+	}
+
+	allocated, err := statcan_net.NetworkAllocated(r.PodCIDR, nodes, reservedSubnets)
+	if err != nil {
+		return []string{}, fmt.Errorf("unable to determine whether subnet %s is already allocated: %w", r.PodCIDR, err)
+	}
+	if allocated {
+		return []string{}, fmt.Errorf("%w: %s", ErrRequestCollides, r.PodCIDR)
+	}
+
+	return []string{r.PodCIDR}, nil
+}
+
+// Pick returns the first subnet that the request can be satisfied with (see Free). ErrNoCapacity is returned when no such subnet exists
+func (r Request) Pick(nodes *corev1.NodeList, reservedSubnets []string) (string, error) {
+	free, err := r.Free(nodes, reservedSubnets)
+	if err != nil {
+		return "", err
+	}
+
+	if len(free) == 0 {
+		return "", ErrNoCapacity
+	}
+
+	return free[0], nil
+}
@@ -0,0 +1,141 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+)
+
+func newRequestingNode(annotations map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "testNode", Annotations: annotations},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourcePods: *resource.NewQuantity(62, resource.DecimalSI),
+			},
+		},
+	}
+}
+
+func TestRequestFor(t *testing.T) {
+	nodeCIDRAllocation := &allocator.Resolved{NodeCIDRAllocation: &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools:  []string{"10.0.0.0/24", "10.1.0.0/22", "10.2.0.0/24"},
+			DrainingPools: []string{"10.2.0.0/24"},
+		},
+	}}
+
+	// Case 1: Node without any request annotation
+	// expected: a subnet sized from the allocatable pods of the Node from any address pool that is not draining
+	got, err := allocator.RequestFor(nodeCIDRAllocation, newRequestingNode(nil))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := (allocator.Request{Ones: 26, Pools: []string{"10.0.0.0/24", "10.1.0.0/22"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+
+	// Case 2: Node requesting a larger subnet
+	// expected: only the address pools that can fit the requested size are used
+	got, err = allocator.RequestFor(nodeCIDRAllocation, newRequestingNode(map[string]string{v1alpha1.AnnotationRequestedPrefixLength: "/23"}))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := (allocator.Request{Ones: 23, Pools: []string{"10.1.0.0/22"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+
+	// Case 3: Node requesting an exact PodCIDR from an address pool
+	// expected: the exact PodCIDR is requested from the requested address pool
+	got, err = allocator.RequestFor(nodeCIDRAllocation, newRequestingNode(map[string]string{
+		v1alpha1.AnnotationRequestedPodCIDR: "10.1.0.128/25",
+		v1alpha1.AnnotationRequestedPool:    "10.1.0.0/22",
+	}))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := (allocator.Request{PodCIDR: "10.1.0.128/25", Ones: 25, Pools: []string{"10.1.0.0/22"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+
+	// Case 4: Requests that can never be honored
+	// expected: should error with ErrInvalidRequest
+	for _, annotations := range []map[string]string{
+		{v1alpha1.AnnotationRequestedPool: "10.2.0.0/24"},
+		{v1alpha1.AnnotationRequestedPool: "10.9.0.0/24"},
+		{v1alpha1.AnnotationRequestedPrefixLength: "abc"},
+		{v1alpha1.AnnotationRequestedPrefixLength: "27"},
+		{v1alpha1.AnnotationRequestedPrefixLength: "21"},
+		{v1alpha1.AnnotationRequestedPodCIDR: "10.0.0.1/26"},
+		{v1alpha1.AnnotationRequestedPodCIDR: "10.9.0.0/26"},
+		{v1alpha1.AnnotationRequestedPodCIDR: "10.0.0.0/26", v1alpha1.AnnotationRequestedPool: "10.1.0.0/22"},
+		{v1alpha1.AnnotationRequestedPodCIDR: "10.0.0.0/26", v1alpha1.AnnotationRequestedPrefixLength: "25"},
+	} {
+		if _, err := allocator.RequestFor(nodeCIDRAllocation, newRequestingNode(annotations)); !errors.Is(err, allocator.ErrInvalidRequest) {
+			t.Errorf("%v: got %v, wanted %v", annotations, err, allocator.ErrInvalidRequest)
+		}
+	}
+}
+
+func TestRequestPick(t *testing.T) {
+	nodes := corev1.NodeList{
+		Items: []corev1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeA"},
+				Spec:       corev1.NodeSpec{PodCIDR: "10.0.0.0/26"},
+			},
+		},
+	}
+
+	// Case 1: Exact PodCIDR that is free
+	// expected: should return the requested PodCIDR
+	got, err := allocator.Request{PodCIDR: "10.0.0.64/26", Ones: 26, Pools: []string{"10.0.0.0/24"}}.Pick(&nodes, []string{})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+
+	// Case 2: Exact PodCIDR held by another Node or reserved
+	// expected: should error with ErrRequestCollides
+	for _, podCIDR := range []string{"10.0.0.0/25", "10.0.0.128/26"} {
+		if _, err := (allocator.Request{PodCIDR: podCIDR, Pools: []string{"10.0.0.0/24"}}).Pick(&nodes, []string{"10.0.0.128/26"}); !errors.Is(err, allocator.ErrRequestCollides) {
+			t.Errorf("got %v, wanted %v", err, allocator.ErrRequestCollides)
+		}
+	}
+
+	// Case 3: Subnet of the requested size
+	// expected: should return the first free subnet of that size
+	got, err = allocator.Request{Ones: 25, Pools: []string{"10.0.0.0/24"}}.Pick(&nodes, []string{})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.128/25" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.128/25")
+	}
+}
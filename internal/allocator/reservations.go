@@ -0,0 +1,211 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+// DefaultReservationTTL represents how long a reservation is held when the informer cache never shows the Node with its PodCIDR
+// (for example, because the Node was deleted before the allocation was observed)
+const DefaultReservationTTL = 5 * time.Minute
+
+// maxReserveAttempts represents how many times a reservation is picked again when it collides with reservations made concurrently.
+// Every collision means that another reservation was recorded, so the limit is only reached under sustained contention
+const maxReserveAttempts = 100
+
+// ErrReservationCollision is returned when a PodCIDR could not be reserved since every attempt collided with a concurrent reservation
+var ErrReservationCollision = errors.New("PodCIDR reservation kept colliding with concurrent reservations")
+
+// reservation represents a PodCIDR that has been assigned to a Node by this process
+type reservation struct {
+	cidr       string
+	reservedAt time.Time
+
+	// uid represents the UID of the Node that the PodCIDR was reserved for. A Node recreated with the same name has a different UID
+	uid types.UID
+
+	// observedAt represents the time at which the informer cache was first seen showing the Node with a PodCIDR (zero while in-flight)
+	observedAt time.Time
+}
+
+// Reservations holds the PodCIDRs assigned to Nodes by this process until the informer cache shows the Nodes with their PodCIDR.
+// Without it, two reconciles running at the same time (or back to back, before the cache has caught up with the first one) can both
+// see the same subnet as free. A single Reservations must be shared by every allocation made by the process. It is safe for concurrent use,
+// and the lock is never held while a PodCIDR is being picked
+type Reservations struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	byNode map[string]*reservation
+}
+
+// NewReservations creates an empty set of reservations which are held for at most DefaultReservationTTL
+func NewReservations() *Reservations {
+	return &Reservations{
+		ttl:    DefaultReservationTTL,
+		byNode: map[string]*reservation{},
+	}
+}
+
+// Reserve reserves a PodCIDR for the named Node. The supplied pick function is called with the PodCIDRs reserved for every other Node
+// that may be missing from the Nodes the caller started listing (from the cache) at listedAt, and must return a PodCIDR that does not overlap
+// with any of them. pick is called without holding the lock (it may read from or write to the API server), so reservations for different Nodes
+// are made concurrently. When a reservation recorded while pick was running overlaps the PodCIDR it returned, pick is called again with the
+// current reservations. When the Node (identified by its name and UID) already holds a reservation, it is returned without calling pick.
+// A reservation held for a previous Node with the same name is dropped, so that a recreated Node is never handed the PodCIDR picked for
+// the Node it replaces
+func (r *Reservations) Reserve(nodeName string, uid types.UID, listedAt time.Time, pick func(reserved []string) (string, error)) (string, error) {
+	for attempt := 0; attempt < maxReserveAttempts; attempt++ {
+		r.mu.Lock()
+		r.expire(time.Now())
+		if existing, ok := r.byNode[nodeName]; ok {
+			if existing.uid == uid {
+				r.mu.Unlock()
+				return existing.cidr, nil
+			}
+
+			// the reservation was made for a previous Node with the same name, which no longer exists
+			delete(r.byNode, nodeName)
+		}
+		reserved := r.reservedSince(nodeName, listedAt)
+		r.mu.Unlock()
+
+		cidr, err := pick(reserved)
+		if err != nil {
+			return "", err
+		}
+
+		r.mu.Lock()
+		if existing, ok := r.byNode[nodeName]; ok && existing.uid == uid {
+			// the Node was reserved by a concurrent caller while pick was running
+			r.mu.Unlock()
+			return existing.cidr, nil
+		}
+		if r.collides(nodeName, cidr) {
+			// another Node was reserved an overlapping PodCIDR while pick was running - pick again against the current reservations
+			r.mu.Unlock()
+			continue
+		}
+
+		r.byNode[nodeName] = &reservation{cidr: cidr, reservedAt: time.Now(), uid: uid}
+		r.mu.Unlock()
+		return cidr, nil
+	}
+
+	return "", fmt.Errorf("%w after %d attempts", ErrReservationCollision, maxReserveAttempts)
+}
+
+// Release drops the reservation held for the named Node, for example when its PodCIDR could not be applied
+func (r *Reservations) Release(nodeName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.byNode, nodeName)
+}
+
+// Observe records that the supplied Nodes (as listed from the informer cache) show their PodCIDR. An observed reservation is no longer
+// passed to callers that started listing Nodes after it was observed, since those callers are guaranteed to see the PodCIDR themselves.
+// It is only dropped once no such caller can remain (after the TTL). A Node with the same name but a different UID does not observe a reservation
+func (r *Reservations) Observe(nodes *corev1.NodeList) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, node := range nodes.Items {
+		if res, ok := r.byNode[node.GetName()]; ok && res.uid == node.GetUID() && node.Spec.PodCIDR != "" && res.observedAt.IsZero() {
+			res.observedAt = now
+		}
+	}
+
+	r.expire(now)
+}
+
+// InFlight returns the number of reservations which the informer cache has not yet been seen showing
+func (r *Reservations) InFlight() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inFlight := 0
+	for _, res := range r.byNode {
+		if res.observedAt.IsZero() {
+			inFlight++
+		}
+	}
+
+	return inFlight
+}
+
+// expire drops every reservation that is older than the TTL. Observed reservations are kept for the TTL after they were observed
+// so that callers that started listing Nodes before then still see them. The caller must hold the lock
+func (r *Reservations) expire(now time.Time) {
+	for nodeName, res := range r.byNode {
+		since := res.reservedAt
+		if !res.observedAt.IsZero() {
+			since = res.observedAt
+		}
+
+		if now.Sub(since) > r.ttl {
+			delete(r.byNode, nodeName)
+		}
+	}
+}
+
+// collides returns true when the supplied PodCIDR overlaps the reservation of any Node other than the named Node. The caller must hold the lock
+func (r *Reservations) collides(nodeName, cidr string) bool {
+	for name, res := range r.byNode {
+		if name == nodeName {
+			continue
+		}
+		if overlap, err := statcan_net.NetworksOverlap(res.cidr, cidr); err == nil && overlap {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reservedSince returns the PodCIDRs reserved for every Node other than the named Node that had not been observed before listedAt,
+// sorted by Node name. The caller must hold the lock
+func (r *Reservations) reservedSince(nodeName string, listedAt time.Time) []string {
+	names := make([]string, 0, len(r.byNode))
+	for name, res := range r.byNode {
+		if name == nodeName {
+			continue
+		}
+		if !res.observedAt.IsZero() && res.observedAt.Before(listedAt) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reserved := make([]string, 0, len(names))
+	for _, name := range names {
+		reserved = append(reserved, r.byNode[name].cidr)
+	}
+
+	return reserved
+}
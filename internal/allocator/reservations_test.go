@@ -0,0 +1,211 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package allocator_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+)
+
+func TestReservations(t *testing.T) {
+	pools := []string{"10.0.0.0/24"}
+	empty := corev1.NodeList{}
+	pick := func(reserved []string) (string, error) {
+		return allocator.Allocate(pools, 26, &empty, reserved)
+	}
+
+	// Case 1: Two Nodes are reserved from a cache that shows neither of them
+	// expected: the second Node should be passed the reservation of the first and receive a different subnet
+	r := allocator.NewReservations()
+	listedAt := time.Now()
+	first, err := r.Reserve("testNodeA", "uidA", listedAt, pick)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	second, err := r.Reserve("testNodeB", "uidB", listedAt, pick)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if first != "10.0.0.0/26" || second != "10.0.0.64/26" {
+		t.Errorf("got %s and %s, wanted %s and %s", first, second, "10.0.0.0/26", "10.0.0.64/26")
+	}
+
+	// Case 2: A Node which already holds a reservation is reserved again
+	// expected: should return the existing reservation without calling pick
+	got, err := r.Reserve("testNodeA", "uidA", listedAt, func(_ []string) (string, error) {
+		t.Error("pick was not expected to be called")
+		return "", nil
+	})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != first {
+		t.Errorf("got %s, wanted %s", got, first)
+	}
+
+	// Case 3: The cache shows the first Node with its PodCIDR
+	// expected: the reservation should only be passed to callers that listed the Nodes before it was observed
+	r.Observe(&corev1.NodeList{Items: []corev1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "testNodeA", UID: "uidA"},
+		Spec:       corev1.NodeSpec{PodCIDR: first},
+	}}})
+	if got := r.InFlight(); got != 1 {
+		t.Errorf("got %d, wanted %d", got, 1)
+	}
+
+	var reserved []string
+	capture := func(cidr string) func([]string) (string, error) {
+		return func(r []string) (string, error) {
+			reserved = r
+			return cidr, nil
+		}
+	}
+	if _, err := r.Reserve("testNodeC", "uidC", listedAt, capture("10.0.0.128/26")); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := []string{first, second}; !reflect.DeepEqual(reserved, want) {
+		t.Errorf("got %v, wanted %v", reserved, want)
+	}
+	if _, err := r.Reserve("testNodeD", "uidD", time.Now(), capture("10.0.0.192/26")); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := []string{second, "10.0.0.128/26"}; !reflect.DeepEqual(reserved, want) {
+		t.Errorf("got %v, wanted %v", reserved, want)
+	}
+
+	// Case 4: The reservation of a Node is released
+	// expected: the subnet should no longer be passed to pick
+	r.Release("testNodeB")
+	if _, err := r.Reserve("testNodeE", "uidE", time.Now(), capture("10.0.0.64/26")); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := []string{"10.0.0.128/26", "10.0.0.192/26"}; !reflect.DeepEqual(reserved, want) {
+		t.Errorf("got %v, wanted %v", reserved, want)
+	}
+
+	// Case 5: The first Node is deleted and recreated with the same name before its observed reservation expires
+	// expected: pick is called for the new Node (with a different UID) instead of returning the PodCIDR reserved for the deleted Node
+	got, err = r.Reserve("testNodeA", "uidA2", time.Now(), capture("10.0.0.0/27"))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.0/27" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/27")
+	}
+	if want := []string{"10.0.0.128/26", "10.0.0.192/26", "10.0.0.64/26"}; !reflect.DeepEqual(reserved, want) {
+		t.Errorf("got %v, wanted %v", reserved, want)
+	}
+}
+
+func TestReservationsConcurrent(t *testing.T) {
+	// Case 1: Many Nodes are reserved at the same time from a cache that shows none of them
+	// expected: every Node should receive a unique subnet
+	pools := []string{"10.0.0.0/20"}
+	empty := corev1.NodeList{}
+	r := allocator.NewReservations()
+	listedAt := time.Now()
+
+	var mu sync.Mutex
+	seen := map[string]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			got, err := r.Reserve(name, "", listedAt, func(reserved []string) (string, error) {
+				return allocator.Allocate(pools, 26, &empty, reserved)
+			})
+			if err != nil {
+				t.Errorf("function was not expected to error. got %e", err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if other, ok := seen[got]; ok {
+				t.Errorf("got duplicate subnet %s for %s and %s", got, name, other)
+			}
+			seen[got] = name
+		}(string(rune('A'+i%26)) + string(rune('a'+i/26)))
+	}
+	wg.Wait()
+
+	if len(seen) != 64 {
+		t.Errorf("got %d, wanted %d", len(seen), 64)
+	}
+}
+
+func TestReservationsPickUnlocked(t *testing.T) {
+	pools := []string{"10.0.0.0/24"}
+	empty := corev1.NodeList{}
+	r := allocator.NewReservations()
+	listedAt := time.Now()
+
+	// Case 1: A Node is reserved while the pick for another Node is still running
+	// expected: the second reservation is not blocked by the first pick
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan string)
+	var once sync.Once
+	go func() {
+		got, err := r.Reserve("testNodeA", "uidA", listedAt, func(reserved []string) (string, error) {
+			once.Do(func() { close(started) })
+			<-release
+			return allocator.Allocate(pools, 26, &empty, reserved)
+		})
+		if err != nil {
+			t.Errorf("function was not expected to error. got %e", err)
+		}
+		done <- got
+	}()
+
+	<-started
+	second := make(chan string)
+	go func() {
+		got, err := r.Reserve("testNodeB", "uidB", listedAt, func(reserved []string) (string, error) {
+			return allocator.Allocate(pools, 26, &empty, reserved)
+		})
+		if err != nil {
+			t.Errorf("function was not expected to error. got %e", err)
+		}
+		second <- got
+	}()
+
+	select {
+	case got := <-second:
+		if got != "10.0.0.0/26" {
+			t.Errorf("got %s, wanted %s", got, "10.0.0.0/26")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reservation was blocked by a pick running for another Node")
+	}
+
+	// Case 2: The first pick completes with the subnet reserved for the other Node in the meantime
+	// expected: the collision is detected and the first Node is reserved a different subnet
+	close(release)
+	if got := <-done; got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+}
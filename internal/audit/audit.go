@@ -0,0 +1,398 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package audit classifies the PodCIDR of every Node in the cluster against the NodeCIDRAllocations that exist, so that PodCIDRs which were
+// not assigned by the CIDR-Allocator (or that are no longer tracked by the NodeCIDRAllocation that assigned them) are reported
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	statcan_metrics "statcan.gc.ca/cidr-allocator/internal/metrics"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+const (
+	// DefaultReportName is the default name of the ConfigMap which stores the audit report
+	DefaultReportName = "cidr-allocator-audit"
+
+	// ReportKeySummary is the ConfigMap key of the human-readable summary of the audit report
+	ReportKeySummary = "summary"
+	// ReportKeyJSON is the ConfigMap key of the audit report, listing a sample of the unmanaged Node PodCIDRs and overlapping pairs
+	ReportKeyJSON = "report.json"
+
+	// DefaultSampleSize is the default maximum number of unmanaged Node PodCIDRs, and of overlapping pairs, listed in the published report.
+	// It keeps the report ConfigMap well below the 1 MiB object size limit in clusters with many Nodes
+	DefaultSampleSize = 100
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "cidr-allocator"
+)
+
+// Classification represents the relationship between a Node PodCIDR and the NodeCIDRAllocations in the cluster
+type Classification string
+
+const (
+	// ClassificationManaged represents a PodCIDR from the address pools of a NodeCIDRAllocation that selects the Node
+	ClassificationManaged Classification = "managed"
+	// ClassificationForeignInsidePool represents a PodCIDR that was not assigned by the CIDR-Allocator but overlaps the address pool of a NodeCIDRAllocation
+	ClassificationForeignInsidePool Classification = "foreign-inside-pool"
+	// ClassificationForeignOutsidePools represents a PodCIDR that was not assigned by the CIDR-Allocator and lies outside of every address pool
+	ClassificationForeignOutsidePools Classification = "foreign-outside-pools"
+	// ClassificationOrphaned represents a PodCIDR assigned by a NodeCIDRAllocation that no longer exists, no longer selects the Node
+	// or no longer contains the PodCIDR in its address pools
+	ClassificationOrphaned Classification = "orphaned"
+)
+
+// Classifications lists every Classification in the order they are reported
+var Classifications = []Classification{
+	ClassificationManaged,
+	ClassificationForeignInsidePool,
+	ClassificationForeignOutsidePools,
+	ClassificationOrphaned,
+}
+
+// NodeAudit represents the classification of the PodCIDR of a single Node
+type NodeAudit struct {
+	// Node represents the name of the Node
+	Node string `json:"node"`
+
+	// PodCIDR represents the PodCIDR of the Node
+	PodCIDR string `json:"podCIDR"`
+
+	// Classification represents the classification of the PodCIDR
+	Classification Classification `json:"classification"`
+
+	// NodeCIDRAllocation represents the NodeCIDRAllocation (namespace/name) that manages the PodCIDR, that assigned the (orphaned) PodCIDR
+	// or whose address pools contain the (foreign) PodCIDR
+	NodeCIDRAllocation string `json:"nodeCIDRAllocation,omitempty"`
+}
+
+// Report represents the result of an audit of every Node PodCIDR in the cluster
+type Report struct {
+	// GeneratedAt represents the time at which the audit was performed
+	GeneratedAt metav1.Time `json:"generatedAt"`
+
+	// Counts represents the number of Node PodCIDRs for each classification
+	Counts map[Classification]int `json:"counts"`
+
+	// Nodes lists every Node PodCIDR which is not managed, sorted by Node name
+	Nodes []NodeAudit `json:"nodes,omitempty"`
+
+	// Overlaps lists every pair of overlapping ranges across the Node PodCIDRs and the static allocations of all NodeCIDRAllocations
+	Overlaps []Overlap `json:"overlaps,omitempty"`
+
+	// NodesOmitted represents the number of unmanaged Node PodCIDRs left out of Nodes (see Sample)
+	NodesOmitted int `json:"nodesOmitted,omitempty"`
+
+	// OverlapsOmitted represents the number of overlapping pairs left out of Overlaps (see Sample)
+	OverlapsOmitted int `json:"overlapsOmitted,omitempty"`
+}
+
+// Sample returns a copy of the report which lists at most size unmanaged Node PodCIDRs and size overlapping pairs. The number of entries
+// left out of each list is recorded in the copy, and the counts are kept as is
+func (r *Report) Sample(size int) *Report {
+	sample := *r
+	if len(sample.Nodes) > size {
+		sample.NodesOmitted += len(sample.Nodes) - size
+		sample.Nodes = sample.Nodes[:size]
+	}
+	if len(sample.Overlaps) > size {
+		sample.OverlapsOmitted += len(sample.Overlaps) - size
+		sample.Overlaps = sample.Overlaps[:size]
+	}
+
+	return &sample
+}
+
+// Summary returns a human-readable summary of the report with the count of each classification, and of overlapping ranges, on a separate line
+func (r *Report) Summary() string {
+	lines := make([]string, 0, len(Classifications)+1)
+	for _, classification := range Classifications {
+		lines = append(lines, fmt.Sprintf("%s: %d", classification, r.Counts[classification]))
+	}
+	lines = append(lines, fmt.Sprintf("overlaps: %d", len(r.Overlaps)+r.OverlapsOmitted))
+
+	return strings.Join(lines, "\n")
+}
+
+// selects returns true when the NodeCIDRAllocation selects the supplied Node
+func selects(nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, node *corev1.Node) bool {
+	return labels.SelectorFromSet(nodeCIDRAllocation.Spec.NodeSelector).Matches(labels.Set(node.GetLabels()))
+}
+
+// keyOf returns the namespace/name of the supplied NodeCIDRAllocation as recorded in the owner annotation of the Nodes it allocates
+func keyOf(nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation) string {
+	return types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+}
+
+// Classify classifies the PodCIDR of the supplied Node against the supplied (resolved) NodeCIDRAllocations, which must be sorted by namespace/name
+func Classify(node *corev1.Node, nodeCIDRAllocations []*allocator.Resolved) NodeAudit {
+	result := NodeAudit{
+		Node:    node.GetName(),
+		PodCIDR: node.Spec.PodCIDR,
+	}
+
+	manages := func(nodeCIDRAllocation *allocator.Resolved) bool {
+		return selects(nodeCIDRAllocation.NodeCIDRAllocation, node) && allocator.PoolFor(nodeCIDRAllocation.AddressPools(), node.Spec.PodCIDR) != ""
+	}
+
+	if owner, ok := node.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation]; ok {
+		result.NodeCIDRAllocation = owner
+		result.Classification = ClassificationOrphaned
+		for _, nodeCIDRAllocation := range nodeCIDRAllocations {
+			if keyOf(nodeCIDRAllocation.NodeCIDRAllocation) == owner && manages(nodeCIDRAllocation) {
+				result.Classification = ClassificationManaged
+			}
+		}
+
+		return result
+	}
+
+	// Nodes allocated before allocations were annotated are managed by the NodeCIDRAllocation that selects them
+	for _, nodeCIDRAllocation := range nodeCIDRAllocations {
+		if manages(nodeCIDRAllocation) {
+			result.NodeCIDRAllocation = keyOf(nodeCIDRAllocation.NodeCIDRAllocation)
+			result.Classification = ClassificationManaged
+			return result
+		}
+	}
+
+	for _, nodeCIDRAllocation := range nodeCIDRAllocations {
+		for _, pool := range nodeCIDRAllocation.AddressPools() {
+			if overlap, err := statcan_net.NetworksOverlap(pool, node.Spec.PodCIDR); err == nil && overlap {
+				result.NodeCIDRAllocation = keyOf(nodeCIDRAllocation.NodeCIDRAllocation)
+				result.Classification = ClassificationForeignInsidePool
+				return result
+			}
+		}
+	}
+
+	result.Classification = ClassificationForeignOutsidePools
+	return result
+}
+
+// Audit classifies the PodCIDR of every supplied Node (that has one) against the supplied (resolved) NodeCIDRAllocations and finds every
+// pair of overlapping ranges
+func Audit(nodes *corev1.NodeList, nodeCIDRAllocations []*allocator.Resolved, now time.Time) *Report {
+	sorted := append([]*allocator.Resolved{}, nodeCIDRAllocations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].GetNamespace() != sorted[j].GetNamespace() {
+			return sorted[i].GetNamespace() < sorted[j].GetNamespace()
+		}
+		return sorted[i].GetName() < sorted[j].GetName()
+	})
+
+	report := &Report{
+		GeneratedAt: metav1.NewTime(now.UTC().Truncate(time.Second)),
+		Counts:      map[Classification]int{},
+	}
+	for _, classification := range Classifications {
+		report.Counts[classification] = 0
+	}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Spec.PodCIDR == "" {
+			continue
+		}
+
+		result := Classify(node, sorted)
+		report.Counts[result.Classification]++
+		if result.Classification != ClassificationManaged {
+			report.Nodes = append(report.Nodes, result)
+		}
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool { return report.Nodes[i].Node < report.Nodes[j].Node })
+
+	report.Overlaps = FindOverlaps(nodes, nodeCIDRAllocations)
+
+	return report
+}
+
+// list returns every (resolved) NodeCIDRAllocation and every Node in the cluster
+func list(ctx context.Context, reader client.Reader) ([]*allocator.Resolved, *corev1.NodeList, error) {
+	nodeCIDRAllocations := v1alpha1.NodeCIDRAllocationList{}
+	if err := reader.List(ctx, &nodeCIDRAllocations); err != nil {
+		return nil, nil, fmt.Errorf("unable to list NodeCIDRAllocations: %w", err)
+	}
+	// a referenced AddressPool that does not exist does not contribute any address pool, and is reported against the NodeCIDRAllocation by the controller
+	resolved, err := allocator.ResolveList(ctx, reader, &nodeCIDRAllocations)
+	if err != nil && !errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		return nil, nil, err
+	}
+
+	nodes := corev1.NodeList{}
+	if err := reader.List(ctx, &nodes); err != nil {
+		return nil, nil, fmt.Errorf("unable to list Nodes: %w", err)
+	}
+
+	return resolved, &nodes, nil
+}
+
+// Auditor periodically audits every Node PodCIDR in the cluster. The counts of each classification and every pair of overlapping ranges
+// are exported as metrics, a Warning event is recorded for every pair of overlapping ranges and a sample of the report is published in a ConfigMap
+type Auditor struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+
+	// Key represents the name and namespace of the ConfigMap which the report is published in
+	Key types.NamespacedName
+
+	// Interval represents the time between consecutive audits
+	Interval time.Duration
+
+	// SampleSize represents the maximum number of unmanaged Node PodCIDRs, and of overlapping pairs, listed in the published report.
+	// DefaultSampleSize is used when it is not set
+	SampleSize int
+}
+
+// SetupWithManager adds the Auditor to the Manager. The audit only runs on the leader
+func (a *Auditor) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(a)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable so that the report is only published by the leader
+func (a *Auditor) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs an audit immediately and then once every Interval until the supplied context is done
+func (a *Auditor) Start(ctx context.Context) error {
+	rl := log.FromContext(ctx).WithName("audit")
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		report, err := a.Run(ctx)
+		if err != nil {
+			rl.Error(
+				err,
+				"unable to audit Node PodCIDRs",
+			)
+			return
+		}
+
+		rl.V(1).Info(
+			"audited Node PodCIDRs",
+			"counts", report.Counts,
+			"overlaps", len(report.Overlaps),
+		)
+	}, a.Interval)
+
+	return nil
+}
+
+// Run performs a single audit of every Node PodCIDR in the cluster, updates the metrics and publishes the report
+func (a *Auditor) Run(ctx context.Context) (*Report, error) {
+	resolved, nodes, err := list(ctx, a.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	report := Audit(nodes, resolved, time.Now())
+
+	counts := make(map[string]int, len(report.Counts))
+	for classification, count := range report.Counts {
+		counts[string(classification)] = count
+	}
+	statcan_metrics.UpdateNodePodCIDRs(counts)
+
+	overlaps := make([]prometheus.Labels, 0, len(report.Overlaps))
+	for _, overlap := range report.Overlaps {
+		overlaps = append(overlaps, prometheus.Labels{
+			"first":       overlap.First.Kind + "/" + overlap.First.Name,
+			"first_cidr":  overlap.First.CIDR,
+			"second":      overlap.Second.Kind + "/" + overlap.Second.Name,
+			"second_cidr": overlap.Second.CIDR,
+		})
+
+		if a.Recorder != nil {
+			a.Recorder.Eventf(overlap.First.reference, corev1.EventTypeWarning, EventReasonOverlappingPodCIDR, "%s overlaps %s", overlap.First, overlap.Second)
+			a.Recorder.Eventf(overlap.Second.reference, corev1.EventTypeWarning, EventReasonOverlappingPodCIDR, "%s overlaps %s", overlap.Second, overlap.First)
+		}
+	}
+	statcan_metrics.UpdatePodCIDROverlaps(overlaps)
+
+	if err := a.publish(ctx, report); err != nil {
+		return report, fmt.Errorf("unable to publish audit report: %w", err)
+	}
+
+	return report, nil
+}
+
+// publish creates or updates the ConfigMap containing a sample of the supplied report
+func (a *Auditor) publish(ctx context.Context, report *Report) error {
+	sampleSize := a.SampleSize
+	if sampleSize <= 0 {
+		sampleSize = DefaultSampleSize
+	}
+	report = report.Sample(sampleSize)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	configMap := corev1.ConfigMap{}
+	if err := a.Client.Get(ctx, a.Key, &configMap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		configMap = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      a.Key.Name,
+				Namespace: a.Key.Namespace,
+				Labels: map[string]string{
+					managedByLabel: managedByValue,
+				},
+			},
+			Data: map[string]string{
+				ReportKeySummary: report.Summary(),
+				ReportKeyJSON:    string(data),
+			},
+		}
+
+		return a.Client.Create(ctx, &configMap)
+	}
+
+	configMap.Data = map[string]string{
+		ReportKeySummary: report.Summary(),
+		ReportKeyJSON:    string(data),
+	}
+
+	return a.Client.Update(ctx, &configMap)
+}
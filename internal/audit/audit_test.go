@@ -0,0 +1,269 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/audit"
+)
+
+func newNodeCIDRAllocation(name string, nodeSelector map[string]string, addressPools ...string) v1alpha1.NodeCIDRAllocation {
+	return v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: addressPools,
+			NodeSelector: nodeSelector,
+		},
+	}
+}
+
+// resolved returns the supplied NodeCIDRAllocations without any referenced AddressPool
+func resolved(nodeCIDRAllocations ...v1alpha1.NodeCIDRAllocation) []*allocator.Resolved {
+	items := make([]*allocator.Resolved, len(nodeCIDRAllocations))
+	for i := range nodeCIDRAllocations {
+		items[i] = &allocator.Resolved{NodeCIDRAllocation: &nodeCIDRAllocations[i]}
+	}
+
+	return items
+}
+
+func newNode(name, podCIDR string, nodeLabels, annotations map[string]string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      nodeLabels,
+			Annotations: annotations,
+		},
+		Spec: corev1.NodeSpec{
+			PodCIDR: podCIDR,
+		},
+	}
+}
+
+func TestClassify(t *testing.T) {
+	nodeCIDRAllocations := resolved(
+		newNodeCIDRAllocation("pool-a", map[string]string{"pool": "a"}, "10.0.0.0/24"),
+		newNodeCIDRAllocation("pool-b", map[string]string{"pool": "b"}, "10.0.1.0/24"),
+	)
+	owner := map[string]string{v1alpha1.AnnotationNodeCIDRAllocation: "default/pool-a"}
+
+	cases := []struct {
+		description string
+		node        corev1.Node
+		want        audit.NodeAudit
+	}{
+		{
+			// Case 1: Node annotated by a NodeCIDRAllocation that selects it and holds a PodCIDR from its address pools
+			// expected: managed by that NodeCIDRAllocation
+			description: "annotated and selected",
+			node:        newNode("n1", "10.0.0.0/28", map[string]string{"pool": "a"}, owner),
+			want:        audit.NodeAudit{Node: "n1", PodCIDR: "10.0.0.0/28", Classification: audit.ClassificationManaged, NodeCIDRAllocation: "default/pool-a"},
+		},
+		{
+			// Case 2: Node without an annotation that is selected by a NodeCIDRAllocation and holds a PodCIDR from its address pools
+			// expected: managed by that NodeCIDRAllocation
+			description: "selected without annotation",
+			node:        newNode("n2", "10.0.1.0/28", map[string]string{"pool": "b"}, nil),
+			want:        audit.NodeAudit{Node: "n2", PodCIDR: "10.0.1.0/28", Classification: audit.ClassificationManaged, NodeCIDRAllocation: "default/pool-b"},
+		},
+		{
+			// Case 3: Node without an annotation holding a PodCIDR from the address pool of a NodeCIDRAllocation that does not select it
+			// expected: foreign inside the pool of that NodeCIDRAllocation
+			description: "foreign inside pool",
+			node:        newNode("n3", "10.0.1.16/28", map[string]string{"pool": "a"}, nil),
+			want:        audit.NodeAudit{Node: "n3", PodCIDR: "10.0.1.16/28", Classification: audit.ClassificationForeignInsidePool, NodeCIDRAllocation: "default/pool-b"},
+		},
+		{
+			// Case 4: Node without an annotation holding a PodCIDR that partially overlaps an address pool
+			// expected: foreign inside the pool of that NodeCIDRAllocation
+			description: "foreign overlapping pool",
+			node:        newNode("n4", "10.0.0.0/16", nil, nil),
+			want:        audit.NodeAudit{Node: "n4", PodCIDR: "10.0.0.0/16", Classification: audit.ClassificationForeignInsidePool, NodeCIDRAllocation: "default/pool-a"},
+		},
+		{
+			// Case 5: Node without an annotation holding a PodCIDR outside of every address pool
+			// expected: foreign outside of all pools
+			description: "foreign outside pools",
+			node:        newNode("n5", "192.168.0.0/28", map[string]string{"pool": "a"}, nil),
+			want:        audit.NodeAudit{Node: "n5", PodCIDR: "192.168.0.0/28", Classification: audit.ClassificationForeignOutsidePools},
+		},
+		{
+			// Case 6: Node annotated by a NodeCIDRAllocation that no longer exists
+			// expected: orphaned, naming the missing NodeCIDRAllocation
+			description: "owner deleted",
+			node:        newNode("n6", "10.0.2.0/28", nil, map[string]string{v1alpha1.AnnotationNodeCIDRAllocation: "default/deleted"}),
+			want:        audit.NodeAudit{Node: "n6", PodCIDR: "10.0.2.0/28", Classification: audit.ClassificationOrphaned, NodeCIDRAllocation: "default/deleted"},
+		},
+		{
+			// Case 7: Node annotated by a NodeCIDRAllocation that no longer selects it
+			// expected: orphaned
+			description: "owner no longer selects",
+			node:        newNode("n7", "10.0.0.16/28", map[string]string{"pool": "b"}, owner),
+			want:        audit.NodeAudit{Node: "n7", PodCIDR: "10.0.0.16/28", Classification: audit.ClassificationOrphaned, NodeCIDRAllocation: "default/pool-a"},
+		},
+		{
+			// Case 8: Node annotated by a NodeCIDRAllocation whose address pools no longer contain the PodCIDR
+			// expected: orphaned
+			description: "pool removed from owner",
+			node:        newNode("n8", "10.0.1.32/28", map[string]string{"pool": "a"}, owner),
+			want:        audit.NodeAudit{Node: "n8", PodCIDR: "10.0.1.32/28", Classification: audit.ClassificationOrphaned, NodeCIDRAllocation: "default/pool-a"},
+		},
+	}
+
+	for _, c := range cases {
+		got := audit.Classify(&c.node, nodeCIDRAllocations)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, wanted %+v", c.description, got, c.want)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	nodeCIDRAllocations := resolved(newNodeCIDRAllocation("pool-a", nil, "10.0.0.0/24"))
+	nodes := &corev1.NodeList{
+		Items: []corev1.Node{
+			newNode("c", "192.168.0.0/28", nil, nil),
+			newNode("b", "10.0.0.0/28", nil, nil),
+			newNode("a", "10.0.1.0/28", nil, map[string]string{v1alpha1.AnnotationNodeCIDRAllocation: "default/deleted"}),
+			newNode("d", "", nil, nil),
+		},
+	}
+
+	// Case 1: Nodes of every classification along with a Node without a PodCIDR
+	// expected: every classification is counted (Nodes without a PodCIDR are skipped) and only non-managed Nodes are listed, sorted by name
+	got := audit.Audit(nodes, nodeCIDRAllocations, time.Now())
+	wantCounts := map[audit.Classification]int{
+		audit.ClassificationManaged:             1,
+		audit.ClassificationForeignInsidePool:   0,
+		audit.ClassificationForeignOutsidePools: 1,
+		audit.ClassificationOrphaned:            1,
+	}
+	if !reflect.DeepEqual(got.Counts, wantCounts) {
+		t.Errorf("got %v, wanted %v", got.Counts, wantCounts)
+	}
+
+	gotNodes := []string{}
+	for _, n := range got.Nodes {
+		gotNodes = append(gotNodes, n.Node)
+	}
+	wantNodes := []string{"a", "c"}
+	if !reflect.DeepEqual(gotNodes, wantNodes) {
+		t.Errorf("got %v, wanted %v", gotNodes, wantNodes)
+	}
+
+	wantSummary := "managed: 1\nforeign-inside-pool: 0\nforeign-outside-pools: 1\norphaned: 1\noverlaps: 0"
+	if got.Summary() != wantSummary {
+		t.Errorf("got %q, wanted %q", got.Summary(), wantSummary)
+	}
+}
+
+func TestAuditorRun(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
+
+	nodeCIDRAllocation := newNodeCIDRAllocation("pool-a", nil, "10.0.0.0/24")
+	nodeA := newNode("a", "10.0.0.0/28", nil, nil)
+	nodeB := newNode("b", "10.0.0.16/28", nil, map[string]string{v1alpha1.AnnotationNodeCIDRAllocation: "default/deleted"})
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(&nodeCIDRAllocation, &nodeA, &nodeB).Build()
+
+	auditor := &audit.Auditor{
+		Client:   c,
+		Key:      types.NamespacedName{Name: audit.DefaultReportName, Namespace: "cidr-allocator-system"},
+		Interval: time.Minute,
+	}
+
+	// Case 1: The report ConfigMap does not exist yet
+	// expected: the ConfigMap is created with the summary and the report listing the orphaned Node
+	if _, err := auditor.Run(context.Background()); err != nil {
+		t.Fatalf("function was not expected to error. got %v", err)
+	}
+
+	configMap := corev1.ConfigMap{}
+	if err := c.Get(context.Background(), auditor.Key, &configMap); err != nil {
+		t.Fatalf("expected the report ConfigMap to exist. got %v", err)
+	}
+
+	report := audit.Report{}
+	if err := json.Unmarshal([]byte(configMap.Data[audit.ReportKeyJSON]), &report); err != nil {
+		t.Fatalf("expected a valid report. got %v", err)
+	}
+	if len(report.Nodes) != 1 || report.Nodes[0].Node != "b" || report.Nodes[0].Classification != audit.ClassificationOrphaned {
+		t.Errorf("got %+v, wanted a single orphaned Node b", report.Nodes)
+	}
+
+	// Case 2: The orphaned Node is removed and the audit is run again
+	// expected: the existing ConfigMap is updated and no longer lists any Node
+	if err := c.Delete(context.Background(), &nodeB); err != nil {
+		t.Fatalf("unable to delete Node. got %v", err)
+	}
+	if _, err := auditor.Run(context.Background()); err != nil {
+		t.Fatalf("function was not expected to error. got %v", err)
+	}
+	if err := c.Get(context.Background(), auditor.Key, &configMap); err != nil {
+		t.Fatalf("expected the report ConfigMap to exist. got %v", err)
+	}
+
+	want := "managed: 1\nforeign-inside-pool: 0\nforeign-outside-pools: 0\norphaned: 0\noverlaps: 0"
+	if got := configMap.Data[audit.ReportKeySummary]; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+
+	// Case 3: More Nodes are not managed than the sample size of the report
+	// expected: only the sample is listed, along with the number of Nodes left out, and the counts include every Node
+	auditor.SampleSize = 1
+	for _, node := range []corev1.Node{newNode("c", "192.168.0.0/28", nil, nil), newNode("d", "192.168.0.16/28", nil, nil)} {
+		if err := c.Create(context.Background(), &node); err != nil {
+			t.Fatalf("unable to create Node. got %v", err)
+		}
+	}
+	if _, err := auditor.Run(context.Background()); err != nil {
+		t.Fatalf("function was not expected to error. got %v", err)
+	}
+	if err := c.Get(context.Background(), auditor.Key, &configMap); err != nil {
+		t.Fatalf("expected the report ConfigMap to exist. got %v", err)
+	}
+
+	report = audit.Report{}
+	if err := json.Unmarshal([]byte(configMap.Data[audit.ReportKeyJSON]), &report); err != nil {
+		t.Fatalf("expected a valid report. got %v", err)
+	}
+	if len(report.Nodes) != 1 || report.Nodes[0].Node != "c" || report.NodesOmitted != 1 {
+		t.Errorf("got %+v with %d omitted, wanted Node c with %d omitted", report.Nodes, report.NodesOmitted, 1)
+	}
+	want = "managed: 1\nforeign-inside-pool: 0\nforeign-outside-pools: 2\norphaned: 0\noverlaps: 0"
+	if got := configMap.Data[audit.ReportKeySummary]; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
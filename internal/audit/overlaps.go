@@ -0,0 +1,255 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+)
+
+const (
+	// RangeKindNode represents a range assigned to a Node through .Spec.PodCIDR or .Spec.PodCIDRs
+	RangeKindNode = "Node"
+	// RangeKindStaticAllocation represents a range reserved through the static allocations (or reservations) of a NodeCIDRAllocation
+	RangeKindStaticAllocation = "StaticAllocation"
+
+	// EventReasonOverlappingPodCIDR is the reason of the Warning events recorded for every pair of overlapping ranges
+	EventReasonOverlappingPodCIDR = "Overlapping PodCIDR"
+)
+
+// Range represents a range of addresses held by a Node or reserved by a NodeCIDRAllocation
+type Range struct {
+	// Kind represents what holds the range (one of Node or StaticAllocation)
+	Kind string `json:"kind"`
+
+	// Name represents the name of the Node, or the namespace/name of the NodeCIDRAllocation, that holds the range
+	Name string `json:"name"`
+
+	// CIDR represents the range in CIDR format
+	CIDR string `json:"cidr"`
+
+	// reference is the object that events about the range are recorded against
+	reference *corev1.ObjectReference
+	prefix    netip.Prefix
+}
+
+// String returns a human-readable description of the range
+func (r Range) String() string {
+	if r.Kind == RangeKindNode {
+		return fmt.Sprintf("PodCIDR %s of Node %s", r.CIDR, r.Name)
+	}
+
+	return fmt.Sprintf("static allocation %s of NodeCIDRAllocation %s", r.CIDR, r.Name)
+}
+
+// Overlap represents two ranges which share addresses. The First range always contains (or is equal to) the Second range
+type Overlap struct {
+	First  Range `json:"first"`
+	Second Range `json:"second"`
+}
+
+// nodeRanges returns every distinct (valid) range in .Spec.PodCIDR and .Spec.PodCIDRs of the supplied Node
+func nodeRanges(node *corev1.Node) []Range {
+	reference := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       node.GetName(),
+		UID:        types.UID(node.GetName()),
+	}
+
+	seen := map[netip.Prefix]struct{}{}
+	ranges := []Range{}
+	for _, cidr := range append([]string{node.Spec.PodCIDR}, node.Spec.PodCIDRs...) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		prefix = prefix.Masked()
+
+		if _, ok := seen[prefix]; ok {
+			continue
+		}
+		seen[prefix] = struct{}{}
+
+		ranges = append(ranges, Range{Kind: RangeKindNode, Name: node.GetName(), CIDR: prefix.String(), reference: reference, prefix: prefix})
+	}
+
+	return ranges
+}
+
+// staticRanges returns every distinct (valid) static allocation and unexpired reservation of the supplied NodeCIDRAllocation
+func staticRanges(nodeCIDRAllocation *allocator.Resolved) []Range {
+	reference := &corev1.ObjectReference{
+		APIVersion: v1alpha1.GroupVersion.String(),
+		Kind:       "NodeCIDRAllocation",
+		Name:       nodeCIDRAllocation.GetName(),
+		Namespace:  nodeCIDRAllocation.GetNamespace(),
+		UID:        nodeCIDRAllocation.GetUID(),
+	}
+
+	seen := map[netip.Prefix]struct{}{}
+	ranges := []Range{}
+	for _, cidr := range nodeCIDRAllocation.ReservedSubnets(time.Now()) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		prefix = prefix.Masked()
+
+		if _, ok := seen[prefix]; ok {
+			continue
+		}
+		seen[prefix] = struct{}{}
+
+		ranges = append(ranges, Range{Kind: RangeKindStaticAllocation, Name: keyOf(nodeCIDRAllocation.NodeCIDRAllocation), CIDR: prefix.String(), reference: reference, prefix: prefix})
+	}
+
+	return ranges
+}
+
+// conflicts returns true when overlapping ranges a and b break routing. Ranges held by the same owner never conflict, and static allocations
+// only conflict with Node ranges since they are reservations that may be shared between NodeCIDRAllocations
+func conflicts(a, b *Range) bool {
+	if a.Kind == RangeKindStaticAllocation && b.Kind == RangeKindStaticAllocation {
+		return false
+	}
+
+	return a.Kind != b.Kind || a.Name != b.Name
+}
+
+// FindOverlaps returns every pair of overlapping ranges across the PodCIDRs of the supplied Nodes and the static allocations of the supplied
+// (resolved) NodeCIDRAllocations. Ranges are compared after being sorted by address, so the check stays cheap for large clusters
+func FindOverlaps(nodes *corev1.NodeList, nodeCIDRAllocations []*allocator.Resolved) []Overlap {
+	ranges := []Range{}
+	for i := range nodes.Items {
+		ranges = append(ranges, nodeRanges(&nodes.Items[i])...)
+	}
+	for _, nodeCIDRAllocation := range nodeCIDRAllocations {
+		ranges = append(ranges, staticRanges(nodeCIDRAllocation)...)
+	}
+
+	// sorting by address (and then from the largest to the smallest prefix) places every range right after the ranges that contain it
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if c := ranges[i].prefix.Addr().Compare(ranges[j].prefix.Addr()); c != 0 {
+			return c < 0
+		}
+		if ranges[i].prefix.Bits() != ranges[j].prefix.Bits() {
+			return ranges[i].prefix.Bits() < ranges[j].prefix.Bits()
+		}
+		if ranges[i].Kind != ranges[j].Kind {
+			return ranges[i].Kind < ranges[j].Kind
+		}
+		return ranges[i].Name < ranges[j].Name
+	})
+
+	// CIDR ranges either nest or are disjoint, so the ranges that still contain the current range form a stack
+	overlaps := []Overlap{}
+	containing := []*Range{}
+	for i := range ranges {
+		current := &ranges[i]
+		for len(containing) > 0 && !containing[len(containing)-1].prefix.Contains(current.prefix.Addr()) {
+			containing = containing[:len(containing)-1]
+		}
+
+		for _, outer := range containing {
+			if conflicts(outer, current) {
+				overlaps = append(overlaps, Overlap{First: *outer, Second: *current})
+			}
+		}
+
+		containing = append(containing, current)
+	}
+
+	return overlaps
+}
+
+// OverlapChecker periodically finds every pair of overlapping ranges from the cache of the replica that it runs in. Unlike the Auditor, which
+// only runs on the leader, it runs on every replica so that its readiness check (see Checker) fails on every replica while overlaps exist
+type OverlapChecker struct {
+	// Reader represents the (cached) reader which NodeCIDRAllocations, AddressPools and Nodes are read from
+	Reader client.Reader
+
+	// Interval represents the time between consecutive checks
+	Interval time.Duration
+
+	// overlaps holds the number of overlapping pairs found by the last check
+	overlaps atomic.Int64
+}
+
+// SetupWithManager adds the OverlapChecker to the Manager. The check runs on every replica
+func (o *OverlapChecker) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(o)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable so that the check runs on every replica, not only on the leader
+func (o *OverlapChecker) NeedLeaderElection() bool {
+	return false
+}
+
+// Start runs a check immediately and then once every Interval until the supplied context is done
+func (o *OverlapChecker) Start(ctx context.Context) error {
+	rl := log.FromContext(ctx).WithName("overlaps")
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if _, err := o.Check(ctx); err != nil {
+			rl.Error(
+				err,
+				"unable to check Node PodCIDRs and static allocations for overlaps",
+			)
+		}
+	}, o.Interval)
+
+	return nil
+}
+
+// Check finds every pair of overlapping ranges across the Node PodCIDRs and the static allocations of every NodeCIDRAllocation, and records
+// their number for the readiness check. On failure to list the resources, the result of the previous check is kept
+func (o *OverlapChecker) Check(ctx context.Context) ([]Overlap, error) {
+	resolved, nodes, err := list(ctx, o.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	overlaps := FindOverlaps(nodes, resolved)
+	o.overlaps.Store(int64(len(overlaps)))
+
+	return overlaps, nil
+}
+
+// Checker is a healthz.Checker which fails while the last check found overlapping ranges. It is used as a readiness check in strict mode
+func (o *OverlapChecker) Checker(_ *http.Request) error {
+	if overlaps := o.overlaps.Load(); overlaps > 0 {
+		return fmt.Errorf("%d pairs of overlapping PodCIDRs or static allocations were found by the last check", overlaps)
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package audit_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/audit"
+)
+
+// overlapPairs returns each overlap as "first cidr <-> second cidr" so that cases can be compared without the unexported fields of Range
+func overlapPairs(overlaps []audit.Overlap) []string {
+	pairs := []string{}
+	for _, o := range overlaps {
+		pairs = append(pairs, o.First.Kind+"/"+o.First.Name+" "+o.First.CIDR+" <-> "+o.Second.Kind+"/"+o.Second.Name+" "+o.Second.CIDR)
+	}
+
+	return pairs
+}
+
+func TestFindOverlaps(t *testing.T) {
+	staticA := newNodeCIDRAllocation("pool-a", nil, "10.0.0.0/16")
+	staticA.Spec.StaticAllocations = []string{"10.0.8.0/24", "10.0.9.0/24"}
+	staticB := newNodeCIDRAllocation("pool-b", nil, "10.0.0.0/16")
+	staticB.Spec.StaticAllocations = []string{"10.0.8.0/24"}
+
+	dualStack := newNode("dual", "10.0.3.0/24", nil, nil)
+	dualStack.Spec.PodCIDRs = []string{"10.0.3.0/24", "fd00::/64"}
+	dualStackOverlap := newNode("dual-overlap", "10.0.4.0/24", nil, nil)
+	dualStackOverlap.Spec.PodCIDRs = []string{"10.0.4.0/24", "fd00::/80"}
+
+	cases := []struct {
+		description         string
+		nodes               []corev1.Node
+		nodeCIDRAllocations []v1alpha1.NodeCIDRAllocation
+		want                []string
+	}{
+		{
+			// Case 1: Disjoint Node PodCIDRs and static allocations
+			// expected: no overlaps
+			description:         "disjoint",
+			nodes:               []corev1.Node{newNode("a", "10.0.0.0/24", nil, nil), newNode("b", "10.0.1.0/24", nil, nil), newNode("c", "", nil, nil)},
+			nodeCIDRAllocations: []v1alpha1.NodeCIDRAllocation{staticA},
+			want:                []string{},
+		},
+		{
+			// Case 2: Two Nodes hold the same PodCIDR and a third Node holds a range containing it
+			// expected: every pair is reported, with the containing range first
+			description: "nested and duplicate Node PodCIDRs",
+			nodes:       []corev1.Node{newNode("a", "10.0.0.0/24", nil, nil), newNode("b", "10.0.0.0/24", nil, nil), newNode("c", "10.0.0.0/16", nil, nil)},
+			want: []string{
+				"Node/c 10.0.0.0/16 <-> Node/a 10.0.0.0/24",
+				"Node/c 10.0.0.0/16 <-> Node/b 10.0.0.0/24",
+				"Node/a 10.0.0.0/24 <-> Node/b 10.0.0.0/24",
+			},
+		},
+		{
+			// Case 3: A Node PodCIDR overlaps a static allocation, which is also listed by a second NodeCIDRAllocation
+			// expected: the Node is reported against both static allocations, static allocations are not reported against each other
+			description:         "Node inside static allocations",
+			nodes:               []corev1.Node{newNode("a", "10.0.8.128/25", nil, nil)},
+			nodeCIDRAllocations: []v1alpha1.NodeCIDRAllocation{staticA, staticB},
+			want: []string{
+				"StaticAllocation/default/pool-a 10.0.8.0/24 <-> Node/a 10.0.8.128/25",
+				"StaticAllocation/default/pool-b 10.0.8.0/24 <-> Node/a 10.0.8.128/25",
+			},
+		},
+		{
+			// Case 4: Dual-stack Nodes whose IPv4 ranges are disjoint but whose IPv6 ranges overlap, and a Node listing its PodCIDR twice
+			// expected: only the IPv6 pair is reported
+			description: "dual-stack",
+			nodes:       []corev1.Node{dualStack, dualStackOverlap},
+			want:        []string{"Node/dual fd00::/64 <-> Node/dual-overlap fd00::/80"},
+		},
+	}
+
+	for _, c := range cases {
+		got := overlapPairs(audit.FindOverlaps(&corev1.NodeList{Items: c.nodes}, resolved(c.nodeCIDRAllocations...)))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, wanted %v", c.description, got, c.want)
+		}
+	}
+}
+
+func TestAuditorOverlaps(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
+
+	nodeA := newNode("a", "10.0.0.0/24", nil, nil)
+	nodeB := newNode("b", "10.0.0.128/25", nil, nil)
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(&nodeA, &nodeB).Build()
+	recorder := record.NewFakeRecorder(10)
+
+	auditor := &audit.Auditor{
+		Client:   c,
+		Recorder: recorder,
+		Key:      types.NamespacedName{Name: audit.DefaultReportName, Namespace: "cidr-allocator-system"},
+		Interval: time.Minute,
+	}
+
+	// Case 1: Two Nodes hold overlapping PodCIDRs
+	// expected: the overlap is reported and a Warning event is recorded against each Node
+	report, err := auditor.Run(context.Background())
+	if err != nil {
+		t.Fatalf("function was not expected to error. got %v", err)
+	}
+	if len(report.Overlaps) != 1 {
+		t.Errorf("got %d, wanted %d", len(report.Overlaps), 1)
+	}
+	if got := len(recorder.Events); got != 2 {
+		t.Errorf("got %d, wanted %d", got, 2)
+	}
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; !strings.HasPrefix(event, corev1.EventTypeWarning+" "+audit.EventReasonOverlappingPodCIDR) {
+			t.Errorf("got %q, wanted a %s event", event, audit.EventReasonOverlappingPodCIDR)
+		}
+	}
+}
+
+func TestOverlapChecker(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
+
+	nodeA := newNode("a", "10.0.0.0/24", nil, nil)
+	nodeB := newNode("b", "10.0.0.128/25", nil, nil)
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(&nodeA, &nodeB).Build()
+
+	checker := &audit.OverlapChecker{
+		Reader:   c,
+		Interval: time.Minute,
+	}
+
+	// Case 1: No check has run yet
+	// expected: the readiness check passes
+	if err := checker.Checker(nil); err != nil {
+		t.Errorf("function was not expected to error. got %v", err)
+	}
+
+	// Case 2: Two Nodes hold overlapping PodCIDRs
+	// expected: the readiness check fails, although the check does not run on the leader
+	if checker.NeedLeaderElection() {
+		t.Errorf("got %v, wanted %v", true, false)
+	}
+	overlaps, err := checker.Check(context.Background())
+	if err != nil {
+		t.Fatalf("function was not expected to error. got %v", err)
+	}
+	if len(overlaps) != 1 {
+		t.Errorf("got %d, wanted %d", len(overlaps), 1)
+	}
+	if err := checker.Checker(nil); err == nil {
+		t.Error("function was expected to return with an error")
+	}
+
+	// Case 3: The overlapping Node is removed
+	// expected: the readiness check passes again after the next check
+	if err := c.Delete(context.Background(), &nodeB); err != nil {
+		t.Fatalf("unable to delete Node. got %v", err)
+	}
+	if _, err := checker.Check(context.Background()); err != nil {
+		t.Fatalf("function was not expected to error. got %v", err)
+	}
+	if err := checker.Checker(nil); err != nil {
+		t.Errorf("function was not expected to error. got %v", err)
+	}
+}
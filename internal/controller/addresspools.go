@@ -0,0 +1,115 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+// resolveAddressPools resolves the AddressPools referenced by the NodeCIDRAllocation, and records a Warning event when any of them does not exist.
+// The NodeCIDRAllocation is returned without any referenced AddressPool when the AddressPools could not be listed
+func (r *NodeCIDRAllocationReconciler) resolveAddressPools(ctx context.Context, nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation) (*allocator.Resolved, error) {
+	resolved, err := allocator.Resolve(ctx, r.Client, nodeCIDRAllocation)
+	if err == nil {
+		return resolved[0], nil
+	}
+	if resolved == nil {
+		resolved = []*allocator.Resolved{{NodeCIDRAllocation: nodeCIDRAllocation}}
+	}
+
+	log.FromContext(ctx).Error(
+		err,
+		"unable to resolve the AddressPools referenced by NodeCIDRAllocation",
+		"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+		"addressPoolRefs", nodeCIDRAllocation.Spec.AddressPoolRefs,
+	)
+
+	if errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		r.Recorder.Eventf(
+			nodeCIDRAllocation,
+			corev1.EventTypeWarning,
+			EventReasonAddressPoolNotFound,
+			"Unable to resolve the referenced AddressPools: %s", err,
+		)
+	}
+
+	return resolved[0], err
+}
+
+// updateAddressPools records the NodeCIDRAllocations that reference each AddressPool referenced by the NodeCIDRAllocation along with the
+// capacity of the AddressPool in its status. The capacity is calculated for the AddressPool as a whole, so it is the same no matter how many
+// NodeCIDRAllocations reference it
+func (r *NodeCIDRAllocationReconciler) updateAddressPools(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) error {
+	if len(nodeCIDRAllocation.ReferencedPools) == 0 {
+		return nil
+	}
+
+	allNodeCIDRAllocations := v1alpha1.NodeCIDRAllocationList{}
+	if err := r.Client.List(ctx, &allNodeCIDRAllocations); err != nil {
+		return fmt.Errorf("unable to list NodeCIDRAllocations to update AddressPool status: %w", err)
+	}
+	allClusterNodes := corev1.NodeList{}
+	if err := r.Client.List(ctx, &allClusterNodes); err != nil {
+		return fmt.Errorf("unable to list Nodes to update AddressPool status: %w", err)
+	}
+
+	errs := []error{}
+	for _, resolved := range nodeCIDRAllocation.ReferencedPools {
+		referrers := []string{}
+		for _, item := range allNodeCIDRAllocations.Items {
+			if slices.Contains(item.Spec.AddressPoolRefs, resolved.GetName()) {
+				referrers = append(referrers, types.NamespacedName{Name: item.GetName(), Namespace: item.GetNamespace()}.String())
+			}
+		}
+		slices.Sort(referrers)
+
+		if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			addressPool := v1alpha1.AddressPool{}
+			if err := r.Client.Get(ctx, types.NamespacedName{Name: resolved.GetName()}, &addressPool); err != nil {
+				return err
+			}
+
+			status := addressPool.Status.DeepCopy()
+			addressPool.SetNodeCIDRAllocations(referrers)
+			addresses, allocated := allocator.AddressPoolCapacity(&addressPool, &allClusterNodes)
+			addressPool.SetCapacity(statcan_net.SaturatedInt64(addresses), statcan_net.SaturatedInt64(allocated))
+			if equality.Semantic.DeepEqual(status, &addressPool.Status) {
+				return nil
+			}
+
+			return r.Status().Update(ctx, &addressPool)
+		}); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("unable to update status of AddressPool %s: %w", resolved.GetName(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,283 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/audit"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+var (
+	// errAdoptionConflict is returned when the PodCIDR of a Node overlaps a different PodCIDR reserved in the ledger
+	errAdoptionConflict = errors.New("PodCIDR overlaps a PodCIDR reserved in the ledger")
+	// errAdoptionNodeChanged is returned when the PodCIDR or owner of a Node changed before its PodCIDR could be adopted
+	errAdoptionNodeChanged = errors.New("node PodCIDR or owner changed before the PodCIDR could be adopted")
+)
+
+// adoptionConflicts holds the ranges that an adopted PodCIDR must not overlap. It is built once per reconcile so that checking a Node does not
+// scan every other Node in the cluster
+type adoptionConflicts struct {
+	static       *ipam.Set
+	reservations []ipam.Reservation
+	reserved     *ipam.Set
+
+	// nodes holds, for each Node whose PodCIDR overlaps the PodCIDR of another Node, a description of the first such range
+	nodes map[string]string
+}
+
+// newAdoptionConflicts builds the ranges that the PodCIDRs adopted by the NodeCIDRAllocation must not overlap: its static allocations,
+// its unexpired reservations and the PodCIDRs of every other Node in the cluster
+func newAdoptionConflicts(nodeCIDRAllocation *allocator.Resolved, allClusterNodes *corev1.NodeList, now time.Time) *adoptionConflicts {
+	conflicts := &adoptionConflicts{
+		static:       ipam.NewSet(),
+		reservations: nodeCIDRAllocation.UnexpiredReservations(now),
+		reserved:     ipam.NewSet(),
+		nodes:        map[string]string{},
+	}
+	for _, static := range nodeCIDRAllocation.Spec.StaticAllocations {
+		if prefix, err := netip.ParsePrefix(static); err == nil {
+			conflicts.static.AddPrefix(prefix)
+		}
+	}
+	for _, reservation := range conflicts.reservations {
+		conflicts.reserved.AddPrefix(reservation.Prefix)
+	}
+
+	for _, overlap := range audit.FindOverlaps(allClusterNodes, nil) {
+		if _, ok := conflicts.nodes[overlap.First.Name]; !ok {
+			conflicts.nodes[overlap.First.Name] = overlap.Second.String()
+		}
+		if _, ok := conflicts.nodes[overlap.Second.Name]; !ok {
+			conflicts.nodes[overlap.Second.Name] = overlap.First.String()
+		}
+	}
+
+	return conflicts
+}
+
+// adoptionMisfit returns the reason that the existing PodCIDR of the supplied Node cannot be adopted by the NodeCIDRAllocation,
+// or an empty string when it can be adopted. The PodCIDR must lie within one of the address pools and must not overlap a static allocation
+// an unexpired reservation or the PodCIDR of any other Node in the cluster
+func adoptionMisfit(nodeCIDRAllocation *allocator.Resolved, node *corev1.Node, conflicts *adoptionConflicts) string {
+	if allocator.PoolFor(nodeCIDRAllocation.AddressPools(), node.Spec.PodCIDR) == "" {
+		return fmt.Sprintf("PodCIDR is outside of the address pools %v", nodeCIDRAllocation.AddressPools())
+	}
+
+	prefix, err := netip.ParsePrefix(node.Spec.PodCIDR)
+	if err != nil {
+		return fmt.Sprintf("PodCIDR is invalid: %s", err)
+	}
+
+	// the address sets only tell whether there is an overlap. The (few) overlapping ranges are only looked up to name them
+	if conflicts.static.Overlaps(prefix) {
+		for _, static := range nodeCIDRAllocation.Spec.StaticAllocations {
+			if overlap, err := statcan_net.NetworksOverlap(static, node.Spec.PodCIDR); err == nil && overlap {
+				return fmt.Sprintf("PodCIDR overlaps static allocation %s", static)
+			}
+		}
+	}
+
+	if conflicts.reserved.Overlaps(prefix) {
+		for _, reservation := range conflicts.reservations {
+			if reservation.Prefix.Overlaps(prefix) {
+				return fmt.Sprintf("PodCIDR overlaps reservation %s (%s)", reservation.Name, reservation.Prefix)
+			}
+		}
+	}
+
+	if other, ok := conflicts.nodes[node.GetName()]; ok {
+		return fmt.Sprintf("PodCIDR overlaps %s", other)
+	}
+
+	return ""
+}
+
+// adoptExistingAllocations adopts the existing PodCIDR of every Node selected by the NodeCIDRAllocation that is not managed yet, so that it is
+// treated as an allocation of the NodeCIDRAllocation from then on. Each adopted PodCIDR is reserved in the ledger (if configured) and the Node
+// is annotated in the same way as the Nodes allocated by the NodeCIDRAllocation, with the addition of the adopted annotation.
+// Nodes whose PodCIDR cannot be adopted are listed as misfits in the returned summary. Errors that may be resolved by a later reconcile
+// (for example, a failed patch) are returned along with the summary
+func (r *NodeCIDRAllocationReconciler) adoptExistingAllocations(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) (*v1alpha1.AdoptionStatus, error) {
+	rl := log.FromContext(ctx)
+	owner := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+
+	allClusterNodes := corev1.NodeList{}
+	if err := r.Client.List(ctx, &allClusterNodes); err != nil {
+		return nodeCIDRAllocation.Adoption(), fmt.Errorf("unable to list Nodes to adopt: %w", err)
+	}
+
+	var previous []v1alpha1.AdoptionMisfit
+	if nodeCIDRAllocation.Adoption() != nil {
+		previous = nodeCIDRAllocation.Adoption().Misfits
+	}
+
+	// events are only recorded for new misfits, since misfit Nodes are checked again on every reconcile
+	adoption := &v1alpha1.AdoptionStatus{}
+	newMisfits := []string{}
+	addMisfit := func(node *corev1.Node, reason string) {
+		misfit := v1alpha1.AdoptionMisfit{Node: node.GetName(), PodCIDR: node.Spec.PodCIDR, Reason: reason}
+		adoption.Misfits = append(adoption.Misfits, misfit)
+		if slices.Contains(previous, misfit) {
+			return
+		}
+
+		newMisfits = append(newMisfits, node.GetName())
+		r.Recorder.Eventf(nodeReference(node), corev1.EventTypeWarning, EventReasonAdoptionMisfit,
+			"PodCIDR %s could not be adopted by NodeCIDRAllocation %s: %s", node.Spec.PodCIDR, owner, reason,
+		)
+	}
+	conflicts := newAdoptionConflicts(nodeCIDRAllocation, &allClusterNodes, time.Now())
+
+	var errs []error
+	selector := labels.SelectorFromSet(nodeCIDRAllocation.Spec.NodeSelector)
+	for i := range allClusterNodes.Items {
+		node := &allClusterNodes.Items[i]
+		if node.Spec.PodCIDR == "" || !selector.Matches(labels.Set(node.GetLabels())) {
+			continue
+		}
+
+		if managedBy, ok := node.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation]; ok {
+			if managedBy != owner {
+				addMisfit(node, fmt.Sprintf("PodCIDR is managed by NodeCIDRAllocation %s", managedBy))
+			} else if node.GetAnnotations()[v1alpha1.AnnotationAdopted] == "true" {
+				adoption.Adopted++
+			}
+
+			continue
+		}
+
+		if reason := adoptionMisfit(nodeCIDRAllocation, node, conflicts); reason != "" {
+			addMisfit(node, reason)
+			continue
+		}
+
+		pool := allocator.PoolFor(nodeCIDRAllocation.AddressPools(), node.Spec.PodCIDR)
+		if err := r.adoptPodCIDR(ctx, nodeCIDRAllocation.NodeCIDRAllocation, node.GetName(), node.GetUID(), node.Spec.PodCIDR, pool); err != nil {
+			if errors.Is(err, errAdoptionConflict) {
+				addMisfit(node, err.Error())
+				continue
+			}
+
+			rl.Error(
+				err,
+				"unable to adopt existing PodCIDR of Node",
+				"name", node.GetName(),
+				"podCIDR", node.Spec.PodCIDR,
+			)
+			errs = append(errs, fmt.Errorf("node %s: %w", node.GetName(), err))
+			continue
+		}
+
+		rl.Info(
+			"adopted existing PodCIDR of Node",
+			"name", node.GetName(),
+			"podCIDR", node.Spec.PodCIDR,
+			"pool", pool,
+		)
+		adoption.Adopted++
+		r.Recorder.Eventf(nodeReference(node), corev1.EventTypeNormal, EventReasonAdopted,
+			"PodCIDR %s was adopted into address pool %s by NodeCIDRAllocation %s", node.Spec.PodCIDR, pool, owner,
+		)
+	}
+
+	adoption.Complete = len(adoption.Misfits) == 0 && len(errs) == 0
+	if len(newMisfits) > 0 {
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeWarning,
+			EventReasonAdoptionMisfit,
+			"PodCIDRs of %d selected Nodes could not be adopted: %s", len(newMisfits), strings.Join(newMisfits, ", "),
+		)
+	} else if len(adoption.Misfits) == 0 && adoption.Complete && (nodeCIDRAllocation.Adoption() == nil || !nodeCIDRAllocation.Adoption().Complete) {
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeNormal,
+			EventReasonAdoptionComplete,
+			"Every selected Node holding a PodCIDR is managed by NodeCIDRAllocation %s (%d adopted)", owner, adoption.Adopted,
+		)
+	}
+
+	return adoption, utilerrors.NewAggregate(errs)
+}
+
+// adoptPodCIDR reserves the existing PodCIDR of the named Node in the ledger (if configured) and annotates the Node as allocated from the
+// supplied address pool by the NodeCIDRAllocation. The annotations are only applied while the Node still holds the same PodCIDR and is not
+// managed by any NodeCIDRAllocation
+func (r *NodeCIDRAllocationReconciler) adoptPodCIDR(ctx context.Context, nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, nodeName string, uid types.UID, podCIDR, pool string) error {
+	if r.Ledger != nil {
+		owner := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+		reserved, err := r.Ledger.Reserve(ctx, nodeName, uid, owner, func(reserved []string) (string, error) {
+			for _, cidr := range reserved {
+				// a reservation of the same PodCIDR (for example, one retained from a deleted NodeCIDRAllocation) is the allocation being adopted
+				if overlap, err := statcan_net.NetworksOverlap(cidr, podCIDR); err == nil && overlap && cidr != podCIDR {
+					return "", fmt.Errorf("%w (%s)", errAdoptionConflict, cidr)
+				}
+			}
+
+			return podCIDR, nil
+		})
+		if err != nil {
+			return err
+		}
+		if reserved != podCIDR {
+			return fmt.Errorf("%w (%s is reserved for the Node)", errAdoptionConflict, reserved)
+		}
+	}
+
+	annotations := nodeAllocationAnnotations(nodeCIDRAllocation, pool, time.Now())
+	annotations[v1alpha1.AnnotationAdopted] = "true"
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node := corev1.Node{}
+		if err := r.apiReader().Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+			return err
+		}
+
+		if _, ok := node.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation]; ok || node.Spec.PodCIDR != podCIDR {
+			return errAdoptionNodeChanged
+		}
+
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		for k, v := range annotations {
+			node.Annotations[k] = v
+		}
+
+		return r.Patch(ctx, &node, patch)
+	})
+}
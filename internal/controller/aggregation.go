@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+const (
+	// aggregatedRoutesConfigMapSuffix is appended to the name of the NodeCIDRAllocation to name its generated ConfigMap
+	aggregatedRoutesConfigMapSuffix = "-aggregated-routes"
+	// aggregatedRoutesPrefixesKey is the ConfigMap key containing a newline-separated list of the aggregated prefixes
+	aggregatedRoutesPrefixesKey = "prefixes"
+	// aggregatedRoutesJSONKey is the ConfigMap key containing the aggregated prefixes along with the Nodes behind each one
+	aggregatedRoutesJSONKey = "aggregates.json"
+)
+
+// aggregationGroup identifies a set of Node allocations that are summarized together
+type aggregationGroup struct {
+	pool  string
+	group string
+}
+
+// calculateRouteAggregates summarizes the PodCIDRs allocated to the provided Nodes into the smallest set of aggregate prefixes
+// for each address pool of the NodeCIDRAllocation (and for each value of the AggregationLabel when configured).
+// Node allocations that do not fall within any of the address pools are not considered.
+func calculateRouteAggregates(nodeCIDRAllocation *allocator.Resolved, nodes *corev1.NodeList) ([]v1alpha1.AggregatedRoute, error) {
+	groups := []aggregationGroup{}
+	allocations := map[aggregationGroup]map[string]string{} // node name -> PodCIDR for every aggregation group
+
+	for _, node := range nodes.Items {
+		if node.Spec.PodCIDR == "" {
+			continue
+		}
+
+		for _, pool := range nodeCIDRAllocation.AddressPools() {
+			contained, err := statcan_net.NetworkContains(pool, node.Spec.PodCIDR)
+			if err != nil {
+				return []v1alpha1.AggregatedRoute{}, err
+			}
+			if !contained {
+				continue
+			}
+
+			g := aggregationGroup{pool: pool}
+			if nodeCIDRAllocation.Spec.AggregationLabel != "" {
+				g.group = node.GetLabels()[nodeCIDRAllocation.Spec.AggregationLabel]
+			}
+			if _, ok := allocations[g]; !ok {
+				allocations[g] = map[string]string{}
+				groups = append(groups, g)
+			}
+			allocations[g][node.GetName()] = node.Spec.PodCIDR
+
+			break
+		}
+	}
+
+	// keep the output stable between reconciles so that the status and ConfigMap are only updated on real changes
+	poolOrder := map[string]int{}
+	for i, pool := range nodeCIDRAllocation.AddressPools() {
+		if _, ok := poolOrder[pool]; !ok {
+			poolOrder[pool] = i
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].pool != groups[j].pool {
+			return poolOrder[groups[i].pool] < poolOrder[groups[j].pool]
+		}
+		return groups[i].group < groups[j].group
+	})
+
+	aggregates := []v1alpha1.AggregatedRoute{}
+	for _, g := range groups {
+		podCIDRs := make([]string, 0, len(allocations[g]))
+		for _, podCIDR := range allocations[g] {
+			podCIDRs = append(podCIDRs, podCIDR)
+		}
+
+		prefixes, err := statcan_net.AggregateNetworks(podCIDRs)
+		if err != nil {
+			return []v1alpha1.AggregatedRoute{}, err
+		}
+
+		for _, prefix := range prefixes {
+			route := v1alpha1.AggregatedRoute{
+				Prefix: prefix,
+				Pool:   g.pool,
+				Group:  g.group,
+			}
+			for nodeName, podCIDR := range allocations[g] {
+				if contained, _ := statcan_net.NetworkContains(prefix, podCIDR); contained {
+					route.Nodes = append(route.Nodes, nodeName)
+				}
+			}
+			sort.Strings(route.Nodes)
+
+			aggregates = append(aggregates, route)
+		}
+	}
+
+	return aggregates, nil
+}
+
+// updateRouteAggregates recalculates the aggregated routes for all Nodes tracked by the NodeCIDRAllocation, stores them in the
+// NodeCIDRAllocation status and publishes them to a generated ConfigMap owned by the NodeCIDRAllocation.
+// The status is only modified in-memory, it is expected to be persisted by the caller
+func (r *NodeCIDRAllocationReconciler) updateRouteAggregates(ctx context.Context, nodeCIDRAllocation *allocator.Resolved, trackedNodes *corev1.NodeList) {
+	log := log.FromContext(ctx)
+
+	aggregates, err := calculateRouteAggregates(nodeCIDRAllocation, trackedNodes)
+	if err != nil {
+		log.Error(
+			err,
+			"unable to calculate aggregated routes for NodeCIDRAllocation",
+			"name", nodeCIDRAllocation.GetName(),
+		)
+		return
+	}
+	nodeCIDRAllocation.SetAggregates(aggregates)
+
+	if err := r.publishRouteAggregates(ctx, nodeCIDRAllocation.NodeCIDRAllocation, aggregates); err != nil {
+		log.Error(
+			err,
+			"unable to publish aggregated routes ConfigMap for NodeCIDRAllocation",
+			"name", nodeCIDRAllocation.GetName(),
+		)
+	}
+}
+
+// publishRouteAggregates creates or updates the ConfigMap containing the aggregated routes for the NodeCIDRAllocation.
+// The ConfigMap is owned by the NodeCIDRAllocation and is garbage collected along with it
+func (r *NodeCIDRAllocationReconciler) publishRouteAggregates(ctx context.Context, nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, aggregates []v1alpha1.AggregatedRoute) error {
+	aggregatesJSON, err := json.Marshal(aggregates)
+	if err != nil {
+		return err
+	}
+
+	prefixes := make([]string, len(aggregates))
+	for i, a := range aggregates {
+		prefixes[i] = a.Prefix
+	}
+
+	configMap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nodeCIDRAllocation.GetName() + aggregatedRoutesConfigMapSuffix,
+			Namespace: nodeCIDRAllocation.GetNamespace(),
+		},
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, &configMap, func() error {
+		configMap.Data = map[string]string{
+			aggregatedRoutesPrefixesKey: strings.Join(prefixes, "\n"),
+			aggregatedRoutesJSONKey:     string(aggregatesJSON),
+		}
+
+		return controllerutil.SetControllerReference(nodeCIDRAllocation, &configMap, r.Scheme)
+	})
+
+	return err
+}
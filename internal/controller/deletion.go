@@ -0,0 +1,182 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+)
+
+// errLedgerRequired is returned when the PodCIDRs of a deleted NodeCIDRAllocation must be retained but no ledger is configured to retain them in
+var errLedgerRequired = errors.New("the RetainAsReservation deletion policy requires a ledger")
+
+// ownedNodes returns every Node in the cluster that holds a PodCIDR owned by the NodeCIDRAllocation, sorted by name.
+// All Nodes are considered (and not only the Nodes that are currently selected) since the labels of an allocated Node may have changed
+func (r *NodeCIDRAllocationReconciler) ownedNodes(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) ([]corev1.Node, error) {
+	allClusterNodes := corev1.NodeList{}
+	if err := r.Client.List(ctx, &allClusterNodes); err != nil {
+		return nil, err
+	}
+
+	owned := []corev1.Node{}
+	for _, node := range allClusterNodes.Items {
+		if allocator.OwnsNode(nodeCIDRAllocation, &node) {
+			owned = append(owned, node)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].GetName() < owned[j].GetName() })
+
+	return owned, nil
+}
+
+// deletionBlocked applies the deletion policy of a NodeCIDRAllocation that is being deleted to the Nodes it owns.
+// It returns true when the deletion must wait, in which case the Nodes blocking the deletion are recorded in the status
+func (r *NodeCIDRAllocationReconciler) deletionBlocked(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) (bool, error) {
+	rl := log.FromContext(ctx)
+
+	owned, err := r.ownedNodes(ctx, nodeCIDRAllocation)
+	if err != nil {
+		return true, fmt.Errorf("unable to list the Nodes owned by the NodeCIDRAllocation: %w", err)
+	}
+	if len(owned) == 0 {
+		return false, nil
+	}
+
+	names := make([]string, 0, len(owned))
+	for _, node := range owned {
+		names = append(names, node.GetName())
+	}
+
+	switch nodeCIDRAllocation.DeletionPolicy() {
+	case v1alpha1.DeletionPolicyOrphan:
+		rl.Info(
+			"leaving owned Nodes with their PodCIDR allocation (deletion policy Orphan)",
+			"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"nodes", names,
+		)
+
+		return false, nil
+	case v1alpha1.DeletionPolicyRetainAsReservation:
+		if r.Ledger == nil {
+			return true, errLedgerRequired
+		}
+
+		podCIDRs := make(map[string]string, len(owned))
+		for _, node := range owned {
+			podCIDRs[node.GetName()] = node.Spec.PodCIDR
+		}
+
+		owner := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+		if err := r.Ledger.Retain(ctx, owner, podCIDRs, nodeCIDRAllocation.RetentionPeriod()); err != nil {
+			return true, fmt.Errorf("unable to retain the PodCIDRs of the owned Nodes in the ledger: %w", err)
+		}
+
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeNormal,
+			EventReasonRetained,
+			"PodCIDRs of %d owned Nodes were retained as reservations in ledger %s", len(owned), r.Ledger.Key(),
+		)
+
+		return false, nil
+	default:
+		rl.V(1).Info(
+			"there are existing Node allocations that are still tied to this resource. waiting until all nodes owned by this NodeCIDRAllocation resource are removed",
+			"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"nodes", names,
+		)
+
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeWarning,
+			EventReasonOrphanedNodes,
+			"Deletion of NodeCIDRAllocation resource (%s) would leave Nodes orphaned: %s", nodeCIDRAllocation.GetName(), strings.Join(names, ", "),
+		)
+
+		nodeCIDRAllocation.SetBlockingNodes(names)
+		nodeCIDRAllocation.SetHealthStatus(v1alpha1.HealthStatusUnhealthy)
+		if err := r.Status().Update(ctx, nodeCIDRAllocation.NodeCIDRAllocation); err != nil {
+			rl.Error(
+				err,
+				"unable to update resource status for NodeCIDRAllocation",
+			)
+		}
+
+		return true, nil
+	}
+}
+
+// releaseRetainedReservations releases the PodCIDRs retained in the ledger (by the RetainAsReservation deletion policy of a deleted
+// NodeCIDRAllocation) that are held by a Node owned by the NodeCIDRAllocation, since the range is accounted for by its owner again.
+// Without it, the range would stay reserved forever once the Node is removed
+func (r *NodeCIDRAllocationReconciler) releaseRetainedReservations(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) error {
+	if r.Ledger == nil {
+		return nil
+	}
+
+	owned, err := r.ownedNodes(ctx, nodeCIDRAllocation)
+	if err != nil {
+		return fmt.Errorf("unable to list the Nodes owned by the NodeCIDRAllocation: %w", err)
+	}
+	if len(owned) == 0 {
+		return nil
+	}
+
+	ownedPodCIDRs := make(map[string]string, len(owned))
+	for _, node := range owned {
+		ownedPodCIDRs[node.Spec.PodCIDR] = node.GetName()
+	}
+
+	released, err := r.Ledger.ReleaseRetained(ctx, func(reservation ledger.Reservation) bool {
+		_, ok := ownedPodCIDRs[reservation.CIDR]
+		return ok
+	})
+	if err != nil {
+		return fmt.Errorf("unable to release the retained PodCIDRs owned by the NodeCIDRAllocation: %w", err)
+	}
+
+	for _, reservation := range released {
+		log.FromContext(ctx).Info(
+			"released retained PodCIDR which is owned by the NodeCIDRAllocation",
+			"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"podCIDR", reservation.CIDR,
+			"node", ownedPodCIDRs[reservation.CIDR],
+			"retainedBy", reservation.Owner,
+		)
+
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeNormal,
+			EventReasonRetainedReleased,
+			"PodCIDR %s retained by deleted NodeCIDRAllocation %s is owned by Node %s of this NodeCIDRAllocation and was released from ledger %s",
+			reservation.CIDR, reservation.Owner, ownedPodCIDRs[reservation.CIDR], r.Ledger.Key(),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+)
+
+// retainRemovedPools keeps the address pools that were removed from the NodeCIDRAllocation since the last reconcile (from .spec.addressPools,
+// from the CIDRs of a referenced AddressPool or along with a deleted AddressPool) while Nodes that it owns still hold a PodCIDR from them.
+// A retained pool is treated as a draining pool until no Node is left on it, so that the Nodes on it stay owned. A Warning event is recorded
+// when a pool is first retained, and a Normal event when it is released. With the force-pool-removal annotation, the pools are removed
+// regardless (with a Warning event)
+func (r *NodeCIDRAllocationReconciler) retainRemovedPools(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) error {
+	previouslyRetained := nodeCIDRAllocation.RetainedPools
+	candidates := append(append([]string{}, previouslyRetained...), nodeCIDRAllocation.Status.AddressPools...)
+
+	nodeCIDRAllocation.RetainedPools = nil
+	pools := nodeCIDRAllocation.AddressPools()
+	removed := []string{}
+	for _, pool := range candidates {
+		if !slices.Contains(pools, pool) && !slices.Contains(removed, pool) {
+			removed = append(removed, pool)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	// Nodes owned through their address pool (rather than the owner annotation) are only owned while the removed pools are considered
+	withRemoved := &allocator.Resolved{
+		NodeCIDRAllocation: nodeCIDRAllocation.NodeCIDRAllocation,
+		ReferencedPools:    nodeCIDRAllocation.ReferencedPools,
+		RetainedPools:      removed,
+	}
+	owned, err := r.ownedNodes(ctx, withRemoved)
+	if err != nil {
+		return fmt.Errorf("unable to list the Nodes owned by the NodeCIDRAllocation: %w", err)
+	}
+
+	forced := nodeCIDRAllocation.GetAnnotations()[v1alpha1.AnnotationForcePoolRemoval] == "true"
+	for _, pool := range removed {
+		nodes := []string{}
+		for _, node := range owned {
+			if allocator.PoolFor([]string{pool}, node.Spec.PodCIDR) != "" {
+				nodes = append(nodes, node.GetName())
+			}
+		}
+		if len(nodes) == 0 {
+			if slices.Contains(previouslyRetained, pool) {
+				log.FromContext(ctx).Info(
+					"removed address pool no longer holds any Node allocation. releasing it",
+					"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+					"pool", pool,
+				)
+
+				r.Recorder.Eventf(
+					nodeCIDRAllocation.NodeCIDRAllocation,
+					corev1.EventTypeNormal,
+					EventReasonPoolDrained,
+					"Removed address pool %s no longer holds any Node allocation and was released", pool,
+				)
+			}
+			continue
+		}
+
+		if forced {
+			log.FromContext(ctx).Info(
+				"address pool removed while Nodes still hold a PodCIDR from it (forced)",
+				"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+				"pool", pool,
+				"nodes", nodes,
+			)
+
+			r.Recorder.Eventf(
+				nodeCIDRAllocation.NodeCIDRAllocation,
+				corev1.EventTypeWarning,
+				EventReasonPoolRemovalForced,
+				"Address pool %s was removed while Nodes %s still hold a PodCIDR from it (%s=true)", pool, strings.Join(nodes, ", "), v1alpha1.AnnotationForcePoolRemoval,
+			)
+			continue
+		}
+
+		nodeCIDRAllocation.RetainedPools = append(nodeCIDRAllocation.RetainedPools, pool)
+		if slices.Contains(previouslyRetained, pool) {
+			continue
+		}
+
+		log.FromContext(ctx).Info(
+			"address pool removed while Nodes still hold a PodCIDR from it. keeping it as a draining pool",
+			"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"pool", pool,
+			"nodes", nodes,
+		)
+
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeWarning,
+			EventReasonPoolRemovalBlocked,
+			"Address pool %s was removed while Nodes %s still hold a PodCIDR from it. It is kept as a draining pool until no Node is left on it, or until the %s=true annotation is set",
+			pool, strings.Join(nodes, ", "), v1alpha1.AnnotationForcePoolRemoval,
+		)
+	}
+
+	return nil
+}
+
+// drainingPools returns each draining (or retained) address pool of the NodeCIDRAllocation along with the owned Nodes (sorted by name) that
+// still hold a PodCIDR from it. Draining pools that are not one of the address pools are ignored
+func drainingPools(nodeCIDRAllocation *allocator.Resolved, owned []corev1.Node) []v1alpha1.DrainingPool {
+	draining := []v1alpha1.DrainingPool{}
+	for _, pool := range nodeCIDRAllocation.AddressPools() {
+		removed := slices.Contains(nodeCIDRAllocation.RetainedPools, pool)
+		if !removed && !slices.Contains(nodeCIDRAllocation.Spec.DrainingPools, pool) {
+			continue
+		}
+
+		drainingPool := v1alpha1.DrainingPool{Pool: pool, Removed: removed}
+		for _, node := range owned {
+			if allocator.PoolFor([]string{pool}, node.Spec.PodCIDR) != "" {
+				drainingPool.Nodes = append(drainingPool.Nodes, node.GetName())
+			}
+		}
+		draining = append(draining, drainingPool)
+	}
+
+	if len(draining) == 0 {
+		return nil
+	}
+
+	return draining
+}
+
+// updateDrainingPools records the Nodes still holding a PodCIDR from each draining (or retained) address pool of the NodeCIDRAllocation in its
+// status, and records an event for every draining pool that no longer has any Node on it
+func (r *NodeCIDRAllocationReconciler) updateDrainingPools(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) error {
+	if len(nodeCIDRAllocation.Spec.DrainingPools) == 0 && len(nodeCIDRAllocation.RetainedPools) == 0 {
+		nodeCIDRAllocation.SetDraining(nil)
+		return nil
+	}
+
+	owned, err := r.ownedNodes(ctx, nodeCIDRAllocation)
+	if err != nil {
+		return fmt.Errorf("unable to list the Nodes owned by the NodeCIDRAllocation: %w", err)
+	}
+
+	previous := map[string]int{}
+	for _, drainingPool := range nodeCIDRAllocation.Draining() {
+		previous[drainingPool.Pool] = len(drainingPool.Nodes)
+	}
+
+	draining := drainingPools(nodeCIDRAllocation, owned)
+	for _, drainingPool := range draining {
+		if len(drainingPool.Nodes) > 0 {
+			continue
+		}
+
+		if count, ok := previous[drainingPool.Pool]; !ok || count > 0 {
+			log.FromContext(ctx).Info(
+				"draining address pool no longer holds any Node allocation",
+				"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+				"pool", drainingPool.Pool,
+			)
+
+			r.Recorder.Eventf(
+				nodeCIDRAllocation.NodeCIDRAllocation,
+				corev1.EventTypeNormal,
+				EventReasonPoolDrained,
+				"Draining address pool %s no longer holds any Node allocation and can be removed", drainingPool.Pool,
+			)
+		}
+	}
+	nodeCIDRAllocation.SetDraining(draining)
+
+	return nil
+}
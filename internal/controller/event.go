@@ -26,8 +26,23 @@ SOFTWARE.
 package controller
 
 const (
-	EventReasonDeleted        = "Delete"
-	EventReasonOrphanedNodes  = "Orphaned Nodes"
-	EventReasonAllocated      = "PodCIDR Allocated"
-	EventReasonNoAddressSpace = "No Free Address Space"
+	EventReasonDeleted             = "Delete"
+	EventReasonOrphanedNodes       = "Orphaned Nodes"
+	EventReasonAllocated           = "PodCIDR Allocated"
+	EventReasonNoAddressSpace      = "No Free Address Space"
+	EventReasonIntegrationDrift    = "Integration Drift"
+	EventReasonAllocationFailed    = "PodCIDR Allocation Failed"
+	EventReasonRetained            = "PodCIDRs Retained"
+	EventReasonRetainedReleased    = "Retained PodCIDR Released"
+	EventReasonAdopted             = "PodCIDR Adopted"
+	EventReasonAdoptionMisfit      = "PodCIDR Adoption Misfit"
+	EventReasonAdoptionComplete    = "Adoption Complete"
+	EventReasonPoolDrained         = "Pool Drained"
+	EventReasonPoolRemovalBlocked  = "Pool Removal Blocked"
+	EventReasonPoolRemovalForced   = "Pool Removal Forced"
+	EventReasonPinConflict         = "Pinned Allocation Conflict"
+	EventReasonReservationExpired  = "Reservation Expired"
+	EventReasonAddressPoolNotFound = "Address Pool Not Found"
+	EventReasonPaused              = "Allocations Paused"
+	EventReasonResumed             = "Allocations Resumed"
 )
@@ -0,0 +1,78 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+)
+
+// TrimNode is a cache transform (see cache.ByObject.Transform) which strips the cached Nodes down to what the allocator reads: the object
+// metadata without managed fields and with only the annotations of the operator, the PodCIDRs, the allocatable pods and the PodCIDRAllocated
+// condition. Everything else (such as images, addresses, the other conditions and the node info) is dropped, which reduces the memory
+// used by the cache considerably on large clusters.
+//
+// The Nodes are written with patches computed from Nodes read through the API reader (not the cache), so nothing is lost on write.
+// Objects which are not Nodes are returned as is
+func TrimNode(obj interface{}) (interface{}, error) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return obj, nil
+	}
+
+	trimmed := &corev1.Node{
+		TypeMeta: node.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              node.Name,
+			UID:               node.UID,
+			ResourceVersion:   node.ResourceVersion,
+			Generation:        node.Generation,
+			CreationTimestamp: node.CreationTimestamp,
+			DeletionTimestamp: node.DeletionTimestamp,
+			Labels:            node.Labels,
+			OwnerReferences:   node.OwnerReferences,
+			Finalizers:        node.Finalizers,
+		},
+		Spec: corev1.NodeSpec{
+			PodCIDR:  node.Spec.PodCIDR,
+			PodCIDRs: node.Spec.PodCIDRs,
+		},
+	}
+
+	for k, v := range node.Annotations {
+		if !strings.HasPrefix(k, v1alpha1.GroupVersion.Group+"/") {
+			continue
+		}
+		if trimmed.Annotations == nil {
+			trimmed.Annotations = map[string]string{}
+		}
+		trimmed.Annotations[k] = v
+	}
+
+	if pods, ok := node.Status.Allocatable[corev1.ResourcePods]; ok {
+		trimmed.Status.Allocatable = corev1.ResourceList{corev1.ResourcePods: pods}
+	}
+	if condition := podCIDRAllocatedCondition(node); condition != nil {
+		trimmed.Status.Conditions = []corev1.NodeCondition{*condition}
+	}
+
+	return trimmed, nil
+}
@@ -0,0 +1,239 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/controller"
+)
+
+// newSyntheticNode creates a Node resembling the Nodes of a production cluster, with images, conditions, addresses, managed fields and
+// annotations written by other components
+func newSyntheticNode(i int) *corev1.Node {
+	node := newTestNode(fmt.Sprintf("node-%05d", i), map[string]string{
+		"kubernetes.io/hostname":           fmt.Sprintf("node-%05d", i),
+		"kubernetes.io/os":                 "linux",
+		"kubernetes.io/arch":               "amd64",
+		"node.kubernetes.io/instance-type": "Standard_D16s_v5",
+		"topology.kubernetes.io/region":    "canadacentral",
+		"topology.kubernetes.io/zone":      fmt.Sprintf("canadacentral-%d", i%3+1),
+		"agentpool":                        "general",
+	}, 110)
+	node.UID = types.UID(fmt.Sprintf("00000000-0000-0000-0000-%012d", i))
+	node.ResourceVersion = fmt.Sprint(i)
+	node.Spec.PodCIDR = fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+	node.Spec.PodCIDRs = []string{node.Spec.PodCIDR}
+	node.Spec.ProviderID = fmt.Sprintf("azure:///subscriptions/0000/resourceGroups/nodes/providers/Microsoft.Compute/virtualMachineScaleSets/general/virtualMachines/%d", i)
+	node.Annotations = map[string]string{
+		v1alpha1.AnnotationNodeCIDRAllocation:                    "default/general",
+		v1alpha1.AnnotationAddressPool:                           "10.0.0.0/8",
+		"node.alpha.kubernetes.io/ttl":                           "0",
+		"volumes.kubernetes.io/controller-managed-attach-detach": "true",
+		"csi.volume.kubernetes.io/nodeid":                        fmt.Sprintf(`{"disk.csi.azure.com":"node-%05d","file.csi.azure.com":"node-%05d"}`, i, i),
+	}
+
+	resources := corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("15820m"),
+		corev1.ResourceMemory:           resource.MustParse("57933636Ki"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("119703055367"),
+		corev1.ResourcePods:             resource.MustParse("110"),
+		"hugepages-1Gi":                 resource.MustParse("0"),
+		"hugepages-2Mi":                 resource.MustParse("0"),
+	}
+	node.Status.Capacity = resources.DeepCopy()
+	node.Status.Allocatable = resources.DeepCopy()
+
+	for _, conditionType := range []corev1.NodeConditionType{"KernelDeadlock", "ReadonlyFilesystem", "FrequentKubeletRestart", corev1.NodeMemoryPressure, corev1.NodeDiskPressure, corev1.NodePIDPressure, corev1.NodeReady} {
+		node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{
+			Type:    conditionType,
+			Status:  corev1.ConditionFalse,
+			Reason:  "KubeletHasSufficient" + string(conditionType),
+			Message: "kubelet has no " + strings.ToLower(string(conditionType)),
+		})
+	}
+	node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{
+		Type:    v1alpha1.NodeConditionPodCIDRAllocated,
+		Status:  corev1.ConditionTrue,
+		Reason:  v1alpha1.NodeConditionReasonAllocated,
+		Message: fmt.Sprintf("PodCIDR %s was allocated by NodeCIDRAllocation default/general", node.Spec.PodCIDR),
+	})
+	node.Status.Addresses = []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: fmt.Sprintf("172.16.%d.%d", i/256, i%256)},
+		{Type: corev1.NodeHostName, Address: node.GetName()},
+	}
+	node.Status.NodeInfo = corev1.NodeSystemInfo{
+		MachineID:               fmt.Sprintf("%032x", i),
+		SystemUUID:              fmt.Sprintf("%032x", i),
+		BootID:                  fmt.Sprintf("%032x", i),
+		KernelVersion:           "5.15.0-1068-azure",
+		OSImage:                 "Ubuntu 22.04.4 LTS",
+		ContainerRuntimeVersion: "containerd://1.7.15-1",
+		KubeletVersion:          "v1.29.4",
+		KubeProxyVersion:        "v1.29.4",
+		OperatingSystem:         "linux",
+		Architecture:            "amd64",
+	}
+	for j := 0; j < 50; j++ {
+		node.Status.Images = append(node.Status.Images, corev1.ContainerImage{
+			Names: []string{
+				fmt.Sprintf("registry.example.com/platform/component-%02d@sha256:%064x", j, j),
+				fmt.Sprintf("registry.example.com/platform/component-%02d:v1.%d.0", j, j),
+			},
+			SizeBytes: int64(j) * 1 << 20,
+		})
+	}
+	for _, manager := range []string{"kubelet", "kube-controller-manager", "node-problem-detector", "cidr-allocator"} {
+		node.ManagedFields = append(node.ManagedFields, metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  metav1.ManagedFieldsOperationUpdate,
+			APIVersion: "v1",
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(strings.Repeat(`{"f:status":{"f:conditions":{"k:{\"type\":\"Ready\"}":{}}}}`, 10))},
+		})
+	}
+
+	return node
+}
+
+func TestTrimNode(t *testing.T) {
+	node := newSyntheticNode(1)
+
+	// Case 1: A Node is trimmed
+	// expected: only the fields read by the allocator are kept
+	obj, err := controller.TrimNode(node.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	trimmed := obj.(*corev1.Node)
+
+	if trimmed.GetName() != node.GetName() || trimmed.GetUID() != node.GetUID() || trimmed.GetResourceVersion() != node.GetResourceVersion() {
+		t.Errorf("got %v, wanted the name, UID and resourceVersion to be kept", trimmed.ObjectMeta)
+	}
+	if !reflect.DeepEqual(trimmed.GetLabels(), node.GetLabels()) {
+		t.Errorf("got %v, wanted %v", trimmed.GetLabels(), node.GetLabels())
+	}
+	wantAnnotations := map[string]string{
+		v1alpha1.AnnotationNodeCIDRAllocation: "default/general",
+		v1alpha1.AnnotationAddressPool:        "10.0.0.0/8",
+	}
+	if !reflect.DeepEqual(trimmed.GetAnnotations(), wantAnnotations) {
+		t.Errorf("got %v, wanted %v", trimmed.GetAnnotations(), wantAnnotations)
+	}
+	if trimmed.Spec.PodCIDR != node.Spec.PodCIDR || !reflect.DeepEqual(trimmed.Spec.PodCIDRs, node.Spec.PodCIDRs) {
+		t.Errorf("got %v, wanted %v", trimmed.Spec, corev1.NodeSpec{PodCIDR: node.Spec.PodCIDR, PodCIDRs: node.Spec.PodCIDRs})
+	}
+	if got := trimmed.Status.Allocatable.Pods().Value(); got != 110 {
+		t.Errorf("got %d, wanted %d", got, 110)
+	}
+	if len(trimmed.Status.Allocatable) != 1 || len(trimmed.Status.Capacity) != 0 {
+		t.Errorf("got %v, wanted only the allocatable pods", trimmed.Status)
+	}
+	if len(trimmed.Status.Conditions) != 1 || trimmed.Status.Conditions[0].Type != v1alpha1.NodeConditionPodCIDRAllocated {
+		t.Errorf("got %v, wanted only the %s condition", trimmed.Status.Conditions, v1alpha1.NodeConditionPodCIDRAllocated)
+	}
+	if len(trimmed.Status.Images) != 0 || len(trimmed.Status.Addresses) != 0 || len(trimmed.ManagedFields) != 0 || trimmed.Spec.ProviderID != "" {
+		t.Errorf("got %v, wanted images, addresses, managed fields and the provider ID to be dropped", trimmed)
+	}
+
+	// Case 2: An object which is not a Node is trimmed
+	// expected: the object is returned as is
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a"}}
+	if obj, _ := controller.TrimNode(configMap); obj != configMap {
+		t.Errorf("got %v, wanted %v", obj, configMap)
+	}
+
+	// Case 3: The cache holds trimmed Nodes that have not been allocated yet
+	// expected: the Nodes are allocated PodCIDRs sized for their allocatable pods
+	ctx := context.Background()
+	unallocated := newSyntheticNode(2)
+	unallocated.Spec = corev1.NodeSpec{}
+	unallocated.Annotations = nil
+	unallocated.ResourceVersion = ""
+	obj, _ = controller.TrimNode(unallocated)
+	c := newTestClientBuilder(newTestNodeCIDRAllocation("general", map[string]string{"agentpool": "general"}, "10.0.0.0/16"), obj.(*corev1.Node)).Build()
+	r := newTestReconciler(c)
+	if _, err := reconcileAll(ctx, t, r, newTestNodeCIDRAllocation("general", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)[unallocated.GetName()]; got != "10.0.0.0/25" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/25")
+	}
+}
+
+// BenchmarkNodeCache reports the heap retained by an informer store holding 5,000 Nodes, with and without trimming the Nodes
+func BenchmarkNodeCache(b *testing.B) {
+	const numNodes = 5000
+
+	nodes := make([]*corev1.Node, numNodes)
+	for i := range nodes {
+		nodes[i] = newSyntheticNode(i)
+	}
+
+	for _, bc := range []struct {
+		name      string
+		transform toolscache.TransformFunc
+	}{
+		{name: "full", transform: func(obj interface{}) (interface{}, error) { return obj, nil }},
+		{name: "trimmed", transform: controller.TrimNode},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var retained uint64
+			for n := 0; n < b.N; n++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				// the informer stores a copy of each Node decoded from the watch, after applying the transform
+				store := toolscache.NewStore(toolscache.MetaNamespaceKeyFunc)
+				for _, node := range nodes {
+					obj, err := bc.transform(node.DeepCopy())
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := store.Add(obj); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(store)
+
+				// the heap may shrink when the GC frees more than the store retains, so the difference is signed and clamped
+				if delta := int64(after.HeapAlloc) - int64(before.HeapAlloc); delta > 0 {
+					retained += uint64(delta)
+				}
+			}
+
+			b.ReportMetric(float64(retained)/float64(b.N)/(1<<20), "MiB/5000nodes")
+			b.ReportMetric(float64(retained)/float64(b.N)/numNodes, "B/node")
+		})
+	}
+}
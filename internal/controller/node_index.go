@@ -0,0 +1,286 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/helper"
+)
+
+// nodeLabel represents a single label of a Node selector
+type nodeLabel struct {
+	key   string
+	value string
+}
+
+// poolOwner represents the owner of an indexed address pool: either a NodeCIDRAllocation (for its inline address pools) or an AddressPool
+type poolOwner struct {
+	nodeCIDRAllocation types.NamespacedName
+	addressPool        string
+}
+
+// nodeCIDRAllocationIndex maps Nodes to the NodeCIDRAllocations that track them without listing every NodeCIDRAllocation.
+// It is maintained from the NodeCIDRAllocation and AddressPool informer events, and matches a Node against:
+//   - the Node selectors, each of them indexed by a single one of its labels (selectors without labels match every Node)
+//   - the address pools (inline or referenced through an AddressPool) that overlap the PodCIDR of the Node
+//
+// so that mapping a Node only costs roughly the number of NodeCIDRAllocations that match it
+type nodeCIDRAllocationIndex struct {
+	mu sync.RWMutex
+
+	selectors map[types.NamespacedName]map[string]string
+	byLabel   map[nodeLabel]map[types.NamespacedName]struct{}
+	selectAll map[types.NamespacedName]struct{}
+
+	refs      map[types.NamespacedName][]string
+	referrers map[string]map[types.NamespacedName]struct{}
+
+	pools       map[poolOwner][]netip.Prefix
+	byPool      map[netip.Prefix]map[poolOwner]struct{}
+	sortedPools []netip.Prefix // the keys of byPool, sorted by address and then by prefix length
+}
+
+// newNodeCIDRAllocationIndex returns an empty nodeCIDRAllocationIndex
+func newNodeCIDRAllocationIndex() *nodeCIDRAllocationIndex {
+	return &nodeCIDRAllocationIndex{
+		selectors: map[types.NamespacedName]map[string]string{},
+		byLabel:   map[nodeLabel]map[types.NamespacedName]struct{}{},
+		selectAll: map[types.NamespacedName]struct{}{},
+		refs:      map[types.NamespacedName][]string{},
+		referrers: map[string]map[types.NamespacedName]struct{}{},
+		pools:     map[poolOwner][]netip.Prefix{},
+		byPool:    map[netip.Prefix]map[poolOwner]struct{}{},
+	}
+}
+
+// setNodeCIDRAllocation adds the supplied NodeCIDRAllocation to the index, replacing any previous version of it
+func (idx *nodeCIDRAllocationIndex) setNodeCIDRAllocation(nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation) {
+	key := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeNodeCIDRAllocation(key)
+
+	selector := nodeCIDRAllocation.Spec.NodeSelector
+	idx.selectors[key] = selector
+	if len(selector) == 0 {
+		idx.selectAll[key] = struct{}{}
+	} else {
+		// a Node can only match when it holds every label of the selector, so indexing any single one of them is enough
+		label := nodeLabel{key: slices.Min(helper.Keys(selector))}
+		label.value = selector[label.key]
+		addToSet(idx.byLabel, label, key)
+	}
+
+	idx.refs[key] = slices.Clone(nodeCIDRAllocation.Spec.AddressPoolRefs)
+	for _, name := range nodeCIDRAllocation.Spec.AddressPoolRefs {
+		addToSet(idx.referrers, name, key)
+	}
+
+	idx.setPools(poolOwner{nodeCIDRAllocation: key}, nodeCIDRAllocation.Spec.AddressPools)
+}
+
+// deleteNodeCIDRAllocation removes the named NodeCIDRAllocation from the index
+func (idx *nodeCIDRAllocationIndex) deleteNodeCIDRAllocation(key types.NamespacedName) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeNodeCIDRAllocation(key)
+}
+
+// removeNodeCIDRAllocation removes the named NodeCIDRAllocation from the index. The caller must hold the lock
+func (idx *nodeCIDRAllocationIndex) removeNodeCIDRAllocation(key types.NamespacedName) {
+	if selector, ok := idx.selectors[key]; ok {
+		delete(idx.selectAll, key)
+		if len(selector) > 0 {
+			label := nodeLabel{key: slices.Min(helper.Keys(selector))}
+			label.value = selector[label.key]
+			removeFromSet(idx.byLabel, label, key)
+		}
+		delete(idx.selectors, key)
+	}
+
+	for _, name := range idx.refs[key] {
+		removeFromSet(idx.referrers, name, key)
+	}
+	delete(idx.refs, key)
+
+	idx.setPools(poolOwner{nodeCIDRAllocation: key}, nil)
+}
+
+// setAddressPool adds the CIDRs of the supplied AddressPool to the index, replacing any previous version of them
+func (idx *nodeCIDRAllocationIndex) setAddressPool(addressPool *v1alpha1.AddressPool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.setPools(poolOwner{addressPool: addressPool.GetName()}, addressPool.Spec.CIDRs)
+}
+
+// deleteAddressPool removes the CIDRs of the named AddressPool from the index
+func (idx *nodeCIDRAllocationIndex) deleteAddressPool(name string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.setPools(poolOwner{addressPool: name}, nil)
+}
+
+// setPools replaces the address pools of the supplied owner. Invalid address pools are ignored. The caller must hold the lock
+func (idx *nodeCIDRAllocationIndex) setPools(owner poolOwner, cidrs []string) {
+	changed := false
+	for _, pool := range idx.pools[owner] {
+		removeFromSet(idx.byPool, pool, owner)
+		changed = true
+	}
+	delete(idx.pools, owner)
+
+	for _, cidr := range cidrs {
+		pool, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		pool = pool.Masked()
+
+		idx.pools[owner] = append(idx.pools[owner], pool)
+		addToSet(idx.byPool, pool, owner)
+		changed = true
+	}
+
+	if changed {
+		idx.sortedPools = make([]netip.Prefix, 0, len(idx.byPool))
+		for pool := range idx.byPool {
+			idx.sortedPools = append(idx.sortedPools, pool)
+		}
+		sort.Slice(idx.sortedPools, func(i, j int) bool {
+			if c := idx.sortedPools[i].Addr().Compare(idx.sortedPools[j].Addr()); c != 0 {
+				return c < 0
+			}
+			return idx.sortedPools[i].Bits() < idx.sortedPools[j].Bits()
+		})
+	}
+}
+
+// match returns the NodeCIDRAllocations that track the supplied Node, either because their Node selector matches the labels of the Node or
+// because one of their address pools overlaps the supplied PodCIDR. The result is sorted by namespace and name
+func (idx *nodeCIDRAllocationIndex) match(o client.Object, podCIDR string) []types.NamespacedName {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	matched := map[types.NamespacedName]struct{}{}
+	for key := range idx.selectAll {
+		matched[key] = struct{}{}
+	}
+	for k, v := range o.GetLabels() {
+		for key := range idx.byLabel[nodeLabel{key: k, value: v}] {
+			if helper.ObjectContainsLabels(o, idx.selectors[key]) {
+				matched[key] = struct{}{}
+			}
+		}
+	}
+
+	if subnet, err := netip.ParsePrefix(podCIDR); err == nil {
+		subnet = subnet.Masked()
+		addOwners := func(pool netip.Prefix) {
+			for owner := range idx.byPool[pool] {
+				if owner.addressPool == "" {
+					matched[owner.nodeCIDRAllocation] = struct{}{}
+					continue
+				}
+				for key := range idx.referrers[owner.addressPool] {
+					matched[key] = struct{}{}
+				}
+			}
+		}
+
+		// address pools containing the PodCIDR
+		for bits := 0; bits <= subnet.Bits(); bits++ {
+			pool, _ := subnet.Addr().Prefix(bits)
+			addOwners(pool)
+		}
+
+		// address pools contained by the PodCIDR
+		i := sort.Search(len(idx.sortedPools), func(i int) bool {
+			return idx.sortedPools[i].Addr().Compare(subnet.Addr()) >= 0
+		})
+		for ; i < len(idx.sortedPools) && subnet.Contains(idx.sortedPools[i].Addr()); i++ {
+			if idx.sortedPools[i].Bits() > subnet.Bits() {
+				addOwners(idx.sortedPools[i])
+			}
+		}
+	}
+
+	keys := helper.Keys(matched)
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	return keys
+}
+
+// eventHandler returns the informer event handler which keeps the index up to date with NodeCIDRAllocations and AddressPools
+func (idx *nodeCIDRAllocationIndex) eventHandler() toolscache.ResourceEventHandler {
+	set := func(obj interface{}) {
+		switch o := obj.(type) {
+		case *v1alpha1.NodeCIDRAllocation:
+			idx.setNodeCIDRAllocation(o)
+		case *v1alpha1.AddressPool:
+			idx.setAddressPool(o)
+		}
+	}
+
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    set,
+		UpdateFunc: func(_, obj interface{}) { set(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			switch o := obj.(type) {
+			case *v1alpha1.NodeCIDRAllocation:
+				idx.deleteNodeCIDRAllocation(types.NamespacedName{Name: o.GetName(), Namespace: o.GetNamespace()})
+			case *v1alpha1.AddressPool:
+				idx.deleteAddressPool(o.GetName())
+			}
+		},
+	}
+}
+
+// addToSet adds the supplied value to the set of the supplied key
+func addToSet[K, V comparable](sets map[K]map[V]struct{}, key K, value V) {
+	if _, ok := sets[key]; !ok {
+		sets[key] = map[V]struct{}{}
+	}
+	sets[key][value] = struct{}{}
+}
+
+// removeFromSet removes the supplied value from the set of the supplied key, dropping the set once it is empty
+func removeFromSet[K, V comparable](sets map[K]map[V]struct{}, key K, value V) {
+	delete(sets[key], value)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+)
+
+// nodeAllocationAnnotations returns the annotations that record the NodeCIDRAllocation and address pool that a Node PodCIDR was allocated from
+func nodeAllocationAnnotations(nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, pool string, allocatedAt time.Time) map[string]string {
+	return map[string]string{
+		v1alpha1.AnnotationNodeCIDRAllocation: types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String(),
+		v1alpha1.AnnotationAddressPool:        pool,
+		v1alpha1.AnnotationAllocatedAt:        allocatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// nodeReference returns a reference to the supplied Node to record events against.
+// The UID is set to the name of the Node (as the kubelet does) since that is what `kubectl describe node` uses to find the events of a Node
+func nodeReference(node *corev1.Node) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       node.GetName(),
+		UID:        types.UID(node.GetName()),
+	}
+}
+
+// podCIDRAllocatedCondition returns the PodCIDRAllocated condition of the supplied Node, or nil when the condition is not set
+func podCIDRAllocatedCondition(node *corev1.Node) *corev1.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == v1alpha1.NodeConditionPodCIDRAllocated {
+			return &node.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// setPodCIDRAllocatedCondition sets the PodCIDRAllocated condition on the named Node. The condition is applied with a strategic merge patch
+// so that only this condition is changed, leaving the conditions maintained by the kubelet (and others) untouched.
+// Nothing is written when the Node already has a matching condition
+func (r *NodeCIDRAllocationReconciler) setPodCIDRAllocatedCondition(ctx context.Context, nodeName string, status corev1.ConditionStatus, reason, message string) error {
+	node := corev1.Node{}
+	if err := r.apiReader().Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	condition := corev1.NodeCondition{
+		Type:               v1alpha1.NodeConditionPodCIDRAllocated,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+
+	patch := client.StrategicMergeFrom(node.DeepCopy())
+	if current := podCIDRAllocatedCondition(&node); current != nil {
+		if current.Status == status && current.Reason == reason && current.Message == message {
+			return nil
+		}
+		if current.Status == status {
+			condition.LastTransitionTime = current.LastTransitionTime
+		}
+		*current = condition
+	} else {
+		node.Status.Conditions = append(node.Status.Conditions, condition)
+	}
+
+	return r.Status().Patch(ctx, &node, patch)
+}
+
+// syncNodeConditions ensures that the PodCIDRAllocated condition is set on every Node tracked by the NodeCIDRAllocation that has been allocated
+// a PodCIDR from it, including Nodes that were allocated when they were admitted (by the Node admission webhook)
+func (r *NodeCIDRAllocationReconciler) syncNodeConditions(ctx context.Context, nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, trackedNodes *corev1.NodeList) {
+	rl := log.FromContext(ctx)
+	owner := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+
+	for i := range trackedNodes.Items {
+		node := &trackedNodes.Items[i]
+		if node.Spec.PodCIDR == "" || node.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation] != owner {
+			continue
+		}
+
+		if condition := podCIDRAllocatedCondition(node); condition != nil && condition.Status == corev1.ConditionTrue {
+			continue
+		}
+
+		if err := r.setPodCIDRAllocatedCondition(
+			ctx,
+			node.GetName(),
+			corev1.ConditionTrue,
+			v1alpha1.NodeConditionReasonAllocated,
+			fmt.Sprintf("PodCIDR %s was allocated by NodeCIDRAllocation %s", node.Spec.PodCIDR, owner),
+		); err != nil {
+			rl.Error(
+				err,
+				"unable to set PodCIDRAllocated condition on Node",
+				"name", node.GetName(),
+			)
+		}
+	}
+}
+
+// recordNodeAllocation reports a successful allocation on the supplied Node through the PodCIDRAllocated condition and a Normal event
+func (r *NodeCIDRAllocationReconciler) recordNodeAllocation(ctx context.Context, node *corev1.Node, message string) {
+	r.Recorder.Event(nodeReference(node), corev1.EventTypeNormal, EventReasonAllocated, message)
+
+	if err := r.setPodCIDRAllocatedCondition(ctx, node.GetName(), corev1.ConditionTrue, v1alpha1.NodeConditionReasonAllocated, message); err != nil {
+		log.FromContext(ctx).Error(
+			err,
+			"unable to set PodCIDRAllocated condition on Node",
+			"name", node.GetName(),
+		)
+	}
+}
+
+// recordNodeAllocationFailure reports a failed allocation on the supplied Node through the PodCIDRAllocated condition and a Warning event
+func (r *NodeCIDRAllocationReconciler) recordNodeAllocationFailure(ctx context.Context, node *corev1.Node, reason, message string) {
+	eventReason := EventReasonAllocationFailed
+	if reason == v1alpha1.NodeConditionReasonNoAddressSpace {
+		eventReason = EventReasonNoAddressSpace
+	}
+	r.Recorder.Event(nodeReference(node), corev1.EventTypeWarning, eventReason, message)
+
+	if err := r.setPodCIDRAllocatedCondition(ctx, node.GetName(), corev1.ConditionFalse, reason, message); err != nil {
+		log.FromContext(ctx).Error(
+			err,
+			"unable to set PodCIDRAllocated condition on Node",
+			"name", node.GetName(),
+		)
+	}
+}
@@ -18,19 +18,32 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"golang.org/x/time/rate"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -38,28 +51,66 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
-	"statcan.gc.ca/cidr-allocator/internal/helper"
-	statcan_metrics "statcan.gc.ca/cidr-allocator/internal/metrics"
-	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/integrations"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
 )
 
 const (
 	finalizerName = "nodecidrallocation.networking.statcan.gc.ca/finalizer"
 )
 
+// errPodCIDRAlreadyAllocated is returned when a Node was assigned a PodCIDR by another writer before an allocation could be applied to it
+var errPodCIDRAlreadyAllocated = errors.New("node has already been assigned a PodCIDR")
+
 // NodeCIDRAllocationReconciler reconciles a NodeCIDRAllocation object
 type NodeCIDRAllocationReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 
 	Recorder record.EventRecorder
+
+	// APIReader represents a client that reads directly from the API server (bypassing the cache).
+	// It is used whenever a decision must be made on the most recent state of an object. Defaults to the Client when unset
+	APIReader client.Reader
+
+	// Ledger represents the shared record of PodCIDR reservations. When set, every allocation is reserved in the ledger before it is applied
+	// so that allocations remain consistent with those made by the Node admission webhook. Optional
+	Ledger *ledger.Ledger
+
+	// Reservations represents the PodCIDRs assigned by this process that are not yet shown by the informer cache. It must be shared by every
+	// allocation made in the process so that concurrent reconciles never hand out the same subnet. Defaults to a new set when the controller is set up
+	Reservations *allocator.Reservations
+
+	// MaxConcurrentReconciles represents the maximum number of NodeCIDRAllocations that are reconciled at the same time. Defaults to 1
+	MaxConcurrentReconciles int
+
+	// Integrations represents the optional third-party IPAM integrations that Node PodCIDR allocations are mirrored into
+	Integrations []integrations.Integration
+
+	// RequeueBaseDelay represents the delay before a NodeCIDRAllocation is first requeued after a failed reconcile or when some of its Nodes
+	// could not be allocated. The delay doubles on each consecutive requeue up to RequeueMaxDelay. Defaults to the controller-runtime rate limiter when unset
+	RequeueBaseDelay time.Duration
+
+	// RequeueMaxDelay represents the maximum delay between consecutive requeues of a NodeCIDRAllocation
+	RequeueMaxDelay time.Duration
+
+	// index maps Nodes to the NodeCIDRAllocations that track them. It is maintained from informer events once the controller is set up
+	index *nodeCIDRAllocationIndex
 }
 
 //+kubebuilder:rbac:groups=networking.statcan.gc.ca,resources=nodecidrallocations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.statcan.gc.ca,resources=nodecidrallocations/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=networking.statcan.gc.ca,resources=nodecidrallocations/finalizers,verbs=update
+//+kubebuilder:rbac:groups=networking.statcan.gc.ca,resources=addresspools,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.statcan.gc.ca,resources=addresspools/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;patch;update;watch
+//+kubebuilder:rbac:groups="",resources=nodes/status,verbs=get;patch;update
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cilium.io,resources=ciliumnodes,verbs=get;list;watch;patch;update
+//+kubebuilder:rbac:groups=crd.projectcalico.org,resources=ippools,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=crd.projectcalico.org,resources=ipamblocks,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state
@@ -132,23 +183,25 @@ func (r *NodeCIDRAllocationReconciler) Reconcile(ctx context.Context, req ctrl.R
 		}
 	} else {
 		if controllerutil.ContainsFinalizer(&nodeCIDRAllocation, finalizerName) {
-			// check if there are any Nodes that are watched by this NodeCIDRAllocation that would be left orphaned
-			if r.anyPodCIDRAllocated(&matchingNodes) {
-				rl.V(1).Info(
-					"there are existing Node allocations that are still tied to this resource. waiting until all nodes watched by this NodeCIDRAllocation resource are removed or no longer managed by this resource",
-					"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
-					"Selector", nodeCIDRAllocation.Spec.NodeSelector,
-				)
+			// a referenced AddressPool that no longer exists only narrows down the Nodes owned through their address pool, so it does not hold up the deletion
+			resolved, _ := r.resolveAddressPools(ctx, &nodeCIDRAllocation)
 
-				r.Recorder.Eventf(
-					&nodeCIDRAllocation,
-					corev1.EventTypeWarning,
-					EventReasonOrphanedNodes,
-					"Deletion of NodeCIDRAllocation resource (%s) would leave Nodes orphaned", nodeCIDRAllocation.GetName(),
+			// apply the deletion policy to the Nodes owned by this NodeCIDRAllocation
+			blocked, err := r.deletionBlocked(ctx, resolved)
+			if err != nil {
+				rl.Error(
+					err,
+					"unable to apply deletion policy to NodeCIDRAllocation resource",
+					"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+					"deletionPolicy", nodeCIDRAllocation.DeletionPolicy(),
 				)
 
-				// NodeCIDRAllocation is being deleted, but is not ready - return and do not requeue
-				return ctrl.Result{}, nil
+				// deletion policy could not be applied - return and requeue
+				return ctrl.Result{}, err
+			}
+			if blocked {
+				// NodeCIDRAllocation is being deleted, but is not ready - return and requeue after the resync period (if configured)
+				return resyncResult(resolved, nil)
 			}
 
 			controllerutil.RemoveFinalizer(&nodeCIDRAllocation, finalizerName)
@@ -185,16 +238,60 @@ func (r *NodeCIDRAllocationReconciler) Reconcile(ctx context.Context, req ctrl.R
 		}
 	}
 
+	// failures to allocate (or adopt) individual Nodes are collected so that a single failing Node does not prevent the remaining Nodes from being allocated
+	var errs []error
+
+	resolved, err := r.resolveAddressPools(ctx, &nodeCIDRAllocation)
+	if err != nil {
+		if !errors.Is(err, allocator.ErrAddressPoolNotFound) {
+			// referenced AddressPools could not be resolved - return and requeue
+			return ctrl.Result{}, err
+		}
+
+		// a deleted AddressPool must not drop the PodCIDRs that Nodes still hold from it, so the reconcile continues with the AddressPools that were found
+		errs = append(errs, err)
+	}
+
+	// removed address pools that Nodes still hold a PodCIDR from are kept as draining pools (unless their removal is forced)
+	if err := r.retainRemovedPools(ctx, resolved); err != nil {
+		// removed address pools could not be checked - return and requeue
+		return ctrl.Result{}, utilerrors.NewAggregate(append(errs, err))
+	}
+
+	if nodeCIDRAllocation.Paused() {
+		rl.V(1).Info("allocations are paused for NodeCIDRAllocation. skipping",
+			"name", nodeCIDRAllocation.GetName(),
+			"waitingNodes", len(matchingNodes.Items),
+		)
+		// no allocation is attempted while paused, so no Node is reported as failing
+		nodeCIDRAllocation.SetFailedAllocations(nil)
+
+		// nodeCIDRAllocation is paused - update the status and return and requeue after the resync period (if configured)
+		return resyncResult(resolved, r.finalizeReconcile(ctx, resolved, &matchingNodes, nil, utilerrors.NewAggregate(errs)))
+	}
+
+	if nodeCIDRAllocation.Spec.AdoptExisting {
+		adoption, err := r.adoptExistingAllocations(ctx, resolved)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		nodeCIDRAllocation.SetAdoption(adoption)
+	} else {
+		nodeCIDRAllocation.SetAdoption(nil)
+	}
+
 	if len(matchingNodes.Items) == 0 {
 		rl.V(1).Info("no matching nodes exist. skipping")
+		nodeCIDRAllocation.SetFailedAllocations(nil)
 
-		// nodeCIDRAllocation does not have any matching nodes - return and do not requeue
-		return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, nil)
+		// nodeCIDRAllocation does not have any matching nodes - return and requeue after the resync period (if configured)
+		return resyncResult(resolved, r.finalizeReconcile(ctx, resolved, &matchingNodes, nil, utilerrors.NewAggregate(errs)))
 	}
 
 	// retrieve a list of all Nodes in the cluster.
 	// this is necessary since we need to ensure that we do not collide with any Node in the cluster regardless of whether it is managed by CIDR-Allocator or not.
 	allClusterNodes := corev1.NodeList{}
+	listedAt := time.Now()
 	if err := r.Client.List(ctx, &allClusterNodes); err != nil {
 		rl.Error(
 			err,
@@ -202,7 +299,23 @@ func (r *NodeCIDRAllocationReconciler) Reconcile(ctx context.Context, req ctrl.R
 		)
 
 		// could not list Nodes in the cluster - return and requeue
-		return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, err)
+		return resyncResult(resolved, r.finalizeReconcile(ctx, resolved, &matchingNodes, nil, utilerrors.NewAggregate(append(errs, err))))
+	}
+
+	if r.Reservations != nil {
+		r.Reservations.Observe(&allClusterNodes)
+	}
+
+	// PodCIDRs pinned by any NodeCIDRAllocation (and not only this one) are never allocated dynamically
+	pinnedSubnets, err := allocator.PinnedSubnets(ctx, r.Client, listedAt)
+	if err != nil {
+		rl.Error(
+			err,
+			"unable to resolve the pinned allocations of NodeCIDRAllocations.",
+		)
+
+		// could not determine the pinned PodCIDRs - return and requeue
+		return resyncResult(resolved, r.finalizeReconcile(ctx, resolved, &matchingNodes, nil, utilerrors.NewAggregate(append(errs, err))))
 	}
 
 	//
@@ -211,10 +324,72 @@ func (r *NodeCIDRAllocationReconciler) Reconcile(ctx context.Context, req ctrl.R
 
 	// The subnets that were used as node podCIRD's in this reconcile
 	var allocatedSubnetInReconcile []string
-	for _, node := range matchingNodes.Items {
-		// The subnets that could have been used for the node's podCIDR in this iteration of the loop (exluding the one used for the node)
-		var remainingFreeSubnets []string
 
+	var failures []v1alpha1.NodeAllocationFailure
+	recordFailure := func(node *corev1.Node, reason, message string) {
+		r.recordNodeAllocationFailure(ctx, node, reason, message)
+		failures = append(failures, v1alpha1.NodeAllocationFailure{Node: node.GetName(), Reason: reason, Message: message})
+	}
+
+	// pinned allocations are honored ahead of any dynamic allocation
+	pins := resolvePins(resolved, &allClusterNodes)
+	for i := range matchingNodes.Items {
+		node := &matchingNodes.Items[i]
+		pin, ok := pins.pinned[node.GetName()]
+		if !ok || node.Spec.PodCIDR != "" {
+			continue
+		}
+
+		if pin.conflict != "" {
+			recordFailure(node, v1alpha1.NodeConditionReasonAllocationFailed, fmt.Sprintf(
+				"NodeCIDRAllocation %s cannot assign pinned PodCIDR %s: %s", nodeCIDRAllocation.GetName(), pin.podCIDR, pin.conflict,
+			))
+
+			// pinned Nodes are never allocated dynamically - move on to processing the next Node
+			continue
+		}
+
+		if err := r.assignPinnedPodCIDR(ctx, resolved, node, pin.podCIDR, listedAt); err != nil {
+			if apierrors.IsNotFound(err) || errors.Is(err, errPodCIDRAlreadyAllocated) {
+				rl.V(1).Info("node no longer needs a PodCIDR. it may have been deleted or allocated by another writer. skipping",
+					"name", node.GetName(),
+				)
+				r.releasePodCIDR(node.GetName())
+
+				// Node no longer needs a PodCIDR - move on to processing the next Node
+				continue
+			}
+
+			rl.Error(err, "unable to assign pinned PodCIDR to Node resource",
+				"name", node.GetName(),
+				"podCIDR", pin.podCIDR,
+			)
+			recordFailure(node, v1alpha1.NodeConditionReasonAllocationFailed, fmt.Sprintf(
+				"NodeCIDRAllocation %s could not assign pinned PodCIDR %s: %s", nodeCIDRAllocation.GetName(), pin.podCIDR, err,
+			))
+			if !errors.Is(err, errPinConflict) {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.GetName(), err))
+			}
+
+			// move on to processing the next Node
+			continue
+		}
+
+		node.Spec.PodCIDR = pin.podCIDR
+		allocatedSubnetInReconcile = append(allocatedSubnetInReconcile, pin.podCIDR)
+
+		rl.Info(
+			"assigned pinned PodCIDR to Node resource",
+			"name", node.GetName(),
+			"podCIDR", pin.podCIDR,
+		)
+		r.recordNodeAllocation(ctx, node, fmt.Sprintf(
+			"Pinned PodCIDR %s was assigned from address pool %s by NodeCIDRAllocation %s",
+			pin.podCIDR, allocator.PoolFor(resolved.AddressPools(), pin.podCIDR), nodeCIDRAllocation.GetName(),
+		))
+	}
+
+	for _, node := range matchingNodes.Items {
 		if node.Spec.PodCIDR != "" {
 			rl.V(1).Info("node already contains CIDR allocation. skipping",
 				"name", node.GetName(),
@@ -225,82 +400,119 @@ func (r *NodeCIDRAllocationReconciler) Reconcile(ctx context.Context, req ctrl.R
 			continue
 		}
 
+		if _, ok := pins.pinned[node.GetName()]; ok {
+			// pinned Node which could not be assigned its pinned PodCIDR - move on to processing the next Node
+			continue
+		}
+
 		maxPods := node.Status.Allocatable.Pods().Value()
-		requiredCIDRMask := statcan_net.SmallestMaskForNumHosts(uint32(maxPods))
+		request, err := allocator.RequestFor(resolved, &node)
+		if err != nil {
+			rl.Info("rejecting PodCIDR request of node",
+				"name", node.GetName(),
+				"reason", err.Error(),
+			)
+			recordFailure(&node, v1alpha1.NodeConditionReasonInvalidRequest, fmt.Sprintf(
+				"NodeCIDRAllocation %s cannot honor the PodCIDR request of the Node: %s", nodeCIDRAllocation.GetName(), err,
+			))
+
+			// the Node annotations request a PodCIDR that can never be allocated - move on to processing the next Node
+			continue
+		}
+		requiredCIDRMask := request.Ones
 
 		rl.V(1).Info("determined Node resource PodCIDR requirements",
 			"name", node.GetName(),
 			"maxPods", maxPods,
 			"requiredMaskCIDR", requiredCIDRMask,
+			"requestedPodCIDR", request.PodCIDR,
+			"pools", request.Pools,
 		)
 
-		for _, pool := range nodeCIDRAllocation.Spec.AddressPools {
-			subnets, err := statcan_net.SubnetsFromPool(pool, requiredCIDRMask)
-			if err != nil {
-				rl.Error(
-					err,
-					"unable to break down address pool into subnets",
-					"pool", pool,
-					"maskCIDR", requiredCIDRMask,
+		podCIDR, remainingFreeSubnets, err := r.allocatePodCIDR(ctx, resolved, &node, request, &allClusterNodes, listedAt, pinnedSubnets, allocatedSubnetInReconcile)
+		if err != nil {
+			if errors.Is(err, allocator.ErrRequestCollides) {
+				rl.Info("unable to allocate requested podCIDR for node. it collides with an existing allocation",
+					"name", node.GetName(),
+					"requestedPodCIDR", request.PodCIDR,
 				)
+				recordFailure(&node, v1alpha1.NodeConditionReasonInvalidRequest, fmt.Sprintf(
+					"NodeCIDRAllocation %s cannot honor the PodCIDR request of the Node: %s", nodeCIDRAllocation.GetName(), err,
+				))
 
-				return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, err)
+				// the requested PodCIDR is held by another Node or reserved - move on to processing the next Node
+				continue
 			}
+			if errors.Is(err, allocator.ErrNoCapacity) {
+				rl.Info("unable to allocate podCIDR for node. no sufficient address space capacity for Node",
+					"name", node.GetName(),
+					"requiredSubnetCIDR", requiredCIDRMask,
+				)
 
-			for _, subnet := range subnets {
-				// check that that the subnet IP space isn't already allocated by another node and doesn't overlap with allocatedSubnetInReconcile & staticAllocations
-				networkAllocated, err := statcan_net.NetworkAllocated(subnet, &allClusterNodes, append(allocatedSubnetInReconcile, nodeCIDRAllocation.Spec.StaticAllocations...))
-				if err != nil {
-					rl.Error(
-						err,
-						"unable to determine whether subnet is already allocated",
-						"subnet", subnet,
-					)
-					return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, err)
-				}
-
-				if !networkAllocated {
-					if node.Spec.PodCIDR == "" {
-						node.Spec.PodCIDR = subnet
-						allocatedSubnetInReconcile = append(allocatedSubnetInReconcile, subnet)
-
-						continue
-					}
+				r.Recorder.Eventf(
+					&nodeCIDRAllocation,
+					corev1.EventTypeWarning,
+					EventReasonNoAddressSpace,
+					"There are no available subnets for the requested size (/%d). Could not assign PodCIDR to Node (%s)", requiredCIDRMask, node.GetName(),
+				)
+				recordFailure(&node, v1alpha1.NodeConditionReasonNoAddressSpace, fmt.Sprintf(
+					"NodeCIDRAllocation %s has no available subnets for the requested size (/%d)", nodeCIDRAllocation.GetName(), requiredCIDRMask,
+				))
 
-					remainingFreeSubnets = append(remainingFreeSubnets, subnet)
-				}
+				// no available subnet to assign to Node - move on to processing the next Node (which may require a smaller subnet)
+				continue
 			}
-		}
 
-		if node.Spec.PodCIDR == "" {
-			rl.Info("unable to allocate podCIDR for node. no sufficient address space capacity for Node",
+			rl.Error(
+				err,
+				"unable to allocate podCIDR for node",
 				"name", node.GetName(),
-				"requiredSubnetCIDR", requiredCIDRMask,
+				"maskCIDR", requiredCIDRMask,
 			)
+			recordFailure(&node, v1alpha1.NodeConditionReasonAllocationFailed, fmt.Sprintf(
+				"NodeCIDRAllocation %s could not allocate a PodCIDR: %s", nodeCIDRAllocation.GetName(), err,
+			))
+			errs = append(errs, fmt.Errorf("node %s: %w", node.GetName(), err))
 
-			r.Recorder.Eventf(
-				&nodeCIDRAllocation,
-				corev1.EventTypeWarning,
-				EventReasonNoAddressSpace,
-				"There are no available subnets for the requested size (/%d). Could not assign PodCIDR to Node (%s)", requiredCIDRMask, node.GetName(),
-			)
-
-			// no available subnet to assign to Node - return and do not requeue
-			return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, nil)
+			// move on to processing the next Node
+			continue
 		}
 
-		if err := r.Update(ctx, &node); err != nil {
+		node.Spec.PodCIDR = podCIDR
+		allocatedSubnetInReconcile = append(allocatedSubnetInReconcile, podCIDR)
+		pool := allocator.PoolFor(resolved.AddressPools(), podCIDR)
+
+		if err := r.assignPodCIDR(ctx, node.GetName(), node.Spec.PodCIDR, nodeAllocationAnnotations(&nodeCIDRAllocation, pool, time.Now())); err != nil {
 			if apierrors.IsNotFound(err) {
-				// Node no longer found. It may have been deleted after reconcilliation request - return and do not requeue
-				return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, nil)
+				rl.V(1).Info("node no longer exists. it may have been deleted after the reconcile request. skipping",
+					"name", node.GetName(),
+				)
+				r.releasePodCIDR(node.GetName())
+
+				// Node no longer needs a PodCIDR - move on to processing the next Node
+				continue
+			}
+			if errors.Is(err, errPodCIDRAlreadyAllocated) {
+				rl.Info("node was assigned a PodCIDR by another writer before the allocation could be applied. skipping",
+					"name", node.GetName(),
+				)
+				r.releasePodCIDR(node.GetName())
+
+				// Node no longer needs a PodCIDR - move on to processing the next Node
+				continue
 			}
 			rl.Error(err, "unable to set pod CIDR for Node resource",
 				"name", node.GetName(),
 				"podCIDR", node.Spec.PodCIDR,
 				"remainingFreeSubnets", remainingFreeSubnets,
 			)
+			recordFailure(&node, v1alpha1.NodeConditionReasonAllocationFailed, fmt.Sprintf(
+				"NodeCIDRAllocation %s could not apply PodCIDR %s: %s", nodeCIDRAllocation.GetName(), node.Spec.PodCIDR, err,
+			))
+			errs = append(errs, fmt.Errorf("node %s: %w", node.GetName(), err))
 
-			return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, err)
+			// move on to processing the next Node
+			continue
 		}
 
 		rl.Info(
@@ -309,64 +521,256 @@ func (r *NodeCIDRAllocationReconciler) Reconcile(ctx context.Context, req ctrl.R
 			"podCIDR", node.Spec.PodCIDR,
 			"remainingFreeSubnets", len(remainingFreeSubnets),
 		)
+		r.recordNodeAllocation(ctx, &node, fmt.Sprintf(
+			"PodCIDR %s was allocated from address pool %s by NodeCIDRAllocation %s", node.Spec.PodCIDR, pool, nodeCIDRAllocation.GetName(),
+		))
+	}
+
+	if len(failures) > 0 {
+		r.Recorder.Eventf(
+			&nodeCIDRAllocation,
+			corev1.EventTypeWarning,
+			EventReasonAllocationFailed,
+			"PodCIDR Allocation could not be applied to %d of %d Matching Nodes { NodeSelector: %v }", len(failures), len(matchingNodes.Items), nodeCIDRAllocation.Spec.NodeSelector,
+		)
+	} else {
+		r.Recorder.Eventf(
+			&nodeCIDRAllocation,
+			corev1.EventTypeNormal,
+			EventReasonAllocated,
+			"PodCIDR Allocation has been applied to Matching Nodes { NodeSelector: %v, MatchingNodesCount: %d }", nodeCIDRAllocation.Spec.NodeSelector, len(matchingNodes.Items),
+		)
+	}
+
+	nodeCIDRAllocation.SetFailedAllocations(failures)
+	err = r.finalizeReconcile(ctx, resolved, &matchingNodes, pins, utilerrors.NewAggregate(errs))
+	if err == nil && slices.ContainsFunc(failures, func(f v1alpha1.NodeAllocationFailure) bool {
+		return f.Reason == v1alpha1.NodeConditionReasonNoAddressSpace
+	}) {
+		// some Nodes could not be allocated since there is no available address space - requeue with backoff in case address space is freed or added
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Allocation processed for all matching Nodes - return and requeue with backoff on transient errors, otherwise requeue after the resync period (if configured).
+	// Permanent failures (requests or pins that cannot be honored) are only retried on a change to a Node or the NodeCIDRAllocation, or after the resync period
+	return resyncResult(resolved, err)
+}
+
+// resyncResult returns the result of a reconcile for the supplied NodeCIDRAllocation that completed with the supplied error.
+// A reconcile that completed without error is scheduled to run again after the NodeCIDRAllocation's resync period (if configured), or when
+// its next reservation expires (if sooner) so that the reserved range is released on time
+func resyncResult(nodeCIDRAllocation *allocator.Resolved, err error) (ctrl.Result, error) {
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	r.Recorder.Eventf(
-		&nodeCIDRAllocation,
-		corev1.EventTypeNormal,
-		EventReasonAllocated,
-		"PodCIDR Allocation has been applied to Matching Nodes { NodeSelector: %v, MatchingNodesCount: %d }", nodeCIDRAllocation.Spec.NodeSelector, len(matchingNodes.Items),
-	)
+	requeueAfter := nodeCIDRAllocation.ResyncPeriod()
+	if untilExpiry := untilNextExpiry(nodeCIDRAllocation, time.Now()); untilExpiry > 0 && (requeueAfter <= 0 || untilExpiry < requeueAfter) {
+		requeueAfter = untilExpiry
+	}
+	if requeueAfter <= 0 {
+		return ctrl.Result{}, nil
+	}
 
-	// Allocation successful for all matching Nodes - update current status + metrics + return and do not requeue
-	return ctrl.Result{}, r.finalizeReconcile(ctx, &nodeCIDRAllocation, &matchingNodes, nil)
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-// anyPodCIDRAllocated checks the PodCIDR field in the Node spec for the provided nodes and returns true if **any** that field is allocated, otherwise, false.
-func (r *NodeCIDRAllocationReconciler) anyPodCIDRAllocated(nodes *corev1.NodeList) bool {
-	for _, node := range nodes.Items {
+// assignPodCIDR applies the supplied PodCIDR (and annotations) to the named Node using a patch that only contains the `spec.podCIDR` and `spec.podCIDRs` fields
+// along with the supplied annotations.
+// The Node is re-read from the API server before every attempt so that the PodCIDR is only applied while the field is still empty, and the patch
+// is guarded by the Node's resourceVersion so that a concurrent write (for example, a kubelet status update or label change) results in a retry
+// instead of failing the allocation. errPodCIDRAlreadyAllocated is returned when the Node was assigned a PodCIDR by another writer.
+func (r *NodeCIDRAllocationReconciler) assignPodCIDR(ctx context.Context, nodeName, podCIDR string, annotations map[string]string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node := corev1.Node{}
+		if err := r.apiReader().Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+			return err
+		}
+
 		if node.Spec.PodCIDR != "" {
-			return true
+			if node.Spec.PodCIDR == podCIDR {
+				// a previous attempt was applied successfully
+				return nil
+			}
+
+			return errPodCIDRAlreadyAllocated
+		}
+
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		node.Spec.PodCIDR = podCIDR
+		node.Spec.PodCIDRs = []string{podCIDR}
+		if len(annotations) > 0 && node.Annotations == nil {
+			node.Annotations = map[string]string{}
 		}
+		for k, v := range annotations {
+			node.Annotations[k] = v
+		}
+
+		return r.Patch(ctx, &node, patch)
+	})
+}
+
+// allocatePodCIDR selects a PodCIDR for the supplied Node that satisfies its request (by default, a PodCIDR sized for its allocatable pods from
+// the address pools of the NodeCIDRAllocation that are not draining).
+// The PodCIDR does not overlap with any Node in the cluster, with the subnets allocated earlier in the same reconcile, with the static allocations
+// and reservations or with the supplied pinned subnets (the pinned allocations of every NodeCIDRAllocation).
+// The PodCIDR is held in the process-wide Reservations (if configured) until the informer cache shows it, so that concurrent reconciles
+// working from the Nodes listed at listedAt cannot select it. When a Ledger is configured, the PodCIDR is additionally reserved in the ledger
+// so that it cannot be handed out by the Node admission webhook.
+// The remaining free subnets (excluding the selected PodCIDR) are returned for informational purposes
+func (r *NodeCIDRAllocationReconciler) allocatePodCIDR(
+	ctx context.Context,
+	nodeCIDRAllocation *allocator.Resolved,
+	node *corev1.Node,
+	request allocator.Request,
+	allClusterNodes *corev1.NodeList,
+	listedAt time.Time,
+	pinnedSubnets []string,
+	allocatedSubnetInReconcile []string,
+) (string, []string, error) {
+	var free []string
+	pick := func(reserved []string) (string, error) {
+		reservedSubnets := append(append(append([]string{}, reserved...), allocatedSubnetInReconcile...), nodeCIDRAllocation.ReservedSubnets(time.Now())...)
+		reservedSubnets = append(reservedSubnets, pinnedSubnets...)
+
+		var err error
+		free, err = request.Free(allClusterNodes, reservedSubnets)
+		if err != nil {
+			return "", err
+		}
+
+		if len(free) == 0 {
+			return "", allocator.ErrNoCapacity
+		}
+
+		return free[0], nil
+	}
+
+	reserve := func(inFlight []string) (string, error) {
+		if r.Ledger == nil {
+			return pick(inFlight)
+		}
+
+		owner := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+		return r.Ledger.Reserve(ctx, node.GetName(), node.GetUID(), owner, func(reserved []string) (string, error) {
+			return pick(append(append([]string{}, reserved...), inFlight...))
+		})
+	}
+
+	var podCIDR string
+	var err error
+	if r.Reservations == nil {
+		podCIDR, err = reserve(nil)
+	} else {
+		podCIDR, err = r.Reservations.Reserve(node.GetName(), node.GetUID(), listedAt, reserve)
+	}
+	if err != nil {
+		return "", nil, err
 	}
 
-	return false
+	remainingFreeSubnets := []string{}
+	for _, subnet := range free {
+		if subnet != podCIDR {
+			remainingFreeSubnets = append(remainingFreeSubnets, subnet)
+		}
+	}
+
+	return podCIDR, remainingFreeSubnets, nil
+}
+
+// releasePodCIDR drops the process-wide reservation held for the named Node (if any) once its PodCIDR is known not to have been applied.
+// Reservations for PodCIDRs that may have been applied (for example, when the patch failed with a timeout) are kept until they expire
+func (r *NodeCIDRAllocationReconciler) releasePodCIDR(nodeName string) {
+	if r.Reservations != nil {
+		r.Reservations.Release(nodeName)
+	}
+}
+
+// apiReader returns the reader used to read objects directly from the API server, falling back to the (cached) client when none is configured
+func (r *NodeCIDRAllocationReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+
+	return r.Client
 }
 
 // finalizeReconcile performs any final tasks/functions before the reconcile will be considered complete.
 // this function will pass-through any errors so that information is not lost, but we can use it to adjust status and metric information
-func (r *NodeCIDRAllocationReconciler) finalizeReconcile(ctx context.Context, nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, nodes *corev1.NodeList, err error) error {
-	r.updateNodeCIDRAllocationStatus(ctx, nodeCIDRAllocation, nodes, err)
-	r.updatePrometheusMetrics(ctx)
+func (r *NodeCIDRAllocationReconciler) finalizeReconcile(ctx context.Context, nodeCIDRAllocation *allocator.Resolved, nodes *corev1.NodeList, pins *pinResolution, err error) error {
+	trackedNodes := corev1.NodeList{}
+	if listErr := r.Client.List(ctx, &trackedNodes, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(nodeCIDRAllocation.Spec.NodeSelector),
+	}); listErr != nil {
+		log.FromContext(ctx).Error(
+			listErr,
+			"unable to list Node resources from API server. cannot update aggregated routes or integrations",
+		)
+	} else {
+		r.updateRouteAggregates(ctx, nodeCIDRAllocation, &trackedNodes)
+		r.syncNodeConditions(ctx, nodeCIDRAllocation.NodeCIDRAllocation, &trackedNodes)
+		err = utilerrors.NewAggregate([]error{err, r.syncIntegrations(ctx, nodeCIDRAllocation.NodeCIDRAllocation, &trackedNodes)})
+
+		// the status is calculated from every tracked Node (not only those that were waiting on an allocation) so that partial progress is reported
+		nodes = &trackedNodes
+	}
+
+	if drainErr := r.updateDrainingPools(ctx, nodeCIDRAllocation); drainErr != nil {
+		err = utilerrors.NewAggregate([]error{err, drainErr})
+	}
+	if retainedErr := r.releaseRetainedReservations(ctx, nodeCIDRAllocation); retainedErr != nil {
+		err = utilerrors.NewAggregate([]error{err, retainedErr})
+	}
+	if pinErr := r.updatePinConflicts(ctx, nodeCIDRAllocation, pins); pinErr != nil {
+		err = utilerrors.NewAggregate([]error{err, pinErr})
+	}
+	r.updateReservations(ctx, nodeCIDRAllocation)
+	if poolErr := r.updateAddressPools(ctx, nodeCIDRAllocation); poolErr != nil {
+		err = utilerrors.NewAggregate([]error{err, poolErr})
+	}
+
+	nodeCIDRAllocation.SetAddressPools(nodeCIDRAllocation.AddressPools())
+
+	r.updateNodeCIDRAllocationStatus(ctx, nodeCIDRAllocation.NodeCIDRAllocation, nodes, err)
 
 	// passthrough for err (if non-nil) to the Reconcile Result
 	return err
 }
 
-// updatePrometheusMetrics will capture metrics for cluster-wide usage of the NodeCIDRAllocator.
-// metrics are aggregate and considers all nodes and all NodeCIDRAllocation resources in its processes
-func (r *NodeCIDRAllocationReconciler) updatePrometheusMetrics(ctx context.Context) {
-	log := log.FromContext(ctx)
-	allNodeCIDRAllocations := v1alpha1.NodeCIDRAllocationList{}
-	if fErr := r.Client.List(ctx, &allNodeCIDRAllocations); fErr != nil {
-		log.Error(
-			fErr,
-			"unable to get NodeCIDRAllocationList resource. cannot update metrics",
-		)
-		return
-	}
+// syncIntegrations mirrors the PodCIDR allocations of all Nodes tracked by the NodeCIDRAllocation into each of the enabled integrations.
+// Drift that could not be reconciled by an integration is reported as a Warning event against the NodeCIDRAllocation
+func (r *NodeCIDRAllocationReconciler) syncIntegrations(ctx context.Context, nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation, nodes *corev1.NodeList) error {
+	rl := log.FromContext(ctx)
 
-	allNodes := corev1.NodeList{}
-	if fErr := r.Client.List(ctx, &allNodes); fErr != nil {
-		log.Error(
-			fErr,
-			"unable to get NodeList resource. cannot update metrics",
-		)
-		return
+	errs := []error{}
+	for _, integration := range r.Integrations {
+		drift, err := integration.Sync(ctx, nodeCIDRAllocation, nodes)
+		if err != nil {
+			rl.Error(
+				err,
+				"unable to synchronize Node PodCIDR allocations with integration",
+				"integration", integration.Name(),
+			)
+			errs = append(errs, err)
+		}
+
+		for _, d := range drift {
+			rl.Info(
+				"detected drift between Node PodCIDR allocations and integration",
+				"integration", integration.Name(),
+				"drift", d,
+			)
+
+			r.Recorder.Eventf(
+				nodeCIDRAllocation,
+				corev1.EventTypeWarning,
+				EventReasonIntegrationDrift,
+				"%s integration drift: %s", integration.Name(), d,
+			)
+		}
 	}
 
-	// calculate and update metrics
-	statcan_metrics.Update(&allNodeCIDRAllocations, &allNodes)
+	return utilerrors.NewAggregate(errs)
 }
 
 // updateNodeCIDRAllocationStatus will calculate the current state of Cluster Node allocations for all matching Nodes from the provided NodeCIDRAllocation
@@ -384,11 +788,15 @@ func (r *NodeCIDRAllocationReconciler) updateNodeCIDRAllocationStatus(ctx contex
 		}
 	}
 
-	if nodeCIDRAllocation.ExpectedAllocations() != nodeCIDRAllocation.CompletedAllocations() {
+	nodeCIDRAllocation.SetWaitingAllocations(nodeCIDRAllocation.ExpectedAllocations() - nodeCIDRAllocation.CompletedAllocations())
+
+	if nodeCIDRAllocation.WaitingAllocations() > 0 {
 		nodeCIDRAllocation.SetHealthStatus(v1alpha1.HealthStatusProgressing)
 	}
 
-	if err != nil {
+	r.updatePausedCondition(nodeCIDRAllocation)
+
+	if err != nil || len(nodeCIDRAllocation.FailedAllocations()) > 0 {
 		nodeCIDRAllocation.SetHealthStatus(v1alpha1.HealthStatusUnhealthy)
 	}
 
@@ -400,62 +808,256 @@ func (r *NodeCIDRAllocationReconciler) updateNodeCIDRAllocationStatus(ctx contex
 	}
 }
 
+// updatePausedCondition sets the Paused condition of the NodeCIDRAllocation, and reports a change in whether allocations are paused with an event
+func (r *NodeCIDRAllocationReconciler) updatePausedCondition(nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation) {
+	wasPaused := meta.IsStatusConditionTrue(nodeCIDRAllocation.Status.Conditions, v1alpha1.ConditionPaused)
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionPaused,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: nodeCIDRAllocation.GetGeneration(),
+		Reason:             v1alpha1.ConditionReasonNotPaused,
+		Message:            "New PodCIDR allocations are made",
+	}
+	switch {
+	case nodeCIDRAllocation.Spec.Paused:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = v1alpha1.ConditionReasonPausedBySpec
+		condition.Message = fmt.Sprintf("New PodCIDR allocations are paused by .spec.paused. %d Nodes are waiting on a PodCIDR", nodeCIDRAllocation.WaitingAllocations())
+	case nodeCIDRAllocation.Paused():
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = v1alpha1.ConditionReasonPausedByAnnotation
+		condition.Message = fmt.Sprintf("New PodCIDR allocations are paused by the %s annotation. %d Nodes are waiting on a PodCIDR",
+			v1alpha1.AnnotationPaused, nodeCIDRAllocation.WaitingAllocations())
+	}
+	nodeCIDRAllocation.SetCondition(condition)
+
+	if paused := nodeCIDRAllocation.Paused(); paused && !wasPaused {
+		r.Recorder.Eventf(
+			nodeCIDRAllocation,
+			corev1.EventTypeNormal,
+			EventReasonPaused,
+			"PodCIDR allocations have been paused (%s). %d Nodes are waiting on a PodCIDR", condition.Reason, nodeCIDRAllocation.WaitingAllocations(),
+		)
+	} else if !paused && wasPaused {
+		r.Recorder.Event(
+			nodeCIDRAllocation,
+			corev1.EventTypeNormal,
+			EventReasonResumed,
+			"PodCIDR allocations have been resumed",
+		)
+	}
+}
+
 // triggerNodeCIDRAllocationReconcileFromNodeChange is a mapping function which takes a Node object
-// and returns a list of reconciliation requests for all NodeCIDRAllocation resources that have a matching NodeSelector
-func (r *NodeCIDRAllocationReconciler) triggerNodeCIDRAllocationReconcileFromNodeChange(ctx context.Context, o client.Object) []reconcile.Request {
-	allNodeCIDRAllocations := &v1alpha1.NodeCIDRAllocationList{}
-	usedByNodeCIDRAllocation := map[*v1alpha1.NodeCIDRAllocation]struct{}{} // implements a set-like structure to ensure that we only process a single reconcile for each unique match
+// and returns a list of reconciliation requests for all NodeCIDRAllocation resources that have a matching NodeSelector.
+// NodeCIDRAllocations with an address pool that overlaps the PodCIDR of the Node are also included, since the deletion of the Node frees address
+// space that any of them may be waiting on. The NodeCIDRAllocations are looked up from the index rather than listed
+func (r *NodeCIDRAllocationReconciler) triggerNodeCIDRAllocationReconcileFromNodeChange(_ context.Context, o client.Object) []reconcile.Request {
+	if r.index == nil {
+		return []reconcile.Request{}
+	}
+
+	podCIDR := ""
+	if node, ok := o.(*corev1.Node); ok {
+		podCIDR = node.Spec.PodCIDR
+	}
+
+	matched := r.index.match(o, podCIDR)
+	requests := make([]reconcile.Request, len(matched))
+	for i, key := range matched {
+		requests[i] = reconcile.Request{NamespacedName: key}
+	}
 
-	// get all the available NodeCIDRAllocations on the cluster
-	if err := r.Client.List(ctx, allNodeCIDRAllocations, &client.ListOptions{
+	return requests
+}
+
+// triggerAllNodeCIDRAllocationReconciles is a mapping function which returns a list of reconciliation requests for all NodeCIDRAllocation resources.
+// It is used when the address space of one NodeCIDRAllocation (or of any AddressPool) changes, since allocations are made against every Node in the cluster regardless of its owner
+func (r *NodeCIDRAllocationReconciler) triggerAllNodeCIDRAllocationReconciles(ctx context.Context, _ client.Object) []reconcile.Request {
+	allNodeCIDRAllocations := v1alpha1.NodeCIDRAllocationList{}
+	if err := r.Client.List(ctx, &allNodeCIDRAllocations, &client.ListOptions{
 		Namespace: corev1.NamespaceAll,
 	}); err != nil {
 		return []reconcile.Request{}
 	}
 
-	// find CIDR allocations that have a NodeSelector that points to the node that triggered this reconciliation
-	for _, item := range allNodeCIDRAllocations.Items {
-		if helper.ObjectContainsLabels(o, item.Spec.NodeSelector) {
-			usedByNodeCIDRAllocation[&item] = struct{}{}
-		}
-	}
-
-	// create reconcile requests for all matching NodeCIDRAllocation resources for the Node object
-	requests := make([]reconcile.Request, len(usedByNodeCIDRAllocation))
-	i := 0
-	for used := range usedByNodeCIDRAllocation {
+	requests := make([]reconcile.Request, len(allNodeCIDRAllocations.Items))
+	for i, item := range allNodeCIDRAllocations.Items {
 		requests[i] = reconcile.Request{
 			NamespacedName: types.NamespacedName{
-				Name:      used.GetName(),
-				Namespace: used.GetNamespace(),
+				Name:      item.GetName(),
+				Namespace: item.GetNamespace(),
 			},
 		}
-		i++
 	}
+
 	return requests
 }
 
+// triggerNodeCIDRAllocationReconcileFromIntegration returns a mapping function which takes an object managed by the supplied integration
+// and returns a list of reconciliation requests for all NodeCIDRAllocation resources that track the Node which the object refers to
+func (r *NodeCIDRAllocationReconciler) triggerNodeCIDRAllocationReconcileFromIntegration(integration integrations.Integration) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		nodeName := integration.NodeNameFor(o)
+		if nodeName == "" {
+			return []reconcile.Request{}
+		}
+
+		node := corev1.Node{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+			return []reconcile.Request{}
+		}
+
+		return r.triggerNodeCIDRAllocationReconcileFromNodeChange(ctx, &node)
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *NodeCIDRAllocationReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr)
+	for _, integration := range r.Integrations {
+		b = b.Watches(
+			integration.WatchObject(),
+			handler.EnqueueRequestsFromMapFunc(r.triggerNodeCIDRAllocationReconcileFromIntegration(integration)),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		)
+	}
+
+	if r.Reservations == nil {
+		r.Reservations = allocator.NewReservations()
+	}
+
+	// the index is kept up to date by the informers of the manager, so that Node events never list every NodeCIDRAllocation
+	if r.index == nil {
+		r.index = newNodeCIDRAllocationIndex()
+	}
+	for _, obj := range []client.Object{&v1alpha1.NodeCIDRAllocation{}, &v1alpha1.AddressPool{}} {
+		informer, err := mgr.GetCache().GetInformer(context.Background(), obj)
+		if err != nil {
+			return err
+		}
+		if _, err := informer.AddEventHandler(r.index.eventHandler()); err != nil {
+			return err
+		}
+	}
+
+	options := controller.Options{
+		MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+	}
+	if r.RequeueBaseDelay > 0 && r.RequeueMaxDelay > 0 {
+		options.RateLimiter = workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(r.RequeueBaseDelay, r.RequeueMaxDelay),
+			// 10 qps, 100 bucket size (matches the controller-runtime default). This only limits the overall retry speed
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+		)
+	}
+	b = b.WithOptions(options)
+
+	return b.
 		For(
 			&v1alpha1.NodeCIDRAllocation{},
+			// the pause annotation does not change the generation of the NodeCIDRAllocation
+			builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, pausedChangedPredicate())),
+		).
+		Watches(
+			&v1alpha1.NodeCIDRAllocation{},
+			handler.EnqueueRequestsFromMapFunc(r.triggerAllNodeCIDRAllocationReconciles),
+			builder.WithPredicates(addressSpaceChangedPredicate()),
+		).
+		Watches(
+			&v1alpha1.AddressPool{},
+			handler.EnqueueRequestsFromMapFunc(r.triggerAllNodeCIDRAllocationReconciles),
 			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
 		).
 		Watches(
 			&corev1.Node{},
 			handler.EnqueueRequestsFromMapFunc(r.triggerNodeCIDRAllocationReconcileFromNodeChange),
-			builder.WithPredicates(predicate.Funcs{
-				CreateFunc:  func(_ event.CreateEvent) bool { return true },
-				UpdateFunc:  func(_ event.UpdateEvent) bool { return false },
-				DeleteFunc:  func(_ event.DeleteEvent) bool { return true },
-				GenericFunc: func(_ event.GenericEvent) bool { return false },
-			}),
+			builder.WithPredicates(nodeChangedPredicate()),
 		).
 		Complete(r)
 }
 
-// init registers custom metrics
-func init() {
-	metrics.Registry.MustRegister(statcan_metrics.Get()...)
+// addressSpaceChangedPredicate returns a predicate which only accepts updates to a NodeCIDRAllocation that change the address space
+// available to allocations (its address pools, referenced AddressPools, static allocations or reservations)
+func addressSpaceChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(_ event.CreateEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNodeCIDRAllocation, ok := e.ObjectOld.(*v1alpha1.NodeCIDRAllocation)
+			if !ok {
+				return false
+			}
+			newNodeCIDRAllocation, ok := e.ObjectNew.(*v1alpha1.NodeCIDRAllocation)
+			if !ok {
+				return false
+			}
+
+			return !slices.Equal(oldNodeCIDRAllocation.Spec.AddressPools, newNodeCIDRAllocation.Spec.AddressPools) ||
+				!slices.Equal(oldNodeCIDRAllocation.Spec.AddressPoolRefs, newNodeCIDRAllocation.Spec.AddressPoolRefs) ||
+				!slices.Equal(oldNodeCIDRAllocation.Spec.StaticAllocations, newNodeCIDRAllocation.Spec.StaticAllocations) ||
+				!equality.Semantic.DeepEqual(oldNodeCIDRAllocation.Spec.Reservations, newNodeCIDRAllocation.Spec.Reservations)
+		},
+		DeleteFunc:  func(_ event.DeleteEvent) bool { return false },
+		GenericFunc: func(_ event.GenericEvent) bool { return false },
+	}
+}
+
+// requestAnnotations are the Node annotations which request a specific PodCIDR from the NodeCIDRAllocation that selects the Node
+var requestAnnotations = []string{
+	v1alpha1.AnnotationRequestedPodCIDR,
+	v1alpha1.AnnotationRequestedPrefixLength,
+	v1alpha1.AnnotationRequestedPool,
+}
+
+// nodeChangedPredicate returns a predicate which accepts the creation and deletion of Nodes, updates to a Node that change its labels and
+// updates to a Node without a PodCIDR that change one of its request annotations (so that a request that could not be honored is retried
+// once it is corrected). A relabelled Node may move between NodeCIDRAllocations or between aggregation groups. Both the old and the new Node
+// are mapped to the NodeCIDRAllocations that select them, so the NodeCIDRAllocation that the Node left is reconciled as well. Any other update
+// to a Node (such as a status heartbeat) is ignored
+func nodeChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(_ event.CreateEvent) bool { return true },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+
+			if !maps.Equal(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) {
+				return true
+			}
+
+			node, ok := e.ObjectNew.(*corev1.Node)
+			if !ok || node.Spec.PodCIDR != "" {
+				return false
+			}
+
+			return slices.ContainsFunc(requestAnnotations, func(annotation string) bool {
+				return e.ObjectOld.GetAnnotations()[annotation] != e.ObjectNew.GetAnnotations()[annotation]
+			})
+		},
+		DeleteFunc:  func(_ event.DeleteEvent) bool { return true },
+		GenericFunc: func(_ event.GenericEvent) bool { return false },
+	}
+}
+
+// pausedChangedPredicate returns a predicate which only accepts updates to a NodeCIDRAllocation that pause or resume its allocations
+func pausedChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(_ event.CreateEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNodeCIDRAllocation, ok := e.ObjectOld.(*v1alpha1.NodeCIDRAllocation)
+			if !ok {
+				return false
+			}
+			newNodeCIDRAllocation, ok := e.ObjectNew.(*v1alpha1.NodeCIDRAllocation)
+			if !ok {
+				return false
+			}
+
+			return oldNodeCIDRAllocation.Paused() != newNodeCIDRAllocation.Paused()
+		},
+		DeleteFunc:  func(_ event.DeleteEvent) bool { return false },
+		GenericFunc: func(_ event.GenericEvent) bool { return false },
+	}
 }
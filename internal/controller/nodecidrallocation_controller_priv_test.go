@@ -0,0 +1,449 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+)
+
+// concurrentNodeWriter returns interceptor functions that run the supplied write against a Node right before the first
+// `times` patches are sent, simulating another writer (for example, the kubelet) racing with the controller
+func concurrentNodeWriter(times int, write func(ctx context.Context, c client.WithWatch, node *corev1.Node) error) interceptor.Funcs {
+	calls := 0
+	return interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if _, ok := obj.(*corev1.Node); ok && calls < times {
+				calls++
+				current := corev1.Node{}
+				if err := c.Get(ctx, client.ObjectKeyFromObject(obj), &current); err != nil {
+					return err
+				}
+				if err := write(ctx, c, &current); err != nil {
+					return err
+				}
+			}
+
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}
+}
+
+func TestAssignPodCIDR(t *testing.T) {
+	ctx := context.Background()
+	newNode := func() *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "testNodeA",
+				Labels: map[string]string{"kubernetes.io/role": "agent"},
+			},
+		}
+	}
+
+	// Case 1: No concurrent writers
+	// expected: the PodCIDR should be applied to the Node
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(newNode()).Build()
+	r := &NodeCIDRAllocationReconciler{Client: c}
+	if err := r.assignPodCIDR(ctx, "testNodeA", "10.0.0.0/26", nil); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	got := corev1.Node{}
+	_ = c.Get(ctx, types.NamespacedName{Name: "testNodeA"}, &got)
+	if got.Spec.PodCIDR != "10.0.0.0/26" || len(got.Spec.PodCIDRs) != 1 || got.Spec.PodCIDRs[0] != "10.0.0.0/26" {
+		t.Errorf("got (%s, %v), wanted (%s, [%s])", got.Spec.PodCIDR, got.Spec.PodCIDRs, "10.0.0.0/26", "10.0.0.0/26")
+	}
+
+	// Case 2: Another writer changes the Node labels while the PodCIDR is being applied (twice in a row)
+	// expected: the conflicting patches should be retried, the PodCIDR applied and the concurrent label changes preserved
+	c = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(newNode()).WithInterceptorFuncs(
+		concurrentNodeWriter(2, func(ctx context.Context, c client.WithWatch, node *corev1.Node) error {
+			node.Labels["kubernetes.io/os"] = "linux"
+			node.Labels[node.ResourceVersion] = "written"
+			return c.Update(ctx, node)
+		}),
+	).Build()
+	r = &NodeCIDRAllocationReconciler{Client: c}
+	if err := r.assignPodCIDR(ctx, "testNodeA", "10.0.0.0/26", nil); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	got = corev1.Node{}
+	_ = c.Get(ctx, types.NamespacedName{Name: "testNodeA"}, &got)
+	if got.Spec.PodCIDR != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", got.Spec.PodCIDR, "10.0.0.0/26")
+	}
+	if got.Labels["kubernetes.io/os"] != "linux" || len(got.Labels) != 4 {
+		t.Errorf("got labels %v, wanted the concurrent label changes to be preserved", got.Labels)
+	}
+
+	// Case 3: Another writer assigns a PodCIDR to the Node while the PodCIDR is being applied
+	// expected: errPodCIDRAlreadyAllocated and the PodCIDR of the other writer should be kept
+	c = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(newNode()).WithInterceptorFuncs(
+		concurrentNodeWriter(1, func(ctx context.Context, c client.WithWatch, node *corev1.Node) error {
+			node.Spec.PodCIDR = "10.1.0.0/26"
+			return c.Update(ctx, node)
+		}),
+	).Build()
+	r = &NodeCIDRAllocationReconciler{Client: c}
+	if err := r.assignPodCIDR(ctx, "testNodeA", "10.0.0.0/26", nil); !errors.Is(err, errPodCIDRAlreadyAllocated) {
+		t.Errorf("got %v, wanted %v", err, errPodCIDRAlreadyAllocated)
+	}
+	got = corev1.Node{}
+	_ = c.Get(ctx, types.NamespacedName{Name: "testNodeA"}, &got)
+	if got.Spec.PodCIDR != "10.1.0.0/26" {
+		t.Errorf("got %s, wanted %s", got.Spec.PodCIDR, "10.1.0.0/26")
+	}
+
+	// Case 4: The Node is continuously being written to by another writer
+	// expected: should give up with a conflict error once the retries are exhausted
+	c = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(newNode()).WithInterceptorFuncs(
+		concurrentNodeWriter(100, func(ctx context.Context, c client.WithWatch, node *corev1.Node) error {
+			node.Labels[node.ResourceVersion] = "written"
+			return c.Update(ctx, node)
+		}),
+	).Build()
+	r = &NodeCIDRAllocationReconciler{Client: c}
+	if err := r.assignPodCIDR(ctx, "testNodeA", "10.0.0.0/26", nil); !apierrors.IsConflict(err) {
+		t.Errorf("got %v, wanted a conflict error", err)
+	}
+
+	// Case 5: The Node does not exist
+	// expected: should return a NotFound error
+	c = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build()
+	r = &NodeCIDRAllocationReconciler{Client: c}
+	if err := r.assignPodCIDR(ctx, "testNodeA", "10.0.0.0/26", nil); !apierrors.IsNotFound(err) {
+		t.Errorf("got %v, wanted a NotFound error", err)
+	}
+}
+
+func TestAddressSpaceChangedPredicate(t *testing.T) {
+	p := addressSpaceChangedPredicate()
+	newNodeCIDRAllocation := func(addressPools, staticAllocations []string, aggregationLabel string) *v1alpha1.NodeCIDRAllocation {
+		return &v1alpha1.NodeCIDRAllocation{
+			Spec: v1alpha1.NodeCIDRAllocationSpec{
+				AddressPools:      addressPools,
+				StaticAllocations: staticAllocations,
+				AggregationLabel:  aggregationLabel,
+			},
+		}
+	}
+
+	// Case 1: An address pool is added
+	// expected: should be accepted
+	if !p.Update(event.UpdateEvent{
+		ObjectOld: newNodeCIDRAllocation([]string{"10.0.0.0/24"}, nil, ""),
+		ObjectNew: newNodeCIDRAllocation([]string{"10.0.0.0/24", "10.1.0.0/24"}, nil, ""),
+	}) {
+		t.Errorf("got %v, wanted %v", false, true)
+	}
+
+	// Case 2: A static allocation is removed
+	// expected: should be accepted
+	if !p.Update(event.UpdateEvent{
+		ObjectOld: newNodeCIDRAllocation([]string{"10.0.0.0/24"}, []string{"10.0.0.0/26"}, ""),
+		ObjectNew: newNodeCIDRAllocation([]string{"10.0.0.0/24"}, nil, ""),
+	}) {
+		t.Errorf("got %v, wanted %v", false, true)
+	}
+
+	// Case 3: A field unrelated to the address space is changed
+	// expected: should be rejected
+	if p.Update(event.UpdateEvent{
+		ObjectOld: newNodeCIDRAllocation([]string{"10.0.0.0/24"}, nil, ""),
+		ObjectNew: newNodeCIDRAllocation([]string{"10.0.0.0/24"}, nil, "topology.kubernetes.io/zone"),
+	}) {
+		t.Errorf("got %v, wanted %v", true, false)
+	}
+
+	// Case 4: A reservation is added
+	// expected: should be accepted
+	withReservation := newNodeCIDRAllocation([]string{"10.0.0.0/24"}, nil, "")
+	withReservation.Spec.Reservations = []v1alpha1.AddressReservation{{Name: "load-balancers", CIDR: "10.0.0.0/26"}}
+	if !p.Update(event.UpdateEvent{
+		ObjectOld: newNodeCIDRAllocation([]string{"10.0.0.0/24"}, nil, ""),
+		ObjectNew: withReservation,
+	}) {
+		t.Errorf("got %v, wanted %v", false, true)
+	}
+}
+
+func TestNodeChangedPredicate(t *testing.T) {
+	p := nodeChangedPredicate()
+	newNode := func(labels map[string]string, podCIDR string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "testNode", Labels: labels},
+			Spec:       corev1.NodeSpec{PodCIDR: podCIDR},
+		}
+	}
+
+	// Case 1: A Node is created or deleted
+	// expected: should be accepted
+	if !p.Create(event.CreateEvent{Object: newNode(nil, "")}) || !p.Delete(event.DeleteEvent{Object: newNode(nil, "10.0.0.0/26")}) {
+		t.Errorf("got %v, wanted %v", false, true)
+	}
+
+	// Case 2: The value of a label of the Node changes
+	// expected: should be accepted
+	if !p.Update(event.UpdateEvent{
+		ObjectOld: newNode(map[string]string{"rack": "a"}, "10.0.0.0/26"),
+		ObjectNew: newNode(map[string]string{"rack": "b"}, "10.0.0.0/26"),
+	}) {
+		t.Errorf("got %v, wanted %v", false, true)
+	}
+
+	// Case 3: Anything but the labels of the Node changes
+	// expected: should be rejected
+	oldNode := newNode(map[string]string{"rack": "a"}, "10.0.0.0/26")
+	newNodeStatus := oldNode.DeepCopy()
+	newNodeStatus.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	if p.Update(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNodeStatus}) {
+		t.Errorf("got %v, wanted %v", true, false)
+	}
+
+	// Case 4: A request annotation is corrected on a Node that has not been allocated a PodCIDR
+	// expected: should be accepted
+	oldRequest := newNode(map[string]string{"rack": "a"}, "")
+	oldRequest.SetAnnotations(map[string]string{v1alpha1.AnnotationRequestedPrefixLength: "invalid"})
+	newRequest := oldRequest.DeepCopy()
+	newRequest.SetAnnotations(map[string]string{v1alpha1.AnnotationRequestedPrefixLength: "24"})
+	if !p.Update(event.UpdateEvent{ObjectOld: oldRequest, ObjectNew: newRequest}) {
+		t.Errorf("got %v, wanted %v", false, true)
+	}
+
+	// Case 5: A request annotation changes on a Node that already holds a PodCIDR
+	// expected: should be rejected since requests only apply to new allocations
+	oldRequest.Spec.PodCIDR = "10.0.0.0/24"
+	newRequest.Spec.PodCIDR = "10.0.0.0/24"
+	if p.Update(event.UpdateEvent{ObjectOld: oldRequest, ObjectNew: newRequest}) {
+		t.Errorf("got %v, wanted %v", true, false)
+	}
+}
+
+func TestPausedChangedPredicate(t *testing.T) {
+	p := pausedChangedPredicate()
+	newNodeCIDRAllocation := func(annotations map[string]string, paused bool) *v1alpha1.NodeCIDRAllocation {
+		return &v1alpha1.NodeCIDRAllocation{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       v1alpha1.NodeCIDRAllocationSpec{Paused: paused},
+		}
+	}
+
+	// Case 1: The pause annotation is added
+	// expected: should be accepted
+	if !p.Update(event.UpdateEvent{
+		ObjectOld: newNodeCIDRAllocation(nil, false),
+		ObjectNew: newNodeCIDRAllocation(map[string]string{v1alpha1.AnnotationPaused: "true"}, false),
+	}) {
+		t.Errorf("got %v, wanted %v", false, true)
+	}
+
+	// Case 2: The pause annotation is removed from a NodeCIDRAllocation which remains paused by its spec
+	// expected: should be rejected
+	if p.Update(event.UpdateEvent{
+		ObjectOld: newNodeCIDRAllocation(map[string]string{v1alpha1.AnnotationPaused: "true"}, true),
+		ObjectNew: newNodeCIDRAllocation(nil, true),
+	}) {
+		t.Errorf("got %v, wanted %v", true, false)
+	}
+
+	// Case 3: An unrelated annotation is changed
+	// expected: should be rejected
+	if p.Update(event.UpdateEvent{
+		ObjectOld: newNodeCIDRAllocation(nil, false),
+		ObjectNew: newNodeCIDRAllocation(map[string]string{v1alpha1.AnnotationForcePoolRemoval: "true"}, false),
+	}) {
+		t.Errorf("got %v, wanted %v", true, false)
+	}
+}
+
+// requestNames returns the names of the NodeCIDRAllocations of the supplied reconcile requests
+func requestNames(requests []reconcile.Request) []string {
+	names := []string{}
+	for _, request := range requests {
+		names = append(names, request.Name)
+	}
+
+	return names
+}
+
+func TestTriggerNodeCIDRAllocationReconcileFromNodeChange(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
+
+	nodeCIDRAllocations := []*v1alpha1.NodeCIDRAllocation{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testAllocationA", Namespace: "default"},
+			Spec: v1alpha1.NodeCIDRAllocationSpec{
+				AddressPools: []string{"10.0.0.0/24"},
+				NodeSelector: map[string]string{"kubernetes.io/role": "agent"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testAllocationB", Namespace: "default"},
+			Spec: v1alpha1.NodeCIDRAllocationSpec{
+				AddressPools: []string{"10.1.0.0/24"},
+				NodeSelector: map[string]string{"kubernetes.io/role": "storage"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testAllocationC", Namespace: "default"},
+			Spec: v1alpha1.NodeCIDRAllocationSpec{
+				AddressPools: []string{"10.2.0.0/24"},
+				NodeSelector: map[string]string{"kubernetes.io/role": "agent", "topology.kubernetes.io/zone": "a"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testAllocationD", Namespace: "other"},
+			Spec: v1alpha1.NodeCIDRAllocationSpec{
+				AddressPoolRefs: []string{"shared"},
+				NodeSelector:    map[string]string{"kubernetes.io/role": "gpu"},
+			},
+		},
+	}
+	addressPool := &v1alpha1.AddressPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+		Spec:       v1alpha1.AddressPoolSpec{CIDRs: []string{"10.3.0.0/16"}},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(nodeCIDRAllocations[0], nodeCIDRAllocations[1]).Build()
+	r := NodeCIDRAllocationReconciler{Client: c, Scheme: s, index: newNodeCIDRAllocationIndex()}
+	handler := r.index.eventHandler()
+	for _, nodeCIDRAllocation := range nodeCIDRAllocations {
+		handler.OnAdd(nodeCIDRAllocation, true)
+	}
+	handler.OnAdd(addressPool, true)
+
+	cases := []struct {
+		name string
+		node *corev1.Node
+		want []string
+	}{
+		{
+			// Case 1: A Node selected by the first NodeCIDRAllocation holding a PodCIDR from the pool of the second NodeCIDRAllocation is deleted
+			// expected: both NodeCIDRAllocations should be reconciled
+			name: "selected by one NodeCIDRAllocation and holding a PodCIDR from another",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeA", Labels: map[string]string{"kubernetes.io/role": "agent"}},
+				Spec:       corev1.NodeSpec{PodCIDR: "10.1.0.64/26"},
+			},
+			want: []string{"testAllocationA", "testAllocationB"},
+		},
+		{
+			// Case 2: A Node which is not selected by any NodeCIDRAllocation and holds no PodCIDR
+			// expected: no NodeCIDRAllocation should be reconciled
+			name: "not selected",
+			node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "testNodeB"}},
+			want: []string{},
+		},
+		{
+			// Case 3: A Node holding every label of two NodeCIDRAllocations with overlapping selectors
+			// expected: both NodeCIDRAllocations should be reconciled
+			name: "selected by several NodeCIDRAllocations",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeC", Labels: map[string]string{"kubernetes.io/role": "agent", "topology.kubernetes.io/zone": "a"}},
+			},
+			want: []string{"testAllocationA", "testAllocationC"},
+		},
+		{
+			// Case 4: A Node holding only some of the labels of a selector
+			// expected: only the NodeCIDRAllocation whose whole selector matches should be reconciled
+			name: "holding only some of the labels of a selector",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeD", Labels: map[string]string{"kubernetes.io/role": "storage", "topology.kubernetes.io/zone": "a"}},
+			},
+			want: []string{"testAllocationB"},
+		},
+		{
+			// Case 5: A Node holding a PodCIDR from an AddressPool referenced by a NodeCIDRAllocation
+			// expected: the referencing NodeCIDRAllocation should be reconciled
+			name: "holding a PodCIDR from a referenced AddressPool",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeE"},
+				Spec:       corev1.NodeSpec{PodCIDR: "10.3.4.0/24"},
+			},
+			want: []string{"testAllocationD"},
+		},
+		{
+			// Case 6: A Node holding a PodCIDR that covers several address pools
+			// expected: every NodeCIDRAllocation with an address pool inside of the PodCIDR should be reconciled
+			name: "holding a PodCIDR covering several address pools",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "testNodeF"},
+				Spec:       corev1.NodeSpec{PodCIDR: "10.0.0.0/14"},
+			},
+			want: []string{"testAllocationA", "testAllocationB", "testAllocationC", "testAllocationD"},
+		},
+	}
+
+	for _, tc := range cases {
+		if got := requestNames(r.triggerNodeCIDRAllocationReconcileFromNodeChange(ctx, tc.node)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, wanted %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Case 7: The selector of a NodeCIDRAllocation changes and another NodeCIDRAllocation is deleted
+	// expected: the index follows the changes
+	updated := nodeCIDRAllocations[2].DeepCopy()
+	updated.Spec.NodeSelector = map[string]string{"kubernetes.io/role": "storage"}
+	handler.OnUpdate(nodeCIDRAllocations[2], updated)
+	handler.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "default/testAllocationB", Obj: nodeCIDRAllocations[1]})
+	got := requestNames(r.triggerNodeCIDRAllocationReconcileFromNodeChange(ctx, &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "testNodeG", Labels: map[string]string{"kubernetes.io/role": "storage"}},
+		Spec:       corev1.NodeSpec{PodCIDR: "10.1.0.0/26"},
+	}))
+	if want := []string{"testAllocationC"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 8: The referenced AddressPool is deleted
+	// expected: its CIDRs no longer match
+	handler.OnDelete(addressPool)
+	if got := r.triggerNodeCIDRAllocationReconcileFromNodeChange(ctx, cases[4].node); len(got) != 0 {
+		t.Errorf("got %v, wanted no requests", got)
+	}
+
+	// Case 9: A NodeCIDRAllocation without a Node selector
+	// expected: every Node should be mapped to it
+	handler.OnAdd(&v1alpha1.NodeCIDRAllocation{ObjectMeta: metav1.ObjectMeta{Name: "testAllocationE", Namespace: "default"}}, false)
+	if got, want := requestNames(r.triggerNodeCIDRAllocationReconcileFromNodeChange(ctx, cases[1].node)), []string{"testAllocationE"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 10: The address space of any NodeCIDRAllocation changes
+	// expected: every NodeCIDRAllocation should be reconciled
+	if got := r.triggerAllNodeCIDRAllocationReconciles(ctx, nil); len(got) != 2 {
+		t.Errorf("got %v, wanted requests for testAllocationA and testAllocationB", got)
+	}
+}
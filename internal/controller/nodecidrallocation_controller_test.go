@@ -0,0 +1,1661 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/controller"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+// newTestScheme creates a runtime scheme containing the core Kubernetes types and the NodeCIDRAllocation types
+func newTestScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
+
+	return s
+}
+
+// newTestClientBuilder creates a fake client builder which mirrors the configuration of the manager client (field indexes, status subresources)
+func newTestClientBuilder(objs ...client.Object) *fake.ClientBuilder {
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(objs...).
+		WithStatusSubresource(&v1alpha1.NodeCIDRAllocation{}, &v1alpha1.AddressPool{}).
+		WithIndex(&corev1.Node{}, "spec.podCIDR", func(o client.Object) []string {
+			return []string{o.(*corev1.Node).Spec.PodCIDR}
+		})
+}
+
+// newTestReconciler creates a NodeCIDRAllocationReconciler backed by the supplied client
+func newTestReconciler(c client.Client) *controller.NodeCIDRAllocationReconciler {
+	return &controller.NodeCIDRAllocationReconciler{
+		Client:   c,
+		Scheme:   c.Scheme(),
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// newTestNode creates a Node with the supplied labels and maximum number of pods
+func newTestNode(name string, labels map[string]string, maxPods int64) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourcePods: *resource.NewQuantity(maxPods, resource.DecimalSI),
+			},
+		},
+	}
+}
+
+// newTestNodeCIDRAllocation creates a NodeCIDRAllocation selecting Nodes with the supplied labels from the supplied address pools
+func newTestNodeCIDRAllocation(name string, nodeSelector map[string]string, addressPools ...string) *v1alpha1.NodeCIDRAllocation {
+	return &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: addressPools,
+			NodeSelector: nodeSelector,
+		},
+	}
+}
+
+// reconcileAll runs a reconcile for the supplied NodeCIDRAllocation
+func reconcileAll(ctx context.Context, t *testing.T, r *controller.NodeCIDRAllocationReconciler, nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation) (ctrl.Result, error) {
+	t.Helper()
+
+	return r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+		Name:      nodeCIDRAllocation.GetName(),
+		Namespace: nodeCIDRAllocation.GetNamespace(),
+	}})
+}
+
+// nodePodCIDRs returns a map of Node name to PodCIDR for all Nodes in the cluster
+func nodePodCIDRs(ctx context.Context, t *testing.T, c client.Client) map[string]string {
+	t.Helper()
+
+	nodes := corev1.NodeList{}
+	if err := c.List(ctx, &nodes); err != nil {
+		t.Fatalf("unable to list Nodes. got %e", err)
+	}
+
+	podCIDRs := map[string]string{}
+	for _, n := range nodes.Items {
+		podCIDRs[n.GetName()] = n.Spec.PodCIDR
+	}
+
+	return podCIDRs
+}
+
+func TestReconcileConcurrentNodeWriters(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+
+	// Case 1: Every Node is written to by another writer (a kubelet status/label update) while the controller is allocating it
+	// expected: all Nodes should be allocated a unique PodCIDR without any error and the concurrent writes should be preserved
+	writes := map[string]int{}
+	c := newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24"),
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62),
+		newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "agent"}, 62),
+		newTestNode("testNodeC", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if node, ok := obj.(*corev1.Node); ok && writes[node.GetName()] == 0 {
+				writes[node.GetName()]++
+				current := corev1.Node{}
+				if err := c.Get(ctx, client.ObjectKeyFromObject(node), &current); err != nil {
+					return err
+				}
+				current.Labels["kubelet-heartbeat"] = "true"
+				if err := c.Update(ctx, &current); err != nil {
+					return err
+				}
+			}
+
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+
+	if _, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+
+	got := nodePodCIDRs(ctx, t, c)
+	seen := map[string]string{}
+	for name, podCIDR := range got {
+		if podCIDR == "" {
+			t.Errorf("got no PodCIDR for Node %s, wanted an allocation", name)
+		}
+		if other, ok := seen[podCIDR]; ok {
+			t.Errorf("got duplicate PodCIDR %s for Nodes %s and %s", podCIDR, name, other)
+		}
+		seen[podCIDR] = name
+	}
+
+	nodes := corev1.NodeList{}
+	_ = c.List(ctx, &nodes)
+	for _, n := range nodes.Items {
+		if n.Labels["kubelet-heartbeat"] != "true" {
+			t.Errorf("got labels %v for Node %s, wanted the concurrent label changes to be preserved", n.Labels, n.GetName())
+		}
+	}
+
+	// Case 2: Another writer allocates a PodCIDR to one of the Nodes while the controller is allocating it
+	// expected: the allocation of the other writer should be kept and the remaining Nodes should still be allocated
+	raced := false
+	c = newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24"),
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62),
+		newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if node, ok := obj.(*corev1.Node); ok && node.GetName() == "testNodeA" && !raced {
+				raced = true
+				current := corev1.Node{}
+				if err := c.Get(ctx, client.ObjectKeyFromObject(node), &current); err != nil {
+					return err
+				}
+				current.Spec.PodCIDR = "10.1.0.0/26"
+				if err := c.Update(ctx, &current); err != nil {
+					return err
+				}
+			}
+
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+
+	if _, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+
+	got = nodePodCIDRs(ctx, t, c)
+	if got["testNodeA"] != "10.1.0.0/26" {
+		t.Errorf("got %s, wanted %s", got["testNodeA"], "10.1.0.0/26")
+	}
+	if got["testNodeB"] == "" {
+		t.Error("got no PodCIDR for Node testNodeB, wanted an allocation")
+	}
+}
+
+func TestReconcileWithLedger(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	ledgerKey := types.NamespacedName{Name: ledger.DefaultName, Namespace: "cidr-allocator-system"}
+
+	// Case 1: The webhook has reserved a PodCIDR for a Node that is still being created
+	// expected: the controller should not allocate the reserved PodCIDR and should record its own allocation in the ledger
+	c := newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24"),
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).Build()
+	l := ledger.New(c, c, ledgerKey)
+	if _, err := l.Reserve(ctx, "testNodePending", "", "default/testAllocation", func(_ []string) (string, error) {
+		return "10.0.0.0/26", nil
+	}); err != nil {
+		t.Fatalf("unable to reserve PodCIDR. got %e", err)
+	}
+
+	r := newTestReconciler(c)
+	r.Ledger = l
+	if _, err := reconcileAll(ctx, t, r, newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+
+	if got := nodePodCIDRs(ctx, t, c)["testNodeA"]; got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+
+	reservations, err := l.Reservations(ctx)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := reservations["testNodeA"].CIDR; got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+}
+
+// nodeCondition returns the PodCIDRAllocated condition of the named Node, or nil if it is not set
+func nodeCondition(ctx context.Context, t *testing.T, c client.Client, name string) *corev1.NodeCondition {
+	t.Helper()
+
+	node := corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, &node); err != nil {
+		t.Fatalf("unable to get Node. got %e", err)
+	}
+
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == v1alpha1.NodeConditionPodCIDRAllocated {
+			return &node.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// drainEvents returns all events recorded by the supplied recorder
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+// containsEvent returns true when any of the supplied events contains all of the supplied substrings
+func containsEvent(events []string, substrings ...string) bool {
+	for _, e := range events {
+		matches := true
+		for _, s := range substrings {
+			if !strings.Contains(e, s) {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestReconcileNodeStatus(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+
+	readyNode := newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62)
+	readyNode.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	webhookNode := newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "agent"}, 62)
+	webhookNode.Spec.PodCIDR = "10.0.0.64/26"
+	webhookNode.Annotations = map[string]string{v1alpha1.AnnotationNodeCIDRAllocation: "default/testAllocation"}
+
+	c := newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/25"),
+		readyNode,
+		webhookNode,
+		newTestNode("testNodeC", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).Build()
+	r := newTestReconciler(c)
+	recorder := record.NewFakeRecorder(100)
+	recorder.IncludeObject = true
+	r.Recorder = recorder
+
+	if _, err := reconcileAll(ctx, t, r, newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	events := drainEvents(recorder)
+
+	// Case 1: Node which was allocated a PodCIDR by the controller
+	// expected: the Node should be annotated with the owner, pool and allocation time, the PodCIDRAllocated condition should be True
+	// (without removing the existing conditions) and a Normal event should be recorded against the Node
+	node := corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "testNodeA"}, &node); err != nil {
+		t.Fatalf("unable to get Node. got %e", err)
+	}
+	if got := node.Annotations[v1alpha1.AnnotationNodeCIDRAllocation]; got != "default/testAllocation" {
+		t.Errorf("got %s, wanted %s", got, "default/testAllocation")
+	}
+	if got := node.Annotations[v1alpha1.AnnotationAddressPool]; got != "10.0.0.0/25" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/25")
+	}
+	if _, err := time.Parse(time.RFC3339, node.Annotations[v1alpha1.AnnotationAllocatedAt]); err != nil {
+		t.Errorf("got %s, wanted an RFC 3339 timestamp", node.Annotations[v1alpha1.AnnotationAllocatedAt])
+	}
+	if got := nodeCondition(ctx, t, c, "testNodeA"); got == nil || got.Status != corev1.ConditionTrue || got.Reason != v1alpha1.NodeConditionReasonAllocated {
+		t.Errorf("got %v, wanted a True %s condition", got, v1alpha1.NodeConditionPodCIDRAllocated)
+	}
+	if len(node.Status.Conditions) != 2 {
+		t.Errorf("got %v, wanted the existing Ready condition to be preserved", node.Status.Conditions)
+	}
+	if !containsEvent(events, corev1.EventTypeNormal, controller.EventReasonAllocated, "10.0.0.0/26", "kind=Node") {
+		t.Errorf("got %v, wanted a Normal %s event against Node testNodeA", events, controller.EventReasonAllocated)
+	}
+
+	// Case 2: Node which was allocated a PodCIDR at admission (by the webhook)
+	// expected: the PodCIDRAllocated condition should be True
+	if got := nodeCondition(ctx, t, c, "testNodeB"); got == nil || got.Status != corev1.ConditionTrue {
+		t.Errorf("got %v, wanted a True %s condition", got, v1alpha1.NodeConditionPodCIDRAllocated)
+	}
+
+	// Case 3: Node which could not be allocated since the address pool is exhausted
+	// expected: the PodCIDRAllocated condition should be False and a Warning event should be recorded against the Node
+	if got := nodeCondition(ctx, t, c, "testNodeC"); got == nil || got.Status != corev1.ConditionFalse || got.Reason != v1alpha1.NodeConditionReasonNoAddressSpace {
+		t.Errorf("got %v, wanted a False %s condition", got, v1alpha1.NodeConditionPodCIDRAllocated)
+	}
+	if !containsEvent(events, corev1.EventTypeWarning, controller.EventReasonNoAddressSpace, "kind=Node") {
+		t.Errorf("got %v, wanted a Warning %s event against Node testNodeC", events, controller.EventReasonNoAddressSpace)
+	}
+}
+
+func TestReconcileRequeue(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+
+	// Case 1: The address pool does not have enough capacity for every matching Node
+	// expected: the reconcile should be requeued (with backoff) so that the Node is retried once address space becomes available
+	c := newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/26"),
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62),
+		newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).Build()
+
+	got, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if !got.Requeue {
+		t.Errorf("got %v, wanted %v", got, ctrl.Result{Requeue: true})
+	}
+
+	// Case 2: A Node requests a PodCIDR that can never be honored and a resync period is configured
+	// expected: the reconcile should not be requeued with backoff since no change to the address space can fix the request
+	requesting := newTestNode("testNodeA", selector, 62)
+	requesting.SetAnnotations(map[string]string{v1alpha1.AnnotationRequestedPodCIDR: "10.9.0.0/26"})
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24")
+	nodeCIDRAllocation.Spec.ResyncPeriod = &metav1.Duration{Duration: 10 * time.Minute}
+	c = newTestClientBuilder(nodeCIDRAllocation, requesting).Build()
+
+	got, err = reconcileAll(ctx, t, newTestReconciler(c), nodeCIDRAllocation)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := (ctrl.Result{RequeueAfter: 10 * time.Minute}); got != want {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 3: A pinned allocation conflicts with the PodCIDR held by another Node
+	// expected: the reconcile should not be requeued
+	holder := newTestNode("testNodeB", selector, 62)
+	holder.Spec.PodCIDR = "10.0.0.0/26"
+	nodeCIDRAllocation = newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24")
+	nodeCIDRAllocation.Spec.PinnedAllocations = []v1alpha1.PinnedAllocation{{NodeName: "testNodeA", PodCIDR: "10.0.0.0/26"}}
+	c = newTestClientBuilder(nodeCIDRAllocation, newTestNode("testNodeA", selector, 62), holder).Build()
+
+	got, err = reconcileAll(ctx, t, newTestReconciler(c), nodeCIDRAllocation)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != (ctrl.Result{}) {
+		t.Errorf("got %v, wanted %v", got, ctrl.Result{})
+	}
+
+	// Case 4: All matching Nodes are allocated and a resync period is configured
+	// expected: the reconcile should be requeued after the resync period
+	nodeCIDRAllocation = newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24")
+	nodeCIDRAllocation.Spec.ResyncPeriod = &metav1.Duration{Duration: 10 * time.Minute}
+	c = newTestClientBuilder(
+		nodeCIDRAllocation,
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).Build()
+
+	got, err = reconcileAll(ctx, t, newTestReconciler(c), nodeCIDRAllocation)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if want := (ctrl.Result{RequeueAfter: 10 * time.Minute}); got != want {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 5: All matching Nodes are allocated and no resync period is configured
+	// expected: the reconcile should not be requeued
+	c = newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24"),
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).Build()
+
+	got, err = reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != (ctrl.Result{}) {
+		t.Errorf("got %v, wanted %v", got, ctrl.Result{})
+	}
+}
+
+func TestReconcilePartialFailure(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+
+	// Case 1: The first matching Node requires a subnet larger than any address pool while the remaining Nodes fit
+	// expected: the remaining Nodes should be allocated, the failing Node should be listed in the status and the reconcile should be requeued
+	c := newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/25"),
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 254),
+		newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "agent"}, 62),
+		newTestNode("testNodeC", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).Build()
+
+	got, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if !got.Requeue {
+		t.Errorf("got %v, wanted %v", got, ctrl.Result{Requeue: true})
+	}
+
+	podCIDRs := nodePodCIDRs(ctx, t, c)
+	if podCIDRs["testNodeA"] != "" || podCIDRs["testNodeB"] == "" || podCIDRs["testNodeC"] == "" {
+		t.Errorf("got %v, wanted testNodeB and testNodeC to be allocated", podCIDRs)
+	}
+
+	nodeCIDRAllocation := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "testAllocation", Namespace: "default"}, &nodeCIDRAllocation); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	failures := nodeCIDRAllocation.FailedAllocations()
+	if len(failures) != 1 || failures[0].Node != "testNodeA" || failures[0].Reason != v1alpha1.NodeConditionReasonNoAddressSpace {
+		t.Errorf("got %v, wanted a single %s failure for testNodeA", failures, v1alpha1.NodeConditionReasonNoAddressSpace)
+	}
+	if nodeCIDRAllocation.ExpectedAllocations() != 3 || nodeCIDRAllocation.CompletedAllocations() != 2 {
+		t.Errorf("got %d/%d, wanted %d/%d", nodeCIDRAllocation.CompletedAllocations(), nodeCIDRAllocation.ExpectedAllocations(), 2, 3)
+	}
+	if nodeCIDRAllocation.HealthStatus() != v1alpha1.HealthStatusUnhealthy {
+		t.Errorf("got %s, wanted %s", nodeCIDRAllocation.HealthStatus(), v1alpha1.HealthStatusUnhealthy)
+	}
+
+	// Case 2: The PodCIDR cannot be applied to one of the matching Nodes
+	// expected: the remaining Nodes should be allocated, the failing Node should be listed in the status and the error should be returned
+	c = newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24"),
+		newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "agent"}, 62),
+		newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "agent"}, 62),
+		newTestNode("testNodeC", map[string]string{"kubernetes.io/role": "agent"}, 62),
+	).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if obj.GetName() == "testNodeB" {
+				return errors.New("injected patch failure")
+			}
+
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+
+	if _, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector)); err == nil {
+		t.Errorf("function was expected to error")
+	}
+
+	podCIDRs = nodePodCIDRs(ctx, t, c)
+	if podCIDRs["testNodeA"] == "" || podCIDRs["testNodeB"] != "" || podCIDRs["testNodeC"] == "" {
+		t.Errorf("got %v, wanted testNodeA and testNodeC to be allocated", podCIDRs)
+	}
+
+	if err := c.Get(ctx, types.NamespacedName{Name: "testAllocation", Namespace: "default"}, &nodeCIDRAllocation); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	failures = nodeCIDRAllocation.FailedAllocations()
+	if len(failures) != 1 || failures[0].Node != "testNodeB" || failures[0].Reason != v1alpha1.NodeConditionReasonAllocationFailed {
+		t.Errorf("got %v, wanted a single %s failure for testNodeB", failures, v1alpha1.NodeConditionReasonAllocationFailed)
+	}
+}
+
+func TestReconcileConcurrentNodeCIDRAllocations(t *testing.T) {
+	ctx := context.Background()
+
+	// Case 1: Several NodeCIDRAllocations with the same address pool are reconciled at the same time (and repeatedly) while the
+	// cache never catches up with the allocations that were made
+	// expected: every Node should be allocated a PodCIDR that does not overlap with the PodCIDR of any other Node
+	const groups, nodesPerGroup = 4, 16
+	objs := []client.Object{}
+	nodeCIDRAllocations := []*v1alpha1.NodeCIDRAllocation{}
+	for g := 0; g < groups; g++ {
+		selector := map[string]string{"kubernetes.io/role": fmt.Sprintf("group%d", g)}
+		nodeCIDRAllocation := newTestNodeCIDRAllocation(fmt.Sprintf("testAllocation%d", g), selector, "10.0.0.0/22")
+		nodeCIDRAllocations = append(nodeCIDRAllocations, nodeCIDRAllocation)
+		objs = append(objs, nodeCIDRAllocation.DeepCopy())
+
+		for n := 0; n < nodesPerGroup; n++ {
+			objs = append(objs, newTestNode(fmt.Sprintf("testNode%d-%d", g, n), selector, 14))
+		}
+	}
+
+	mu := sync.Mutex{}
+	c := newTestClientBuilder(objs...).WithInterceptorFuncs(interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if err := c.List(ctx, list, opts...); err != nil {
+				return err
+			}
+
+			// the cache has not observed any of the allocations yet
+			if nodes, ok := list.(*corev1.NodeList); ok {
+				for i := range nodes.Items {
+					nodes.Items[i].Spec.PodCIDR = ""
+					nodes.Items[i].Spec.PodCIDRs = nil
+				}
+			}
+
+			return nil
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+
+	r := newTestReconciler(c)
+	r.Reservations = allocator.NewReservations()
+
+	for round := 0; round < 3; round++ {
+		var wg sync.WaitGroup
+		for _, nodeCIDRAllocation := range nodeCIDRAllocations {
+			wg.Add(1)
+			go func(nodeCIDRAllocation *v1alpha1.NodeCIDRAllocation) {
+				defer wg.Done()
+
+				if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+					t.Errorf("function was not expected to error. got %e", err)
+				}
+			}(nodeCIDRAllocation)
+		}
+		wg.Wait()
+	}
+
+	nodes := corev1.NodeList{}
+	if err := c.List(ctx, &nodes); err != nil {
+		t.Fatalf("unable to list Nodes. got %e", err)
+	}
+
+	got := map[string]string{}
+	for _, n := range nodes.Items {
+		current := corev1.Node{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(&n), &current); err != nil {
+			t.Fatalf("unable to get Node. got %e", err)
+		}
+		if current.Spec.PodCIDR == "" {
+			t.Errorf("got no PodCIDR for Node %s, wanted an allocation", current.GetName())
+		}
+		got[current.GetName()] = current.Spec.PodCIDR
+	}
+
+	for nameA, podCIDRA := range got {
+		for nameB, podCIDRB := range got {
+			if nameA >= nameB || podCIDRA == "" || podCIDRB == "" {
+				continue
+			}
+
+			if overlap, err := statcan_net.NetworksOverlap(podCIDRA, podCIDRB); err != nil || overlap {
+				t.Errorf("got overlapping PodCIDRs %s (%s) and %s (%s), wanted unique allocations", podCIDRA, nameA, podCIDRB, nameB)
+			}
+		}
+	}
+}
+
+// newDeletedTestNodeCIDRAllocation creates a NodeCIDRAllocation which is being deleted (but is held by the controller finalizer) with the supplied deletion policy
+func newDeletedTestNodeCIDRAllocation(deletionPolicy v1alpha1.DeletionPolicy, nodeSelector map[string]string, addressPools ...string) *v1alpha1.NodeCIDRAllocation {
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", nodeSelector, addressPools...)
+	nodeCIDRAllocation.Spec.DeletionPolicy = deletionPolicy
+	nodeCIDRAllocation.Finalizers = []string{"nodecidrallocation.networking.statcan.gc.ca/finalizer"}
+	nodeCIDRAllocation.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	return nodeCIDRAllocation
+}
+
+func TestReconcileDeletionPolicy(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	key := types.NamespacedName{Name: "testAllocation", Namespace: "default"}
+
+	// ownedNodes returns a Node allocated by the NodeCIDRAllocation (since moved to other labels), a selected Node which was allocated from
+	// its address pool before allocations were annotated and Nodes which are not owned by it
+	ownedNodes := func() []client.Object {
+		annotated := newTestNode("testNodeA", map[string]string{"kubernetes.io/role": "other"}, 62)
+		annotated.Spec.PodCIDR = "10.0.0.0/26"
+		annotated.Annotations = map[string]string{v1alpha1.AnnotationNodeCIDRAllocation: "default/testAllocation"}
+		unannotated := newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "agent"}, 62)
+		unannotated.Spec.PodCIDR = "10.0.0.64/26"
+		foreign := newTestNode("testNodeC", map[string]string{"kubernetes.io/role": "agent"}, 62)
+		foreign.Spec.PodCIDR = "10.1.0.0/26"
+		other := newTestNode("testNodeD", map[string]string{"kubernetes.io/role": "agent"}, 62)
+		other.Spec.PodCIDR = "10.0.0.128/26"
+		other.Annotations = map[string]string{v1alpha1.AnnotationNodeCIDRAllocation: "default/otherAllocation"}
+
+		return []client.Object{annotated, unannotated, foreign, other}
+	}
+
+	// Case 1: Block deletion policy with owned Nodes
+	// expected: the deletion should be blocked and the owned Nodes should be listed in the status
+	c := newTestClientBuilder(append(ownedNodes(), newDeletedTestNodeCIDRAllocation(v1alpha1.DeletionPolicyBlock, selector, "10.0.0.0/24"))...).Build()
+	if _, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+
+	nodeCIDRAllocation := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, key, &nodeCIDRAllocation); err != nil {
+		t.Fatalf("got %e, wanted the NodeCIDRAllocation to still exist", err)
+	}
+	if want := []string{"testNodeA", "testNodeB"}; !reflect.DeepEqual(nodeCIDRAllocation.BlockingNodes(), want) {
+		t.Errorf("got %v, wanted %v", nodeCIDRAllocation.BlockingNodes(), want)
+	}
+
+	// Case 2: Block deletion policy without owned Nodes
+	// expected: the NodeCIDRAllocation should be deleted
+	c = newTestClientBuilder(newDeletedTestNodeCIDRAllocation(v1alpha1.DeletionPolicyBlock, selector, "10.0.0.0/24")).Build()
+	if _, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &nodeCIDRAllocation); !apierrors.IsNotFound(err) {
+		t.Errorf("got %v, wanted the NodeCIDRAllocation to be deleted", err)
+	}
+
+	// Case 3: Orphan deletion policy with owned Nodes
+	// expected: the NodeCIDRAllocation should be deleted and the Nodes should keep their PodCIDR
+	c = newTestClientBuilder(append(ownedNodes(), newDeletedTestNodeCIDRAllocation(v1alpha1.DeletionPolicyOrphan, selector, "10.0.0.0/24"))...).Build()
+	if _, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &nodeCIDRAllocation); !apierrors.IsNotFound(err) {
+		t.Errorf("got %v, wanted the NodeCIDRAllocation to be deleted", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeA"]; got != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/26")
+	}
+
+	// Case 4: RetainAsReservation deletion policy with owned Nodes
+	// expected: the NodeCIDRAllocation should be deleted and the PodCIDRs of the owned Nodes should be retained in the ledger
+	c = newTestClientBuilder(append(ownedNodes(), newDeletedTestNodeCIDRAllocation(v1alpha1.DeletionPolicyRetainAsReservation, selector, "10.0.0.0/24"))...).Build()
+	r := newTestReconciler(c)
+	r.Ledger = ledger.New(c, c, types.NamespacedName{Name: ledger.DefaultName, Namespace: "cidr-allocator-system"})
+	if _, err := reconcileAll(ctx, t, r, newTestNodeCIDRAllocation("testAllocation", selector)); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &nodeCIDRAllocation); !apierrors.IsNotFound(err) {
+		t.Errorf("got %v, wanted the NodeCIDRAllocation to be deleted", err)
+	}
+
+	reservations, err := r.Ledger.Reservations(ctx)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	got := map[string]string{}
+	for _, reservation := range reservations {
+		if reservation.Retained() {
+			got[reservation.CIDR] = reservation.Owner
+		}
+	}
+	if want := map[string]string{"10.0.0.0/26": "default/testAllocation", "10.0.0.64/26": "default/testAllocation"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 5: Another NodeCIDRAllocation adopts one of the Nodes whose PodCIDR was retained
+	// expected: the retained reservation of the adopted PodCIDR should be released with an event, while the other one is kept
+	adopter := newTestNodeCIDRAllocation("adopterAllocation", selector, "10.0.0.0/24")
+	adopter.Spec.AdoptExisting = true
+	if err := c.Create(ctx, adopter); err != nil {
+		t.Fatalf("unable to create NodeCIDRAllocation. got %e", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAll(ctx, t, r, adopter); err != nil {
+			t.Errorf("function was not expected to error. got %e", err)
+		}
+	}
+	if reservations, err = r.Ledger.Reservations(ctx); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	got = map[string]string{}
+	for _, reservation := range reservations {
+		if reservation.Retained() {
+			got[reservation.CIDR] = reservation.Owner
+		}
+	}
+	if want := map[string]string{"10.0.0.0/26": "default/testAllocation"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonRetainedReleased, "10.0.0.64/26", "testNodeB") {
+		t.Errorf("got %v, wanted a %s event naming the PodCIDR and the Node", events, controller.EventReasonRetainedReleased)
+	}
+
+	// Case 6: RetainAsReservation deletion policy without a ledger
+	// expected: the deletion should fail and be retried
+	c = newTestClientBuilder(append(ownedNodes(), newDeletedTestNodeCIDRAllocation(v1alpha1.DeletionPolicyRetainAsReservation, selector, "10.0.0.0/24"))...).Build()
+	if _, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector)); err == nil {
+		t.Errorf("function was expected to error")
+	}
+	if err := c.Get(ctx, key, &nodeCIDRAllocation); err != nil {
+		t.Errorf("got %e, wanted the NodeCIDRAllocation to still exist", err)
+	}
+}
+
+func TestReconcileAdoption(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	key := types.NamespacedName{Name: "testAllocation", Namespace: "default"}
+
+	inPool := newTestNode("testNodeA", selector, 62)
+	inPool.Spec.PodCIDR = "10.0.0.0/26"
+	outOfPool := newTestNode("testNodeB", selector, 62)
+	outOfPool.Spec.PodCIDR = "10.1.0.0/26"
+	overlapping := newTestNode("testNodeC", selector, 62)
+	overlapping.Spec.PodCIDR = "10.0.0.128/25"
+	overlapped := newTestNode("testNodeD", map[string]string{"kubernetes.io/role": "other"}, 62)
+	overlapped.Spec.PodCIDR = "10.0.0.192/26"
+	unallocated := newTestNode("testNodeE", selector, 62)
+
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24")
+	nodeCIDRAllocation.Spec.AdoptExisting = true
+
+	c := newTestClientBuilder(inPool, outOfPool, overlapping, overlapped, unallocated, nodeCIDRAllocation).Build()
+	r := newTestReconciler(c)
+	r.Ledger = ledger.New(c, c, types.NamespacedName{Name: ledger.DefaultName, Namespace: "cidr-allocator-system"})
+
+	// Case 1: Selected Nodes hold PodCIDRs inside the address pool, outside of it and overlapping another Node
+	// expected: the PodCIDR inside the address pool is adopted (annotated and reserved in the ledger), the others are reported as misfits
+	// and the Node without a PodCIDR is allocated as usual
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+
+	node := corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "testNodeA"}, &node); err != nil {
+		t.Fatalf("unable to get Node. got %e", err)
+	}
+	if got := node.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation]; got != "default/testAllocation" {
+		t.Errorf("got %q, wanted %q", got, "default/testAllocation")
+	}
+	if got := node.GetAnnotations()[v1alpha1.AnnotationAdopted]; got != "true" {
+		t.Errorf("got %q, wanted %q", got, "true")
+	}
+
+	reservations, err := r.Ledger.Reservations(ctx)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := reservations["testNodeA"].CIDR; got != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/26")
+	}
+
+	if got := nodePodCIDRs(ctx, t, c)["testNodeE"]; got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+
+	current := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	adoption := current.Adoption()
+	if adoption == nil {
+		t.Fatal("got nil, wanted the adoption status to be set")
+	}
+	if adoption.Adopted != 1 || adoption.Complete {
+		t.Errorf("got (%d, %t), wanted (%d, %t)", adoption.Adopted, adoption.Complete, 1, false)
+	}
+	misfits := []string{}
+	for _, misfit := range adoption.Misfits {
+		misfits = append(misfits, misfit.Node)
+	}
+	if want := []string{"testNodeB", "testNodeC"}; !reflect.DeepEqual(misfits, want) {
+		t.Errorf("got %v, wanted %v", misfits, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonAdoptionMisfit, "testNodeB, testNodeC") {
+		t.Errorf("got %v, wanted a %s event naming the misfit Nodes", events, controller.EventReasonAdoptionMisfit)
+	}
+
+	// Case 2: The NodeCIDRAllocation is reconciled again with the same misfit Nodes
+	// expected: the misfits are still reported in the status, but no events are recorded for them again
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if got := len(current.Adoption().Misfits); got != 2 {
+		t.Errorf("got %d, wanted %d", got, 2)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); containsEvent(events, controller.EventReasonAdoptionMisfit) {
+		t.Errorf("got %v, wanted no %s events", events, controller.EventReasonAdoptionMisfit)
+	}
+
+	// Case 3: The misfit Nodes are removed
+	// expected: the adoption is reported as complete, the previously adopted Node is still counted
+	for _, name := range []string{"testNodeB", "testNodeC"} {
+		if err := c.Delete(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}); err != nil {
+			t.Fatalf("unable to delete Node. got %e", err)
+		}
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if adoption := current.Adoption(); adoption == nil || adoption.Adopted != 1 || !adoption.Complete || len(adoption.Misfits) != 0 {
+		t.Errorf("got %+v, wanted 1 adopted Node and a complete adoption", adoption)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonAdoptionComplete) {
+		t.Errorf("got %v, wanted a %s event", events, controller.EventReasonAdoptionComplete)
+	}
+
+	// Case 4: Adoption is disabled
+	// expected: the adoption status is cleared
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	current.Spec.AdoptExisting = false
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatalf("unable to update NodeCIDRAllocation. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if current.Adoption() != nil {
+		t.Errorf("got %+v, wanted nil", current.Adoption())
+	}
+}
+
+func TestReconcileDrainingPools(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	key := types.NamespacedName{Name: "testAllocation", Namespace: "default"}
+
+	draining := newTestNode("testNodeA", selector, 62)
+	draining.Spec.PodCIDR = "10.0.0.0/26"
+	unallocated := newTestNode("testNodeB", selector, 62)
+
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24", "10.1.0.0/24")
+	nodeCIDRAllocation.Spec.DrainingPools = []string{"10.0.0.0/24"}
+
+	c := newTestClientBuilder(draining, unallocated, nodeCIDRAllocation).Build()
+	r := newTestReconciler(c)
+
+	// Case 1: The first address pool is draining while an owned Node still holds a PodCIDR from it
+	// expected: the unallocated Node is allocated from the remaining address pool and the draining pool lists the Node still on it
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeB"]; got != "10.1.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.1.0.0/26")
+	}
+
+	current := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	want := []v1alpha1.DrainingPool{{Pool: "10.0.0.0/24", Nodes: []string{"testNodeA"}}}
+	if got := current.Draining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); containsEvent(events, controller.EventReasonPoolDrained) {
+		t.Errorf("got %v, wanted no %s event", events, controller.EventReasonPoolDrained)
+	}
+
+	// Case 2: The Node on the draining pool is removed
+	// expected: the draining pool is reported as empty with a Pool Drained event
+	if err := c.Delete(ctx, draining.DeepCopy()); err != nil {
+		t.Fatalf("unable to delete Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	want = []v1alpha1.DrainingPool{{Pool: "10.0.0.0/24"}}
+	if got := current.Draining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPoolDrained, "10.0.0.0/24") {
+		t.Errorf("got %v, wanted a %s event naming the address pool", events, controller.EventReasonPoolDrained)
+	}
+}
+
+func TestReconcileRemovedPools(t *testing.T) {
+	ctx := context.Background()
+	selectorA := map[string]string{"kubernetes.io/role": "agent"}
+	selectorB := map[string]string{"kubernetes.io/role": "infra"}
+	keyA := types.NamespacedName{Name: "testAllocationA", Namespace: "default"}
+	keyB := types.NamespacedName{Name: "testAllocationB", Namespace: "default"}
+
+	removed := newTestNode("testNodeA", selectorA, 62)
+	removed.Spec.PodCIDR = "10.0.0.0/26"
+	referencedC := newTestNode("testNodeC", selectorB, 62)
+	referencedC.Spec.PodCIDR = "10.2.0.0/26"
+	referencedD := newTestNode("testNodeD", selectorB, 62)
+	referencedD.Spec.PodCIDR = "10.3.0.0/26"
+
+	addressPool := &v1alpha1.AddressPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+		Spec:       v1alpha1.AddressPoolSpec{CIDRs: []string{"10.2.0.0/24", "10.3.0.0/24"}},
+	}
+	nodeCIDRAllocationA := newTestNodeCIDRAllocation("testAllocationA", selectorA, "10.0.0.0/24", "10.1.0.0/24")
+	nodeCIDRAllocationB := newTestNodeCIDRAllocation("testAllocationB", selectorB)
+	nodeCIDRAllocationB.Spec.AddressPoolRefs = []string{"shared"}
+
+	c := newTestClientBuilder(removed, referencedC, referencedD, addressPool, nodeCIDRAllocationA, nodeCIDRAllocationB).Build()
+	r := newTestReconciler(c)
+
+	current := v1alpha1.NodeCIDRAllocation{}
+	getCurrent := func(key types.NamespacedName) {
+		t.Helper()
+		if err := c.Get(ctx, key, &current); err != nil {
+			t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+		}
+	}
+
+	// Case 1: An inline address pool is removed while an owned Node still holds a PodCIDR from it
+	// expected: the pool is kept as a draining pool (the new Node is allocated from the remaining pool) with a Pool Removal Blocked event
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationA); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	getCurrent(keyA)
+	current.Spec.AddressPools = []string{"10.1.0.0/24"}
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatalf("unable to update NodeCIDRAllocation. got %e", err)
+	}
+	if err := c.Create(ctx, newTestNode("testNodeB", selectorA, 62)); err != nil {
+		t.Fatalf("unable to create Node. got %e", err)
+	}
+	_ = drainEvents(r.Recorder.(*record.FakeRecorder))
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationA); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeB"]; got != "10.1.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.1.0.0/26")
+	}
+	getCurrent(keyA)
+	want := []v1alpha1.DrainingPool{{Pool: "10.0.0.0/24", Nodes: []string{"testNodeA"}, Removed: true}}
+	if got := current.Draining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPoolRemovalBlocked, "10.0.0.0/24", "testNodeA") {
+		t.Errorf("got %v, wanted a %s event naming the address pool and the Node", events, controller.EventReasonPoolRemovalBlocked)
+	}
+
+	// Case 2: The NodeCIDRAllocation is reconciled again while the Node is still on the removed pool
+	// expected: the pool is still kept as a draining pool, without another event
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationA); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	getCurrent(keyA)
+	if got := current.Draining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); containsEvent(events, controller.EventReasonPoolRemovalBlocked) {
+		t.Errorf("got %v, wanted no %s event", events, controller.EventReasonPoolRemovalBlocked)
+	}
+
+	// Case 3: The Node on the removed pool is deleted
+	// expected: the pool is released with a Pool Drained event and is no longer one of the address pools
+	if err := c.Delete(ctx, removed.DeepCopy()); err != nil {
+		t.Fatalf("unable to delete Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationA); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	getCurrent(keyA)
+	if got := current.Draining(); len(got) != 0 {
+		t.Errorf("got %+v, wanted no draining pool", got)
+	}
+	if got, want := current.Status.AddressPools, []string{"10.1.0.0/24"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPoolDrained, "10.0.0.0/24", "released") {
+		t.Errorf("got %v, wanted a %s event naming the address pool", events, controller.EventReasonPoolDrained)
+	}
+
+	// Case 4: A CIDR is removed from a referenced AddressPool while an owned Node still holds a PodCIDR from it
+	// expected: the CIDR is kept as a draining pool with a Pool Removal Blocked event
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationB); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	currentPool := v1alpha1.AddressPool{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "shared"}, &currentPool); err != nil {
+		t.Fatalf("unable to get AddressPool. got %e", err)
+	}
+	currentPool.Spec.CIDRs = []string{"10.3.0.0/24"}
+	if err := c.Update(ctx, &currentPool); err != nil {
+		t.Fatalf("unable to update AddressPool. got %e", err)
+	}
+	_ = drainEvents(r.Recorder.(*record.FakeRecorder))
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationB); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	getCurrent(keyB)
+	want = []v1alpha1.DrainingPool{{Pool: "10.2.0.0/24", Nodes: []string{"testNodeC"}, Removed: true}}
+	if got := current.Draining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPoolRemovalBlocked, "10.2.0.0/24", "testNodeC") {
+		t.Errorf("got %v, wanted a %s event naming the address pool and the Node", events, controller.EventReasonPoolRemovalBlocked)
+	}
+
+	// Case 5: The referenced AddressPool is deleted while owned Nodes still hold a PodCIDR from it
+	// expected: the reconcile fails with an Address Pool Not Found error, but every CIDR of the AddressPool is kept as a draining pool
+	if err := c.Delete(ctx, &currentPool); err != nil {
+		t.Fatalf("unable to delete AddressPool. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationB); !errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		t.Errorf("got %v, wanted %v", err, allocator.ErrAddressPoolNotFound)
+	}
+	getCurrent(keyB)
+	want = []v1alpha1.DrainingPool{
+		{Pool: "10.2.0.0/24", Nodes: []string{"testNodeC"}, Removed: true},
+		{Pool: "10.3.0.0/24", Nodes: []string{"testNodeD"}, Removed: true},
+	}
+	if got := current.Draining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPoolRemovalBlocked, "10.3.0.0/24", "testNodeD") {
+		t.Errorf("got %v, wanted a %s event naming the address pool and the Node", events, controller.EventReasonPoolRemovalBlocked)
+	}
+
+	// Case 6: The removal is forced with the annotation
+	// expected: the removed pools are dropped (with a Pool Removal Forced event) even though Nodes still hold a PodCIDR from them
+	current.SetAnnotations(map[string]string{v1alpha1.AnnotationForcePoolRemoval: "true"})
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatalf("unable to update NodeCIDRAllocation. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationB); !errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		t.Errorf("got %v, wanted %v", err, allocator.ErrAddressPoolNotFound)
+	}
+	getCurrent(keyB)
+	if got := current.Draining(); len(got) != 0 {
+		t.Errorf("got %+v, wanted no draining pool", got)
+	}
+	if got := current.Status.AddressPools; len(got) != 0 {
+		t.Errorf("got %v, wanted no address pool", got)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPoolRemovalForced, "10.2.0.0/24", "testNodeC") {
+		t.Errorf("got %v, wanted a %s event naming the address pool and the Node", events, controller.EventReasonPoolRemovalForced)
+	}
+}
+
+func TestReconcilePaused(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	key := types.NamespacedName{Name: "testAllocation", Namespace: "default"}
+
+	allocated := newTestNode("testNodeA", selector, 62)
+	allocated.Spec.PodCIDR = "10.0.0.0/26"
+	waiting := newTestNode("testNodeB", selector, 62)
+	pinned := newTestNode("testNodeC", selector, 62)
+
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24")
+	nodeCIDRAllocation.Spec.PinnedAllocations = []v1alpha1.PinnedAllocation{{NodeName: "testNodeC", PodCIDR: "10.0.0.192/26"}}
+	nodeCIDRAllocation.SetAnnotations(map[string]string{v1alpha1.AnnotationPaused: "true"})
+
+	c := newTestClientBuilder(allocated, waiting, pinned, nodeCIDRAllocation).Build()
+	r := newTestReconciler(c)
+
+	current := v1alpha1.NodeCIDRAllocation{}
+	getCurrent := func() {
+		t.Helper()
+		if err := c.Get(ctx, key, &current); err != nil {
+			t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+		}
+	}
+
+	// Case 1: Allocations are paused with the annotation
+	// expected: no Node is allocated (not even the pinned Node), the waiting Nodes are counted and the Paused condition is set with an event
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	podCIDRs := nodePodCIDRs(ctx, t, c)
+	if podCIDRs["testNodeB"] != "" || podCIDRs["testNodeC"] != "" {
+		t.Errorf("got %v, wanted no new PodCIDR", podCIDRs)
+	}
+	getCurrent()
+	if current.ExpectedAllocations() != 3 || current.CompletedAllocations() != 1 || current.WaitingAllocations() != 2 {
+		t.Errorf("got %+v, wanted 3 expected, 1 completed and 2 waiting allocations", current.Status)
+	}
+	if got := current.HealthStatus(); got != v1alpha1.HealthStatusProgressing {
+		t.Errorf("got %s, wanted %s", got, v1alpha1.HealthStatusProgressing)
+	}
+	condition := meta.FindStatusCondition(current.Status.Conditions, v1alpha1.ConditionPaused)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != v1alpha1.ConditionReasonPausedByAnnotation {
+		t.Errorf("got %+v, wanted the Paused condition to be true by annotation", condition)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPaused, "2 Nodes are waiting") {
+		t.Errorf("got %v, wanted a %s event counting the waiting Nodes", events, controller.EventReasonPaused)
+	}
+
+	// Case 2: Allocations are paused with the spec field instead of the annotation
+	// expected: no Node is allocated, the reason of the Paused condition changes and no further event is recorded
+	current.SetAnnotations(nil)
+	current.Spec.Paused = true
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatalf("unable to update NodeCIDRAllocation. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeB"]; got != "" {
+		t.Errorf("got %s, wanted no PodCIDR", got)
+	}
+	getCurrent()
+	condition = meta.FindStatusCondition(current.Status.Conditions, v1alpha1.ConditionPaused)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != v1alpha1.ConditionReasonPausedBySpec {
+		t.Errorf("got %+v, wanted the Paused condition to be true by spec", condition)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); containsEvent(events, controller.EventReasonPaused) {
+		t.Errorf("got %v, wanted no %s event", events, controller.EventReasonPaused)
+	}
+
+	// Case 3: Allocations are resumed
+	// expected: the waiting Nodes are allocated, the Paused condition is cleared and an event is recorded
+	current.Spec.Paused = false
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatalf("unable to update NodeCIDRAllocation. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	podCIDRs = nodePodCIDRs(ctx, t, c)
+	if podCIDRs["testNodeB"] != "10.0.0.64/26" || podCIDRs["testNodeC"] != "10.0.0.192/26" {
+		t.Errorf("got %v, wanted the waiting Nodes to be allocated", podCIDRs)
+	}
+	getCurrent()
+	if current.WaitingAllocations() != 0 || current.HealthStatus() != v1alpha1.HealthStatusHealthy {
+		t.Errorf("got %+v, wanted no waiting allocations and a healthy status", current.Status)
+	}
+	if meta.IsStatusConditionTrue(current.Status.Conditions, v1alpha1.ConditionPaused) {
+		t.Errorf("got %+v, wanted the Paused condition to be false", current.Status.Conditions)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonResumed) {
+		t.Errorf("got %v, wanted a %s event", events, controller.EventReasonResumed)
+	}
+}
+
+func TestReconcilePinnedAllocations(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	router := map[string]string{"kubernetes.io/role": "agent", "role": "router"}
+	key := types.NamespacedName{Name: "testAllocation", Namespace: "default"}
+
+	pinnedByName := newTestNode("testNodeA", selector, 62)
+	pinnedByLabel := newTestNode("testNodeB", router, 62)
+	conflicting := newTestNode("testNodeC", selector, 62)
+	conflicting.Spec.PodCIDR = "10.0.0.192/26"
+	dynamic := newTestNode("testNodeD", selector, 62)
+
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/23")
+	nodeCIDRAllocation.Spec.PinnedAllocations = []v1alpha1.PinnedAllocation{
+		{NodeName: "testNodeA", PodCIDR: "10.0.0.128/26"},
+		{NodeSelector: map[string]string{"role": "router"}, PodCIDR: "10.0.0.0/26"},
+		{NodeName: "testNodeC", PodCIDR: "10.0.0.64/26"},
+		{NodeName: "testNodeE", PodCIDR: "10.2.0.0/26"},
+	}
+
+	c := newTestClientBuilder(pinnedByName, pinnedByLabel, conflicting, dynamic, nodeCIDRAllocation).Build()
+	r := newTestReconciler(c)
+
+	// Case 1: Nodes pinned by name and by label, a pinned Node holding a different PodCIDR and a pin outside of the address pools
+	// expected: the pinned Nodes receive their pinned PodCIDRs, the dynamically allocated Node skips every pinned PodCIDR
+	// and the pins that cannot be honored are reported
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+
+	podCIDRs := nodePodCIDRs(ctx, t, c)
+	for name, want := range map[string]string{"testNodeA": "10.0.0.128/26", "testNodeB": "10.0.0.0/26", "testNodeC": "10.0.0.192/26", "testNodeD": "10.0.1.0/26"} {
+		if got := podCIDRs[name]; got != want {
+			t.Errorf("%s: got %s, wanted %s", name, got, want)
+		}
+	}
+
+	current := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	conflicts := current.PinConflicts()
+	if len(conflicts) != 2 {
+		t.Fatalf("got %+v, wanted 2 pin conflicts", conflicts)
+	}
+	if got := conflicts[0]; got.Node != "testNodeC" || got.PodCIDR != "10.0.0.64/26" || !strings.Contains(got.Reason, "holds PodCIDR 10.0.0.192/26") {
+		t.Errorf("got %+v, wanted a conflict for the PodCIDR held by testNodeC", got)
+	}
+	if got := conflicts[1]; got.PodCIDR != "10.2.0.0/26" || !strings.Contains(got.Reason, "outside of the address pools") {
+		t.Errorf("got %+v, wanted a conflict for the pin outside of the address pools", got)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonPinConflict, "10.0.0.64/26") {
+		t.Errorf("got %v, wanted a %s event naming the pinned PodCIDR", events, controller.EventReasonPinConflict)
+	}
+
+	// Case 2: A second Node matches the pinned node selector
+	// expected: the pin is reported as ambiguous and the new Node is not allocated dynamically
+	if err := c.Create(ctx, newTestNode("testNodeF", router, 62)); err != nil {
+		t.Fatalf("unable to create Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeF"]; got != "" {
+		t.Errorf("got %s, wanted no PodCIDR", got)
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if !slices.ContainsFunc(current.PinConflicts(), func(conflict v1alpha1.PinConflict) bool {
+		return conflict.Node == "testNodeF" && strings.Contains(conflict.Reason, "matches 2 Nodes")
+	}) {
+		t.Errorf("got %+v, wanted a conflict for the ambiguous node selector", current.PinConflicts())
+	}
+
+	// Case 3: Another NodeCIDRAllocation shares the address pool and allocates a new Node dynamically
+	// expected: the PodCIDRs pinned by the first NodeCIDRAllocation are skipped
+	infra := map[string]string{"kubernetes.io/role": "infra"}
+	other := newTestNodeCIDRAllocation("testAllocationB", infra, "10.0.0.0/23")
+	if err := c.Create(ctx, other); err != nil {
+		t.Fatalf("unable to create NodeCIDRAllocation. got %e", err)
+	}
+	if err := c.Create(ctx, newTestNode("testNodeG", infra, 62)); err != nil {
+		t.Fatalf("unable to create Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, other); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeG"]; got != "10.0.1.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.1.64/26")
+	}
+}
+
+func TestReconcileRequestedAllocations(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	requesting := func(name string, annotations map[string]string) *corev1.Node {
+		node := newTestNode(name, selector, 62)
+		node.SetAnnotations(annotations)
+		return node
+	}
+
+	c := newTestClientBuilder(
+		newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24", "10.1.0.0/24"),
+		requesting("testNodeA", map[string]string{v1alpha1.AnnotationRequestedPodCIDR: "10.0.0.192/26"}),
+		requesting("testNodeB", map[string]string{v1alpha1.AnnotationRequestedPool: "10.1.0.0/24"}),
+		requesting("testNodeC", map[string]string{v1alpha1.AnnotationRequestedPrefixLength: "25"}),
+		requesting("testNodeD", map[string]string{v1alpha1.AnnotationRequestedPodCIDR: "10.0.0.192/26"}),
+		requesting("testNodeE", map[string]string{v1alpha1.AnnotationRequestedPool: "10.9.0.0/24"}),
+	).Build()
+
+	// Case 1: Nodes requesting an exact PodCIDR, an address pool and a prefix length, a Node requesting a PodCIDR already taken
+	// and a Node requesting an address pool that does not belong to the NodeCIDRAllocation
+	// expected: the valid requests are honored and the others are listed in the status with the InvalidAllocationRequest reason. The reconcile
+	// is not requeued with backoff since no change to the address space can fix the invalid requests
+	got, err := reconcileAll(ctx, t, newTestReconciler(c), newTestNodeCIDRAllocation("testAllocation", selector))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != (ctrl.Result{}) {
+		t.Errorf("got %v, wanted %v", got, ctrl.Result{})
+	}
+
+	podCIDRs := nodePodCIDRs(ctx, t, c)
+	for name, want := range map[string]string{"testNodeA": "10.0.0.192/26", "testNodeB": "10.1.0.0/26", "testNodeC": "10.0.0.0/25", "testNodeD": "", "testNodeE": ""} {
+		if got := podCIDRs[name]; got != want {
+			t.Errorf("%s: got %q, wanted %q", name, got, want)
+		}
+	}
+
+	nodeCIDRAllocation := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "testAllocation", Namespace: "default"}, &nodeCIDRAllocation); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	failures := nodeCIDRAllocation.FailedAllocations()
+	if len(failures) != 2 {
+		t.Fatalf("got %v, wanted 2 failures", failures)
+	}
+	for i, name := range []string{"testNodeD", "testNodeE"} {
+		if failures[i].Node != name || failures[i].Reason != v1alpha1.NodeConditionReasonInvalidRequest {
+			t.Errorf("got %v, wanted a %s failure for %s", failures[i], v1alpha1.NodeConditionReasonInvalidRequest, name)
+		}
+	}
+}
+
+// routeAggregates returns the aggregated routes listed in the status and in the aggregated routes ConfigMap of the NodeCIDRAllocation
+func routeAggregates(ctx context.Context, t *testing.T, c client.Client, key types.NamespacedName) ([]v1alpha1.AggregatedRoute, string) {
+	t.Helper()
+
+	current := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+
+	configMap := corev1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Name: key.Name + "-aggregated-routes", Namespace: key.Namespace}, &configMap); err != nil {
+		t.Fatalf("unable to get aggregated routes ConfigMap. got %e", err)
+	}
+	if owner := metav1.GetControllerOf(&configMap); owner == nil || owner.Name != key.Name {
+		t.Errorf("got %v, wanted the ConfigMap to be controlled by %s", owner, key.Name)
+	}
+
+	return current.Status.Aggregates, configMap.Data["prefixes"]
+}
+
+func TestReconcileRouteAggregates(t *testing.T) {
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "testAllocation", Namespace: "default"}
+	rackA := map[string]string{"kubernetes.io/role": "agent", "rack": "a"}
+	rackB := map[string]string{"kubernetes.io/role": "agent", "rack": "b"}
+
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", map[string]string{"kubernetes.io/role": "agent"}, "10.0.0.0/24", "10.1.0.0/24")
+	nodeCIDRAllocation.Spec.AggregationLabel = "rack"
+	nodeCIDRAllocation.Spec.PinnedAllocations = []v1alpha1.PinnedAllocation{{NodeName: "testNodeE", PodCIDR: "10.1.0.0/26"}}
+
+	c := newTestClientBuilder(
+		nodeCIDRAllocation,
+		newTestNode("testNodeA", rackA, 62),
+		newTestNode("testNodeB", rackA, 62),
+		newTestNode("testNodeC", rackB, 62),
+		newTestNode("testNodeE", rackB, 62),
+	).Build()
+	r := newTestReconciler(c)
+
+	// Case 1: Nodes of two racks are allocated from the first address pool, and one Node is pinned in the second address pool
+	// expected: the aggregates of each pool and rack are listed in the status and published in the ConfigMap
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	want := []v1alpha1.AggregatedRoute{
+		{Prefix: "10.0.0.0/25", Pool: "10.0.0.0/24", Group: "a", Nodes: []string{"testNodeA", "testNodeB"}},
+		{Prefix: "10.0.0.128/26", Pool: "10.0.0.0/24", Group: "b", Nodes: []string{"testNodeC"}},
+		{Prefix: "10.1.0.0/26", Pool: "10.1.0.0/24", Group: "b", Nodes: []string{"testNodeE"}},
+	}
+	aggregates, prefixes := routeAggregates(ctx, t, c, key)
+	if !reflect.DeepEqual(aggregates, want) {
+		t.Errorf("got %+v, wanted %+v", aggregates, want)
+	}
+	if wantPrefixes := "10.0.0.0/25\n10.0.0.128/26\n10.1.0.0/26"; prefixes != wantPrefixes {
+		t.Errorf("got %q, wanted %q", prefixes, wantPrefixes)
+	}
+
+	// Case 2: A Node is added to the second rack
+	// expected: its PodCIDR is merged into the aggregate of the rack
+	if err := c.Create(ctx, newTestNode("testNodeD", rackB, 62)); err != nil {
+		t.Fatalf("unable to create Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	want[1] = v1alpha1.AggregatedRoute{Prefix: "10.0.0.128/25", Pool: "10.0.0.0/24", Group: "b", Nodes: []string{"testNodeC", "testNodeD"}}
+	if aggregates, _ := routeAggregates(ctx, t, c, key); !reflect.DeepEqual(aggregates, want) {
+		t.Errorf("got %+v, wanted %+v", aggregates, want)
+	}
+
+	// Case 3: A Node of the first rack is deleted
+	// expected: the aggregate of the rack shrinks to the PodCIDR of the remaining Node
+	if err := c.Delete(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "testNodeA"}}); err != nil {
+		t.Fatalf("unable to delete Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	want[0] = v1alpha1.AggregatedRoute{Prefix: "10.0.0.64/26", Pool: "10.0.0.0/24", Group: "a", Nodes: []string{"testNodeB"}}
+	if aggregates, _ := routeAggregates(ctx, t, c, key); !reflect.DeepEqual(aggregates, want) {
+		t.Errorf("got %+v, wanted %+v", aggregates, want)
+	}
+
+	// Case 4: The only Node in the second address pool is deleted
+	// expected: the aggregate of the address pool is removed from the status and the ConfigMap
+	if err := c.Delete(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "testNodeE"}}); err != nil {
+		t.Fatalf("unable to delete Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	want = want[:2]
+	aggregates, prefixes = routeAggregates(ctx, t, c, key)
+	if !reflect.DeepEqual(aggregates, want) {
+		t.Errorf("got %+v, wanted %+v", aggregates, want)
+	}
+	if wantPrefixes := "10.0.0.64/26\n10.0.0.128/25"; prefixes != wantPrefixes {
+		t.Errorf("got %q, wanted %q", prefixes, wantPrefixes)
+	}
+
+	// Case 5: The Nodes of the second rack are relabelled into the first rack
+	// expected: the aggregate of the second rack is removed and its PodCIDRs are aggregated with the first rack
+	for _, name := range []string{"testNodeC", "testNodeD"} {
+		node := corev1.Node{}
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, &node); err != nil {
+			t.Fatalf("unable to get Node. got %e", err)
+		}
+		node.SetLabels(rackA)
+		if err := c.Update(ctx, &node); err != nil {
+			t.Fatalf("unable to update Node. got %e", err)
+		}
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	want = []v1alpha1.AggregatedRoute{
+		{Prefix: "10.0.0.64/26", Pool: "10.0.0.0/24", Group: "a", Nodes: []string{"testNodeB"}},
+		{Prefix: "10.0.0.128/25", Pool: "10.0.0.0/24", Group: "a", Nodes: []string{"testNodeC", "testNodeD"}},
+	}
+	aggregates, prefixes = routeAggregates(ctx, t, c, key)
+	if !reflect.DeepEqual(aggregates, want) {
+		t.Errorf("got %+v, wanted %+v", aggregates, want)
+	}
+	if wantPrefixes := "10.0.0.64/26\n10.0.0.128/25"; prefixes != wantPrefixes {
+		t.Errorf("got %q, wanted %q", prefixes, wantPrefixes)
+	}
+}
+
+func TestReconcileReservations(t *testing.T) {
+	ctx := context.Background()
+	selector := map[string]string{"kubernetes.io/role": "agent"}
+	key := types.NamespacedName{Name: "testAllocation", Namespace: "default"}
+
+	nodeA := newTestNode("testNodeA", selector, 62)
+	nodeCIDRAllocation := newTestNodeCIDRAllocation("testAllocation", selector, "10.0.0.0/24")
+	nodeCIDRAllocation.Spec.Reservations = []v1alpha1.AddressReservation{
+		{Name: "load-balancers", CIDR: "10.0.0.0/26", Owner: "network-team", Reason: "MetalLB", ExpiresAt: &metav1.Time{Time: time.Now().Add(time.Hour)}},
+		{Name: "expired", CIDR: "10.0.0.64/26", ExpiresAt: &metav1.Time{Time: time.Now().Add(-time.Hour)}},
+	}
+
+	c := newTestClientBuilder(nodeA, nodeCIDRAllocation).Build()
+	r := newTestReconciler(c)
+
+	// Case 1: One reservation is active and expires in an hour, the other has already expired
+	// expected: the Node is allocated from the expired range, only the active reservation is listed and a reconcile is scheduled before it expires
+	result, err := reconcileAll(ctx, t, r, nodeCIDRAllocation)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeA"]; got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > time.Hour {
+		t.Errorf("got %v, wanted a requeue within %v", result.RequeueAfter, time.Hour)
+	}
+
+	current := v1alpha1.NodeCIDRAllocation{}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if got := current.ActiveReservations(); len(got) != 1 || got[0].Name != "load-balancers" {
+		t.Errorf("got %+v, wanted only the %s reservation", got, "load-balancers")
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); containsEvent(events, controller.EventReasonReservationExpired) {
+		t.Errorf("got %v, wanted no %s event for a reservation that was never active", events, controller.EventReasonReservationExpired)
+	}
+
+	// Case 2: The active reservation expires and a new Node is created
+	// expected: the released range is allocated to the new Node, a Reservation Expired event is recorded and no reservation is listed
+	current.Spec.Reservations[0].ExpiresAt = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatalf("unable to update NodeCIDRAllocation. got %e", err)
+	}
+	if err := c.Create(ctx, newTestNode("testNodeB", selector, 62)); err != nil {
+		t.Fatalf("unable to create Node. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got := nodePodCIDRs(ctx, t, c)["testNodeB"]; got != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/26")
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if got := current.ActiveReservations(); got != nil {
+		t.Errorf("got %+v, wanted %v", got, nil)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonReservationExpired, "load-balancers", "10.0.0.0/26") {
+		t.Errorf("got %v, wanted a %s event naming the reservation", events, controller.EventReasonReservationExpired)
+	}
+
+	// Case 3: The NodeCIDRAllocation references an AddressPool with a reservation that is active and then expires
+	// expected: the reservation of the AddressPool is listed while active, and a Reservation Expired event is recorded once it expires
+	addressPool := &v1alpha1.AddressPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+		Spec: v1alpha1.AddressPoolSpec{
+			CIDRs:        []string{"10.1.0.0/24"},
+			Reservations: []v1alpha1.AddressReservation{{Name: "gateways", CIDR: "10.1.0.0/28", ExpiresAt: &metav1.Time{Time: time.Now().Add(time.Hour)}}},
+		},
+	}
+	if err := c.Create(ctx, addressPool); err != nil {
+		t.Fatalf("unable to create AddressPool. got %e", err)
+	}
+	current.Spec.AddressPoolRefs = []string{"shared"}
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatalf("unable to update NodeCIDRAllocation. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if got := current.ActiveReservations(); len(got) != 1 || got[0].Name != "gateways" {
+		t.Errorf("got %+v, wanted only the %s reservation", got, "gateways")
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); containsEvent(events, controller.EventReasonReservationExpired) {
+		t.Errorf("got %v, wanted no %s event while the reservation is active", events, controller.EventReasonReservationExpired)
+	}
+
+	if err := c.Get(ctx, types.NamespacedName{Name: "shared"}, addressPool); err != nil {
+		t.Fatalf("unable to get AddressPool. got %e", err)
+	}
+	addressPool.Spec.Reservations[0].ExpiresAt = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+	if err := c.Update(ctx, addressPool); err != nil {
+		t.Fatalf("unable to update AddressPool. got %e", err)
+	}
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Get(ctx, key, &current); err != nil {
+		t.Fatalf("unable to get NodeCIDRAllocation. got %e", err)
+	}
+	if got := current.ActiveReservations(); got != nil {
+		t.Errorf("got %+v, wanted %v", got, nil)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonReservationExpired, "gateways", "10.1.0.0/28") {
+		t.Errorf("got %v, wanted a %s event naming the reservation of the AddressPool", events, controller.EventReasonReservationExpired)
+	}
+}
+
+func TestReconcileAddressPoolRefs(t *testing.T) {
+	ctx := context.Background()
+	selectorA := map[string]string{"kubernetes.io/role": "agent"}
+	selectorB := map[string]string{"kubernetes.io/role": "infra"}
+
+	addressPool := &v1alpha1.AddressPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+		Spec: v1alpha1.AddressPoolSpec{
+			CIDRs:        []string{"10.0.0.0/24"},
+			Reservations: []v1alpha1.AddressReservation{{Name: "load-balancers", CIDR: "10.0.0.0/26"}},
+		},
+	}
+	nodeCIDRAllocationA := newTestNodeCIDRAllocation("testAllocationA", selectorA)
+	nodeCIDRAllocationA.Spec.AddressPoolRefs = []string{"shared"}
+	nodeCIDRAllocationB := newTestNodeCIDRAllocation("testAllocationB", selectorB)
+	nodeCIDRAllocationB.Spec.AddressPoolRefs = []string{"shared"}
+	nodeCIDRAllocationC := newTestNodeCIDRAllocation("testAllocationC", map[string]string{"kubernetes.io/role": "edge"})
+	nodeCIDRAllocationC.Spec.AddressPoolRefs = []string{"missing"}
+
+	c := newTestClientBuilder(
+		newTestNode("testNodeA", selectorA, 62),
+		newTestNode("testNodeB", selectorB, 62),
+		addressPool, nodeCIDRAllocationA, nodeCIDRAllocationB, nodeCIDRAllocationC,
+	).Build()
+	r := newTestReconciler(c)
+
+	// Case 1: Two NodeCIDRAllocations reference the same AddressPool
+	// expected: both Nodes are allocated from the AddressPool (outside of its reservation) and the capacity of the AddressPool is counted once
+	for _, nodeCIDRAllocation := range []*v1alpha1.NodeCIDRAllocation{nodeCIDRAllocationA, nodeCIDRAllocationB} {
+		if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocation); err != nil {
+			t.Errorf("function was not expected to error. got %e", err)
+		}
+	}
+	want := map[string]string{"testNodeA": "10.0.0.64/26", "testNodeB": "10.0.0.128/26"}
+	if got := nodePodCIDRs(ctx, t, c); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	current := v1alpha1.AddressPool{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "shared"}, &current); err != nil {
+		t.Fatalf("unable to get AddressPool. got %e", err)
+	}
+	wantStatus := v1alpha1.AddressPoolStatus{
+		NodeCIDRAllocations: []string{"default/testAllocationA", "default/testAllocationB"},
+		Addresses:           256,
+		Allocated:           128,
+	}
+	if !reflect.DeepEqual(current.Status, wantStatus) {
+		t.Errorf("got %+v, wanted %+v", current.Status, wantStatus)
+	}
+
+	// Case 2: A NodeCIDRAllocation references an AddressPool that does not exist
+	// expected: the reconcile fails with an Address Pool Not Found event
+	_ = drainEvents(r.Recorder.(*record.FakeRecorder))
+	if _, err := reconcileAll(ctx, t, r, nodeCIDRAllocationC); !errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		t.Errorf("got %v, wanted %v", err, allocator.ErrAddressPoolNotFound)
+	}
+	if events := drainEvents(r.Recorder.(*record.FakeRecorder)); !containsEvent(events, controller.EventReasonAddressPoolNotFound, "missing") {
+		t.Errorf("got %v, wanted a %s event naming the AddressPool", events, controller.EventReasonAddressPoolNotFound)
+	}
+}
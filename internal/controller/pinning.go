@@ -0,0 +1,244 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+// errPinConflict is returned when a pinned PodCIDR overlaps a PodCIDR reserved for another Node
+var errPinConflict = errors.New("pinned PodCIDR overlaps a reserved PodCIDR")
+
+// pinnedNode represents the PodCIDR pinned to a Node selected by a NodeCIDRAllocation, along with the reason that it cannot be assigned (if any)
+type pinnedNode struct {
+	podCIDR  string
+	conflict string
+}
+
+// pinResolution represents the pinned allocations of a NodeCIDRAllocation matched to the Nodes in the cluster (see resolvePins)
+type pinResolution struct {
+	// pinned holds the pin of every matched Node keyed by Node name
+	pinned map[string]pinnedNode
+	// conflicts holds every pinned allocation that cannot be honored
+	conflicts []v1alpha1.PinConflict
+}
+
+// resolvePins matches the pinned allocations of the NodeCIDRAllocation to the Nodes in the cluster that it selects. It returns the pin of every
+// matched Node along with every pinned allocation that cannot be honored. A pin cannot be honored when it is invalid, when its Node is not
+// selected by the NodeCIDRAllocation or holds a different PodCIDR, when its node selector matches several Nodes or when its PodCIDR overlaps
+// the PodCIDR of another Node
+func resolvePins(nodeCIDRAllocation *allocator.Resolved, allClusterNodes *corev1.NodeList) *pinResolution {
+	pinned := map[string]pinnedNode{}
+	conflicts := []v1alpha1.PinConflict{}
+	addConflict := func(pin v1alpha1.PinnedAllocation, node, reason string) {
+		conflicts = append(conflicts, v1alpha1.PinConflict{PodCIDR: pin.PodCIDR, Node: node, Reason: reason})
+		if node != "" {
+			pinned[node] = pinnedNode{podCIDR: pin.PodCIDR, conflict: reason}
+		}
+	}
+
+	pins := allocator.NewPins(nodeCIDRAllocation, time.Now())
+	selector := labels.SelectorFromSet(nodeCIDRAllocation.Spec.NodeSelector)
+	for i, pin := range nodeCIDRAllocation.Spec.PinnedAllocations {
+		if reason := pins.Invalid(i); reason != "" {
+			addConflict(pin, "", reason)
+			continue
+		}
+
+		targets := []*corev1.Node{}
+		unselected := false
+		for j := range allClusterNodes.Items {
+			node := &allClusterNodes.Items[j]
+			if !selector.Matches(labels.Set(node.GetLabels())) {
+				unselected = unselected || node.GetName() == pin.NodeName
+				continue
+			}
+
+			if pins.For(node) == pin.PodCIDR {
+				targets = append(targets, node)
+			}
+		}
+		if unselected {
+			addConflict(pin, "", fmt.Sprintf("Node %s is not selected by the NodeCIDRAllocation", pin.NodeName))
+			continue
+		}
+
+		if len(targets) > 1 {
+			names := make([]string, 0, len(targets))
+			for _, node := range targets {
+				names = append(names, node.GetName())
+			}
+			slices.Sort(names)
+
+			reason := fmt.Sprintf("node selector %v matches %d Nodes (%s)", pin.NodeSelector, len(names), strings.Join(names, ", "))
+			for _, name := range names {
+				addConflict(pin, name, reason)
+			}
+			continue
+		}
+
+		target := pin.NodeName
+		if len(targets) == 1 {
+			target = targets[0].GetName()
+			if podCIDR := targets[0].Spec.PodCIDR; podCIDR != "" {
+				if podCIDR != pin.PodCIDR {
+					addConflict(pin, target, fmt.Sprintf("Node holds PodCIDR %s", podCIDR))
+				} else {
+					pinned[target] = pinnedNode{podCIDR: pin.PodCIDR}
+				}
+				continue
+			}
+		}
+
+		if reason := pinOverlap(pin.PodCIDR, target, allClusterNodes); reason != "" {
+			addConflict(pin, target, reason)
+			continue
+		}
+
+		if len(targets) == 1 {
+			pinned[target] = pinnedNode{podCIDR: pin.PodCIDR}
+		}
+	}
+
+	return &pinResolution{pinned: pinned, conflicts: conflicts}
+}
+
+// pinOverlap returns the reason that the pinned PodCIDR cannot be assigned to the named Node because it overlaps the PodCIDR of another Node
+// in the cluster, or an empty string when it does not overlap any
+func pinOverlap(podCIDR, nodeName string, allClusterNodes *corev1.NodeList) string {
+	for _, other := range allClusterNodes.Items {
+		if other.GetName() == nodeName {
+			continue
+		}
+
+		for _, otherPodCIDR := range append([]string{other.Spec.PodCIDR}, other.Spec.PodCIDRs...) {
+			if otherPodCIDR == "" {
+				continue
+			}
+
+			if overlap, err := statcan_net.NetworksOverlap(otherPodCIDR, podCIDR); err == nil && overlap {
+				return fmt.Sprintf("PodCIDR overlaps PodCIDR %s of Node %s", otherPodCIDR, other.GetName())
+			}
+		}
+	}
+
+	return ""
+}
+
+// assignPinnedPodCIDR reserves the pinned PodCIDR for the supplied Node in the process-wide Reservations and in the ledger (when configured)
+// and applies it to the Node. errPinConflict is returned when the PodCIDR overlaps a PodCIDR reserved for another Node, or when a different
+// PodCIDR is already reserved for the Node
+func (r *NodeCIDRAllocationReconciler) assignPinnedPodCIDR(ctx context.Context, nodeCIDRAllocation *allocator.Resolved, node *corev1.Node, podCIDR string, listedAt time.Time) error {
+	check := func(reserved []string) (string, error) {
+		for _, cidr := range reserved {
+			if overlap, err := statcan_net.NetworksOverlap(cidr, podCIDR); err == nil && overlap {
+				return "", fmt.Errorf("%w (%s)", errPinConflict, cidr)
+			}
+		}
+
+		return podCIDR, nil
+	}
+
+	reserve := func(inFlight []string) (string, error) {
+		if r.Ledger == nil {
+			return check(inFlight)
+		}
+
+		owner := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+		return r.Ledger.Reserve(ctx, node.GetName(), node.GetUID(), owner, func(reserved []string) (string, error) {
+			return check(append(append([]string{}, reserved...), inFlight...))
+		})
+	}
+
+	var reserved string
+	var err error
+	if r.Reservations == nil {
+		reserved, err = reserve(nil)
+	} else {
+		reserved, err = r.Reservations.Reserve(node.GetName(), node.GetUID(), listedAt, reserve)
+	}
+	if err != nil {
+		return err
+	}
+	if reserved != podCIDR {
+		return fmt.Errorf("%w (%s is reserved for the Node)", errPinConflict, reserved)
+	}
+
+	pool := allocator.PoolFor(nodeCIDRAllocation.AddressPools(), podCIDR)
+	return r.assignPodCIDR(ctx, node.GetName(), podCIDR, nodeAllocationAnnotations(nodeCIDRAllocation.NodeCIDRAllocation, pool, time.Now()))
+}
+
+// updatePinConflicts records the pinned allocations of the NodeCIDRAllocation that cannot be honored in its status, and records a Warning event
+// for every conflict that was not reported by the previous reconcile. The pins resolved by the reconcile are used when supplied, otherwise
+// they are resolved against the Nodes in the cluster
+func (r *NodeCIDRAllocationReconciler) updatePinConflicts(ctx context.Context, nodeCIDRAllocation *allocator.Resolved, pins *pinResolution) error {
+	if len(nodeCIDRAllocation.Spec.PinnedAllocations) == 0 {
+		nodeCIDRAllocation.SetPinConflicts(nil)
+		return nil
+	}
+
+	if pins == nil {
+		allClusterNodes := corev1.NodeList{}
+		if err := r.Client.List(ctx, &allClusterNodes); err != nil {
+			return fmt.Errorf("unable to list Nodes to resolve pinned allocations: %w", err)
+		}
+		pins = resolvePins(nodeCIDRAllocation, &allClusterNodes)
+	}
+
+	conflicts := pins.conflicts
+	for _, conflict := range conflicts {
+		if slices.Contains(nodeCIDRAllocation.PinConflicts(), conflict) {
+			continue
+		}
+
+		log.FromContext(ctx).Info(
+			"pinned allocation cannot be honored",
+			"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"podCIDR", conflict.PodCIDR,
+			"node", conflict.Node,
+			"reason", conflict.Reason,
+		)
+
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeWarning,
+			EventReasonPinConflict,
+			"Pinned PodCIDR %s cannot be honored: %s", conflict.PodCIDR, conflict.Reason,
+		)
+	}
+
+	if len(conflicts) == 0 {
+		conflicts = nil
+	}
+	nodeCIDRAllocation.SetPinConflicts(conflicts)
+
+	return nil
+}
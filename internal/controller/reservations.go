@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+// untilNextExpiry returns the time remaining until the earliest unexpired reservation of the NodeCIDRAllocation expires, or zero when no
+// unexpired reservation has an expiry time
+func untilNextExpiry(nodeCIDRAllocation *allocator.Resolved, now time.Time) time.Duration {
+	next := ipam.NextExpiry(nodeCIDRAllocation.Reservations(), now)
+	if next.IsZero() {
+		return 0
+	}
+
+	return next.Sub(now)
+}
+
+// updateReservations records the unexpired reservations of the NodeCIDRAllocation (and of its referenced AddressPools) in its status, and records
+// an event for every reservation that was active at the previous reconcile and has since expired. The range of an expired reservation is released
+// for allocation
+func (r *NodeCIDRAllocationReconciler) updateReservations(ctx context.Context, nodeCIDRAllocation *allocator.Resolved) {
+	now := time.Now()
+	for _, reservation := range nodeCIDRAllocation.Reservations() {
+		if !reservation.Expired(now) {
+			continue
+		}
+
+		if !slices.ContainsFunc(nodeCIDRAllocation.ActiveReservations(), func(active v1alpha1.AddressReservation) bool {
+			return active.Name == reservation.Name && active.CIDR == reservation.Prefix.String()
+		}) {
+			continue
+		}
+
+		log.FromContext(ctx).Info(
+			"reservation expired and its range was released",
+			"NodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"reservation", reservation.Name,
+			"cidr", reservation.Prefix.String(),
+			"owner", reservation.Owner,
+		)
+
+		r.Recorder.Eventf(
+			nodeCIDRAllocation.NodeCIDRAllocation,
+			corev1.EventTypeNormal,
+			EventReasonReservationExpired,
+			"Reservation %s (%s) expired at %s and its range was released", reservation.Name, reservation.Prefix, reservation.ExpiresAt.UTC().Format(time.RFC3339),
+		)
+	}
+
+	var active []v1alpha1.AddressReservation
+	for _, reservation := range nodeCIDRAllocation.UnexpiredReservations(now) {
+		active = append(active, addressReservation(reservation))
+	}
+	nodeCIDRAllocation.SetActiveReservations(active)
+}
+
+// addressReservation converts the reservation back to the AddressReservation listed in the status of a NodeCIDRAllocation
+func addressReservation(reservation ipam.Reservation) v1alpha1.AddressReservation {
+	addressReservation := v1alpha1.AddressReservation{
+		Name:   reservation.Name,
+		CIDR:   reservation.Prefix.String(),
+		Owner:  reservation.Owner,
+		Reason: reservation.Reason,
+	}
+	if !reservation.ExpiresAt.IsZero() {
+		addressReservation.ExpiresAt = &metav1.Time{Time: reservation.ExpiresAt}
+	}
+
+	return addressReservation
+}
@@ -0,0 +1,318 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+const (
+	// CalicoIPPoolNamePrefix is prepended to the Node name to form the name of the IPPool mirroring its allocation
+	CalicoIPPoolNamePrefix = "cidr-allocator-"
+
+	// calicoManagedByLabel identifies the IPPools that are managed by the Calico integration
+	calicoManagedByLabel = "app.kubernetes.io/managed-by"
+	calicoManagedByValue = "cidr-allocator"
+	// calicoNodeAnnotation records the name of the Node whose allocation is mirrored by an IPPool
+	calicoNodeAnnotation = "networking.statcan.gc.ca/node"
+
+	// calicoBlockAffinityPrefix is the prefix of the IPAMBlock affinity field for blocks that are affine to a host
+	calicoBlockAffinityPrefix = "host:"
+
+	// calicoMinIPv4BlockSize / calicoMinIPv6BlockSize are the largest block sizes (smallest prefix lengths) that are supported by Calico
+	calicoMinIPv4BlockSize = 20
+	calicoMinIPv6BlockSize = 116
+)
+
+// calicoInheritedFields are the IPPool spec fields that are copied from the Calico IPPool containing the Node PodCIDR, so that Pods on the
+// Node keep the same encapsulation and outbound NAT as the rest of the cluster
+var calicoInheritedFields = []string{"ipipMode", "vxlanMode", "natOutgoing"}
+
+var (
+	// CalicoIPPoolGVK is the GroupVersionKind of the Calico IPPool resource
+	CalicoIPPoolGVK = schema.GroupVersionKind{Group: "crd.projectcalico.org", Version: "v1", Kind: "IPPool"}
+	// CalicoIPAMBlockGVK is the GroupVersionKind of the Calico IPAMBlock resource
+	CalicoIPAMBlockGVK = schema.GroupVersionKind{Group: "crd.projectcalico.org", Version: "v1", Kind: "IPAMBlock"}
+)
+
+// Calico maintains a per-Node Calico IPPool mirroring each Node PodCIDR allocation so that `calico-ipam` only hands out Pod
+// addresses from the range that was allocated to the Node. The encapsulation and outbound NAT settings of each IPPool are copied
+// from the (unmanaged) IPPool that contains the Node PodCIDR. IPPools are removed once their Node no longer exists and any
+// Calico IPAM block affine to a Node that falls outside of the Node's allocation is reported as drift.
+type Calico struct {
+	Client client.Client
+}
+
+// NewCalico returns a new Calico IPPool integration using the provided client
+func NewCalico(c client.Client) *Calico {
+	return &Calico{Client: c}
+}
+
+// Name returns the name of the integration
+func (c *Calico) Name() string {
+	return "Calico"
+}
+
+// WatchObject returns an empty unstructured IPAMBlock object. Calico creates IPAMBlocks when Pod addresses are handed out on a Node
+func (c *Calico) WatchObject() client.Object {
+	block := &unstructured.Unstructured{}
+	block.SetGroupVersionKind(CalicoIPAMBlockGVK)
+
+	return block
+}
+
+// NodeNameFor returns the name of the Node that the supplied IPAMBlock is affine to
+func (c *Calico) NodeNameFor(o client.Object) string {
+	block, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+
+	affinity, _, _ := unstructured.NestedString(block.Object, "spec", "affinity")
+	if !strings.HasPrefix(affinity, calicoBlockAffinityPrefix) {
+		return ""
+	}
+
+	return strings.TrimPrefix(affinity, calicoBlockAffinityPrefix)
+}
+
+// Sync creates or updates the IPPool for every Node with a PodCIDR allocation, deletes the IPPools of Nodes that no longer exist
+// and reports drift for IPPools with an unexpected CIDR and for IPAM blocks that fall outside of their Node's allocation
+func (c *Calico) Sync(ctx context.Context, owner *v1alpha1.NodeCIDRAllocation, nodes *corev1.NodeList) ([]string, error) {
+	allPools := unstructured.UnstructuredList{}
+	allPools.SetGroupVersionKind(CalicoIPPoolGVK.GroupVersion().WithKind(CalicoIPPoolGVK.Kind + "List"))
+	if err := c.Client.List(ctx, &allPools); err != nil {
+		return []string{}, err
+	}
+
+	managedPools := []unstructured.Unstructured{}
+	clusterPools := []unstructured.Unstructured{}
+	for _, pool := range allPools.Items {
+		if pool.GetLabels()[calicoManagedByLabel] == calicoManagedByValue {
+			managedPools = append(managedPools, pool)
+		} else {
+			clusterPools = append(clusterPools, pool)
+		}
+	}
+
+	blocks := unstructured.UnstructuredList{}
+	blocks.SetGroupVersionKind(CalicoIPAMBlockGVK.GroupVersion().WithKind(CalicoIPAMBlockGVK.Kind + "List"))
+	if err := c.Client.List(ctx, &blocks); err != nil {
+		return []string{}, err
+	}
+
+	blocksByNode := map[string][]string{}
+	for _, block := range blocks.Items {
+		nodeName := c.NodeNameFor(&block)
+		if nodeName == "" {
+			continue
+		}
+		cidr, _, _ := unstructured.NestedString(block.Object, "spec", "cidr")
+		blocksByNode[nodeName] = append(blocksByNode[nodeName], cidr)
+	}
+
+	poolsByName := map[string]*unstructured.Unstructured{}
+	for i := range managedPools {
+		poolsByName[managedPools[i].GetName()] = &managedPools[i]
+	}
+
+	drift := []string{}
+	errs := []error{}
+	trackedNodes := map[string]struct{}{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		trackedNodes[node.GetName()] = struct{}{}
+		if node.Spec.PodCIDR == "" {
+			continue
+		}
+
+		d, err := c.syncIPPool(ctx, owner, node, calicoParentPool(clusterPools, node.Spec.PodCIDR), poolsByName[CalicoIPPoolNamePrefix+node.GetName()])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		drift = append(drift, d...)
+
+		for _, blockCIDR := range blocksByNode[node.GetName()] {
+			contained, err := statcan_net.NetworkContains(node.Spec.PodCIDR, blockCIDR)
+			if err != nil || !contained {
+				drift = append(drift, fmt.Sprintf("IPAM block %s affine to Node %s is outside of its PodCIDR %s", blockCIDR, node.GetName(), node.Spec.PodCIDR))
+			}
+		}
+	}
+
+	for _, pool := range managedPools {
+		nodeName := pool.GetAnnotations()[calicoNodeAnnotation]
+		if _, ok := trackedNodes[nodeName]; ok {
+			continue
+		}
+
+		if err := c.Client.Get(ctx, types.NamespacedName{Name: nodeName}, &corev1.Node{}); err == nil || !apierrors.IsNotFound(err) {
+			// the Node still exists (it could be tracked by another NodeCIDRAllocation) or its existence is unknown
+			continue
+		}
+
+		if err := c.Client.Delete(ctx, &pool); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	return drift, utilerrors.NewAggregate(errs)
+}
+
+// calicoParentPool returns the most specific of the supplied IPPools that contains the PodCIDR (or nil when none does)
+func calicoParentPool(pools []unstructured.Unstructured, podCIDR string) *unstructured.Unstructured {
+	var parent *unstructured.Unstructured
+	parentBits := -1
+	for i := range pools {
+		cidr, _, _ := unstructured.NestedString(pools[i].Object, "spec", "cidr")
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil || prefix.Bits() <= parentBits {
+			continue
+		}
+
+		if contained, err := statcan_net.NetworkContains(cidr, podCIDR); err == nil && contained {
+			parent = &pools[i]
+			parentBits = prefix.Bits()
+		}
+	}
+
+	return parent
+}
+
+// syncIPPool creates the IPPool for the supplied Node when it does not exist (current is nil) and otherwise ensures that it only
+// applies to the Node and that it has the settings of its parent IPPool. An existing IPPool with a different CIDR is never modified since
+// Calico may have handed out addresses from it
+func (c *Calico) syncIPPool(ctx context.Context, owner *v1alpha1.NodeCIDRAllocation, node *corev1.Node, parent, current *unstructured.Unstructured) ([]string, error) {
+	desired, err := c.desiredIPPool(owner, node, parent)
+	if err != nil {
+		return []string{}, err
+	}
+
+	if current == nil {
+		if err := c.Client.Create(ctx, desired); err != nil && !apierrors.IsAlreadyExists(err) {
+			return []string{}, err
+		}
+		return []string{}, nil
+	}
+
+	currentCIDR, _, _ := unstructured.NestedString(current.Object, "spec", "cidr")
+	desiredCIDR, _, _ := unstructured.NestedString(desired.Object, "spec", "cidr")
+	if currentCIDR != desiredCIDR {
+		return []string{
+			fmt.Sprintf("IPPool %s has CIDR %s which does not match the Node %s PodCIDR %s", current.GetName(), currentCIDR, node.GetName(), node.Spec.PodCIDR),
+		}, nil
+	}
+
+	currentSelector, _, _ := unstructured.NestedString(current.Object, "spec", "nodeSelector")
+	desiredSelector, _, _ := unstructured.NestedString(desired.Object, "spec", "nodeSelector")
+	inherited := true
+	for _, field := range calicoInheritedFields {
+		currentValue, _, _ := unstructured.NestedFieldNoCopy(current.Object, "spec", field)
+		desiredValue, _, _ := unstructured.NestedFieldNoCopy(desired.Object, "spec", field)
+		inherited = inherited && reflect.DeepEqual(currentValue, desiredValue)
+	}
+	if currentSelector == desiredSelector && inherited && current.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation] == desired.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation] {
+		return []string{}, nil
+	}
+
+	patch := client.MergeFrom(current.DeepCopy())
+	if err := unstructured.SetNestedField(current.Object, desiredSelector, "spec", "nodeSelector"); err != nil {
+		return []string{}, err
+	}
+	for _, field := range calicoInheritedFields {
+		if value, ok, _ := unstructured.NestedFieldCopy(desired.Object, "spec", field); ok {
+			if err := unstructured.SetNestedField(current.Object, value, "spec", field); err != nil {
+				return []string{}, err
+			}
+		} else {
+			unstructured.RemoveNestedField(current.Object, "spec", field)
+		}
+	}
+	annotations := current.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[v1alpha1.AnnotationNodeCIDRAllocation] = desired.GetAnnotations()[v1alpha1.AnnotationNodeCIDRAllocation]
+	current.SetAnnotations(annotations)
+
+	return []string{}, c.Client.Patch(ctx, current, patch)
+}
+
+// desiredIPPool builds the IPPool mirroring the PodCIDR allocation of the supplied Node. The IPPool is restricted to the Node using its
+// hostname label and uses a block size equal to the allocation (within the limits supported by Calico). The encapsulation and outbound NAT
+// settings are copied from the parent IPPool (if any), with encapsulation disabled when the parent does not set it
+func (c *Calico) desiredIPPool(owner *v1alpha1.NodeCIDRAllocation, node *corev1.Node, parent *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	prefix, err := netip.ParsePrefix(node.Spec.PodCIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := prefix.Bits()
+	if prefix.Addr().Is4() && blockSize < calicoMinIPv4BlockSize {
+		blockSize = calicoMinIPv4BlockSize
+	}
+	if prefix.Addr().Is6() && blockSize < calicoMinIPv6BlockSize {
+		blockSize = calicoMinIPv6BlockSize
+	}
+
+	hostname := node.GetName()
+	if v, ok := node.GetLabels()[corev1.LabelHostname]; ok && v != "" {
+		hostname = v
+	}
+
+	pool := &unstructured.Unstructured{}
+	pool.SetGroupVersionKind(CalicoIPPoolGVK)
+	pool.SetName(CalicoIPPoolNamePrefix + node.GetName())
+	pool.SetLabels(map[string]string{calicoManagedByLabel: calicoManagedByValue})
+	pool.SetAnnotations(map[string]string{
+		calicoNodeAnnotation:                  node.GetName(),
+		v1alpha1.AnnotationNodeCIDRAllocation: owner.GetNamespace() + "/" + owner.GetName(),
+	})
+	spec := map[string]interface{}{
+		"cidr":         prefix.Masked().String(),
+		"blockSize":    int64(blockSize),
+		"nodeSelector": fmt.Sprintf("%s == '%s'", corev1.LabelHostname, hostname),
+		"ipipMode":     "Never",
+		"vxlanMode":    "Never",
+	}
+	if parent != nil {
+		for _, field := range calicoInheritedFields {
+			if value, ok, _ := unstructured.NestedFieldCopy(parent.Object, "spec", field); ok {
+				spec[field] = value
+			}
+		}
+	}
+	pool.Object["spec"] = spec
+
+	return pool, nil
+}
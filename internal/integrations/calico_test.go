@@ -0,0 +1,252 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package integrations_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/integrations"
+)
+
+// newCalicoScheme creates a runtime scheme which knows about core types and the unstructured Calico kinds
+func newCalicoScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	for _, gvk := range []schema.GroupVersionKind{integrations.CalicoIPPoolGVK, integrations.CalicoIPAMBlockGVK} {
+		s.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		s.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+
+	return s
+}
+
+// newIPAMBlock creates an unstructured Calico IPAMBlock affine to the supplied Node
+func newIPAMBlock(name, cidr, nodeName string) *unstructured.Unstructured {
+	block := &unstructured.Unstructured{}
+	block.SetGroupVersionKind(integrations.CalicoIPAMBlockGVK)
+	block.SetName(name)
+	block.Object["spec"] = map[string]interface{}{
+		"cidr":     cidr,
+		"affinity": "host:" + nodeName,
+	}
+
+	return block
+}
+
+// getIPPool retrieves the IPPool mirroring the allocation of the supplied Node
+func getIPPool(ctx context.Context, c client.Client, nodeName string) (*unstructured.Unstructured, error) {
+	pool := &unstructured.Unstructured{}
+	pool.SetGroupVersionKind(integrations.CalicoIPPoolGVK)
+	err := c.Get(ctx, types.NamespacedName{Name: integrations.CalicoIPPoolNamePrefix + nodeName}, pool)
+
+	return pool, err
+}
+
+func TestCalicoSync(t *testing.T) {
+	ctx := context.Background()
+	owner := &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testAllocation",
+			Namespace: "default",
+		},
+	}
+	nodeA := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "testnodea",
+			Labels: map[string]string{corev1.LabelHostname: "testnodea.example"},
+		},
+		Spec: corev1.NodeSpec{
+			PodCIDR: "10.0.0.0/26",
+		},
+	}
+	nodeB := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "testnodeb",
+		},
+		Spec: corev1.NodeSpec{
+			PodCIDR: "10.0.0.64/26",
+		},
+	}
+	nodes := &corev1.NodeList{Items: []corev1.Node{nodeA}}
+
+	// Case 1: Node has a PodCIDR allocated and no IPPool exists
+	// expected: an IPPool mirroring the Node allocation should be created without drift
+	c := fake.NewClientBuilder().WithScheme(newCalicoScheme()).WithObjects(nodeA.DeepCopy()).Build()
+	calico := integrations.NewCalico(c)
+	drift, err := calico.Sync(ctx, owner, nodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 0 {
+		t.Errorf("got [%s], wanted []string{}", strings.Join(drift, ","))
+	}
+
+	pool, err := getIPPool(ctx, c, "testnodea")
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	gotCIDR, _, _ := unstructured.NestedString(pool.Object, "spec", "cidr")
+	gotSelector, _, _ := unstructured.NestedString(pool.Object, "spec", "nodeSelector")
+	gotBlockSize, _, _ := unstructured.NestedInt64(pool.Object, "spec", "blockSize")
+	if gotCIDR != "10.0.0.0/26" || gotSelector != "kubernetes.io/hostname == 'testnodea.example'" || gotBlockSize != 26 {
+		t.Errorf("got (%s, %s, %d), wanted (%s, %s, %d)", gotCIDR, gotSelector, gotBlockSize, "10.0.0.0/26", "kubernetes.io/hostname == 'testnodea.example'", 26)
+	}
+
+	// Case 2: IPPool already exists and Calico has handed out a block within the Node allocation
+	// expected: should not produce any drift or error
+	if err := c.Create(ctx, newIPAMBlock("10-0-0-0-28", "10.0.0.0/28", "testnodea")); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	drift, err = calico.Sync(ctx, owner, nodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 0 {
+		t.Errorf("got [%s], wanted []string{}", strings.Join(drift, ","))
+	}
+
+	// Case 3: Calico has handed out a block to the Node that is outside of its allocation
+	// expected: should report drift for the block
+	if err := c.Create(ctx, newIPAMBlock("10-1-0-0-26", "10.1.0.0/26", "testnodea")); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	drift, err = calico.Sync(ctx, owner, nodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 1 || !strings.Contains(drift[0], "10.1.0.0/26") {
+		t.Errorf("got [%s], wanted a single drift entry for 10.1.0.0/26", strings.Join(drift, ","))
+	}
+
+	// Case 4: IPPool exists with a CIDR that does not match the Node allocation
+	// expected: should report drift and leave the IPPool CIDR untouched
+	c = fake.NewClientBuilder().WithScheme(newCalicoScheme()).WithObjects(nodeA.DeepCopy()).Build()
+	calico = integrations.NewCalico(c)
+	if _, err := calico.Sync(ctx, owner, nodes); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	changedNodes := &corev1.NodeList{Items: []corev1.Node{*nodeA.DeepCopy()}}
+	changedNodes.Items[0].Spec.PodCIDR = "10.0.0.128/26"
+	drift, err = calico.Sync(ctx, owner, changedNodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 1 {
+		t.Errorf("got %d drift entries, wanted %d", len(drift), 1)
+	}
+	pool, _ = getIPPool(ctx, c, "testnodea")
+	gotCIDR, _, _ = unstructured.NestedString(pool.Object, "spec", "cidr")
+	if gotCIDR != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", gotCIDR, "10.0.0.0/26")
+	}
+
+	// Case 5: A Node with an IPPool has been removed from the cluster while another one is no longer tracked but still exists
+	// expected: only the IPPool of the removed Node should be cleaned up
+	c = fake.NewClientBuilder().WithScheme(newCalicoScheme()).WithObjects(nodeA.DeepCopy(), nodeB.DeepCopy()).Build()
+	calico = integrations.NewCalico(c)
+	if _, err := calico.Sync(ctx, owner, &corev1.NodeList{Items: []corev1.Node{nodeA, nodeB}}); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if err := c.Delete(ctx, nodeA.DeepCopy()); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if _, err := calico.Sync(ctx, owner, &corev1.NodeList{}); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if _, err := getIPPool(ctx, c, "testnodea"); !apierrors.IsNotFound(err) {
+		t.Errorf("expected IPPool for removed Node to be deleted. got %v", err)
+	}
+	if _, err := getIPPool(ctx, c, "testnodeb"); err != nil {
+		t.Errorf("expected IPPool for existing Node to be kept. got %v", err)
+	}
+
+	// Case 6: The Node PodCIDR is contained in a cluster IPPool using IPIP encapsulation and outbound NAT
+	// expected: the encapsulation and outbound NAT settings of the cluster IPPool carry over to the IPPool of the Node
+	clusterPool := &unstructured.Unstructured{}
+	clusterPool.SetGroupVersionKind(integrations.CalicoIPPoolGVK)
+	clusterPool.SetName("default-ipv4-ippool")
+	clusterPool.Object["spec"] = map[string]interface{}{
+		"cidr":        "10.0.0.0/16",
+		"ipipMode":    "Always",
+		"vxlanMode":   "Never",
+		"natOutgoing": true,
+	}
+	c = fake.NewClientBuilder().WithScheme(newCalicoScheme()).WithObjects(nodeA.DeepCopy(), clusterPool.DeepCopy()).Build()
+	calico = integrations.NewCalico(c)
+	if _, err := calico.Sync(ctx, owner, nodes); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	pool, _ = getIPPool(ctx, c, "testnodea")
+	gotIPIP, _, _ := unstructured.NestedString(pool.Object, "spec", "ipipMode")
+	gotVXLAN, _, _ := unstructured.NestedString(pool.Object, "spec", "vxlanMode")
+	gotNAT, _, _ := unstructured.NestedBool(pool.Object, "spec", "natOutgoing")
+	if gotIPIP != "Always" || gotVXLAN != "Never" || !gotNAT {
+		t.Errorf("got (%s, %s, %t), wanted (%s, %s, %t)", gotIPIP, gotVXLAN, gotNAT, "Always", "Never", true)
+	}
+
+	// Case 7: The cluster IPPool switches to VXLAN encapsulation
+	// expected: the existing IPPool of the Node is updated with the new settings
+	if err := c.Get(ctx, types.NamespacedName{Name: "default-ipv4-ippool"}, clusterPool); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	clusterPool.Object["spec"].(map[string]interface{})["ipipMode"] = "Never"
+	clusterPool.Object["spec"].(map[string]interface{})["vxlanMode"] = "CrossSubnet"
+	if err := c.Update(ctx, clusterPool); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if _, err := calico.Sync(ctx, owner, nodes); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	pool, _ = getIPPool(ctx, c, "testnodea")
+	gotIPIP, _, _ = unstructured.NestedString(pool.Object, "spec", "ipipMode")
+	gotVXLAN, _, _ = unstructured.NestedString(pool.Object, "spec", "vxlanMode")
+	if gotIPIP != "Never" || gotVXLAN != "CrossSubnet" {
+		t.Errorf("got (%s, %s), wanted (%s, %s)", gotIPIP, gotVXLAN, "Never", "CrossSubnet")
+	}
+}
+
+func TestCalicoNodeNameFor(t *testing.T) {
+	calico := integrations.NewCalico(nil)
+
+	// Case 1: IPAM block is affine to a host
+	// expected: the name of the host
+	got := calico.NodeNameFor(newIPAMBlock("10-0-0-0-28", "10.0.0.0/28", "testnodea"))
+	want := "testnodea"
+	if got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+
+	// Case 2: IPAM block is not affine to any host
+	// expected: an empty string
+	block := newIPAMBlock("10-0-0-0-28", "10.0.0.0/28", "")
+	block.Object["spec"] = map[string]interface{}{"cidr": "10.0.0.0/28"}
+	got = calico.NodeNameFor(block)
+	want = ""
+	if got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+)
+
+// CiliumNodeGVK is the GroupVersionKind of the Cilium CiliumNode resource
+var CiliumNodeGVK = schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: "CiliumNode"}
+
+// Cilium mirrors Node PodCIDR allocations into the `spec.ipam.podCIDRs` field of the CiliumNode resource matching each Node.
+// This is intended for clusters running Cilium in the cluster-pool or kubernetes IPAM modes.
+type Cilium struct {
+	Client client.Client
+}
+
+// NewCilium returns a new CiliumNode integration using the provided client
+func NewCilium(c client.Client) *Cilium {
+	return &Cilium{Client: c}
+}
+
+// Name returns the name of the integration
+func (c *Cilium) Name() string {
+	return "Cilium"
+}
+
+// WatchObject returns an empty unstructured CiliumNode object
+func (c *Cilium) WatchObject() client.Object {
+	ciliumNode := &unstructured.Unstructured{}
+	ciliumNode.SetGroupVersionKind(CiliumNodeGVK)
+
+	return ciliumNode
+}
+
+// NodeNameFor returns the name of the Node for the supplied CiliumNode. CiliumNodes are always named after their Node
+func (c *Cilium) NodeNameFor(o client.Object) string {
+	return o.GetName()
+}
+
+// Sync writes the PodCIDR allocation of each Node into the matching CiliumNode. CiliumNodes are owned (and cleaned up) by Cilium itself
+func (c *Cilium) Sync(ctx context.Context, _ *v1alpha1.NodeCIDRAllocation, nodes *corev1.NodeList) ([]string, error) {
+	drift := []string{}
+	errs := []error{}
+	for i := range nodes.Items {
+		d, err := c.syncNode(ctx, &nodes.Items[i])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		drift = append(drift, d...)
+	}
+
+	return drift, utilerrors.NewAggregate(errs)
+}
+
+// syncNode writes the Node PodCIDR allocation into the matching CiliumNode when none is set and reports drift when the CiliumNode
+// already contains a different set of PodCIDRs. CiliumNodes are created by the Cilium agent, so a missing CiliumNode is not
+// considered an error. The CiliumNode will be synchronized once it is created.
+func (c *Cilium) syncNode(ctx context.Context, node *corev1.Node) ([]string, error) {
+	want := nodePodCIDRs(node)
+	if len(want) == 0 {
+		return []string{}, nil
+	}
+
+	ciliumNode := &unstructured.Unstructured{}
+	ciliumNode.SetGroupVersionKind(CiliumNodeGVK)
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: node.GetName()}, ciliumNode); err != nil {
+		if apierrors.IsNotFound(err) {
+			return []string{}, nil
+		}
+
+		return []string{}, err
+	}
+
+	got, _, err := unstructured.NestedStringSlice(ciliumNode.Object, "spec", "ipam", "podCIDRs")
+	if err != nil {
+		return []string{}, fmt.Errorf("unable to read spec.ipam.podCIDRs from CiliumNode %s: %w", ciliumNode.GetName(), err)
+	}
+
+	if len(got) == 0 {
+		patch := client.MergeFrom(ciliumNode.DeepCopy())
+		if err := unstructured.SetNestedStringSlice(ciliumNode.Object, want, "spec", "ipam", "podCIDRs"); err != nil {
+			return []string{}, err
+		}
+
+		return []string{}, c.Client.Patch(ctx, ciliumNode, patch)
+	}
+
+	if !sameCIDRs(got, want) {
+		return []string{
+			fmt.Sprintf("CiliumNode %s has spec.ipam.podCIDRs [%s] which does not match the Node PodCIDRs [%s]", ciliumNode.GetName(), strings.Join(got, ","), strings.Join(want, ",")),
+		}, nil
+	}
+
+	return []string{}, nil
+}
+
+// sameCIDRs compares two lists of CIDRs irrespective of their order
+func sameCIDRs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+
+	return true
+}
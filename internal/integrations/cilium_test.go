@@ -0,0 +1,138 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package integrations_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/integrations"
+)
+
+// newCiliumNode creates an unstructured CiliumNode with the supplied spec.ipam.podCIDRs (if any)
+func newCiliumNode(name string, podCIDRs []string) *unstructured.Unstructured {
+	ciliumNode := &unstructured.Unstructured{}
+	ciliumNode.SetGroupVersionKind(integrations.CiliumNodeGVK)
+	ciliumNode.SetName(name)
+	if podCIDRs != nil {
+		_ = unstructured.SetNestedStringSlice(ciliumNode.Object, podCIDRs, "spec", "ipam", "podCIDRs")
+	}
+
+	return ciliumNode
+}
+
+// newCiliumScheme creates a runtime scheme which knows about the unstructured CiliumNode kind
+func newCiliumScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	s.AddKnownTypeWithName(integrations.CiliumNodeGVK, &unstructured.Unstructured{})
+	s.AddKnownTypeWithName(integrations.CiliumNodeGVK.GroupVersion().WithKind("CiliumNodeList"), &unstructured.UnstructuredList{})
+
+	return s
+}
+
+func TestCiliumSync(t *testing.T) {
+	ctx := context.Background()
+	owner := &v1alpha1.NodeCIDRAllocation{}
+	nodes := &corev1.NodeList{
+		Items: []corev1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "testNodeA",
+				},
+				Spec: corev1.NodeSpec{
+					PodCIDR: "10.0.0.0/26",
+				},
+			},
+		},
+	}
+
+	// Case 1: CiliumNode does not exist (yet) for the Node
+	// expected: should not produce any drift or error
+	c := fake.NewClientBuilder().WithScheme(newCiliumScheme()).Build()
+	drift, err := integrations.NewCilium(c).Sync(ctx, owner, nodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 0 {
+		t.Errorf("got [%s], wanted []string{}", strings.Join(drift, ","))
+	}
+
+	// Case 2: CiliumNode exists without any PodCIDRs
+	// expected: the Node PodCIDR should be written into spec.ipam.podCIDRs without drift
+	c = fake.NewClientBuilder().WithScheme(newCiliumScheme()).WithObjects(newCiliumNode("testNodeA", nil)).Build()
+	drift, err = integrations.NewCilium(c).Sync(ctx, owner, nodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 0 {
+		t.Errorf("got [%s], wanted []string{}", strings.Join(drift, ","))
+	}
+
+	got := newCiliumNode("", nil)
+	if err := c.Get(ctx, types.NamespacedName{Name: "testNodeA"}, got); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	gotPodCIDRs, _, _ := unstructured.NestedStringSlice(got.Object, "spec", "ipam", "podCIDRs")
+	if strings.Join(gotPodCIDRs, ",") != "10.0.0.0/26" {
+		t.Errorf("got [%s], wanted [%s]", strings.Join(gotPodCIDRs, ","), "10.0.0.0/26")
+	}
+
+	// Case 3: CiliumNode already contains the same PodCIDRs as the Node
+	// expected: should not produce any drift or error
+	drift, err = integrations.NewCilium(c).Sync(ctx, owner, nodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 0 {
+		t.Errorf("got [%s], wanted []string{}", strings.Join(drift, ","))
+	}
+
+	// Case 4: CiliumNode contains a PodCIDR that is different from the one allocated to the Node
+	// expected: should report drift and leave the CiliumNode untouched
+	c = fake.NewClientBuilder().WithScheme(newCiliumScheme()).WithObjects(newCiliumNode("testNodeA", []string{"10.1.0.0/26"})).Build()
+	drift, err = integrations.NewCilium(c).Sync(ctx, owner, nodes)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 1 {
+		t.Errorf("got %d drift entries, wanted %d", len(drift), 1)
+	}
+
+	got = newCiliumNode("", nil)
+	if err := c.Get(ctx, client.ObjectKey{Name: "testNodeA"}, got); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	gotPodCIDRs, _, _ = unstructured.NestedStringSlice(got.Object, "spec", "ipam", "podCIDRs")
+	if strings.Join(gotPodCIDRs, ",") != "10.1.0.0/26" {
+		t.Errorf("got [%s], wanted [%s]", strings.Join(gotPodCIDRs, ","), "10.1.0.0/26")
+	}
+
+	// Case 5: Node does not have a PodCIDR allocated
+	// expected: should not produce any drift or error
+	drift, err = integrations.NewCilium(c).Sync(ctx, owner, &corev1.NodeList{Items: []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "testNodeA"}}}})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(drift) != 0 {
+		t.Errorf("got [%s], wanted []string{}", strings.Join(drift, ","))
+	}
+}
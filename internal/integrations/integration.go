@@ -0,0 +1,59 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package integrations contains optional integrations which mirror Node PodCIDR allocations into the IPAM
+// configuration of third-party networking components (such as CNIs). Integrations only make use of unstructured
+// objects so that the controller does not take a hard dependency on any third-party API.
+package integrations
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+)
+
+// Integration mirrors Node PodCIDR allocations into a third-party IPAM configuration
+type Integration interface {
+	// Name returns a short human-readable name for the integration
+	Name() string
+
+	// WatchObject returns an (empty) object of the kind that is managed by the integration. Changes to objects of this kind
+	// will trigger a reconcile for the NodeCIDRAllocation resources that track the Node which the object refers to.
+	WatchObject() client.Object
+
+	// NodeNameFor returns the name of the Node that the supplied watched object refers to (or an empty string if it does not refer to a Node)
+	NodeNameFor(o client.Object) string
+
+	// Sync ensures that the PodCIDRs allocated to the supplied Nodes (tracked by the owner NodeCIDRAllocation) are reflected in the
+	// third-party IPAM configuration, and cleans up anything the integration created for Nodes that no longer exist.
+	// Any drift that could not be reconciled automatically is returned as a list of human-readable descriptions.
+	Sync(ctx context.Context, owner *v1alpha1.NodeCIDRAllocation, nodes *corev1.NodeList) ([]string, error)
+}
+
+// nodePodCIDRs returns the list of PodCIDRs allocated to the supplied Node
+func nodePodCIDRs(node *corev1.Node) []string {
+	if len(node.Spec.PodCIDRs) > 0 {
+		return node.Spec.PodCIDRs
+	}
+	if node.Spec.PodCIDR != "" {
+		return []string{node.Spec.PodCIDR}
+	}
+
+	return []string{}
+}
@@ -0,0 +1,345 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package ledger implements a shared record of PodCIDR reservations that is persisted in a ConfigMap.
+// Every writer (the controller and each replica of the Node admission webhook) reserves a PodCIDR in the ledger before applying it, and every
+// change to the ledger is guarded by the ConfigMap's resourceVersion. This serializes allocations across processes so that two writers can never
+// hand out the same PodCIDR, even when the Node that a PodCIDR was reserved for does not exist yet
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultName is the default name of the ConfigMap which stores the ledger
+	DefaultName = "cidr-allocator-ledger"
+
+	// DefaultTTL is the default amount of time that a reservation is kept in the ledger.
+	// It must be long enough for a reserved PodCIDR to be persisted on its Node and observed by every writer
+	DefaultTTL = 5 * time.Minute
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "cidr-allocator"
+)
+
+// reserveBackoff is the backoff used when a reservation conflicts with a concurrent change to the ledger.
+// It allows more attempts than retry.DefaultRetry since every webhook replica and the controller contend for the same ConfigMap during bursts of Node creation
+var reserveBackoff = wait.Backoff{
+	Steps:    10,
+	Duration: 10 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.5,
+	Cap:      time.Second,
+}
+
+// Reservation represents a PodCIDR which has been set aside for a Node
+type Reservation struct {
+	// CIDR represents the reserved PodCIDR
+	CIDR string `json:"cidr"`
+
+	// Owner represents the NodeCIDRAllocation (namespace/name) that the PodCIDR was reserved from
+	Owner string `json:"owner,omitempty"`
+
+	// UID represents the UID of the Node that the PodCIDR was reserved for. It is empty when the Node did not exist yet (at admission)
+	UID types.UID `json:"uid,omitempty"`
+
+	// Node represents the name of the Node whose PodCIDR was retained. It is only set on retained reservations
+	Node string `json:"node,omitempty"`
+
+	// Expires represents the time after which the reservation is no longer honoured. Retained reservations without a retention period never expire
+	Expires metav1.Time `json:"expires"`
+}
+
+// Expired returns true when the reservation is no longer honoured at the supplied time
+func (r Reservation) Expired(now time.Time) bool {
+	return !r.Expires.IsZero() && !now.Before(r.Expires.Time)
+}
+
+// Retained returns true when the reservation was retained from a deleted NodeCIDRAllocation. It is kept until it expires (when a retention
+// period was set) or until it is released (see ReleaseRetained)
+func (r Reservation) Retained() bool {
+	return r.Node != ""
+}
+
+// PickFunc selects a PodCIDR which does not overlap with any of the supplied reserved PodCIDRs
+type PickFunc func(reserved []string) (string, error)
+
+// Ledger reserves PodCIDRs for Nodes in a ConfigMap using optimistic concurrency
+type Ledger struct {
+	client client.Client
+	reader client.Reader
+
+	key types.NamespacedName
+	ttl time.Duration
+	now func() time.Time
+}
+
+// New creates a new Ledger which is stored in the ConfigMap identified by key.
+// The reader should read directly from the API server so that reservations are always made against the latest version of the ledger
+func New(c client.Client, reader client.Reader, key types.NamespacedName) *Ledger {
+	return &Ledger{
+		client: c,
+		reader: reader,
+		key:    key,
+		ttl:    DefaultTTL,
+		now:    time.Now,
+	}
+}
+
+// WithTTL sets the amount of time that new reservations are kept in the ledger
+func (l *Ledger) WithTTL(ttl time.Duration) *Ledger {
+	l.ttl = ttl
+	return l
+}
+
+// Key returns the name and namespace of the ConfigMap which stores the ledger
+func (l *Ledger) Key() types.NamespacedName {
+	return l.key
+}
+
+// Reservations returns all reservations in the ledger that have not expired, keyed by Node name
+func (l *Ledger) Reservations(ctx context.Context) (map[string]Reservation, error) {
+	configMap := corev1.ConfigMap{}
+	if err := l.reader.Get(ctx, l.key, &configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return map[string]Reservation{}, nil
+		}
+
+		return nil, err
+	}
+
+	return l.decode(&configMap), nil
+}
+
+// Reserve reserves a PodCIDR for the named Node and returns it.
+// When the Node (identified by its name and UID) already holds a reservation of the same owner that has not expired, the reservation is renewed
+// and its PodCIDR is returned. Otherwise, pick is called with the PodCIDRs reserved for all other Nodes and the PodCIDR it selects replaces any
+// reservation held for the name, so that a Node recreated with the same name (or selected by another owner) is never handed a stale PodCIDR.
+// A Node without a UID (at admission) is always picked a new PodCIDR.
+// When another writer changes the ledger concurrently, the reservation is retried against the latest version of the ledger
+func (l *Ledger) Reserve(ctx context.Context, nodeName string, uid types.UID, owner string, pick PickFunc) (string, error) {
+	var podCIDR string
+	err := retry.OnError(reserveBackoff, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		configMap, exists, err := l.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		reservations := l.decode(configMap)
+		reservation, ok := reservations[nodeName]
+		if !ok || reservation.UID == "" || reservation.UID != uid || reservation.Owner != owner {
+			// the reservation held for the name (if any) was made for a previous Node, at admission or for another owner
+			delete(reservations, nodeName)
+
+			reserved := make([]string, 0, len(reservations))
+			for _, name := range sortedNames(reservations) {
+				reserved = append(reserved, reservations[name].CIDR)
+			}
+
+			cidr, err := pick(reserved)
+			if err != nil {
+				return err
+			}
+
+			reservation = Reservation{
+				CIDR:  cidr,
+				Owner: owner,
+				UID:   uid,
+			}
+		}
+		reservation.Expires = metav1.NewTime(l.now().Add(l.ttl).UTC().Truncate(time.Second))
+		reservations[nodeName] = reservation
+
+		if err := l.write(ctx, configMap, exists, reservations); err != nil {
+			return err
+		}
+
+		podCIDR = reservation.CIDR
+		return nil
+	})
+
+	return podCIDR, err
+}
+
+// Retain records the supplied PodCIDRs (keyed by Node name) as reservations of the supplied owner which expire after the supplied retention
+// period, or never when it is zero. Retained reservations are stored under a key derived from the owner and the Node name (see RetainedKey) so
+// that they are never renewed or reused by a new Node with the same name, and they remain in the ledger until they expire or are released
+func (l *Ledger) Retain(ctx context.Context, owner string, podCIDRs map[string]string, period time.Duration) error {
+	expires := metav1.Time{}
+	if period > 0 {
+		expires = metav1.NewTime(l.now().Add(period).UTC().Truncate(time.Second))
+	}
+
+	return retry.OnError(reserveBackoff, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		configMap, exists, err := l.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		reservations := l.decode(configMap)
+		for nodeName, cidr := range podCIDRs {
+			reservations[RetainedKey(owner, nodeName)] = Reservation{
+				CIDR:    cidr,
+				Owner:   owner,
+				Node:    nodeName,
+				Expires: expires,
+			}
+		}
+
+		return l.write(ctx, configMap, exists, reservations)
+	})
+}
+
+// ReleaseRetained removes every retained reservation for which release returns true (along with any expired reservation) from the ledger,
+// and returns the released reservations. The ledger is only written when at least one retained reservation is released
+func (l *Ledger) ReleaseRetained(ctx context.Context, release func(reservation Reservation) bool) ([]Reservation, error) {
+	var released []Reservation
+	err := retry.OnError(reserveBackoff, func(err error) bool {
+		return apierrors.IsConflict(err)
+	}, func() error {
+		released = nil
+
+		configMap, exists, err := l.get(ctx)
+		if err != nil || !exists {
+			return err
+		}
+
+		reservations := l.decode(configMap)
+		for _, name := range sortedNames(reservations) {
+			if reservation := reservations[name]; reservation.Retained() && release(reservation) {
+				released = append(released, reservation)
+				delete(reservations, name)
+			}
+		}
+		if len(released) == 0 {
+			return nil
+		}
+
+		return l.write(ctx, configMap, exists, reservations)
+	})
+
+	return released, err
+}
+
+// RetainedKey returns the ledger key of the reservation retained by the supplied owner (namespace/name) for the named Node.
+// The key contains underscores, which are not valid in Node names, so that it never collides with the reservation of a Node
+func RetainedKey(owner, nodeName string) string {
+	return "retained_" + strings.ReplaceAll(owner, "/", "_") + "_" + nodeName
+}
+
+// get reads the latest version of the ledger ConfigMap from the API server. When the ConfigMap does not exist yet,
+// a new (empty) ConfigMap is returned along with false
+func (l *Ledger) get(ctx context.Context) (*corev1.ConfigMap, bool, error) {
+	configMap := corev1.ConfigMap{}
+	if err := l.reader.Get(ctx, l.key, &configMap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, false, err
+		}
+
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      l.key.Name,
+				Namespace: l.key.Namespace,
+				Labels: map[string]string{
+					managedByLabel: managedByValue,
+				},
+			},
+		}, false, nil
+	}
+
+	return &configMap, true, nil
+}
+
+// write stores the supplied reservations in the ledger ConfigMap. The write is guarded by the resourceVersion of the ConfigMap (when it exists)
+// so that a concurrent change results in a conflict
+func (l *Ledger) write(ctx context.Context, configMap *corev1.ConfigMap, exists bool, reservations map[string]Reservation) error {
+	data, err := encode(reservations)
+	if err != nil {
+		return err
+	}
+	configMap.Data = data
+
+	if exists {
+		return l.client.Update(ctx, configMap)
+	}
+	return l.client.Create(ctx, configMap)
+}
+
+// decode reads all reservations that have not expired from the supplied ledger ConfigMap.
+// Entries that cannot be decoded are dropped since they cannot be honoured
+func (l *Ledger) decode(configMap *corev1.ConfigMap) map[string]Reservation {
+	now := l.now()
+
+	reservations := map[string]Reservation{}
+	for name, value := range configMap.Data {
+		reservation := Reservation{}
+		if err := json.Unmarshal([]byte(value), &reservation); err != nil {
+			continue
+		}
+
+		if reservation.Expired(now) {
+			continue
+		}
+
+		reservations[name] = reservation
+	}
+
+	return reservations
+}
+
+// encode converts the supplied reservations into ConfigMap data
+func encode(reservations map[string]Reservation) (map[string]string, error) {
+	data := make(map[string]string, len(reservations))
+	for name, reservation := range reservations {
+		value, err := json.Marshal(reservation)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode reservation for Node %s: %w", name, err)
+		}
+
+		data[name] = string(value)
+	}
+
+	return data, nil
+}
+
+// sortedNames returns the Node names of the supplied reservations in a stable order
+func sortedNames(reservations map[string]Reservation) []string {
+	names := make([]string, 0, len(reservations))
+	for name := range reservations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,279 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ledger_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+)
+
+var ledgerKey = types.NamespacedName{Name: ledger.DefaultName, Namespace: "cidr-allocator-system"}
+
+// newAtomicClient creates a fake client whose writes are serialized. The fake client does not compare and store the resourceVersion of an
+// object atomically, which the API server guarantees, so concurrent writers could otherwise overwrite each other without a conflict
+func newAtomicClient() client.WithWatch {
+	mu := sync.Mutex{}
+
+	return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return c.Update(ctx, obj, opts...)
+		},
+	}).Build()
+}
+
+// pickFrom returns a PickFunc which allocates subnets of the supplied size from the supplied pool
+func pickFrom(pool string, ones uint8) ledger.PickFunc {
+	return func(reserved []string) (string, error) {
+		return allocator.Allocate([]string{pool}, ones, &corev1.NodeList{}, reserved)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	ctx := context.Background()
+
+	// Case 1: Reservation for a Node when the ledger does not exist yet
+	// expected: the ledger should be created and the first subnet should be reserved
+	c := fake.NewClientBuilder().Build()
+	l := ledger.New(c, c, ledgerKey)
+
+	got, err := l.Reserve(ctx, "testNodeA", "uidA", "default/testAllocation", pickFrom("10.0.0.0/24", 26))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/26")
+	}
+
+	// Case 2: Reservation for a second Node
+	// expected: the subnet reserved for the first Node should be skipped
+	got, err = l.Reserve(ctx, "testNodeB", "uidB", "default/testAllocation", pickFrom("10.0.0.0/24", 26))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+
+	// Case 3: Repeated reservation for the first Node (for example, a retried reconcile)
+	// expected: the existing reservation should be returned
+	got, err = l.Reserve(ctx, "testNodeA", "uidA", "default/testAllocation", pickFrom("10.0.0.0/24", 26))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/26")
+	}
+
+	reservations, err := l.Reservations(ctx)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if len(reservations) != 2 || reservations["testNodeB"].Owner != "default/testAllocation" {
+		t.Errorf("got %v, wanted reservations for testNodeA and testNodeB", reservations)
+	}
+
+	// Case 4: The address pool is exhausted
+	// expected: the error returned by pick should be returned and the ledger should not be changed
+	if _, err := l.Reserve(ctx, "testNodeC", "uidC", "default/testAllocation", pickFrom("10.0.0.0/25", 26)); !errors.Is(err, allocator.ErrNoCapacity) {
+		t.Errorf("got %v, wanted %v", err, allocator.ErrNoCapacity)
+	}
+	if reservations, _ := l.Reservations(ctx); len(reservations) != 2 {
+		t.Errorf("got %d reservations, wanted %d", len(reservations), 2)
+	}
+
+	// Case 5: The first Node is deleted and recreated with the same name (and a different UID) before its reservation expires
+	// expected: a new PodCIDR should be picked for the recreated Node and replace the reservation of the deleted Node
+	got, err = l.Reserve(ctx, "testNodeA", "uidA2", "default/testAllocation", pickFrom("10.0.0.0/24", 27))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.0/27" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/27")
+	}
+
+	// Case 6: The Node is reserved again for another owner (for example, after it was relabelled)
+	// expected: a new PodCIDR should be picked instead of renewing the reservation of the previous owner
+	got, err = l.Reserve(ctx, "testNodeA", "uidA2", "default/otherAllocation", pickFrom("10.1.0.0/24", 26))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.1.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.1.0.0/26")
+	}
+	reservations, _ = l.Reservations(ctx)
+	if want := (ledger.Reservation{CIDR: "10.1.0.0/26", Owner: "default/otherAllocation", UID: "uidA2"}); reservations["testNodeA"].CIDR != want.CIDR || reservations["testNodeA"].Owner != want.Owner || reservations["testNodeA"].UID != want.UID {
+		t.Errorf("got %+v, wanted %+v", reservations["testNodeA"], want)
+	}
+}
+
+func TestReserveExpired(t *testing.T) {
+	ctx := context.Background()
+
+	expired, _ := json.Marshal(ledger.Reservation{
+		CIDR:    "10.0.0.0/26",
+		Expires: metav1.NewTime(time.Now().Add(-time.Minute)),
+	})
+
+	// Case 1: The ledger contains a reservation that has expired
+	// expected: the expired reservation should not be honoured and should be removed from the ledger
+	c := fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ledgerKey.Name,
+			Namespace: ledgerKey.Namespace,
+		},
+		Data: map[string]string{
+			"testNodeOld": string(expired),
+			"invalid":     "{",
+		},
+	}).Build()
+	l := ledger.New(c, c, ledgerKey)
+
+	got, err := l.Reserve(ctx, "testNodeA", "uidA", "default/testAllocation", pickFrom("10.0.0.0/24", 26))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.0/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.0/26")
+	}
+
+	configMap := corev1.ConfigMap{}
+	if err := c.Get(ctx, ledgerKey, &configMap); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if len(configMap.Data) != 1 {
+		t.Errorf("got %v, wanted only the reservation for testNodeA", configMap.Data)
+	}
+}
+
+func TestReserveConcurrentReplicas(t *testing.T) {
+	ctx := context.Background()
+
+	// Case 1: Many Nodes are reserved at the same time by several replicas, each with their own Ledger against the same ConfigMap
+	// expected: every Node should be reserved a unique subnet
+	c := newAtomicClient()
+	replicas := []*ledger.Ledger{
+		ledger.New(c, c, ledgerKey),
+		ledger.New(c, c, ledgerKey),
+		ledger.New(c, c, ledgerKey),
+	}
+
+	const numNodes = 24
+	results := make([]string, numNodes)
+	errs := make([]error, numNodes)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < numNodes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = replicas[i%len(replicas)].Reserve(ctx, fmt.Sprintf("testNode%d", i), types.UID(fmt.Sprintf("uid%d", i)), "default/testAllocation", pickFrom("10.0.0.0/22", 27))
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]int{}
+	for i, podCIDR := range results {
+		if errs[i] != nil {
+			t.Errorf("function was not expected to error. got %e", errs[i])
+			continue
+		}
+		if other, ok := seen[podCIDR]; ok {
+			t.Errorf("got duplicate reservation %s for testNode%d and testNode%d", podCIDR, i, other)
+		}
+		seen[podCIDR] = i
+	}
+}
+
+func TestRetain(t *testing.T) {
+	ctx := context.Background()
+
+	// Case 1: The PodCIDRs of a deleted NodeCIDRAllocation are retained
+	// expected: the reservations should never expire and should be skipped by later reservations, including one for a Node with the same name
+	c := fake.NewClientBuilder().Build()
+	l := ledger.New(c, c, ledgerKey).WithTTL(-time.Minute)
+
+	if err := l.Retain(ctx, "default/testAllocation", map[string]string{"testNodeA": "10.0.0.0/26"}, 0); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+
+	reservations, err := l.Reservations(ctx)
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	retained, ok := reservations[ledger.RetainedKey("default/testAllocation", "testNodeA")]
+	if !ok || !retained.Retained() || retained.CIDR != "10.0.0.0/26" {
+		t.Errorf("got %v, wanted a retained reservation for %s", reservations, "10.0.0.0/26")
+	}
+
+	got, err := l.Reserve(ctx, "testNodeA", "uidA", "default/otherAllocation", pickFrom("10.0.0.0/24", 26))
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+
+	// Case 2: The PodCIDRs of a deleted NodeCIDRAllocation are retained with a retention period that has since elapsed
+	// expected: the reservation should expire
+	if err := l.Retain(ctx, "default/expiringAllocation", map[string]string{"testNodeB": "10.0.0.128/26"}, time.Nanosecond); err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	reservations, _ = l.Reservations(ctx)
+	if _, ok := reservations[ledger.RetainedKey("default/expiringAllocation", "testNodeB")]; ok {
+		t.Errorf("got %v, wanted the retained reservation for %s to have expired", reservations, "10.0.0.128/26")
+	}
+
+	// Case 3: The retained PodCIDR is owned again
+	// expected: the retained reservation should be released and returned
+	released, err := l.ReleaseRetained(ctx, func(reservation ledger.Reservation) bool {
+		return reservation.CIDR == "10.0.0.0/26"
+	})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	}
+	if len(released) != 1 || released[0].CIDR != "10.0.0.0/26" || released[0].Node != "testNodeA" {
+		t.Errorf("got %+v, wanted the retained reservation for %s", released, "10.0.0.0/26")
+	}
+	reservations, _ = l.Reservations(ctx)
+	if _, ok := reservations[ledger.RetainedKey("default/testAllocation", "testNodeA")]; ok {
+		t.Errorf("got %v, wanted the retained reservation for %s to be released", reservations, "10.0.0.0/26")
+	}
+}
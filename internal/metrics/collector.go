@@ -0,0 +1,173 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package metrics
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+// Collector is a prometheus.Collector which exposes all of the operator metrics. The capacity metrics are computed on each scrape from
+// the NodeCIDRAllocations, AddressPools and Nodes read from the supplied (cached) reader, rather than on every reconcile.
+// Computations are memoized: a scrape only recomputes the capacity metrics when any of the fields that they are computed from changed
+// or when a reservation expired since the last computation
+type Collector struct {
+	reader client.Reader
+	now    func() time.Time
+
+	mu          sync.Mutex
+	fingerprint uint64
+	validUntil  time.Time
+	computed    bool
+}
+
+// NewCollector returns a Collector which reads NodeCIDRAllocations, AddressPools and Nodes from the supplied reader. The reader
+// is expected to be backed by the informer cache (such as the client of the Manager)
+func NewCollector(reader client.Reader) *Collector {
+	return &Collector{reader: reader, now: time.Now}
+}
+
+// WithClock sets the function used to read the current time, against which reservations are expired
+func (c *Collector) WithClock(now func() time.Time) *Collector {
+	c.now = now
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range Get() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector. The capacity metrics are refreshed (when required) before all of the metrics are collected
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.Refresh(context.Background())
+
+	for _, collector := range Get() {
+		collector.Collect(ch)
+	}
+}
+
+// Refresh recomputes the capacity metrics unless the resources they are computed from are unchanged since the last computation.
+// Concurrent scrapes wait for a single computation. On failure to list the resources, the metrics keep their previous values
+func (c *Collector) Refresh(ctx context.Context) {
+	log := log.FromContext(ctx).WithName("metrics")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	allNodeCIDRAllocations := v1alpha1.NodeCIDRAllocationList{}
+	if err := c.reader.List(ctx, &allNodeCIDRAllocations); err != nil {
+		log.Error(err, "unable to get NodeCIDRAllocationList resource. cannot update metrics")
+		return
+	}
+
+	allAddressPools := v1alpha1.AddressPoolList{}
+	if err := c.reader.List(ctx, &allAddressPools); err != nil {
+		log.Error(err, "unable to get AddressPoolList resource. cannot update metrics")
+		return
+	}
+
+	allNodes := corev1.NodeList{}
+	if err := c.reader.List(ctx, &allNodes); err != nil {
+		log.Error(err, "unable to get NodeList resource. cannot update metrics")
+		return
+	}
+
+	now := c.now()
+	fingerprint := resourceFingerprint(&allNodeCIDRAllocations, &allAddressPools, &allNodes)
+	if c.computed && fingerprint == c.fingerprint && (c.validUntil.IsZero() || now.Before(c.validUntil)) {
+		return
+	}
+
+	update(&allNodeCIDRAllocations, &allAddressPools, &allNodes, now)
+
+	c.computed = true
+	c.fingerprint = fingerprint
+	c.validUntil = nextReservationExpiry(&allNodeCIDRAllocations, &allAddressPools, now)
+}
+
+// resourceFingerprint returns a hash of the fields of the supplied resources that the capacity metrics are computed from: the specs
+// and paused annotation of the NodeCIDRAllocations (going by their generation) along with their removed draining pools, the specs of the AddressPools (going by their
+// generation) and the labels, PodCIDRs and allocatable pods of the Nodes. Changes to any other field (such as the status or heartbeats
+// of a Node) do not change the hash. The hash does not depend on the order of the resources, since lists read from the cache are not ordered
+func resourceFingerprint(nodeCIDRAllocations *v1alpha1.NodeCIDRAllocationList, addressPools *v1alpha1.AddressPoolList, nodes *corev1.NodeList) uint64 {
+	var fingerprint uint64
+	add := func(fields ...string) {
+		h := fnv.New64a()
+		for _, s := range fields {
+			_, _ = h.Write([]byte(s))
+			_, _ = h.Write([]byte{0})
+		}
+		fingerprint += h.Sum64()
+	}
+
+	for i := range nodeCIDRAllocations.Items {
+		n := &nodeCIDRAllocations.Items[i]
+		fields := []string{"NodeCIDRAllocation", n.GetNamespace(), n.GetName(), strconv.FormatInt(n.GetGeneration(), 10), strconv.FormatBool(n.Paused())}
+		// removed pools are retained (and counted in the capacity) through the status, which does not bump the generation
+		for _, drainingPool := range n.Draining() {
+			if drainingPool.Removed {
+				fields = append(fields, drainingPool.Pool)
+			}
+		}
+		add(fields...)
+	}
+	for i := range addressPools.Items {
+		p := &addressPools.Items[i]
+		add("AddressPool", p.GetName(), strconv.FormatInt(p.GetGeneration(), 10))
+	}
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		fields := []string{"Node", n.GetName(), n.Spec.PodCIDR, strings.Join(n.Spec.PodCIDRs, ","), n.Status.Allocatable.Pods().String()}
+		labels := make([]string, 0, len(n.GetLabels()))
+		for key, value := range n.GetLabels() {
+			labels = append(labels, key+"="+value)
+		}
+		slices.Sort(labels)
+		add(append(fields, labels...)...)
+	}
+
+	return fingerprint
+}
+
+// nextReservationExpiry returns the time at which the next unexpired reservation of the supplied NodeCIDRAllocations (including the
+// reservations of the supplied AddressPools they reference) expires. The zero time is returned when no reservation expires
+func nextReservationExpiry(nodeCIDRAllocations *v1alpha1.NodeCIDRAllocationList, addressPools *v1alpha1.AddressPoolList, now time.Time) time.Time {
+	reservations := []ipam.Reservation{}
+	for i := range nodeCIDRAllocations.Items {
+		resolved, _ := allocator.ResolveFrom(&nodeCIDRAllocations.Items[i], addressPools.Items)
+		reservations = append(reservations, resolved.Reservations()...)
+	}
+
+	return ipam.NextExpiry(reservations, now)
+}
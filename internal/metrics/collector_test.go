@@ -0,0 +1,151 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package metrics_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/metrics"
+)
+
+func TestCollector(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
+
+	nodeCIDRAllocation := v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"},
+		Spec:       v1alpha1.NodeCIDRAllocationSpec{AddressPools: []string{"10.0.0.0/24"}},
+	}
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "a"},
+		Spec:       corev1.NodeSpec{PodCIDR: "10.0.0.0/26"},
+	}
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(&nodeCIDRAllocation, &node).Build()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := metrics.NewCollector(c).WithClock(func() time.Time { return now })
+
+	// Case 1: The metrics are gathered from a registry
+	// expected: the capacity metrics are computed from the resources read on scrape
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("got %v, wanted %v", err, nil)
+	}
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 192 {
+		t.Errorf("got %.0f, wanted %d", got, 192)
+	}
+
+	// Case 2: None of the resources changed since the last scrape
+	// expected: the capacity metrics are not recomputed
+	metrics.AvailableHosts().Set(-10)
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != -10 {
+		t.Errorf("got %.0f, wanted %d", got, -10)
+	}
+
+	// Case 3: A Node changed since the last scrape
+	// expected: the capacity metrics are recomputed
+	node.Spec.PodCIDR = "10.0.0.0/25"
+	if err := c.Update(context.Background(), &node); err != nil {
+		t.Fatal(err)
+	}
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 128 {
+		t.Errorf("got %.0f, wanted %d", got, 128)
+	}
+
+	// Case 4: Only the status of a Node changed since the last scrape
+	// expected: the capacity metrics are not recomputed
+	metrics.AvailableHosts().Set(-10)
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	if err := c.Update(context.Background(), &node); err != nil {
+		t.Fatal(err)
+	}
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != -10 {
+		t.Errorf("got %.0f, wanted %d", got, -10)
+	}
+
+	// Case 5: The labels of a Node changed since the last scrape
+	// expected: the capacity metrics are recomputed
+	node.SetLabels(map[string]string{"pool": "a"})
+	if err := c.Update(context.Background(), &node); err != nil {
+		t.Fatal(err)
+	}
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 128 {
+		t.Errorf("got %.0f, wanted %d", got, 128)
+	}
+
+	// Case 6: A reservation expired since the last scrape
+	// expected: the capacity metrics are recomputed although none of the resources changed
+	// Note: the generation is bumped by the API server, not by the fake client
+	nodeCIDRAllocation.Spec.Reservations = []v1alpha1.AddressReservation{{
+		Name:      "temporary",
+		CIDR:      "10.0.0.128/26",
+		ExpiresAt: &metav1.Time{Time: now.Add(time.Minute)},
+	}}
+	nodeCIDRAllocation.SetGeneration(nodeCIDRAllocation.GetGeneration() + 1)
+	if err := c.Update(context.Background(), &nodeCIDRAllocation); err != nil {
+		t.Fatal(err)
+	}
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 64 {
+		t.Errorf("got %.0f, wanted %d", got, 64)
+	}
+
+	now = now.Add(time.Minute)
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 128 {
+		t.Errorf("got %.0f, wanted %d", got, 128)
+	}
+
+	// Case 7: A removed pool is retained and later released through the status of the NodeCIDRAllocation
+	// expected: the capacity metrics are recomputed, although the generation did not change
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(&nodeCIDRAllocation), &nodeCIDRAllocation); err != nil {
+		t.Fatal(err)
+	}
+	nodeCIDRAllocation.SetDraining([]v1alpha1.DrainingPool{{Pool: "10.1.0.0/24", Nodes: []string{"b"}, Removed: true}})
+	if err := c.Update(context.Background(), &nodeCIDRAllocation); err != nil {
+		t.Fatal(err)
+	}
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 384 {
+		t.Errorf("got %.0f, wanted %d", got, 384)
+	}
+
+	nodeCIDRAllocation.SetDraining(nil)
+	if err := c.Update(context.Background(), &nodeCIDRAllocation); err != nil {
+		t.Fatal(err)
+	}
+	collector.Refresh(context.Background())
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 128 {
+		t.Errorf("got %.0f, wanted %d", got, 128)
+	}
+}
@@ -17,15 +17,23 @@ WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
 package metrics
 
 import (
-	"net"
+	"math/big"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	corev1 "k8s.io/api/core/v1"
 
 	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
 	"statcan.gc.ca/cidr-allocator/internal/helper"
 	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+const (
+	// ReservationOwnerUnspecified is the owner label used for the reserved addresses of reservations that do not name an owner
+	ReservationOwnerUnspecified = "unspecified"
 )
 
 var (
@@ -45,18 +53,48 @@ var (
 		Name: "cnp_cidr_allocator_available_hosts_percent",
 		Help: "the ratio of host address space remaining compared to the total number of addresses available for all configured address pools across ALL NodeCIDRAllocation CRs",
 	})
+	metricsNodePodCIDRs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cnp_cidr_allocator_node_podcidrs",
+		Help: "the number of Node PodCIDRs in the cluster by audit classification (managed, foreign inside an address pool, foreign outside of all address pools or orphaned)",
+	}, []string{"classification"})
+	metricsPodCIDROverlaps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cnp_cidr_allocator_podcidr_overlaps",
+		Help: "set to 1 for every pair of overlapping ranges across all Node PodCIDRs and static allocations found by the last audit",
+	}, []string{"first", "first_cidr", "second", "second_cidr"})
+	metricsAddressPoolAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cnp_cidr_allocator_address_pool_addresses",
+		Help: "the total number of addresses in the CIDRs of each AddressPool, counted once no matter how many NodeCIDRAllocation CRs reference it",
+	}, []string{"address_pool"})
+	metricsAddressPoolAllocatedAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cnp_cidr_allocator_address_pool_allocated_addresses",
+		Help: "the number of addresses of each AddressPool that are allocated to Nodes",
+	}, []string{"address_pool"})
+	metricsReservedAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cnp_cidr_allocator_reserved_addresses",
+		Help: "the number of host addresses held by unexpired reservations across ALL NodeCIDRAllocation CRs by reservation owner",
+	}, []string{"owner"})
+	metricsPausedWaitingNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cnp_cidr_allocator_paused_waiting_nodes",
+		Help: "the number of Nodes waiting on a PodCIDR from each NodeCIDRAllocation CR whose allocations are paused",
+	}, []string{"namespace", "nodecidrallocation"})
 )
 
 // Get returns a list of all associated metrics collectors
 // Note: values returned from this function will need to be coerced into the appropriate types if attempting to make changes with resulting collectors
-// this is out-of-scope for this function, however. This function is designed to be used ONLY as an interface for supplying dynamic set of metrics
-// to the controllers metrics registry directly.
+// this is out-of-scope for this function, however. These collectors are exposed to the controllers metrics registry through a Collector
+// (see NewCollector), which refreshes the capacity metrics on each scrape.
 func Get() []prometheus.Collector {
 	return []prometheus.Collector{
 		metricsExpectedAllocations,
 		metricsActualAllocations,
 		metricsAvailableHosts,
 		metricsAvailableHostsPercent,
+		metricsNodePodCIDRs,
+		metricsPodCIDROverlaps,
+		metricsReservedAddresses,
+		metricsAddressPoolAddresses,
+		metricsAddressPoolAllocatedAddresses,
+		metricsPausedWaitingNodes,
 	}
 }
 
@@ -76,81 +114,181 @@ func AvailableHostsPercent() prometheus.Gauge {
 	return metricsAvailableHostsPercent
 }
 
+// NodePodCIDRs returns the gauge of Node PodCIDRs by audit classification
+func NodePodCIDRs() *prometheus.GaugeVec {
+	return metricsNodePodCIDRs
+}
+
+// UpdateNodePodCIDRs sets the number of Node PodCIDRs for each of the supplied audit classifications
+func UpdateNodePodCIDRs(counts map[string]int) {
+	for classification, count := range counts {
+		metricsNodePodCIDRs.WithLabelValues(classification).Set(float64(count))
+	}
+}
+
+// PodCIDROverlaps returns the gauge of overlapping pairs of ranges
+func PodCIDROverlaps() *prometheus.GaugeVec {
+	return metricsPodCIDROverlaps
+}
+
+// UpdatePodCIDROverlaps replaces the series of overlapping pairs of ranges with the supplied pairs. Each pair is labelled with first, first_cidr,
+// second and second_cidr
+func UpdatePodCIDROverlaps(overlaps []prometheus.Labels) {
+	metricsPodCIDROverlaps.Reset()
+	for _, labels := range overlaps {
+		metricsPodCIDROverlaps.With(labels).Set(1)
+	}
+}
+
+// ReservedAddresses returns the gauge of host addresses held by unexpired reservations by owner
+func ReservedAddresses() *prometheus.GaugeVec {
+	return metricsReservedAddresses
+}
+
+// AddressPoolAddresses returns the gauge of the total number of addresses of each AddressPool
+func AddressPoolAddresses() *prometheus.GaugeVec {
+	return metricsAddressPoolAddresses
+}
+
+// AddressPoolAllocatedAddresses returns the gauge of the number of allocated addresses of each AddressPool
+func AddressPoolAllocatedAddresses() *prometheus.GaugeVec {
+	return metricsAddressPoolAllocatedAddresses
+}
+
+// PausedWaitingNodes returns the gauge of the number of Nodes waiting on a PodCIDR from each paused NodeCIDRAllocation
+func PausedWaitingNodes() *prometheus.GaugeVec {
+	return metricsPausedWaitingNodes
+}
+
 // Update performs an update to ALL available metrics captured for the operator. These are not to be accessed or supplied via the `Get()` function,
-// but rather from the local package variables. Metrics will be exposed via `Get()` outside of the package
-func Update(nodeCIDRAllocations *v1alpha1.NodeCIDRAllocationList, allNodes *corev1.NodeList) {
-	var notAllocated uint64
-	nodeAllocationsCumulative := map[string]struct{}{}
-	overlappingStaticAllocationsCumulative := map[string]struct{}{}
-	addressPoolsCumulative := map[string]struct{}{} // like helper.ObjectContainsLabel(...), we are using a map-like DS to avoid duplicates
-	for _, n := range nodeCIDRAllocations.Items {
-		for _, p := range n.Spec.AddressPools {
-			addressPoolsCumulative[p] = struct{}{}
+// but rather from the local package variables. Metrics will be exposed via `Get()` outside of the package.
+// Addresses are accounted for as a union of address ranges, so that overlapping address pools, AddressPools referenced by several
+// NodeCIDRAllocations and reservations that only partly overlap an address pool are never counted more than once
+func Update(nodeCIDRAllocations *v1alpha1.NodeCIDRAllocationList, addressPools *v1alpha1.AddressPoolList, allNodes *corev1.NodeList) {
+	update(nodeCIDRAllocations, addressPools, allNodes, time.Now())
+}
+
+// update performs Update, expiring reservations against the supplied time
+func update(nodeCIDRAllocations *v1alpha1.NodeCIDRAllocationList, addressPools *v1alpha1.AddressPoolList, allNodes *corev1.NodeList, now time.Time) {
+	pools := &ipam.Set{}
+	reserved := &ipam.Set{}
+	reservedByOwner := map[string]*ipam.Set{}
+	for i := range nodeCIDRAllocations.Items {
+		n, _ := allocator.ResolveFrom(&nodeCIDRAllocations.Items[i], addressPools.Items)
+
+		pools = pools.Union(statcan_net.SetFromCIDRs(n.AddressPools()...))
+		reserved = reserved.Union(statcan_net.SetFromCIDRs(n.Spec.StaticAllocations...)).Union(ipam.Reserved(n.Reservations(), now))
+
+		for _, r := range n.UnexpiredReservations(now) {
+			owner := r.Owner
+			if owner == "" {
+				owner = ReservationOwnerUnspecified
+			}
+			if reservedByOwner[owner] == nil {
+				reservedByOwner[owner] = &ipam.Set{}
+			}
+			reservedByOwner[owner].AddPrefix(r.Prefix)
 		}
 	}
-	totalAvailableHosts := accumulatedHosts(helper.Keys(addressPoolsCumulative))
 
-	for _, n := range nodeCIDRAllocations.Items {
-		for _, p := range n.Spec.StaticAllocations {
-			for _, a := range n.Spec.AddressPools {
-				networksOverlap, _ := statcan_net.NetworksOverlap(p, a)
+	metricsAddressPoolAddresses.Reset()
+	metricsAddressPoolAllocatedAddresses.Reset()
+	for i := range addressPools.Items {
+		addressPool := &addressPools.Items[i]
+		addresses, allocated := allocator.AddressPoolCapacity(addressPool, allNodes)
+		metricsAddressPoolAddresses.WithLabelValues(addressPool.GetName()).Set(bigToFloat(addresses))
+		metricsAddressPoolAllocatedAddresses.WithLabelValues(addressPool.GetName()).Set(bigToFloat(allocated))
+	}
 
-				if networksOverlap {
-					overlappingStaticAllocationsCumulative[p] = struct{}{}
-				}
+	metricsReservedAddresses.Reset()
+	for owner, set := range reservedByOwner {
+		metricsReservedAddresses.WithLabelValues(owner).Set(bigToFloat(set.Size()))
+	}
+
+	metricsPausedWaitingNodes.Reset()
+	for i := range nodeCIDRAllocations.Items {
+		n := &nodeCIDRAllocations.Items[i]
+		if !n.Paused() {
+			continue
+		}
+
+		var waiting int
+		for j := range allNodes.Items {
+			if allNodes.Items[j].Spec.PodCIDR == "" && helper.ObjectContainsLabels(&allNodes.Items[j], n.Spec.NodeSelector) {
+				waiting++
 			}
 		}
+		metricsPausedWaitingNodes.WithLabelValues(n.GetNamespace(), n.GetName()).Set(float64(waiting))
 	}
-	totalOverlappingStaticAllocations := accumulatedHosts(helper.Keys(overlappingStaticAllocationsCumulative))
 
+	var notAllocated uint64
+	podCIDRs := []string{}
 	for _, n := range allNodes.Items {
 		if n.Spec.PodCIDR == "" {
 			notAllocated++
 			continue
 		}
-		nodeAllocationsCumulative[n.Spec.PodCIDR] = struct{}{}
+		podCIDRs = append(podCIDRs, n.Spec.PodCIDR)
 	}
-	totalAllocatedHosts := accumulatedHosts(helper.Keys(nodeAllocationsCumulative))
+	allocated := statcan_net.SetFromCIDRs(podCIDRs...)
 
 	metricsExpectedAllocations.Set(float64(len(allNodes.Items)))
 	metricsActualAllocations.Set(float64(len(allNodes.Items) - int(notAllocated)))
 
-	remainingCount, remainingPercent := calculateRemainingHosts(totalAvailableHosts, totalAllocatedHosts, totalOverlappingStaticAllocations)
+	remainingCount, remainingPercent := calculateRemainingHosts(pools, reserved, allocated)
 	metricsAvailableHosts.Set(remainingCount)
 	metricsAvailableHostsPercent.Set(remainingPercent)
 }
 
-// accumulatedHosts will calculate the total number of hosts accumulated for all networkCIDRs that are passed
-func accumulatedHosts(networkCIDRs []string) uint32 {
-	var totalSupportedHosts uint32
-	for _, n := range networkCIDRs {
-		_, ipNet, err := net.ParseCIDR(n)
-		if err != nil {
-			continue
-		}
-		networkOnes, _ := ipNet.Mask.Size()
-		networkSize, err := statcan_net.NumHostsForMask(uint8(networkOnes))
-		if err != nil {
-			continue
-		}
+// calculateRemainingHosts is a helper function to calculate remaining hosts given the address pools, the reserved addresses and the addresses
+// that are already allocated. Reserved and allocated addresses are only subtracted where they overlap the address pools.
+// this function returns both the total count of available hosts and a ratio (as a percent) of the usable (non-reserved) hosts left that are
+// allocable. The ratio is 0 when there are no usable hosts
+func calculateRemainingHosts(pools, reserved, allocated *ipam.Set) (float64, float64) {
+	usable := pools.Subtract(reserved)
+	remaining := usable.Subtract(allocated)
 
-		totalSupportedHosts += networkSize
+	if usable.IsEmpty() {
+		return 0, 0
 	}
 
-	return totalSupportedHosts
+	ratio := new(big.Float).Quo(new(big.Float).SetInt(remaining.Size()), new(big.Float).SetInt(usable.Size()))
+	percent, _ := ratio.Mul(ratio, big.NewFloat(100)).Float64()
+
+	return bigToFloat(remaining.Size()), percent
 }
 
-// calculateRemainingHosts is a helper function to calculate remaining hosts given total available hosts and the number of hosts that are already allocated.
-// this function returns both the total count of available hosts and a ratio (as a percent) of hosts left that are allocable.
-func calculateRemainingHosts(totalAvailableHosts, totalAllocatedHosts, totalOverlappingStaticAllocations uint32) (float64, float64) {
-	return float64(totalAvailableHosts - totalOverlappingStaticAllocations - totalAllocatedHosts), (1.0 - (float64(totalAllocatedHosts) / float64(totalAvailableHosts-totalOverlappingStaticAllocations))) * 100
+// bigToFloat returns the supplied number as the nearest float64, as used for metric values
+func bigToFloat(n *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return f
 }
 
 // GetMetricValue is a helper function from the metrics package to
 // pull metric values as floats from prometheus metrics collectors.
 //
-// Currently, it supports only Counter and Gauge metrics.
+// Currently, it supports only Counter and Gauge metrics. For a GaugeVec, the sum of the values of all of its series is returned.
 func GetMetricValue(col prometheus.Collector) float64 {
+	if vec, ok := col.(*prometheus.GaugeVec); ok {
+		c := make(chan prometheus.Metric)
+		go func() {
+			vec.Collect(c)
+			close(c)
+		}()
+
+		var sum float64
+		for metric := range c {
+			m := dto.Metric{}
+			if err := metric.Write(&m); err != nil || sum < 0 {
+				sum = -1 // keep draining so that Collect can return
+				continue
+			}
+			sum += m.GetGauge().GetValue()
+		}
+
+		return sum
+	}
+
 	c := make(chan prometheus.Metric, 1)
 	col.Collect(c)
 	m := dto.Metric{}
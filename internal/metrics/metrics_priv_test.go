@@ -16,47 +16,80 @@ WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
 
 package metrics
 
-import "testing"
+import (
+	"testing"
 
-func TestAccumulatedHosts(t *testing.T) {
-	cidrs := []string{"10.0.0.0/26", "10.0.0.64/27"}
-
-	// Case 1: Valid CIDRs are provided and specify /26 and /27 as CIDR block size
-	// expected: 64 + 32 = 96
-	var got = accumulatedHosts(cidrs)
-	var want uint32 = 96
-
-	if got != want {
-		t.Errorf("got %d, wanted %d", got, want)
-	}
-
-	// Case 2: There is an invalid CIDR among the specified network CIDRs provided
-	// expected: should calculate all addresses and ignore invalid CIDRs
-	cidrs = append(cidrs, "10.0.0.128/36")
-	got = accumulatedHosts(cidrs)
-	want = 96
-
-	if got != want {
-		t.Errorf("got %d, wanted %d", got, want)
-	}
-
-	// Case 3: No CIDRs are passed
-	// expected: should return 0 accumulated addresses
-	got = accumulatedHosts([]string{})
-	want = 0
-
-	if got != want {
-		t.Errorf("got %d, wanted %d", got, want)
-	}
-}
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
 
 func TestCalculateRemainingHosts(t *testing.T) {
-	// Case 1: 64 available hosts and 16 allocated hosts
-	// expected: should return (48, 75) where 48 is the number of remaining addresses and 50 is the percentage representation of remaining addresses
-	got, gotP := calculateRemainingHosts(64, 24, 16)
-	var want, wantP float64 = 24, 50
+	cases := []struct {
+		name        string
+		pools       []string
+		reserved    []string
+		allocated   []string
+		wantCount   float64
+		wantPercent float64
+	}{
+		{
+			name:        "no address pools",
+			allocated:   []string{"10.0.0.0/26"},
+			wantCount:   0,
+			wantPercent: 0,
+		},
+		{
+			name:        "64 available hosts with 16 reserved and 24 allocated",
+			pools:       []string{"10.0.0.0/26"},
+			reserved:    []string{"10.0.0.48/28"},
+			allocated:   []string{"10.0.0.0/28", "10.0.0.16/29"},
+			wantCount:   24,
+			wantPercent: 50,
+		},
+		{
+			name:        "overlapping address pools are counted once",
+			pools:       []string{"10.0.0.0/24", "10.0.0.0/25", "10.0.0.128/26"},
+			allocated:   []string{"10.0.0.0/26"},
+			wantCount:   192,
+			wantPercent: 75,
+		},
+		{
+			name:        "a reservation that only partly overlaps the address pools",
+			pools:       []string{"10.0.0.0/25"},
+			reserved:    []string{"10.0.0.64/26", "10.0.0.128/26"},
+			wantCount:   64,
+			wantPercent: 100,
+		},
+		{
+			name:        "allocations outside of the address pools are not subtracted",
+			pools:       []string{"10.0.0.0/26"},
+			allocated:   []string{"10.0.0.0/27", "192.168.0.0/24"},
+			wantCount:   32,
+			wantPercent: 50,
+		},
+		{
+			name:        "address pools that are entirely reserved",
+			pools:       []string{"10.0.0.0/26"},
+			reserved:    []string{"10.0.0.0/24"},
+			wantCount:   0,
+			wantPercent: 0,
+		},
+		{
+			name:        "a pool larger than 32 bits worth of addresses",
+			pools:       []string{"0.0.0.0/0", "fd00::/64"},
+			allocated:   []string{"fd00::/65"},
+			wantCount:   4294967296 + 9223372036854775808,
+			wantPercent: (4294967296 + 9223372036854775808) / (4294967296 + 18446744073709551616.0) * 100,
+		},
+	}
 
-	if got != want || gotP != wantP {
-		t.Errorf("got (%.0f, %.0f), wanted (%.0f, %.0f)", got, gotP, want, wantP)
+	for i, c := range cases {
+		gotCount, gotPercent := calculateRemainingHosts(
+			statcan_net.SetFromCIDRs(c.pools...),
+			statcan_net.SetFromCIDRs(c.reserved...),
+			statcan_net.SetFromCIDRs(c.allocated...),
+		)
+		if gotCount != c.wantCount || gotPercent != c.wantPercent {
+			t.Errorf("case %d (%s): got (%.0f, %.2f), wanted (%.0f, %.2f)", i+1, c.name, gotCount, gotPercent, c.wantCount, c.wantPercent)
+		}
 	}
 }
@@ -17,7 +17,9 @@ WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
 package metrics_test
 
 import (
+	"math"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
@@ -102,7 +104,7 @@ func TestUpdate(t *testing.T) {
 	// Case 1: Address pools of 10.0.0.0/24 for 4 nodes each occupying a /26 (64 IPs)
 	// expected: should result in 0 available hosts and expected allocations equal to actual allocations.
 	// This result is given by: 2^8 - 4(2^(32-26)) = 0
-	metrics.Update(allocations, nodes)
+	metrics.Update(allocations, &v1alpha1.AddressPoolList{}, nodes)
 
 	for _, c := range metrics.Get() {
 		if metrics.GetMetricValue(c) == -1 {
@@ -127,10 +129,11 @@ func TestUpdate(t *testing.T) {
 
 	// Case 2: Address pools of 10.0.0.0/24 for 4 nodes where 1 does not have a PodCIDR set & there is staticAllocations
 	// expected: should result in expected allocations not equal to actual allocations
-	// and the difference being 1. Additionally, the available hosts should be 64 and as a percent should be 25.
+	// and the difference being 1. The static allocations inside of the address pool (16 addresses) overlap the PodCIDR of testNodeD and are only
+	// counted once, so the available hosts should be 256 - 16 - 176 = 64 and as a percent of the 240 usable hosts should be 26.67.
 	allocations.Items[0].Spec.StaticAllocations = []string{"10.0.0.252/30", "10.0.0.248/30", "10.0.0.244/30", "10.0.0.240/30", "10.0.1.238/30"}
 	nodes.Items[0].Spec.PodCIDR = ""
-	metrics.Update(allocations, nodes)
+	metrics.Update(allocations, &v1alpha1.AddressPoolList{}, nodes)
 
 	actualVal = metrics.GetMetricValue(metrics.ActualAllocations())
 	expectedVal = metrics.GetMetricValue(metrics.ExpectedAllocations())
@@ -145,15 +148,15 @@ func TestUpdate(t *testing.T) {
 
 	actualVal = metrics.GetMetricValue(metrics.AvailableHosts())
 	actualValPercent = metrics.GetMetricValue(metrics.AvailableHostsPercent())
-	expectedVal = 48
-	expectedValPercent := float64(19.999999999999996)
+	expectedVal = 64
+	expectedValPercent := 64.0 / 240.0 * 100
 
 	if expectedVal != actualVal {
 		t.Errorf("got %.0f, wanted %.0f", actualVal, expectedVal)
 	}
 
-	if actualValPercent != expectedValPercent {
-		t.Errorf("got %.0f, wanted %.0f", actualValPercent, expectedValPercent)
+	if math.Abs(actualValPercent-expectedValPercent) > 1e-9 {
+		t.Errorf("got %.2f, wanted %.2f", actualValPercent, expectedValPercent)
 	}
 }
 
@@ -197,4 +200,171 @@ func TestGetMetricValue(t *testing.T) {
 	if got != want {
 		t.Errorf("got %.0f, wanted %.0f", got, want)
 	}
+
+	// Case 4: Metric is a GaugeVec
+	// expected: the sum of the values of every series (0 when there are no series)
+	m4 := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "test_gauge_vec_a",
+	}, []string{"label"})
+	got = metrics.GetMetricValue(m4)
+	want = 0
+
+	if got != want {
+		t.Errorf("got %.0f, wanted %.0f", got, want)
+	}
+
+	m4.WithLabelValues("a").Set(2)
+	m4.WithLabelValues("b").Set(3)
+	got = metrics.GetMetricValue(m4)
+	want = 5
+
+	if got != want {
+		t.Errorf("got %.0f, wanted %.0f", got, want)
+	}
+}
+
+func TestUpdateNodePodCIDRs(t *testing.T) {
+	// Case 1: Counts are supplied for several classifications
+	// expected: the gauge for each classification is set to its count
+	metrics.UpdateNodePodCIDRs(map[string]int{"managed": 3, "orphaned": 1})
+
+	for classification, want := range map[string]float64{"managed": 3, "orphaned": 1} {
+		got := metrics.GetMetricValue(metrics.NodePodCIDRs().WithLabelValues(classification))
+		if got != want {
+			t.Errorf("got %.0f, wanted %.0f", got, want)
+		}
+	}
+
+	// Case 2: A classification that was previously counted drops to zero
+	// expected: the gauge for that classification is reset to 0
+	metrics.UpdateNodePodCIDRs(map[string]int{"managed": 4, "orphaned": 0})
+
+	got := metrics.GetMetricValue(metrics.NodePodCIDRs().WithLabelValues("orphaned"))
+	var want float64 = 0
+	if got != want {
+		t.Errorf("got %.0f, wanted %.0f", got, want)
+	}
+}
+
+func TestUpdatePodCIDROverlaps(t *testing.T) {
+	// Case 1: Two overlapping pairs are supplied
+	// expected: a series is set to 1 for each pair
+	metrics.UpdatePodCIDROverlaps([]prometheus.Labels{
+		{"first": "Node/a", "first_cidr": "10.0.0.0/24", "second": "Node/b", "second_cidr": "10.0.0.0/25"},
+		{"first": "Node/a", "first_cidr": "10.0.0.0/24", "second": "Node/c", "second_cidr": "10.0.0.128/25"},
+	})
+	got := metrics.GetMetricValue(metrics.PodCIDROverlaps())
+	var want float64 = 2
+
+	if got != want {
+		t.Errorf("got %.0f, wanted %.0f", got, want)
+	}
+
+	// Case 2: The overlaps are resolved
+	// expected: the series of the previous pairs are removed
+	metrics.UpdatePodCIDROverlaps(nil)
+	got = metrics.GetMetricValue(metrics.PodCIDROverlaps())
+	want = 0
+
+	if got != want {
+		t.Errorf("got %.0f, wanted %.0f", got, want)
+	}
+}
+
+func TestUpdateReservedAddresses(t *testing.T) {
+	expired := metav1.NewTime(time.Now().Add(-time.Hour))
+	allocations := &v1alpha1.NodeCIDRAllocationList{
+		Items: []v1alpha1.NodeCIDRAllocation{
+			{
+				Spec: v1alpha1.NodeCIDRAllocationSpec{
+					AddressPools: []string{"10.0.0.0/16"},
+					Reservations: []v1alpha1.AddressReservation{
+						{Name: "a", CIDR: "10.0.0.0/24", Owner: "network-team"},
+						{Name: "b", CIDR: "10.0.1.0/24", Owner: "network-team"},
+						{Name: "c", CIDR: "10.0.2.0/24"},
+						{Name: "d", CIDR: "10.0.3.0/24", Owner: "storage-team", ExpiresAt: &expired},
+					},
+				},
+			},
+		},
+	}
+	// Case 1: Reservations with and without an owner, one of which has expired
+	// expected: the addresses of the unexpired reservations are counted by owner and the expired reservation is not counted
+	metrics.Update(allocations, &v1alpha1.AddressPoolList{}, &corev1.NodeList{})
+	for owner, want := range map[string]float64{"network-team": 512, metrics.ReservationOwnerUnspecified: 256} {
+		if got := metrics.GetMetricValue(metrics.ReservedAddresses().WithLabelValues(owner)); got != want {
+			t.Errorf("got %.0f, wanted %.0f", got, want)
+		}
+	}
+
+	// Case 2: The owners of the previous update are gone
+	// expected: the series of the previous owners are removed
+	metrics.Update(&v1alpha1.NodeCIDRAllocationList{}, &v1alpha1.AddressPoolList{}, &corev1.NodeList{})
+	if got := metrics.GetMetricValue(metrics.ReservedAddresses()); got != 0 {
+		t.Errorf("got %.0f, wanted %.0f", got, 0.0)
+	}
+}
+
+func TestUpdateAddressPools(t *testing.T) {
+	addressPools := &v1alpha1.AddressPoolList{Items: []v1alpha1.AddressPool{
+		{ObjectMeta: metav1.ObjectMeta{Name: "shared"}, Spec: v1alpha1.AddressPoolSpec{CIDRs: []string{"10.0.0.0/24"}}},
+	}}
+	allocations := &v1alpha1.NodeCIDRAllocationList{Items: []v1alpha1.NodeCIDRAllocation{
+		{Spec: v1alpha1.NodeCIDRAllocationSpec{AddressPoolRefs: []string{"shared"}}},
+		{Spec: v1alpha1.NodeCIDRAllocationSpec{AddressPoolRefs: []string{"shared"}}},
+	}}
+	nodes := &corev1.NodeList{Items: []corev1.Node{
+		{Spec: corev1.NodeSpec{PodCIDR: "10.0.0.0/26"}},
+	}}
+
+	// Case 1: Two NodeCIDRAllocations reference the same AddressPool
+	// expected: the capacity of the AddressPool is only counted once
+	metrics.Update(allocations, addressPools, nodes)
+	if got := metrics.GetMetricValue(metrics.AddressPoolAddresses().WithLabelValues("shared")); got != 256 {
+		t.Errorf("got %.0f, wanted %.0f", got, 256.0)
+	}
+	if got := metrics.GetMetricValue(metrics.AddressPoolAllocatedAddresses().WithLabelValues("shared")); got != 64 {
+		t.Errorf("got %.0f, wanted %.0f", got, 64.0)
+	}
+	if got := metrics.GetMetricValue(metrics.AvailableHosts()); got != 192 {
+		t.Errorf("got %.0f, wanted %.0f", got, 192.0)
+	}
+}
+
+func TestUpdatePausedWaitingNodes(t *testing.T) {
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+	allocations := &v1alpha1.NodeCIDRAllocationList{Items: []v1alpha1.NodeCIDRAllocation{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "paused", Namespace: "default"},
+			Spec:       v1alpha1.NodeCIDRAllocationSpec{AddressPools: []string{"10.0.0.0/24"}, NodeSelector: agent, Paused: true},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "active", Namespace: "default"},
+			Spec:       v1alpha1.NodeCIDRAllocationSpec{AddressPools: []string{"10.1.0.0/24"}, NodeSelector: agent},
+		},
+	}}
+	nodes := &corev1.NodeList{Items: []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: agent}, Spec: corev1.NodeSpec{PodCIDR: "10.0.0.0/26"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Labels: agent}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c", Labels: agent}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "d"}},
+	}}
+
+	// Case 1: One of two NodeCIDRAllocations selecting the same Nodes is paused
+	// expected: only the selected Nodes without a PodCIDR are counted, and only for the paused NodeCIDRAllocation
+	metrics.Update(allocations, &v1alpha1.AddressPoolList{}, nodes)
+	if got := metrics.GetMetricValue(metrics.PausedWaitingNodes().WithLabelValues("default", "paused")); got != 2 {
+		t.Errorf("got %.0f, wanted %.0f", got, 2.0)
+	}
+	if got := metrics.GetMetricValue(metrics.PausedWaitingNodes()); got != 2 {
+		t.Errorf("got %.0f, wanted %.0f", got, 2.0)
+	}
+
+	// Case 2: Allocations are resumed
+	// expected: the series of the previously paused NodeCIDRAllocation is removed
+	allocations.Items[0].Spec.Paused = false
+	metrics.Update(allocations, &v1alpha1.AddressPoolList{}, nodes)
+	if got := metrics.GetMetricValue(metrics.PausedWaitingNodes()); got != 0 {
+		t.Errorf("got %.0f, wanted %.0f", got, 0.0)
+	}
 }
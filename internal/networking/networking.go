@@ -19,10 +19,13 @@ package networking
 import (
 	"fmt"
 	"math"
-	"net"
+	"math/big"
+	"net/netip"
+	"sort"
 
-	"github.com/c-robinson/iplib"
 	corev1 "k8s.io/api/core/v1"
+
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
 )
 
 const (
@@ -58,72 +61,179 @@ func NumUsableHostsForMask(ones uint8) (uint32, error) {
 
 // SubnetsFromPool breaks down the supplied pool into all possible subnets of the supplied size (given by ones)
 func SubnetsFromPool(pool string, ones uint8) ([]string, error) {
-	_, poolNet, err := net.ParseCIDR(pool)
+	p, err := ipam.ParsePool(pool)
 	if err != nil {
 		return []string{}, err
 	}
-	poolNetOnes, _ := poolNet.Mask.Size()
-	numSubnets := uint32(math.Pow(2, float64(ones-uint8(poolNetOnes))))
 
-	subnets := make([]string, numSubnets)
-	for i := uint32(0); i < numSubnets; i++ {
-		netSize, err := NumHostsForMask(ones)
-		if err != nil {
-			return []string{}, err
-		}
-		offset := i * netSize
-
-		nextSub := iplib.IncrementIP4By(poolNet.IP, offset)
-		subnets[i] = fmt.Sprintf("%s/%d", nextSub, ones)
+	subnets, err := p.SubnetList(int(ones))
+	if err != nil {
+		return []string{}, err
 	}
 
-	return subnets, nil
+	return prefixStrings(subnets), nil
 }
 
 // NetworksOverlap determines whether the supplied networks (in CIDR format)
 // are overlapping or otherwise have an intersection between them
 func NetworksOverlap(a, b string) (bool, error) {
-	_, aNet, err := iplib.ParseCIDR(a)
+	aPool, err := ipam.ParsePool(a)
 	if err != nil {
 		return false, err
 	}
-	_, bNet, err := iplib.ParseCIDR(b)
+	bPrefix, err := netip.ParsePrefix(b)
 	if err != nil {
 		return false, err
 	}
 
-	return a == b || aNet.Contains(bNet.IP()) || bNet.Contains(aNet.IP()), nil
+	return aPool.Overlaps(bPrefix), nil
 }
 
 // NetworkAllocated uses a variety of conditions to ensure that there is no
 // conflicting allocation that would present problems for subnet.
 // returns (true,nil) when the subnet provided is not allocated by or overlapping with any nodes.
+// Every range in .Spec.PodCIDRs is considered along with .Spec.PodCIDR, so ranges assigned to Nodes by something other than
+// the CIDR-Allocator are avoided as well
 func NetworkAllocated(subnet string, nodes *corev1.NodeList, reservedSubnets []string) (bool, error) {
-	for _, n := range nodes.Items {
-		if n.Spec.PodCIDR == "" {
-			continue
-		}
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return false, err
+	}
+
+	used, err := UsedAddresses(nodes, reservedSubnets)
+	if err != nil {
+		return false, err
+	}
 
-		networksOverlap, err := NetworksOverlap(subnet, n.Spec.PodCIDR)
+	return used.Overlaps(prefix), nil
+}
+
+// UsedAddresses returns the set of addresses that are in use by the supplied Nodes (every range in .Spec.PodCIDR and .Spec.PodCIDRs)
+// or held by the reserved subnets. An error is returned when any of the ranges is invalid
+func UsedAddresses(nodes *corev1.NodeList, reservedSubnets []string) (*ipam.Set, error) {
+	used := &ipam.Set{}
+	add := func(cidr string) error {
+		prefix, err := netip.ParsePrefix(cidr)
 		if err != nil {
-			return false, err
+			return err
 		}
 
-		if networksOverlap {
-			return true, nil
+		used.AddPrefix(prefix)
+		return nil
+	}
+
+	for _, n := range nodes.Items {
+		for _, podCIDR := range append([]string{n.Spec.PodCIDR}, n.Spec.PodCIDRs...) {
+			if podCIDR == "" {
+				continue
+			}
+			if err := add(podCIDR); err != nil {
+				return &ipam.Set{}, err
+			}
 		}
 	}
 
 	for _, s := range reservedSubnets {
-		networksOverlap, err := NetworksOverlap(subnet, s)
+		if err := add(s); err != nil {
+			return &ipam.Set{}, err
+		}
+	}
+
+	return used, nil
+}
+
+// SetFromCIDRs returns the union of the supplied networks (in CIDR format). Invalid networks are ignored
+func SetFromCIDRs(cidrs ...string) *ipam.Set {
+	set := &ipam.Set{}
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			set.AddPrefix(prefix)
+		}
+	}
+
+	return set
+}
+
+// SaturatedInt64 returns the supplied number as an int64, or math.MaxInt64 when it does not fit (as can be the case for IPv6 address
+// counts). Negative numbers are returned as 0
+func SaturatedInt64(n *big.Int) int64 {
+	switch {
+	case n.Sign() < 0:
+		return 0
+	case !n.IsInt64():
+		return math.MaxInt64
+	}
+
+	return n.Int64()
+}
+
+// NetworkContains determines whether the supplied inner network (in CIDR format) is
+// entirely contained within the supplied outer network (in CIDR format)
+func NetworkContains(outer, inner string) (bool, error) {
+	outerPool, err := ipam.ParsePool(outer)
+	if err != nil {
+		return false, err
+	}
+	innerPrefix, err := netip.ParsePrefix(inner)
+	if err != nil {
+		return false, err
+	}
+
+	return outerPool.Contains(innerPrefix), nil
+}
+
+// AggregateNetworks summarizes the supplied networks (in CIDR format) into the smallest set of
+// aggregate prefixes that covers exactly the same address space. Networks that are contained by
+// another network are absorbed and adjacent sibling networks are merged into their parent prefix.
+// The resulting prefixes are returned in ascending address order.
+func AggregateNetworks(networks []string) ([]string, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for _, n := range networks {
+		p, err := netip.ParsePrefix(n)
 		if err != nil {
-			return false, err
+			return []string{}, err
 		}
+		prefixes = append(prefixes, p.Masked())
+	}
 
-		if networksOverlap {
-			return true, nil
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
 		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+
+	aggregated := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if len(aggregated) > 0 && aggregated[len(aggregated)-1].Overlaps(p) {
+			// prefixes are sorted by address then size, so an overlapping prefix is always contained by the previous one
+			continue
+		}
+		aggregated = append(aggregated, p)
+
+		// merge sibling prefixes into their parent for as long as possible
+		for len(aggregated) > 1 {
+			a, b := aggregated[len(aggregated)-2], aggregated[len(aggregated)-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() {
+				break
+			}
+			parent, err := a.Addr().Prefix(a.Bits() - 1)
+			if err != nil || parent.Addr() != a.Addr() || !parent.Contains(b.Addr()) {
+				break
+			}
+			aggregated = append(aggregated[:len(aggregated)-2], parent)
+		}
+	}
+
+	return prefixStrings(aggregated), nil
+}
+
+// prefixStrings returns the supplied prefixes in CIDR format
+func prefixStrings(prefixes []netip.Prefix) []string {
+	result := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		result[i] = p.String()
 	}
 
-	return false, nil
+	return result
 }
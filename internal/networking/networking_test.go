@@ -341,4 +341,146 @@ func TestNetworkAllocated(t *testing.T) {
 	} else if got != want {
 		t.Errorf("got %t, wanted %t", got, want)
 	}
+
+	// Case 8: Provided subnet intersects with a (foreign) range listed only in .Spec.PodCIDRs of a dual-stack Node
+	// expected: true
+	got, err = networking.NetworkAllocated(subnets[0], &corev1.NodeList{
+		Items: []corev1.Node{
+			{
+				Spec: corev1.NodeSpec{
+					PodCIDR:  "fd00::/64",
+					PodCIDRs: []string{"fd00::/64", "10.0.0.0/28"},
+				},
+			},
+		},
+	}, []string{})
+	want = true
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if got != want {
+		t.Errorf("got %t, wanted %t", got, want)
+	}
+
+	// Case 9: Provided subnet does not intersect with any range in .Spec.PodCIDR or .Spec.PodCIDRs
+	// expected: false
+	got, err = networking.NetworkAllocated(subnets[0], &corev1.NodeList{
+		Items: []corev1.Node{
+			{
+				Spec: corev1.NodeSpec{
+					PodCIDR:  "10.0.1.0/28",
+					PodCIDRs: []string{"10.0.1.0/28", "fd00::/64"},
+				},
+			},
+		},
+	}, []string{})
+	want = false
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if got != want {
+		t.Errorf("got %t, wanted %t", got, want)
+	}
+}
+
+func TestNetworkContains(t *testing.T) {
+	// Case 1: Outer network is invalid
+	// expected: should produce an error
+	_, err := networking.NetworkContains("10.0.0/24", "10.0.0.0/26")
+	if err == nil {
+		t.Error("function was expected to return with an error")
+	}
+
+	// Case 2: Inner network is invalid
+	// expected: should produce an error
+	_, err = networking.NetworkContains("10.0.0.0/24", "10.0.0.0/33")
+	if err == nil {
+		t.Error("function was expected to return with an error")
+	}
+
+	// Case 3: Inner network is a subnet of the outer network
+	// expected: true
+	got, err := networking.NetworkContains("10.0.0.0/24", "10.0.0.64/26")
+	want := true
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if got != want {
+		t.Errorf("got %t, wanted %t", got, want)
+	}
+
+	// Case 4: Inner network is a superset of the outer network
+	// expected: false
+	got, err = networking.NetworkContains("10.0.0.64/26", "10.0.0.0/24")
+	want = false
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if got != want {
+		t.Errorf("got %t, wanted %t", got, want)
+	}
+
+	// Case 5: Networks do not overlap
+	// expected: false
+	got, err = networking.NetworkContains("10.0.1.0/24", "10.0.0.64/26")
+	want = false
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if got != want {
+		t.Errorf("got %t, wanted %t", got, want)
+	}
+}
+
+func TestAggregateNetworks(t *testing.T) {
+	// Case 1: One of the provided networks is invalid
+	// expected: should produce an error
+	_, err := networking.AggregateNetworks([]string{"10.0.0.0/26", "10.0.0/26"})
+	if err == nil {
+		t.Error("function was expected to return with an error")
+	}
+
+	// Case 2: No networks are provided
+	// expected: should produce an empty list of prefixes
+	got, err := networking.AggregateNetworks([]string{})
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if len(got) != 0 {
+		t.Errorf("got [%s], wanted []string{}", strings.Join(got, ","))
+	}
+
+	// Case 3: All four /26 subnets of a /24 are provided (out of order)
+	// expected: should be summarized into the single /24
+	got, err = networking.AggregateNetworks([]string{"10.0.0.128/26", "10.0.0.0/26", "10.0.0.192/26", "10.0.0.64/26"})
+	want := []string{"10.0.0.0/24"}
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got [%s], wanted [%s]", strings.Join(got, ","), strings.Join(want, ","))
+	}
+
+	// Case 4: Adjacent networks that are not siblings (they do not share a parent prefix)
+	// expected: should not be merged since the parent prefix would cover unallocated address space
+	got, err = networking.AggregateNetworks([]string{"10.0.0.64/26", "10.0.0.128/26"})
+	want = []string{"10.0.0.64/26", "10.0.0.128/26"}
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got [%s], wanted [%s]", strings.Join(got, ","), strings.Join(want, ","))
+	}
+
+	// Case 5: Networks of mixed sizes where some are contained by others and some can be merged in cascade
+	// expected: 10.0.0.0/26 + 10.0.0.64/27 + 10.0.0.96/27 => 10.0.0.0/25, and the duplicate/contained networks are absorbed
+	got, err = networking.AggregateNetworks([]string{"10.0.0.96/27", "10.0.0.0/26", "10.0.0.16/28", "10.0.0.64/27", "10.0.0.0/26", "10.0.1.0/28"})
+	want = []string{"10.0.0.0/25", "10.0.1.0/28"}
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got [%s], wanted [%s]", strings.Join(got, ","), strings.Join(want, ","))
+	}
+
+	// Case 6: Networks are provided with host bits set
+	// expected: should be normalized to their network address before being summarized
+	got, err = networking.AggregateNetworks([]string{"10.0.0.5/25", "10.0.0.200/25"})
+	want = []string{"10.0.0.0/24"}
+	if err != nil {
+		t.Errorf("function was not expected to error. got %e", err)
+	} else if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got [%s], wanted [%s]", strings.Join(got, ","), strings.Join(want, ","))
+	}
 }
@@ -0,0 +1,188 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+)
+
+const (
+	// NodeValidatingWebhookPath is the path that the Node capacity guard validating webhook is served on
+	NodeValidatingWebhookPath = "/validate-v1-node"
+)
+
+//+kubebuilder:webhook:path=/validate-v1-node,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=nodes,verbs=create,versions=v1,name=vnode.networking.statcan.gc.ca,admissionReviewVersions=v1
+
+// NodeCapacityValidator rejects the creation of Nodes that are selected by a NodeCIDRAllocation which no longer has a free subnet large enough
+// for the Node, so that pool exhaustion is surfaced to whatever is provisioning the Node instead of leaving a Node without a PodCIDR.
+// The check is made against the Nodes and reservations that exist when the request is admitted. It does not reserve the subnet it finds,
+// so Nodes created at the same time may still compete for the last free subnet
+type NodeCapacityValidator struct {
+	// Client is used to read NodeCIDRAllocation resources
+	Client client.Reader
+
+	// APIReader is used to read Nodes directly from the API server (bypassing the cache)
+	APIReader client.Reader
+
+	// Ledger is used to read the PodCIDRs that are reserved for Nodes which do not exist yet. Optional
+	Ledger *ledger.Ledger
+
+	decoder *admission.Decoder
+}
+
+// SetupNodeCapacityWebhookWithManager registers the Node capacity guard validating webhook with the webhook server of the Manager
+func SetupNodeCapacityWebhookWithManager(mgr ctrl.Manager, l *ledger.Ledger) {
+	mgr.GetWebhookServer().Register(NodeValidatingWebhookPath, &webhook.Admission{
+		Handler: NewNodeCapacityValidator(mgr.GetClient(), mgr.GetAPIReader(), l, admission.NewDecoder(mgr.GetScheme())),
+	})
+}
+
+// NewNodeCapacityValidator creates a new NodeCapacityValidator which decodes admission requests using the supplied decoder
+func NewNodeCapacityValidator(c client.Reader, apiReader client.Reader, l *ledger.Ledger, decoder *admission.Decoder) *NodeCapacityValidator {
+	return &NodeCapacityValidator{
+		Client:    c,
+		APIReader: apiReader,
+		Ledger:    l,
+		decoder:   decoder,
+	}
+}
+
+// Handle denies the Node in the admission request when the NodeCIDRAllocation that selects it cannot fit the Node's required subnet size
+func (v *NodeCapacityValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	rl := log.FromContext(ctx)
+
+	node := corev1.Node{}
+	if err := v.decoder.Decode(req, &node); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if node.Spec.PodCIDR != "" {
+		return admission.Allowed("node already contains CIDR allocation")
+	}
+
+	nodeCIDRAllocation, err := matchingNodeCIDRAllocation(ctx, v.Client, &node)
+	if errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		// the missing AddressPool is reported against the NodeCIDRAllocation by the controller
+		return admission.Allowed("the NodeCIDRAllocation references an AddressPool that does not exist").WithWarnings(err.Error())
+	}
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if nodeCIDRAllocation == nil {
+		return admission.Allowed("no NodeCIDRAllocation selects the node")
+	}
+	if allocator.NewPins(nodeCIDRAllocation, time.Now()).For(&node) != "" {
+		return admission.Allowed("node is pinned to a PodCIDR")
+	}
+
+	if node.Status.Allocatable.Pods().Value() == 0 {
+		return admission.Allowed("node does not report allocatable pods. required subnet size cannot be determined")
+	}
+	request, err := allocator.RequestFor(nodeCIDRAllocation, &node)
+	if err != nil {
+		rl.Info("rejecting node. its PodCIDR request cannot be honored",
+			"name", node.GetName(),
+			"nodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"namespace", nodeCIDRAllocation.GetNamespace(),
+			"reason", err.Error(),
+		)
+
+		return admission.Denied(fmt.Sprintf(
+			"NodeCIDRAllocation %s/%s cannot honor the PodCIDR request of Node %s: %s",
+			nodeCIDRAllocation.GetNamespace(),
+			nodeCIDRAllocation.GetName(),
+			node.GetName(),
+			err,
+		))
+	}
+	requiredCIDRMask := request.Ones
+
+	allClusterNodes := corev1.NodeList{}
+	if err := v.APIReader.List(ctx, &allClusterNodes); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	// PodCIDRs pinned by any NodeCIDRAllocation (and not only the matching one) are never allocated dynamically
+	pinnedSubnets, err := allocator.PinnedSubnets(ctx, v.Client, time.Now())
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	reservedSubnets := append(append([]string{}, nodeCIDRAllocation.ReservedSubnets(time.Now())...), pinnedSubnets...)
+	if v.Ledger != nil {
+		reservations, err := v.Ledger.Reservations(ctx)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+
+		for name, reservation := range reservations {
+			if name != node.GetName() {
+				reservedSubnets = append(reservedSubnets, reservation.CIDR)
+			}
+		}
+	}
+
+	free, err := request.Free(&allClusterNodes, reservedSubnets)
+	if err != nil {
+		if errors.Is(err, allocator.ErrRequestCollides) {
+			return admission.Denied(fmt.Sprintf(
+				"NodeCIDRAllocation %s/%s cannot honor the PodCIDR request of Node %s: %s",
+				nodeCIDRAllocation.GetNamespace(),
+				nodeCIDRAllocation.GetName(),
+				node.GetName(),
+				err,
+			))
+		}
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if len(free) == 0 {
+		rl.Info("rejecting node. no sufficient address space capacity for Node",
+			"name", node.GetName(),
+			"nodeCIDRAllocation", nodeCIDRAllocation.GetName(),
+			"namespace", nodeCIDRAllocation.GetNamespace(),
+			"requiredSubnetCIDR", requiredCIDRMask,
+		)
+
+		return admission.Denied(fmt.Sprintf(
+			"NodeCIDRAllocation %s/%s has no available /%d subnet for Node %s in address pools [%s]",
+			nodeCIDRAllocation.GetNamespace(),
+			nodeCIDRAllocation.GetName(),
+			requiredCIDRMask,
+			node.GetName(),
+			strings.Join(request.Pools, ", "),
+		))
+	}
+
+	return admission.Allowed(fmt.Sprintf("%d subnets of size /%d are available", len(free), requiredCIDRMask))
+}
@@ -0,0 +1,114 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package webhook_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+	"statcan.gc.ca/cidr-allocator/internal/webhook"
+)
+
+func TestHandleCapacity(t *testing.T) {
+	ctx := context.Background()
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+
+	c := newTestClient(
+		&v1alpha1.NodeCIDRAllocation{
+			ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+			Spec: v1alpha1.NodeCIDRAllocationSpec{
+				AddressPools:      []string{"10.0.0.0/24"},
+				StaticAllocations: []string{"10.0.0.0/26"},
+				NodeSelector:      agent,
+			},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "testNodeExisting"},
+			Spec:       corev1.NodeSpec{PodCIDR: "10.0.0.64/26"},
+		},
+	)
+	l := ledger.New(c, c, ledgerKey)
+	v := webhook.NewNodeCapacityValidator(c, c, l, admission.NewDecoder(c.Scheme()))
+
+	// Case 1: Node that fits into the remaining capacity of the matching NodeCIDRAllocation
+	// expected: should be allowed
+	if resp := v.Handle(ctx, newCreateRequest(t, newTestNode("testNodeA", agent, 62), false)); !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+
+	// Case 2: Node that requires a larger subnet than any that is free
+	// expected: should be denied with a message naming the NodeCIDRAllocation and its address pools
+	resp := v.Handle(ctx, newCreateRequest(t, newTestNode("testNodeB", agent, 254), false))
+	if resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, false)
+	}
+	if msg := resp.Result.Message; !strings.Contains(msg, "default/testAllocation") || !strings.Contains(msg, "10.0.0.0/24") {
+		t.Errorf("got %q, wanted a message naming the NodeCIDRAllocation and address pool", msg)
+	}
+
+	// Case 3: The remaining capacity is reserved in the ledger for other Nodes
+	// expected: should be denied
+	for i, podCIDR := range []string{"10.0.0.128/26", "10.0.0.192/26"} {
+		if _, err := l.Reserve(ctx, []string{"testNodePendingA", "testNodePendingB"}[i], "", "default/testAllocation", func(_ []string) (string, error) {
+			return podCIDR, nil
+		}); err != nil {
+			t.Fatalf("unable to reserve PodCIDR. got %e", err)
+		}
+	}
+	if resp := v.Handle(ctx, newCreateRequest(t, newTestNode("testNodeC", agent, 62), false)); resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, false)
+	}
+
+	// Case 4: The Node that is being created holds one of the reservations
+	// expected: should be allowed since its own reservation is available to it
+	if resp := v.Handle(ctx, newCreateRequest(t, newTestNode("testNodePendingA", agent, 62), false)); !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+
+	// Case 5: Node which was already assigned a PodCIDR (for example, by the mutating webhook)
+	// expected: should be allowed
+	node := newTestNode("testNodeD", agent, 62)
+	node.Spec.PodCIDR = "10.0.0.128/26"
+	if resp := v.Handle(ctx, newCreateRequest(t, node, false)); !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+
+	// Case 6: Node not selected by any NodeCIDRAllocation
+	// expected: should be allowed
+	if resp := v.Handle(ctx, newCreateRequest(t, newTestNode("testNodeE", map[string]string{"kubernetes.io/role": "control-plane"}, 62), false)); !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+
+	// Case 7: Node requesting an address pool that does not belong to the matching NodeCIDRAllocation
+	// expected: should be denied with a message naming the requested address pool
+	node = newTestNode("testNodeF", agent, 62)
+	node.SetAnnotations(map[string]string{v1alpha1.AnnotationRequestedPool: "10.9.0.0/24"})
+	resp = v.Handle(ctx, newCreateRequest(t, node, false))
+	if resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, false)
+	}
+	if msg := resp.Result.Message; !strings.Contains(msg, "10.9.0.0/24") {
+		t.Errorf("got %q, wanted a message naming the requested address pool", msg)
+	}
+}
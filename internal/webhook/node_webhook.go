@@ -0,0 +1,236 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package webhook implements the admission webhooks served by the cidr-allocator manager
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+	"statcan.gc.ca/cidr-allocator/internal/helper"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+	statcan_net "statcan.gc.ca/cidr-allocator/internal/networking"
+)
+
+const (
+	// NodeMutatingWebhookPath is the path that the Node PodCIDR mutating webhook is served on
+	NodeMutatingWebhookPath = "/mutate-v1-node"
+)
+
+//+kubebuilder:webhook:path=/mutate-v1-node,mutating=true,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups="",resources=nodes,verbs=create,versions=v1,name=mnode.networking.statcan.gc.ca,admissionReviewVersions=v1
+
+// NodePodCIDRMutator assigns a PodCIDR to Nodes when they are created so that the PodCIDR exists before the kubelet starts using the Node.
+// Allocations are reserved in the shared ledger before they are returned so that concurrent requests answered by different webhook replicas
+// (and the controller) can never hand out the same PodCIDR. Any Node that cannot be allocated at admission is admitted unmodified and is
+// allocated by the controller instead
+type NodePodCIDRMutator struct {
+	// Client is used to read NodeCIDRAllocation resources
+	Client client.Reader
+
+	// APIReader is used to read Nodes directly from the API server (bypassing the cache)
+	APIReader client.Reader
+
+	// Ledger is used to reserve PodCIDRs across all writers
+	Ledger *ledger.Ledger
+
+	decoder *admission.Decoder
+}
+
+// SetupNodeWebhookWithManager registers the Node PodCIDR mutating webhook with the webhook server of the Manager
+func SetupNodeWebhookWithManager(mgr ctrl.Manager, l *ledger.Ledger) {
+	mgr.GetWebhookServer().Register(NodeMutatingWebhookPath, &webhook.Admission{
+		Handler: NewNodePodCIDRMutator(mgr.GetClient(), mgr.GetAPIReader(), l, admission.NewDecoder(mgr.GetScheme())),
+	})
+}
+
+// NewNodePodCIDRMutator creates a new NodePodCIDRMutator which decodes admission requests using the supplied decoder
+func NewNodePodCIDRMutator(c client.Reader, apiReader client.Reader, l *ledger.Ledger, decoder *admission.Decoder) *NodePodCIDRMutator {
+	return &NodePodCIDRMutator{
+		Client:    c,
+		APIReader: apiReader,
+		Ledger:    l,
+		decoder:   decoder,
+	}
+}
+
+// Handle assigns a PodCIDR to the Node in the admission request from the first NodeCIDRAllocation that selects it
+func (m *NodePodCIDRMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	rl := log.FromContext(ctx)
+
+	node := corev1.Node{}
+	if err := m.decoder.Decode(req, &node); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if node.Spec.PodCIDR != "" {
+		return admission.Allowed("node already contains CIDR allocation")
+	}
+
+	if req.DryRun != nil && *req.DryRun {
+		return admission.Allowed("node PodCIDRs are not allocated for dry-run requests")
+	}
+
+	nodeCIDRAllocation, err := matchingNodeCIDRAllocation(ctx, m.Client, &node)
+	if err != nil {
+		rl.Error(err, "unable to find the NodeCIDRAllocation for the node. deferring allocation to the controller", "name", node.GetName())
+		return admission.Allowed("unable to find the NodeCIDRAllocation for the node")
+	}
+	if nodeCIDRAllocation == nil {
+		return admission.Allowed("no NodeCIDRAllocation selects the node")
+	}
+	if nodeCIDRAllocation.Paused() {
+		return admission.Allowed("allocations are paused for the NodeCIDRAllocation. deferring allocation to the controller")
+	}
+
+	pinnedPodCIDR := allocator.NewPins(nodeCIDRAllocation, time.Now()).For(&node)
+	if pinnedPodCIDR == "" && node.Status.Allocatable.Pods().Value() == 0 {
+		return admission.Allowed("node does not report allocatable pods. deferring allocation to the controller")
+	}
+	request, err := allocator.RequestFor(nodeCIDRAllocation, &node)
+	if pinnedPodCIDR == "" && err != nil {
+		rl.Info("unable to honor the PodCIDR request of node at admission. deferring to the controller", "name", node.GetName(), "reason", err.Error())
+		return admission.Allowed("node PodCIDR request cannot be honored")
+	}
+
+	// PodCIDRs pinned by any NodeCIDRAllocation (and not only the matching one) are never allocated dynamically
+	pinnedSubnets, err := allocator.PinnedSubnets(ctx, m.Client, time.Now())
+	if err != nil {
+		rl.Error(err, "unable to resolve the pinned allocations of NodeCIDRAllocations. deferring allocation to the controller", "name", node.GetName())
+		return admission.Allowed("unable to resolve pinned allocations")
+	}
+
+	owner := types.NamespacedName{Name: nodeCIDRAllocation.GetName(), Namespace: nodeCIDRAllocation.GetNamespace()}.String()
+	podCIDR, err := m.Ledger.Reserve(ctx, node.GetName(), node.GetUID(), owner, func(reserved []string) (string, error) {
+		allClusterNodes := corev1.NodeList{}
+		if err := m.APIReader.List(ctx, &allClusterNodes); err != nil {
+			return "", err
+		}
+
+		if pinnedPodCIDR != "" {
+			// pinned Nodes are only ever assigned their pinned PodCIDR
+			allocated, err := statcan_net.NetworkAllocated(pinnedPodCIDR, &allClusterNodes, reserved)
+			if err != nil {
+				return "", err
+			}
+			if allocated {
+				return "", fmt.Errorf("pinned PodCIDR %s overlaps an existing allocation", pinnedPodCIDR)
+			}
+
+			return pinnedPodCIDR, nil
+		}
+
+		return request.Pick(
+			&allClusterNodes,
+			append(append(reserved, nodeCIDRAllocation.ReservedSubnets(time.Now())...), pinnedSubnets...),
+		)
+	})
+	if err == nil && pinnedPodCIDR != "" && podCIDR != pinnedPodCIDR {
+		err = fmt.Errorf("PodCIDR %s is reserved for the node instead of its pinned PodCIDR %s", podCIDR, pinnedPodCIDR)
+	}
+	if err != nil {
+		if errors.Is(err, allocator.ErrNoCapacity) {
+			rl.Info("unable to allocate podCIDR for node at admission. no sufficient address space capacity for Node",
+				"name", node.GetName(),
+				"requiredSubnetCIDR", request.Ones,
+			)
+		} else {
+			rl.Error(err, "unable to reserve podCIDR for node at admission. deferring allocation to the controller", "name", node.GetName())
+		}
+
+		return admission.Allowed("unable to allocate podCIDR at admission")
+	}
+
+	node.Spec.PodCIDR = podCIDR
+	node.Spec.PodCIDRs = []string{podCIDR}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[v1alpha1.AnnotationNodeCIDRAllocation] = owner
+	node.Annotations[v1alpha1.AnnotationAddressPool] = allocator.PoolFor(nodeCIDRAllocation.AddressPools(), podCIDR)
+	node.Annotations[v1alpha1.AnnotationAllocatedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	marshaled, err := json.Marshal(&node)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	rl.Info(
+		"assigned PodCIDR to Node resource at admission",
+		"name", node.GetName(),
+		"podCIDR", podCIDR,
+		"nodeCIDRAllocation", owner,
+	)
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// matchingNodeCIDRAllocation returns the NodeCIDRAllocation that the supplied Node is allocated from. When several NodeCIDRAllocation resources
+// select the Node, the first one ordered by namespace and name is used. nil is returned when no NodeCIDRAllocation selects the Node.
+// The NodeCIDRAllocation is returned along with the AddressPools that it references
+func matchingNodeCIDRAllocation(ctx context.Context, c client.Reader, node *corev1.Node) (*allocator.Resolved, error) {
+	allNodeCIDRAllocations := v1alpha1.NodeCIDRAllocationList{}
+	if err := c.List(ctx, &allNodeCIDRAllocations, &client.ListOptions{
+		Namespace: corev1.NamespaceAll,
+	}); err != nil {
+		return nil, err
+	}
+
+	matching := []v1alpha1.NodeCIDRAllocation{}
+	for _, item := range allNodeCIDRAllocations.Items {
+		if !item.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		if helper.ObjectContainsLabels(node, item.Spec.NodeSelector) {
+			matching = append(matching, item)
+		}
+	}
+
+	if len(matching) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].GetNamespace() != matching[j].GetNamespace() {
+			return matching[i].GetNamespace() < matching[j].GetNamespace()
+		}
+
+		return matching[i].GetName() < matching[j].GetName()
+	})
+
+	resolved, err := allocator.Resolve(ctx, c, &matching[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved[0], nil
+}
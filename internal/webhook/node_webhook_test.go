@@ -0,0 +1,377 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/ledger"
+	"statcan.gc.ca/cidr-allocator/internal/webhook"
+)
+
+var ledgerKey = types.NamespacedName{Name: ledger.DefaultName, Namespace: "cidr-allocator-system"}
+
+// newTestScheme creates a runtime scheme containing the core Kubernetes types and the NodeCIDRAllocation types
+func newTestScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
+
+	return s
+}
+
+// newTestClient creates a fake client containing the supplied objects. Writes are serialized since the fake client does not compare and
+// store the resourceVersion of an object atomically, which the API server guarantees
+func newTestClient(objs ...client.Object) client.WithWatch {
+	mu := sync.Mutex{}
+
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				return c.Create(ctx, obj, opts...)
+			},
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				return c.Update(ctx, obj, opts...)
+			},
+		}).
+		Build()
+}
+
+// newTestMutator creates a NodePodCIDRMutator (representing a single webhook replica) backed by the supplied client
+func newTestMutator(c client.Client) *webhook.NodePodCIDRMutator {
+	return webhook.NewNodePodCIDRMutator(c, c, ledger.New(c, c, ledgerKey), admission.NewDecoder(c.Scheme()))
+}
+
+// newTestNode creates a Node with the supplied labels and maximum number of pods
+func newTestNode(name string, labels map[string]string, maxPods int64) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourcePods: *resource.NewQuantity(maxPods, resource.DecimalSI),
+			},
+		},
+	}
+}
+
+// newCreateRequest creates an admission request for the creation of the supplied Node
+func newCreateRequest(t *testing.T, node *corev1.Node, dryRun bool) admission.Request {
+	t.Helper()
+
+	raw, err := json.Marshal(node)
+	if err != nil {
+		t.Fatalf("unable to marshal Node. got %e", err)
+	}
+
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Name:      node.GetName(),
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+		DryRun:    &dryRun,
+	}}
+}
+
+// patchedPodCIDR returns the PodCIDR that the admission response assigns to the Node, or an empty string if it is not modified
+func patchedPodCIDR(resp admission.Response) string {
+	for _, p := range resp.Patches {
+		if p.Path == "/spec/podCIDR" {
+			return fmt.Sprint(p.Value)
+		}
+		if p.Path == "/spec" {
+			if spec, ok := p.Value.(map[string]interface{}); ok {
+				return fmt.Sprint(spec["podCIDR"])
+			}
+		}
+	}
+
+	return ""
+}
+
+// patchedAnnotations returns the annotations that the admission response adds to the Node
+func patchedAnnotations(resp admission.Response) map[string]string {
+	annotations := map[string]string{}
+	for _, p := range resp.Patches {
+		if p.Path == "/metadata/annotations" {
+			if values, ok := p.Value.(map[string]interface{}); ok {
+				for k, v := range values {
+					annotations[k] = fmt.Sprint(v)
+				}
+			}
+		}
+	}
+
+	return annotations
+}
+
+func TestHandle(t *testing.T) {
+	ctx := context.Background()
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+
+	c := newTestClient(
+		&v1alpha1.NodeCIDRAllocation{
+			ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+			Spec: v1alpha1.NodeCIDRAllocationSpec{
+				AddressPools:      []string{"10.0.0.0/24"},
+				StaticAllocations: []string{"10.0.0.0/26"},
+				NodeSelector:      agent,
+			},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "testNodeExisting"},
+			Spec:       corev1.NodeSpec{PodCIDR: "10.0.0.64/26"},
+		},
+	)
+	m := newTestMutator(c)
+
+	// Case 1: Node selected by a NodeCIDRAllocation
+	// expected: should be allowed and patched with the first subnet that is not statically allocated or used by another Node
+	resp := m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeA", agent, 62), false))
+	if !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+	if got := patchedPodCIDR(resp); got != "10.0.0.128/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.128/26")
+	}
+	if got := patchedAnnotations(resp); got[v1alpha1.AnnotationNodeCIDRAllocation] != "default/testAllocation" || got[v1alpha1.AnnotationAddressPool] != "10.0.0.0/24" {
+		t.Errorf("got %v, wanted the owning NodeCIDRAllocation and address pool to be recorded", got)
+	}
+
+	// Case 2: Node not selected by any NodeCIDRAllocation
+	// expected: should be allowed without modification
+	resp = m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "control-plane"}, 62), false))
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Errorf("got %v (patches %v), wanted the Node to be allowed without modification", resp.Allowed, resp.Patches)
+	}
+
+	// Case 3: Node which already contains a PodCIDR
+	// expected: should be allowed without modification
+	node := newTestNode("testNodeC", agent, 62)
+	node.Spec.PodCIDR = "10.1.0.0/26"
+	resp = m.Handle(ctx, newCreateRequest(t, node, false))
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Errorf("got %v (patches %v), wanted the Node to be allowed without modification", resp.Allowed, resp.Patches)
+	}
+
+	// Case 4: Dry-run request
+	// expected: should be allowed without modification and nothing should be reserved
+	resp = m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeD", agent, 62), true))
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Errorf("got %v (patches %v), wanted the Node to be allowed without modification", resp.Allowed, resp.Patches)
+	}
+
+	// Case 5: Address pool exhausted
+	// expected: should be allowed without modification so that the controller can report the lack of capacity
+	resp = m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeE", agent, 62), false))
+	if got := patchedPodCIDR(resp); got != "10.0.0.192/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.192/26")
+	}
+	resp = m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeF", agent, 62), false))
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Errorf("got %v (patches %v), wanted the Node to be allowed without modification", resp.Allowed, resp.Patches)
+	}
+
+	// Case 6: Node which does not report any allocatable pods
+	// expected: should be allowed without modification
+	resp = m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeG", agent, 0), false))
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Errorf("got %v (patches %v), wanted the Node to be allowed without modification", resp.Allowed, resp.Patches)
+	}
+}
+
+func TestHandlePinned(t *testing.T) {
+	ctx := context.Background()
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+
+	infra := map[string]string{"kubernetes.io/role": "infra"}
+
+	c := newTestClient(&v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: []string{"10.0.0.0/24"},
+			NodeSelector: agent,
+			PinnedAllocations: []v1alpha1.PinnedAllocation{
+				{NodeName: "testNodeRouter", PodCIDR: "10.0.0.192/26"},
+				{NodeName: "testNodeFirst", PodCIDR: "10.0.0.0/26"},
+			},
+		},
+	}, &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocationInfra", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: []string{"10.0.0.0/24"},
+			NodeSelector: infra,
+		},
+	})
+	m := newTestMutator(c)
+
+	// Case 1: Node pinned to a PodCIDR
+	// expected: should be patched with the pinned PodCIDR
+	if got := patchedPodCIDR(m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeRouter", agent, 62), false))); got != "10.0.0.192/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.192/26")
+	}
+
+	// Case 2: Node which is not pinned
+	// expected: should be patched with the first subnet that is not pinned to another Node
+	if got := patchedPodCIDR(m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeA", agent, 62), false))); got != "10.0.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.64/26")
+	}
+
+	// Case 3: Node selected by another NodeCIDRAllocation sharing the address pool
+	// expected: should be patched with the first subnet that is neither reserved nor pinned by any NodeCIDRAllocation
+	if got := patchedPodCIDR(m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeB", infra, 62), false))); got != "10.0.0.128/26" {
+		t.Errorf("got %s, wanted %s", got, "10.0.0.128/26")
+	}
+}
+
+func TestHandlePaused(t *testing.T) {
+	ctx := context.Background()
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+
+	c := newTestClient(&v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testAllocation",
+			Namespace:   "default",
+			Annotations: map[string]string{v1alpha1.AnnotationPaused: "true"},
+		},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: []string{"10.0.0.0/24"},
+			NodeSelector: agent,
+		},
+	})
+	m := newTestMutator(c)
+
+	// Case 1: Node selected by a paused NodeCIDRAllocation
+	// expected: should be allowed without modification so that the Node waits until allocations are resumed
+	resp := m.Handle(ctx, newCreateRequest(t, newTestNode("testNodeA", agent, 62), false))
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Errorf("got %v (patches %v), wanted the Node to be allowed without modification", resp.Allowed, resp.Patches)
+	}
+}
+
+func TestHandleRequested(t *testing.T) {
+	ctx := context.Background()
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+
+	c := newTestClient(&v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: []string{"10.0.0.0/24", "10.1.0.0/24"},
+			NodeSelector: agent,
+		},
+	})
+	m := newTestMutator(c)
+	requesting := func(name string, annotations map[string]string) *corev1.Node {
+		node := newTestNode(name, agent, 62)
+		node.SetAnnotations(annotations)
+		return node
+	}
+
+	// Case 1: Node requesting an exact PodCIDR
+	// expected: should be patched with the requested PodCIDR
+	if got := patchedPodCIDR(m.Handle(ctx, newCreateRequest(t, requesting("testNodeA", map[string]string{v1alpha1.AnnotationRequestedPodCIDR: "10.1.0.64/26"}), false))); got != "10.1.0.64/26" {
+		t.Errorf("got %s, wanted %s", got, "10.1.0.64/26")
+	}
+
+	// Case 2: Node requesting an address pool and a prefix length
+	// expected: should be patched with the first free subnet of the requested size from the requested address pool
+	if got := patchedPodCIDR(m.Handle(ctx, newCreateRequest(t, requesting("testNodeB", map[string]string{
+		v1alpha1.AnnotationRequestedPool:         "10.1.0.0/24",
+		v1alpha1.AnnotationRequestedPrefixLength: "25",
+	}), false))); got != "10.1.0.128/25" {
+		t.Errorf("got %s, wanted %s", got, "10.1.0.128/25")
+	}
+
+	// Case 3: Node requesting a PodCIDR that is already reserved
+	// expected: should be allowed without modification so that the controller can report the collision
+	resp := m.Handle(ctx, newCreateRequest(t, requesting("testNodeC", map[string]string{v1alpha1.AnnotationRequestedPodCIDR: "10.1.0.0/25"}), false))
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Errorf("got %v (patches %v), wanted the Node to be allowed without modification", resp.Allowed, resp.Patches)
+	}
+}
+
+func TestHandleConcurrentReplicas(t *testing.T) {
+	ctx := context.Background()
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+
+	// Case 1: Many Nodes are created at the same time and the admission requests are spread across several webhook replicas
+	// expected: every Node should be patched with a unique PodCIDR
+	c := newTestClient(&v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: []string{"10.0.0.0/22"},
+			NodeSelector: agent,
+		},
+	})
+	replicas := []*webhook.NodePodCIDRMutator{newTestMutator(c), newTestMutator(c), newTestMutator(c)}
+
+	const numNodes = 16
+	requests := make([]admission.Request, numNodes)
+	for i := range requests {
+		requests[i] = newCreateRequest(t, newTestNode(fmt.Sprintf("testNode%d", i), agent, 30), false)
+	}
+	results := make([]string, numNodes)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < numNodes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := replicas[i%len(replicas)].Handle(ctx, requests[i])
+			results[i] = patchedPodCIDR(resp)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]int{}
+	for i, podCIDR := range results {
+		if podCIDR == "" {
+			t.Errorf("got no PodCIDR for testNode%d, wanted an allocation", i)
+			continue
+		}
+		if other, ok := seen[podCIDR]; ok {
+			t.Errorf("got duplicate PodCIDR %s for testNode%d and testNode%d", podCIDR, i, other)
+		}
+		seen[podCIDR] = i
+	}
+}
@@ -0,0 +1,155 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/allocator"
+)
+
+const (
+	// PoolRemovalValidatingWebhookPath is the path that the NodeCIDRAllocation pool removal guard validating webhook is served on
+	PoolRemovalValidatingWebhookPath = "/validate-networking-statcan-gc-ca-v1alpha1-nodecidrallocation"
+)
+
+//+kubebuilder:webhook:path=/validate-networking-statcan-gc-ca-v1alpha1-nodecidrallocation,mutating=false,failurePolicy=fail,sideEffects=None,groups=networking.statcan.gc.ca,resources=nodecidrallocations,verbs=update,versions=v1alpha1,name=vnodecidrallocation.networking.statcan.gc.ca,admissionReviewVersions=v1
+
+// PoolRemovalValidator rejects updates to a NodeCIDRAllocation that remove an address pool from which Nodes owned by the NodeCIDRAllocation
+// still hold a PodCIDR, unless the NodeCIDRAllocation is annotated to force the removal. A pool should be marked as draining (see
+// .spec.drainingPools) until no Node is left on it before it is removed.
+// The validator is only an early rejection: the controller keeps a removed pool as a draining pool until no Node is left on it regardless
+// (including when CIDRs are removed from a referenced AddressPool or when the AddressPool is deleted)
+type PoolRemovalValidator struct {
+	// APIReader is used to read Nodes directly from the API server (bypassing the cache)
+	APIReader client.Reader
+
+	decoder *admission.Decoder
+}
+
+// SetupPoolRemovalWebhookWithManager registers the NodeCIDRAllocation pool removal guard validating webhook with the webhook server of the Manager
+func SetupPoolRemovalWebhookWithManager(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(PoolRemovalValidatingWebhookPath, &webhook.Admission{
+		Handler: NewPoolRemovalValidator(mgr.GetAPIReader(), admission.NewDecoder(mgr.GetScheme())),
+	})
+}
+
+// NewPoolRemovalValidator creates a new PoolRemovalValidator which decodes admission requests using the supplied decoder
+func NewPoolRemovalValidator(apiReader client.Reader, decoder *admission.Decoder) *PoolRemovalValidator {
+	return &PoolRemovalValidator{
+		APIReader: apiReader,
+		decoder:   decoder,
+	}
+}
+
+// Handle denies the NodeCIDRAllocation update in the admission request when it removes an address pool that still holds owned Node allocations
+func (v *PoolRemovalValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	rl := log.FromContext(ctx)
+
+	if req.Operation != admissionv1.Update {
+		return admission.Allowed("only updates can remove address pools")
+	}
+
+	newNodeCIDRAllocation := v1alpha1.NodeCIDRAllocation{}
+	if err := v.decoder.Decode(req, &newNodeCIDRAllocation); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	oldNodeCIDRAllocation := v1alpha1.NodeCIDRAllocation{}
+	if err := v.decoder.DecodeRaw(req.OldObject, &oldNodeCIDRAllocation); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// a referenced AddressPool that does not exist does not contribute any address pool
+	resolved, err := allocator.Resolve(ctx, v.APIReader, &oldNodeCIDRAllocation, &newNodeCIDRAllocation)
+	if err != nil && !errors.Is(err, allocator.ErrAddressPoolNotFound) {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	oldResolved, newResolved := resolved[0], resolved[1]
+
+	removed := []string{}
+	for _, pool := range oldResolved.AddressPools() {
+		if !slices.Contains(newResolved.AddressPools(), pool) {
+			removed = append(removed, pool)
+		}
+	}
+	if len(removed) == 0 {
+		return admission.Allowed("no address pool was removed")
+	}
+
+	allClusterNodes := corev1.NodeList{}
+	if err := v.APIReader.List(ctx, &allClusterNodes); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	inUse := map[string][]string{}
+	for i := range allClusterNodes.Items {
+		node := &allClusterNodes.Items[i]
+		if !allocator.OwnsNode(oldResolved, node) {
+			continue
+		}
+
+		if pool := allocator.PoolFor(removed, node.Spec.PodCIDR); pool != "" {
+			inUse[pool] = append(inUse[pool], node.GetName())
+		}
+	}
+	if len(inUse) == 0 {
+		return admission.Allowed("removed address pools do not hold any Node allocation")
+	}
+
+	details := make([]string, 0, len(inUse))
+	for _, pool := range removed {
+		if nodes, ok := inUse[pool]; ok {
+			sort.Strings(nodes)
+			details = append(details, fmt.Sprintf("%s (Nodes: %s)", pool, strings.Join(nodes, ", ")))
+		}
+	}
+
+	if newNodeCIDRAllocation.GetAnnotations()[v1alpha1.AnnotationForcePoolRemoval] == "true" {
+		rl.Info("allowing removal of address pools that still hold Node allocations (forced)",
+			"nodeCIDRAllocation", newNodeCIDRAllocation.GetName(),
+			"namespace", newNodeCIDRAllocation.GetNamespace(),
+			"pools", details,
+		)
+
+		return admission.Allowed("address pool removal forced").WithWarnings(fmt.Sprintf(
+			"address pools removed while Nodes still hold PodCIDRs from them: %s", strings.Join(details, "; "),
+		))
+	}
+
+	return admission.Denied(fmt.Sprintf(
+		"NodeCIDRAllocation %s/%s cannot remove address pools that still hold Node allocations: %s. Mark the pools as draining until no Node is left on them, or set the %s=true annotation to force the removal",
+		newNodeCIDRAllocation.GetNamespace(),
+		newNodeCIDRAllocation.GetName(),
+		strings.Join(details, "; "),
+		v1alpha1.AnnotationForcePoolRemoval,
+	))
+}
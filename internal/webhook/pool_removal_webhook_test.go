@@ -0,0 +1,115 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"statcan.gc.ca/cidr-allocator/api/v1alpha1"
+	"statcan.gc.ca/cidr-allocator/internal/webhook"
+)
+
+// newUpdateRequest returns an admission request which updates the supplied NodeCIDRAllocation from oldObj to newObj
+func newUpdateRequest(t *testing.T, oldObj, newObj *v1alpha1.NodeCIDRAllocation) admission.Request {
+	t.Helper()
+
+	oldRaw, err := json.Marshal(oldObj)
+	if err != nil {
+		t.Fatalf("unable to marshal NodeCIDRAllocation. got %e", err)
+	}
+	newRaw, err := json.Marshal(newObj)
+	if err != nil {
+		t.Fatalf("unable to marshal NodeCIDRAllocation. got %e", err)
+	}
+
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Name:      newObj.GetName(),
+		Namespace: newObj.GetNamespace(),
+		Operation: admissionv1.Update,
+		OldObject: runtime.RawExtension{Raw: oldRaw},
+		Object:    runtime.RawExtension{Raw: newRaw},
+	}}
+}
+
+func TestHandlePoolRemoval(t *testing.T) {
+	ctx := context.Background()
+	agent := map[string]string{"kubernetes.io/role": "agent"}
+
+	oldNodeCIDRAllocation := &v1alpha1.NodeCIDRAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "testAllocation", Namespace: "default"},
+		Spec: v1alpha1.NodeCIDRAllocationSpec{
+			AddressPools: []string{"10.0.0.0/24", "10.1.0.0/24", "10.2.0.0/24"},
+			NodeSelector: agent,
+		},
+	}
+
+	allocated := newTestNode("testNodeA", agent, 62)
+	allocated.Spec.PodCIDR = "10.0.0.0/26"
+	foreign := newTestNode("testNodeB", map[string]string{"kubernetes.io/role": "control-plane"}, 62)
+	foreign.Spec.PodCIDR = "10.1.0.0/26"
+
+	c := newTestClient(oldNodeCIDRAllocation, allocated, foreign)
+	v := webhook.NewPoolRemovalValidator(c, admission.NewDecoder(c.Scheme()))
+
+	withPools := func(pools ...string) *v1alpha1.NodeCIDRAllocation {
+		n := oldNodeCIDRAllocation.DeepCopy()
+		n.Spec.AddressPools = pools
+		return n
+	}
+
+	// Case 1: Update which does not remove any address pool
+	// expected: should be allowed
+	if resp := v.Handle(ctx, newUpdateRequest(t, oldNodeCIDRAllocation, withPools("10.0.0.0/24", "10.1.0.0/24", "10.2.0.0/24", "10.3.0.0/24"))); !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+
+	// Case 2: Removal of address pools that only hold Nodes not owned by the NodeCIDRAllocation
+	// expected: should be allowed
+	if resp := v.Handle(ctx, newUpdateRequest(t, oldNodeCIDRAllocation, withPools("10.0.0.0/24"))); !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+
+	// Case 3: Removal of an address pool that still holds an owned Node allocation
+	// expected: should be denied with a message naming the address pool and the Node
+	resp := v.Handle(ctx, newUpdateRequest(t, oldNodeCIDRAllocation, withPools("10.1.0.0/24", "10.2.0.0/24")))
+	if resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, false)
+	}
+	if msg := resp.Result.Message; !strings.Contains(msg, "10.0.0.0/24 (Nodes: testNodeA)") {
+		t.Errorf("got %q, wanted a message naming the address pool and its Nodes", msg)
+	}
+
+	// Case 4: Same removal with the force annotation set
+	// expected: should be allowed with a warning
+	forced := withPools("10.1.0.0/24", "10.2.0.0/24")
+	forced.SetAnnotations(map[string]string{v1alpha1.AnnotationForcePoolRemoval: "true"})
+	resp = v.Handle(ctx, newUpdateRequest(t, oldNodeCIDRAllocation, forced))
+	if !resp.Allowed {
+		t.Errorf("got %v, wanted %v", resp.Allowed, true)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Errorf("got %v, wanted a single warning", resp.Warnings)
+	}
+}
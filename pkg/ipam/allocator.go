@@ -0,0 +1,105 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrNoCapacity is returned when none of the pools have a free subnet of the requested size
+var ErrNoCapacity = errors.New("there are no available subnets for the requested size")
+
+// Allocator represents a strategy for selecting free subnets from a list of pools
+type Allocator interface {
+	// Allocate returns a subnet with the supplied prefix length from one of the pools which does not overlap any address in use.
+	// ErrNoCapacity is returned when there is no such subnet
+	Allocate(pools []Pool, bits int, used *Set) (netip.Prefix, error)
+
+	// Free returns every subnet with the supplied prefix length from the pools which does not overlap any address in use, in the order
+	// that the Allocator would allocate them
+	Free(pools []Pool, bits int, used *Set) ([]netip.Prefix, error)
+}
+
+// FirstFit is an Allocator which allocates the first free subnet, going through the pools in order and through the subnets of each pool
+// in ascending address order. It keeps allocations packed at the start of the first pools
+type FirstFit struct{}
+
+var _ Allocator = FirstFit{}
+
+// Allocate implements Allocator
+func (f FirstFit) Allocate(pools []Pool, bits int, used *Set) (netip.Prefix, error) {
+	var allocated netip.Prefix
+	err := f.walk(pools, bits, used, func(subnet netip.Prefix) bool {
+		allocated = subnet
+		return false
+	})
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	if !allocated.IsValid() {
+		return netip.Prefix{}, ErrNoCapacity
+	}
+
+	return allocated, nil
+}
+
+// Free implements Allocator
+func (f FirstFit) Free(pools []Pool, bits int, used *Set) ([]netip.Prefix, error) {
+	free := []netip.Prefix{}
+	err := f.walk(pools, bits, used, func(subnet netip.Prefix) bool {
+		free = append(free, subnet)
+		return true
+	})
+	if err != nil {
+		return []netip.Prefix{}, err
+	}
+
+	return free, nil
+}
+
+// walk calls visit with every free subnet of the pools, in order, until visit returns false. Ranges of addresses in use are skipped over
+// rather than visited subnet by subnet
+func (FirstFit) walk(pools []Pool, bits int, used *Set, visit func(netip.Prefix) bool) error {
+	if used == nil {
+		used = &Set{}
+	}
+
+	for _, pool := range pools {
+		subnets, err := pool.Subnets(bits)
+		if err != nil {
+			return err
+		}
+
+		for subnet, ok := subnets.Next(); ok; subnet, ok = subnets.Next() {
+			if r, overlaps := used.overlapping(subnet); overlaps {
+				if next := r.Last.Next(); next.IsValid() {
+					subnets.SkipTo(next)
+					continue
+				}
+				break
+			}
+
+			if !visit(subnet) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
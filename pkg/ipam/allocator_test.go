@@ -0,0 +1,162 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam_test
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+func TestFirstFit(t *testing.T) {
+	cases := []struct {
+		name         string
+		pools        []string
+		used         []string
+		bits         int
+		wantAllocate string
+		wantFree     []string
+		wantErr      error
+	}{
+		{
+			name:         "nothing in use",
+			pools:        []string{"10.0.0.0/24"},
+			bits:         26,
+			wantAllocate: "10.0.0.0/26",
+			wantFree:     []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/26"},
+		},
+		{
+			name:         "subnets partially in use are skipped",
+			pools:        []string{"10.0.0.0/24"},
+			used:         []string{"10.0.0.0/26", "10.0.0.70/32"},
+			bits:         26,
+			wantAllocate: "10.0.0.128/26",
+			wantFree:     []string{"10.0.0.128/26", "10.0.0.192/26"},
+		},
+		{
+			name:         "pools are used in order",
+			pools:        []string{"10.0.0.0/25", "10.0.1.0/25"},
+			used:         []string{"10.0.0.0/25"},
+			bits:         26,
+			wantAllocate: "10.0.1.0/26",
+			wantFree:     []string{"10.0.1.0/26", "10.0.1.64/26"},
+		},
+		{
+			name:         "a large range in use",
+			pools:        []string{"fd00::/48"},
+			used:         []string{"fd00::/49", "fd00:0:0:8000::/50"},
+			bits:         64,
+			wantAllocate: "fd00:0:0:c000::/64",
+		},
+		{
+			name:     "every subnet in use",
+			pools:    []string{"10.0.0.0/24"},
+			used:     []string{"10.0.0.0/16"},
+			bits:     26,
+			wantFree: []string{},
+			wantErr:  ipam.ErrNoCapacity,
+		},
+		{
+			name:     "pools smaller than the requested size",
+			pools:    []string{"10.0.0.0/24"},
+			bits:     20,
+			wantFree: []string{},
+			wantErr:  ipam.ErrNoCapacity,
+		},
+		{
+			name:         "the end of the address space",
+			pools:        []string{"255.255.255.0/24"},
+			used:         []string{"255.255.255.0/25"},
+			bits:         25,
+			wantAllocate: "255.255.255.128/25",
+			wantFree:     []string{"255.255.255.128/25"},
+		},
+		{
+			name:     "an invalid prefix length",
+			pools:    []string{"10.0.0.0/24"},
+			bits:     33,
+			wantFree: []string{},
+			wantErr:  ipam.ErrInvalidPrefixLength,
+		},
+	}
+
+	for _, c := range cases {
+		pools, err := ipam.ParsePools(c.pools...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		used := mustParseSet(t, c.used...)
+
+		allocated, err := ipam.FirstFit{}.Allocate(pools, c.bits, used)
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: got error %v, wanted %v", c.name, err, c.wantErr)
+		}
+		if c.wantAllocate != "" && allocated.String() != c.wantAllocate {
+			t.Errorf("%s: got %s, wanted %s", c.name, allocated, c.wantAllocate)
+		}
+		if c.wantAllocate == "" && allocated.IsValid() {
+			t.Errorf("%s: got %s, wanted no subnet", c.name, allocated)
+		}
+
+		if c.wantFree == nil {
+			continue
+		}
+		// an empty list rather than ErrNoCapacity is returned when there are no free subnets
+		free, err := ipam.FirstFit{}.Free(pools, c.bits, used)
+		if wantErr := errors.Is(c.wantErr, ipam.ErrInvalidPrefixLength); (err != nil) != wantErr {
+			t.Errorf("%s: got error %v, wanted error %t", c.name, err, wantErr)
+		}
+		if got := prefixStrings(free); !equalStrings(got, c.wantFree) {
+			t.Errorf("%s: got %v, wanted %v", c.name, got, c.wantFree)
+		}
+	}
+}
+
+func TestFirstFitNilUsed(t *testing.T) {
+	// Case 1: No set of addresses in use is supplied
+	// expected: every address is treated as free
+	pools, err := ipam.ParsePools("10.0.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocated, err := ipam.FirstFit{}.Allocate(pools, 24, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParsePrefix("10.0.0.0/24"); allocated != want {
+		t.Errorf("got %s, wanted %s", allocated, want)
+	}
+}
+
+func BenchmarkFirstFit(b *testing.B) {
+	// every /64 of the first half of a /32 is in use
+	pools, err := ipam.ParsePools("fd00::/32")
+	if err != nil {
+		b.Fatal(err)
+	}
+	used := mustParseSet(b, "fd00::/33")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := (ipam.FirstFit{}).Allocate(pools, 64, used); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
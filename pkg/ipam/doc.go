@@ -0,0 +1,31 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package ipam provides the IP address management primitives used by the CIDR-Allocator, so that other controllers can carve address
+// pools, check for overlaps and allocate subnets the same way. It has no dependency on Kubernetes and is built on net/netip, which means
+// that IPv4 and IPv6 are handled alike.
+//
+// The package is built around four types:
+//   - Pool, a network which is carved into subnets of a given prefix length
+//   - Set, a union of address ranges used to account for addresses (in use, reserved or free) without ever counting them twice
+//   - Allocator, a strategy which selects a free subnet from a list of pools. FirstFit is the strategy used by the CIDR-Allocator
+//   - Reservation, a named network held back from allocation, optionally until an expiry time
+//
+// Pools, sets and reservations can be serialized to and from JSON (and pools also to text), so that they can be stored
+// in ConfigMaps, annotations or custom resources.
+//
+// The exported API of this package is stable: breaking changes are only made in a new major version of the module
+package ipam
@@ -0,0 +1,80 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam_test
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+func Example() {
+	pools, err := ipam.ParsePools("10.0.0.0/24", "10.0.1.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	// addresses already allocated, plus a reservation which has not expired
+	used, err := ipam.ParseSet("10.0.0.0/26", "10.0.0.128/26")
+	if err != nil {
+		panic(err)
+	}
+	reservations := []ipam.Reservation{
+		{Name: "vpn", Prefix: netip.MustParsePrefix("10.0.0.64/26"), ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	used = used.Union(ipam.Reserved(reservations, time.Now()))
+
+	var allocator ipam.Allocator = ipam.FirstFit{}
+	subnet, err := allocator.Allocate(pools, 26, used)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(subnet)
+
+	free, err := allocator.Free(pools, 25, used.Union(ipam.NewSet(subnet)))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(free)
+
+	// Output:
+	// 10.0.0.192/26
+	// [10.0.1.0/25 10.0.1.128/25]
+}
+
+func ExamplePool_Subnets() {
+	pool, err := ipam.ParsePool("fd00::/62")
+	if err != nil {
+		panic(err)
+	}
+
+	subnets, err := pool.Subnets(64)
+	if err != nil {
+		panic(err)
+	}
+	for subnet, ok := subnets.Next(); ok; subnet, ok = subnets.Next() {
+		fmt.Println(subnet)
+	}
+
+	// Output:
+	// fd00::/64
+	// fd00:0:0:1::/64
+	// fd00:0:0:2::/64
+	// fd00:0:0:3::/64
+}
@@ -0,0 +1,169 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+)
+
+// ErrInvalidPrefixLength is returned when a prefix length is out of range for the IP family of a pool
+var ErrInvalidPrefixLength = errors.New("invalid prefix length")
+
+// Pool represents a network that subnets are carved from. The zero value is not a valid pool
+type Pool struct {
+	prefix netip.Prefix
+}
+
+// NewPool returns a pool for the supplied prefix. The host bits of the prefix are cleared
+func NewPool(prefix netip.Prefix) (Pool, error) {
+	if !prefix.IsValid() {
+		return Pool{}, fmt.Errorf("invalid pool prefix %s", prefix)
+	}
+
+	return Pool{prefix: prefix.Masked()}, nil
+}
+
+// ParsePool returns a pool for the supplied network (in CIDR format). The host bits of the network are cleared
+func ParsePool(cidr string) (Pool, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return Pool{}, err
+	}
+
+	return NewPool(prefix)
+}
+
+// ParsePools returns a pool for each of the supplied networks (in CIDR format). An error is returned for the first invalid network
+func ParsePools(cidrs ...string) ([]Pool, error) {
+	pools := make([]Pool, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		pool, err := ParsePool(cidr)
+		if err != nil {
+			return []Pool{}, err
+		}
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+// Prefix returns the network of the pool
+func (p Pool) Prefix() netip.Prefix {
+	return p.prefix
+}
+
+// String returns the network of the pool in CIDR format
+func (p Pool) String() string {
+	return p.prefix.String()
+}
+
+// Size returns the number of addresses in the pool
+func (p Pool) Size() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(p.prefix.Addr().BitLen()-p.prefix.Bits()))
+}
+
+// Contains returns true when every address of the supplied prefix is in the pool
+func (p Pool) Contains(prefix netip.Prefix) bool {
+	return prefix.IsValid() && p.prefix.Bits() <= prefix.Bits() && p.prefix.Contains(prefix.Addr())
+}
+
+// Overlaps returns true when any address of the supplied prefix is in the pool
+func (p Pool) Overlaps(prefix netip.Prefix) bool {
+	return prefix.IsValid() && p.prefix.Overlaps(prefix)
+}
+
+// Subnets returns an iterator over every subnet of the pool with the supplied prefix length, in ascending address order.
+// When the prefix length is shorter than the one of the pool, the pool cannot be carved and the iterator is empty.
+// An error wrapping ErrInvalidPrefixLength is returned when the prefix length is out of range for the IP family of the pool
+func (p Pool) Subnets(bits int) (*Subnets, error) {
+	if bits < 0 || bits > p.prefix.Addr().BitLen() {
+		return nil, fmt.Errorf("%w: /%d for pool %s", ErrInvalidPrefixLength, bits, p.prefix)
+	}
+
+	subnets := &Subnets{bits: bits}
+	if bits >= p.prefix.Bits() {
+		subnets.next = p.prefix.Addr()
+		subnets.last = lastAddr(p.prefix)
+	}
+
+	return subnets, nil
+}
+
+// SubnetList returns every subnet of the pool with the supplied prefix length (see Subnets). Prefer Subnets for large pools, since the
+// number of subnets grows exponentially with the difference between the prefix lengths
+func (p Pool) SubnetList(bits int) ([]netip.Prefix, error) {
+	subnets, err := p.Subnets(bits)
+	if err != nil {
+		return []netip.Prefix{}, err
+	}
+
+	list := []netip.Prefix{}
+	for subnet, ok := subnets.Next(); ok; subnet, ok = subnets.Next() {
+		list = append(list, subnet)
+	}
+
+	return list, nil
+}
+
+// MarshalText implements encoding.TextMarshaler. The pool is serialized as its network in CIDR format
+func (p Pool) MarshalText() ([]byte, error) {
+	return p.prefix.MarshalText()
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (p *Pool) UnmarshalText(text []byte) error {
+	pool, err := ParsePool(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = pool
+	return nil
+}
+
+// Subnets iterates over the subnets of a pool (see Pool.Subnets)
+type Subnets struct {
+	bits int
+	next netip.Addr
+	last netip.Addr
+}
+
+// Next returns the next subnet, or false once every subnet has been returned
+func (s *Subnets) Next() (netip.Prefix, bool) {
+	if !s.next.IsValid() || s.next.Compare(s.last) > 0 {
+		return netip.Prefix{}, false
+	}
+
+	subnet := netip.PrefixFrom(s.next, s.bits)
+	s.next = lastAddr(subnet).Next() // invalid once the end of the address space is reached
+	return subnet, true
+}
+
+// SkipTo advances the iterator to the first subnet that does not end before the supplied address, so that iterating past a large
+// range of addresses (for example, a range already in use) does not visit each of its subnets
+func (s *Subnets) SkipTo(addr netip.Addr) {
+	if !s.next.IsValid() || addr.BitLen() != s.next.BitLen() || addr.Compare(s.next) <= 0 {
+		return
+	}
+
+	// the subnet holding addr
+	subnet := netip.PrefixFrom(addr, s.bits).Masked()
+	s.next = subnet.Addr()
+}
@@ -0,0 +1,224 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+// prefixStrings returns the supplied prefixes in CIDR format
+func prefixStrings(prefixes []netip.Prefix) []string {
+	strs := []string{}
+	for _, p := range prefixes {
+		strs = append(strs, p.String())
+	}
+
+	return strs
+}
+
+func TestParsePool(t *testing.T) {
+	// Case 1: A network with host bits set
+	// expected: the host bits are cleared
+	pool, err := ipam.ParsePool("10.0.0.17/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pool.String(), "10.0.0.0/24"; got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+	if got, want := pool.Size().String(), "256"; got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+
+	// Case 2: An invalid network
+	// expected: an error is returned
+	if _, err := ipam.ParsePool("10.0.0.0/33"); err == nil {
+		t.Error("function was expected to return with an error")
+	}
+
+	// Case 3: A list of networks where one is invalid
+	// expected: an error is returned along with an empty list
+	if pools, err := ipam.ParsePools("10.0.0.0/24", "not-a-network"); err == nil || len(pools) != 0 {
+		t.Errorf("got (%v, %v), wanted an error and no pools", pools, err)
+	}
+}
+
+func TestPoolLookups(t *testing.T) {
+	pool, err := ipam.ParsePool("10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		prefix       string
+		wantContains bool
+		wantOverlaps bool
+	}{
+		{prefix: "10.0.4.0/24", wantContains: true, wantOverlaps: true},
+		{prefix: "10.0.0.0/16", wantContains: true, wantOverlaps: true},
+		{prefix: "10.0.0.0/8", wantContains: false, wantOverlaps: true},
+		{prefix: "10.1.0.0/24", wantContains: false, wantOverlaps: false},
+		{prefix: "fd00::/64", wantContains: false, wantOverlaps: false},
+	}
+
+	for _, c := range cases {
+		prefix := netip.MustParsePrefix(c.prefix)
+		if got := pool.Contains(prefix); got != c.wantContains {
+			t.Errorf("%s contains: got %t, wanted %t", c.prefix, got, c.wantContains)
+		}
+		if got := pool.Overlaps(prefix); got != c.wantOverlaps {
+			t.Errorf("%s overlaps: got %t, wanted %t", c.prefix, got, c.wantOverlaps)
+		}
+	}
+}
+
+func TestPoolSubnetList(t *testing.T) {
+	cases := []struct {
+		name    string
+		pool    string
+		bits    int
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "an IPv4 pool",
+			pool: "10.0.0.0/24",
+			bits: 26,
+			want: []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/26"},
+		},
+		{
+			name: "an IPv6 pool",
+			pool: "fd00::/62",
+			bits: 64,
+			want: []string{"fd00::/64", "fd00:0:0:1::/64", "fd00:0:0:2::/64", "fd00:0:0:3::/64"},
+		},
+		{
+			name: "the pool itself",
+			pool: "10.0.0.0/24",
+			bits: 24,
+			want: []string{"10.0.0.0/24"},
+		},
+		{
+			name: "a pool smaller than the requested size",
+			pool: "10.0.0.0/24",
+			bits: 20,
+			want: []string{},
+		},
+		{
+			name: "the end of the address space",
+			pool: "255.255.255.252/30",
+			bits: 31,
+			want: []string{"255.255.255.252/31", "255.255.255.254/31"},
+		},
+		{
+			name:    "a prefix length out of range for IPv4",
+			pool:    "10.0.0.0/24",
+			bits:    64,
+			want:    []string{},
+			wantErr: ipam.ErrInvalidPrefixLength,
+		},
+		{
+			name:    "a negative prefix length",
+			pool:    "fd00::/64",
+			bits:    -1,
+			want:    []string{},
+			wantErr: ipam.ErrInvalidPrefixLength,
+		},
+	}
+
+	for _, c := range cases {
+		pool, err := ipam.ParsePool(c.pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		subnets, err := pool.SubnetList(c.bits)
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: got error %v, wanted %v", c.name, err, c.wantErr)
+		}
+		if got := prefixStrings(subnets); !equalStrings(got, c.want) {
+			t.Errorf("%s: got %v, wanted %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSubnetsSkipTo(t *testing.T) {
+	pool, err := ipam.ParsePool("10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnets, err := pool.Subnets(24)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Case 1: Skipping to an address in the middle of a subnet
+	// expected: the subnet holding the address is returned next
+	subnets.SkipTo(netip.MustParseAddr("10.0.200.17"))
+	if got, _ := subnets.Next(); got.String() != "10.0.200.0/24" {
+		t.Errorf("got %s, wanted %s", got, "10.0.200.0/24")
+	}
+
+	// Case 2: Skipping backwards
+	// expected: the iterator does not move
+	subnets.SkipTo(netip.MustParseAddr("10.0.0.0"))
+	if got, _ := subnets.Next(); got.String() != "10.0.201.0/24" {
+		t.Errorf("got %s, wanted %s", got, "10.0.201.0/24")
+	}
+
+	// Case 3: Skipping past the end of the pool
+	// expected: the iterator is exhausted
+	subnets.SkipTo(netip.MustParseAddr("10.1.0.0"))
+	if got, ok := subnets.Next(); ok {
+		t.Errorf("got %s, wanted no subnet", got)
+	}
+}
+
+func TestPoolJSON(t *testing.T) {
+	// Case 1: Pools are serialized to JSON and back
+	// expected: each pool is serialized as its network in CIDR format
+	pools, err := ipam.ParsePools("10.0.0.0/16", "fd00::/56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(pools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["10.0.0.0/16","fd00::/56"]`; string(data) != want {
+		t.Errorf("got %s, wanted %s", data, want)
+	}
+
+	got := []ipam.Pool{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(pools) || got[0] != pools[0] || got[1] != pools[1] {
+		t.Errorf("got %v, wanted %v", got, pools)
+	}
+
+	// Case 2: An invalid pool
+	// expected: an error is returned
+	if err := json.Unmarshal([]byte(`["10.0.0.0/33"]`), &got); err == nil {
+		t.Error("function was expected to return with an error")
+	}
+}
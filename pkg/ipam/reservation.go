@@ -0,0 +1,76 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam
+
+import (
+	"net/netip"
+	"time"
+)
+
+// Reservation represents a named network which is held back from allocation, optionally until an expiry time
+type Reservation struct {
+	// Name represents the name of the reservation
+	Name string `json:"name"`
+	// Prefix represents the reserved network
+	Prefix netip.Prefix `json:"prefix"`
+	// Owner represents the team or system that the network is reserved for. Optional
+	Owner string `json:"owner,omitempty"`
+	// Reason represents why the network is reserved. Optional
+	Reason string `json:"reason,omitempty"`
+	// ExpiresAt represents the time after which the reservation no longer applies. The reservation never expires when it is the zero time
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Expired returns true when the reservation has an expiry time which is not after the supplied time
+func (r Reservation) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !r.ExpiresAt.After(now)
+}
+
+// Unexpired returns the reservations which have not expired at the supplied time
+func Unexpired(reservations []Reservation, now time.Time) []Reservation {
+	unexpired := []Reservation{}
+	for _, r := range reservations {
+		if !r.Expired(now) {
+			unexpired = append(unexpired, r)
+		}
+	}
+
+	return unexpired
+}
+
+// Reserved returns the set of addresses held by the reservations which have not expired at the supplied time
+func Reserved(reservations []Reservation, now time.Time) *Set {
+	set := &Set{}
+	for _, r := range Unexpired(reservations, now) {
+		set.AddPrefix(r.Prefix)
+	}
+
+	return set
+}
+
+// NextExpiry returns the earliest expiry time of the reservations which have not expired at the supplied time, or the zero time when
+// none of them expire
+func NextExpiry(reservations []Reservation, now time.Time) time.Time {
+	var next time.Time
+	for _, r := range Unexpired(reservations, now) {
+		if !r.ExpiresAt.IsZero() && (next.IsZero() || r.ExpiresAt.Before(next)) {
+			next = r.ExpiresAt
+		}
+	}
+
+	return next
+}
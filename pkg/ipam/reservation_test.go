@@ -0,0 +1,91 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam_test
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+func TestReservations(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	reservations := []ipam.Reservation{
+		{Name: "permanent", Prefix: netip.MustParsePrefix("10.0.0.0/24")},
+		{Name: "expired", Prefix: netip.MustParsePrefix("10.0.1.0/24"), ExpiresAt: now.Add(-time.Hour)},
+		{Name: "expiring-now", Prefix: netip.MustParsePrefix("10.0.2.0/24"), ExpiresAt: now},
+		{Name: "later", Prefix: netip.MustParsePrefix("10.0.3.0/24"), ExpiresAt: now.Add(2 * time.Hour)},
+		{Name: "sooner", Prefix: netip.MustParsePrefix("fd00::/64"), ExpiresAt: now.Add(time.Hour)},
+	}
+
+	// Case 1: Reservations which expire at or before the supplied time
+	// expected: only the permanent and future reservations are unexpired
+	names := []string{}
+	for _, r := range ipam.Unexpired(reservations, now) {
+		names = append(names, r.Name)
+	}
+	if want := []string{"permanent", "later", "sooner"}; !equalStrings(names, want) {
+		t.Errorf("got %v, wanted %v", names, want)
+	}
+
+	// Case 2: The addresses held by the reservations
+	// expected: only the unexpired reservations hold addresses
+	if got, want := ipam.Reserved(reservations, now).String(), "10.0.0.0/24,10.0.3.0/24,fd00::/64"; got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+
+	// Case 3: The next expiry time
+	// expected: the earliest expiry time of the unexpired reservations
+	if got, want := ipam.NextExpiry(reservations, now), now.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// Case 4: No unexpired reservation has an expiry time
+	// expected: the zero time
+	if got := ipam.NextExpiry(reservations[:3], now); !got.IsZero() {
+		t.Errorf("got %v, wanted the zero time", got)
+	}
+}
+
+func TestReservationJSON(t *testing.T) {
+	// Case 1: A reservation is serialized to JSON and back
+	// expected: the prefix is serialized in CIDR format and the reservation is unchanged once deserialized
+	want := ipam.Reservation{
+		Name:      "vpn",
+		Prefix:    netip.MustParsePrefix("10.0.0.0/24"),
+		Owner:     "networking",
+		ExpiresAt: time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC),
+	}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantJSON := `{"name":"vpn","prefix":"10.0.0.0/24","owner":"networking","expiresAt":"2024-06-01T12:00:00Z"}`; string(data) != wantJSON {
+		t.Errorf("got %s, wanted %s", data, wantJSON)
+	}
+
+	got := ipam.Reservation{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+}
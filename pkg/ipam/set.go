@@ -0,0 +1,309 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Range represents an inclusive range of addresses of a single IP family
+type Range struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// String returns the range in <first>-<last> format
+func (r Range) String() string {
+	return r.First.String() + "-" + r.Last.String()
+}
+
+// Size returns the number of addresses in the range
+func (r Range) Size() *big.Int {
+	size := new(big.Int).Sub(addrToInt(r.Last), addrToInt(r.First))
+	return size.Add(size, big.NewInt(1))
+}
+
+// Set represents a set of addresses as a union of address ranges. The ranges are kept sorted by address, and overlapping or adjacent
+// ranges are merged so that every address of the set is counted exactly once. IPv4 and IPv6 ranges can be held in the same set.
+// The zero value is an empty set. A Set is not safe for concurrent modification
+type Set struct {
+	ranges []Range
+}
+
+// NewSet returns the union of the supplied prefixes
+func NewSet(prefixes ...netip.Prefix) *Set {
+	set := &Set{}
+	for _, prefix := range prefixes {
+		set.AddPrefix(prefix)
+	}
+
+	return set
+}
+
+// ParseSet returns the union of the supplied networks (in CIDR format). An error is returned for the first invalid network
+func ParseSet(cidrs ...string) (*Set, error) {
+	set := &Set{}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return &Set{}, err
+		}
+		set.AddPrefix(prefix)
+	}
+
+	return set, nil
+}
+
+// AddPrefix adds every address of the supplied prefix to the set. Invalid prefixes are ignored
+func (s *Set) AddPrefix(prefix netip.Prefix) {
+	if !prefix.IsValid() {
+		return
+	}
+
+	prefix = prefix.Masked()
+	s.ranges = normalize(append(s.ranges, Range{First: prefix.Addr(), Last: lastAddr(prefix)}))
+}
+
+// Ranges returns the ranges of the set in ascending address order
+func (s *Set) Ranges() []Range {
+	return append([]Range{}, s.ranges...)
+}
+
+// Prefixes returns the smallest list of prefixes that covers exactly the addresses of the set, in ascending address order
+func (s *Set) Prefixes() []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, r := range s.ranges {
+		for first := r.First; first.IsValid() && first.Compare(r.Last) <= 0; {
+			// the largest prefix starting at first that does not extend past the end of the range
+			var prefix netip.Prefix
+			for bits := 0; bits <= first.BitLen(); bits++ {
+				prefix = netip.PrefixFrom(first, bits)
+				if prefix.Masked().Addr() == first && lastAddr(prefix).Compare(r.Last) <= 0 {
+					break
+				}
+			}
+
+			prefixes = append(prefixes, prefix)
+			first = lastAddr(prefix).Next()
+		}
+	}
+
+	return prefixes
+}
+
+// IsEmpty returns true when the set does not hold any address
+func (s *Set) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+// Size returns the number of addresses in the set
+func (s *Set) Size() *big.Int {
+	size := new(big.Int)
+	for _, r := range s.ranges {
+		size.Add(size, r.Size())
+	}
+
+	return size
+}
+
+// Overlaps returns true when any address of the supplied prefix is in the set
+func (s *Set) Overlaps(prefix netip.Prefix) bool {
+	_, overlaps := s.overlapping(prefix)
+	return overlaps
+}
+
+// overlapping returns the first range of the set which overlaps the supplied prefix
+func (s *Set) overlapping(prefix netip.Prefix) (Range, bool) {
+	if !prefix.IsValid() {
+		return Range{}, false
+	}
+
+	prefix = prefix.Masked()
+	first, last := prefix.Addr(), lastAddr(prefix)
+
+	// the first range that does not end before the prefix
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].Last.Compare(first) >= 0
+	})
+	if i < len(s.ranges) && s.ranges[i].First.Compare(last) <= 0 {
+		return s.ranges[i], true
+	}
+
+	return Range{}, false
+}
+
+// Contains returns true when every address of the supplied prefix is in the set
+func (s *Set) Contains(prefix netip.Prefix) bool {
+	if !prefix.IsValid() {
+		return false
+	}
+
+	prefix = prefix.Masked()
+	first, last := prefix.Addr(), lastAddr(prefix)
+
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].Last.Compare(first) >= 0
+	})
+
+	return i < len(s.ranges) && s.ranges[i].First.Compare(first) <= 0 && s.ranges[i].Last.Compare(last) >= 0
+}
+
+// Union returns a new set holding the addresses that are in either set
+func (s *Set) Union(other *Set) *Set {
+	return &Set{ranges: normalize(append(append([]Range{}, s.ranges...), other.ranges...))}
+}
+
+// Intersect returns a new set holding the addresses that are in both sets
+func (s *Set) Intersect(other *Set) *Set {
+	result := &Set{}
+	for i, j := 0, 0; i < len(s.ranges) && j < len(other.ranges); {
+		a, b := s.ranges[i], other.ranges[j]
+
+		first, last := maxAddr(a.First, b.First), minAddr(a.Last, b.Last)
+		if first.Compare(last) <= 0 {
+			result.ranges = append(result.ranges, Range{First: first, Last: last})
+		}
+
+		if a.Last.Compare(b.Last) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return result
+}
+
+// Subtract returns a new set holding the addresses of the set that are not in the other set
+func (s *Set) Subtract(other *Set) *Set {
+	result := &Set{}
+	j := 0
+	for _, r := range s.ranges {
+		// skip the ranges of the other set that end before this range
+		for j < len(other.ranges) && other.ranges[j].Last.Compare(r.First) < 0 {
+			j++
+		}
+
+		next := r.First
+		for k := j; k < len(other.ranges) && next.IsValid() && other.ranges[k].First.Compare(r.Last) <= 0; k++ {
+			o := other.ranges[k]
+			if o.First.Compare(next) > 0 {
+				result.ranges = append(result.ranges, Range{First: next, Last: o.First.Prev()})
+			}
+			if o.Last.Compare(next) >= 0 {
+				// Next returns an invalid address once the end of the address space is reached
+				next = o.Last.Next()
+			}
+		}
+
+		if next.IsValid() && next.Compare(r.Last) <= 0 {
+			result.ranges = append(result.ranges, Range{First: next, Last: r.Last})
+		}
+	}
+
+	return result
+}
+
+// String returns the prefixes of the set (see Prefixes) as a comma-separated list
+func (s *Set) String() string {
+	prefixes := []string{}
+	for _, prefix := range s.Prefixes() {
+		prefixes = append(prefixes, prefix.String())
+	}
+
+	return strings.Join(prefixes, ",")
+}
+
+// MarshalJSON implements json.Marshaler. The set is serialized as the list of its prefixes (see Prefixes)
+func (s *Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Prefixes())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The set is replaced by the union of the listed prefixes
+func (s *Set) UnmarshalJSON(data []byte) error {
+	prefixes := []netip.Prefix{}
+	if err := json.Unmarshal(data, &prefixes); err != nil {
+		return err
+	}
+
+	*s = *NewSet(prefixes...)
+	return nil
+}
+
+// normalize sorts the supplied ranges by address and merges the ranges that overlap or are adjacent
+func normalize(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].First.Compare(ranges[j].First) < 0
+	})
+
+	merged := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if last.First.Is4() == r.First.Is4() && (r.First.Compare(last.Last) <= 0 || last.Last.Next() == r.First) {
+				last.Last = maxAddr(last.Last, r.Last)
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// lastAddr returns the last address of the supplied (masked) prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	bits := prefix.Bits()
+	for i := range bytes {
+		if bits >= 8 {
+			bits -= 8
+			continue
+		}
+
+		bytes[i] |= byte(0xff >> bits)
+		bits = 0
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// addrToInt returns the supplied address as an integer
+func addrToInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
+
+func minAddr(a, b netip.Addr) netip.Addr {
+	if a.Compare(b) < 0 {
+		return a
+	}
+
+	return b
+}
+
+func maxAddr(a, b netip.Addr) netip.Addr {
+	if a.Compare(b) > 0 {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,308 @@
+/*
+MIT License
+
+Copyright (c) His Majesty the King in Right of Canada, as represented by the Minister responsible for Statistics Canada, 2024
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package ipam_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/netip"
+	"testing"
+
+	"statcan.gc.ca/cidr-allocator/pkg/ipam"
+)
+
+// mustParseSet returns the union of the supplied networks, failing the test when any of them is invalid
+func mustParseSet(t testing.TB, cidrs ...string) *ipam.Set {
+	t.Helper()
+
+	set, err := ipam.ParseSet(cidrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return set
+}
+
+// rangeStrings returns the ranges of the supplied set in <first>-<last> format
+func rangeStrings(set *ipam.Set) []string {
+	ranges := []string{}
+	for _, r := range set.Ranges() {
+		ranges = append(ranges, r.String())
+	}
+
+	return ranges
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestParseSet(t *testing.T) {
+	cases := []struct {
+		name       string
+		cidrs      []string
+		wantRanges []string
+		wantSize   string
+	}{
+		{
+			name:       "no networks",
+			cidrs:      nil,
+			wantRanges: []string{},
+			wantSize:   "0",
+		},
+		{
+			name:       "duplicate networks are counted once",
+			cidrs:      []string{"10.0.0.0/24", "10.0.0.0/24"},
+			wantRanges: []string{"10.0.0.0-10.0.0.255"},
+			wantSize:   "256",
+		},
+		{
+			name:       "a network contained by another network is absorbed",
+			cidrs:      []string{"10.0.0.0/16", "10.0.4.0/24"},
+			wantRanges: []string{"10.0.0.0-10.0.255.255"},
+			wantSize:   "65536",
+		},
+		{
+			name:       "adjacent networks are merged",
+			cidrs:      []string{"10.0.1.0/24", "10.0.0.0/24"},
+			wantRanges: []string{"10.0.0.0-10.0.1.255"},
+			wantSize:   "512",
+		},
+		{
+			name:       "disjoint networks are kept apart and sorted",
+			cidrs:      []string{"10.0.2.0/24", "10.0.0.0/24"},
+			wantRanges: []string{"10.0.0.0-10.0.0.255", "10.0.2.0-10.0.2.255"},
+			wantSize:   "512",
+		},
+		{
+			name:       "host bits are masked",
+			cidrs:      []string{"10.0.0.7/30"},
+			wantRanges: []string{"10.0.0.4-10.0.0.7"},
+			wantSize:   "4",
+		},
+		{
+			name:       "the whole IPv4 address space does not overflow",
+			cidrs:      []string{"0.0.0.0/0", "10.0.0.0/8"},
+			wantRanges: []string{"0.0.0.0-255.255.255.255"},
+			wantSize:   "4294967296",
+		},
+		{
+			name:       "IPv4 and IPv6 networks are held apart",
+			cidrs:      []string{"fd00::/64", "255.255.255.0/24"},
+			wantRanges: []string{"255.255.255.0-255.255.255.255", "fd00::-fd00::ffff:ffff:ffff:ffff"},
+			wantSize:   "18446744073709551872",
+		},
+		{
+			name:       "the whole IPv6 address space is counted",
+			cidrs:      []string{"::/0"},
+			wantRanges: []string{"::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+			wantSize:   "340282366920938463463374607431768211456",
+		},
+	}
+
+	for i, c := range cases {
+		set := mustParseSet(t, c.cidrs...)
+		if got := rangeStrings(set); !equalStrings(got, c.wantRanges) {
+			t.Errorf("case %d (%s): got %v, wanted %v", i+1, c.name, got, c.wantRanges)
+		}
+		if got := set.Size().String(); got != c.wantSize {
+			t.Errorf("case %d (%s): got %s, wanted %s", i+1, c.name, got, c.wantSize)
+		}
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	cases := []struct {
+		name          string
+		a             []string
+		b             []string
+		wantUnion     []string
+		wantIntersect []string
+		wantSubtract  []string
+	}{
+		{
+			name:          "empty sets",
+			wantUnion:     []string{},
+			wantIntersect: []string{},
+			wantSubtract:  []string{},
+		},
+		{
+			name:          "subtracting from an empty set",
+			b:             []string{"10.0.0.0/24"},
+			wantUnion:     []string{"10.0.0.0-10.0.0.255"},
+			wantIntersect: []string{},
+			wantSubtract:  []string{},
+		},
+		{
+			name:          "a reservation that only partly overlaps a pool",
+			a:             []string{"10.0.0.0/24"},
+			b:             []string{"10.0.0.192/26", "10.0.1.0/26"},
+			wantUnion:     []string{"10.0.0.0-10.0.1.63"},
+			wantIntersect: []string{"10.0.0.192-10.0.0.255"},
+			wantSubtract:  []string{"10.0.0.0-10.0.0.191"},
+		},
+		{
+			name:          "a hole in the middle of a range",
+			a:             []string{"10.0.0.0/24"},
+			b:             []string{"10.0.0.64/26"},
+			wantUnion:     []string{"10.0.0.0-10.0.0.255"},
+			wantIntersect: []string{"10.0.0.64-10.0.0.127"},
+			wantSubtract:  []string{"10.0.0.0-10.0.0.63", "10.0.0.128-10.0.0.255"},
+		},
+		{
+			name:          "a range covering several ranges",
+			a:             []string{"10.0.0.0/26", "10.0.0.128/26"},
+			b:             []string{"10.0.0.0/24"},
+			wantUnion:     []string{"10.0.0.0-10.0.0.255"},
+			wantIntersect: []string{"10.0.0.0-10.0.0.63", "10.0.0.128-10.0.0.191"},
+			wantSubtract:  []string{},
+		},
+		{
+			name:          "the end of the address space",
+			a:             []string{"255.255.255.0/24"},
+			b:             []string{"255.255.255.128/25"},
+			wantUnion:     []string{"255.255.255.0-255.255.255.255"},
+			wantIntersect: []string{"255.255.255.128-255.255.255.255"},
+			wantSubtract:  []string{"255.255.255.0-255.255.255.127"},
+		},
+		{
+			name:          "different IP families never overlap",
+			a:             []string{"10.0.0.0/24"},
+			b:             []string{"::/0"},
+			wantUnion:     []string{"10.0.0.0-10.0.0.255", "::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+			wantIntersect: []string{},
+			wantSubtract:  []string{"10.0.0.0-10.0.0.255"},
+		},
+	}
+
+	for i, c := range cases {
+		a, b := mustParseSet(t, c.a...), mustParseSet(t, c.b...)
+		if got := rangeStrings(a.Union(b)); !equalStrings(got, c.wantUnion) {
+			t.Errorf("case %d (%s) union: got %v, wanted %v", i+1, c.name, got, c.wantUnion)
+		}
+		if got := rangeStrings(a.Intersect(b)); !equalStrings(got, c.wantIntersect) {
+			t.Errorf("case %d (%s) intersect: got %v, wanted %v", i+1, c.name, got, c.wantIntersect)
+		}
+		if got := rangeStrings(a.Subtract(b)); !equalStrings(got, c.wantSubtract) {
+			t.Errorf("case %d (%s) subtract: got %v, wanted %v", i+1, c.name, got, c.wantSubtract)
+		}
+	}
+}
+
+func TestRangeSize(t *testing.T) {
+	// Case 1: A single address
+	// expected: the range holds one address
+	r := mustParseSet(t, "10.0.0.1/32").Ranges()[0]
+	if got := r.Size(); got.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("got %s, wanted %d", got, 1)
+	}
+}
+
+func TestParseSetInvalid(t *testing.T) {
+	// Case 1: One of the networks is invalid
+	// expected: an error is returned along with an empty set
+	set, err := ipam.ParseSet("10.0.0.0/24", "10.0.0.0/36")
+	if err == nil || !set.IsEmpty() {
+		t.Errorf("got (%v, %v), wanted an error and an empty set", set, err)
+	}
+}
+
+func TestSetLookups(t *testing.T) {
+	set := mustParseSet(t, "10.0.0.0/25", "10.0.1.0/24", "fd00::/64")
+
+	cases := []struct {
+		prefix       string
+		wantOverlaps bool
+		wantContains bool
+	}{
+		{prefix: "10.0.0.0/26", wantOverlaps: true, wantContains: true},
+		{prefix: "10.0.0.0/24", wantOverlaps: true, wantContains: false},
+		{prefix: "10.0.0.128/25", wantOverlaps: false, wantContains: false},
+		{prefix: "10.0.0.0/23", wantOverlaps: true, wantContains: false},
+		{prefix: "10.0.1.255/32", wantOverlaps: true, wantContains: true},
+		{prefix: "10.0.2.0/24", wantOverlaps: false, wantContains: false},
+		{prefix: "9.0.0.0/8", wantOverlaps: false, wantContains: false},
+		{prefix: "fd00::1/128", wantOverlaps: true, wantContains: true},
+		{prefix: "fd00::/63", wantOverlaps: true, wantContains: false},
+		{prefix: "::/0", wantOverlaps: true, wantContains: false},
+	}
+
+	for _, c := range cases {
+		prefix := netip.MustParsePrefix(c.prefix)
+		if got := set.Overlaps(prefix); got != c.wantOverlaps {
+			t.Errorf("%s overlaps: got %t, wanted %t", c.prefix, got, c.wantOverlaps)
+		}
+		if got := set.Contains(prefix); got != c.wantContains {
+			t.Errorf("%s contains: got %t, wanted %t", c.prefix, got, c.wantContains)
+		}
+	}
+}
+
+func TestSetPrefixes(t *testing.T) {
+	cases := []struct {
+		name  string
+		cidrs []string
+		want  string
+	}{
+		{name: "empty set", want: ""},
+		{name: "adjacent siblings are merged", cidrs: []string{"10.0.0.0/25", "10.0.0.128/25"}, want: "10.0.0.0/24"},
+		{name: "an unaligned range", cidrs: []string{"10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/26"}, want: "10.0.0.64/26,10.0.0.128/25,10.0.1.0/26"},
+		{name: "the whole address spaces", cidrs: []string{"::/0", "0.0.0.0/1", "128.0.0.0/1"}, want: "0.0.0.0/0,::/0"},
+	}
+
+	for _, c := range cases {
+		if got := mustParseSet(t, c.cidrs...).String(); got != c.want {
+			t.Errorf("%s: got %q, wanted %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSetJSON(t *testing.T) {
+	// Case 1: A set is serialized to JSON and back
+	// expected: the set is serialized as its prefixes and holds the same addresses once deserialized
+	set := mustParseSet(t, "10.0.0.0/25", "10.0.0.128/25", "fd00::/64")
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["10.0.0.0/24","fd00::/64"]`; string(data) != want {
+		t.Errorf("got %s, wanted %s", data, want)
+	}
+
+	got := &ipam.Set{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(rangeStrings(got), rangeStrings(set)) {
+		t.Errorf("got %v, wanted %v", rangeStrings(got), rangeStrings(set))
+	}
+
+	// Case 2: Invalid JSON
+	// expected: an error is returned
+	if err := json.Unmarshal([]byte(`["10.0.0.0/36"]`), got); err == nil {
+		t.Error("function was expected to return with an error")
+	}
+}